@@ -43,6 +43,7 @@ import (
 	"github.com/spf13/cobra"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	apimachruntime "k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
@@ -145,8 +146,9 @@ func run(cmd *cobra.Command, f *flags.Flags) {
 
 	// Set default manager options
 	options = f.ToManagerOptions(options)
-	if options.Scheme == nil {
-		options.Scheme = apimachruntime.NewScheme()
+	if err := configureScheme(&options); err != nil {
+		log.Error(err, "Failed to configure the scheme")
+		os.Exit(1)
 	}
 
 	ws, err := watches.Load(f.WatchesFile)
@@ -179,6 +181,12 @@ func run(cmd *cobra.Command, f *flags.Flags) {
 		os.Exit(1)
 	}
 
+	referenceCache, err := newReferenceCache(mgr, options)
+	if err != nil {
+		log.Error(err, "Failed to create the cache of the referenced objects")
+		os.Exit(1)
+	}
+
 	acg, err := helmClient.NewActionConfigGetter(mgr.GetConfig(), mgr.GetRESTMapper(), mgr.GetLogger())
 	if err != nil {
 		log.Error(err, "Failed to create Helm action config getter")
@@ -200,6 +208,20 @@ func run(cmd *cobra.Command, f *flags.Flags) {
 			SuppressOverrideValues:  f.SuppressOverrideValues,
 			MaxConcurrentReconciles: f.MaxConcurrentReconciles,
 			Selector:                w.Selector,
+			ReleaseWait: controller.ReleaseWaitOptions{
+				Wait:        w.Wait,
+				WaitForJobs: w.WaitForJobs,
+				Timeout:     w.Timeout.Duration,
+				Atomic:      w.Atomic,
+			},
+			ReleaseTest: controller.ReleaseTestOptions{
+				Enabled:           w.Test,
+				RollbackOnFailure: w.RollbackOnTestFailure,
+			},
+			NodeFeatureLabels: w.NodeFeatureLabels,
+			ImagePolicy:       w.ImagePolicy,
+			MaintenanceWindow: w.MaintenanceWindow,
+			ReferenceCache:    referenceCache,
 		})
 		if err != nil {
 			log.Error(err, "Failed to add manager factory to controller.")
@@ -224,6 +246,15 @@ func exitIfUnsupported(options manager.Options) {
 	}
 }
 
+// configureScheme registers the built-in kinds, like the Secrets and the
+// Nodes read by the reconcilers, in the scheme of the manager.
+func configureScheme(options *manager.Options) error {
+	if options.Scheme == nil {
+		options.Scheme = apimachruntime.NewScheme()
+	}
+	return clientgoscheme.AddToScheme(options.Scheme)
+}
+
 func configureWatchNamespaces(options *manager.Options, log logr.Logger) {
 	namespaces := splitNamespaces(os.Getenv(k8sutil.WatchNamespaceEnvVar))
 
@@ -263,6 +294,24 @@ func splitNamespaces(namespaces string) []string {
 	return out
 }
 
+// newReferenceCache returns a cache, started with the manager, for the
+// metadata of the ConfigMaps and Secrets referenced by the ModelServers.
+// They are created by the users, so unlike the cache of the manager, which
+// filters the ConfigMaps and Secrets created by the charts with the chart
+// label, it only filters the objects by namespace.
+func newReferenceCache(mgr manager.Manager, options manager.Options) (cache.Cache, error) {
+	c, err := cache.New(mgr.GetConfig(), cache.Options{
+		HTTPClient:        mgr.GetHTTPClient(),
+		Scheme:            mgr.GetScheme(),
+		Mapper:            mgr.GetRESTMapper(),
+		DefaultNamespaces: options.Cache.DefaultNamespaces,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, mgr.Add(c)
+}
+
 func configureSelectors(opts *manager.Options, ws []watches.Watch, sch *apimachruntime.Scheme) error {
 	selectorsByObject := map[client.Object]cache.ByObject{}
 	chartNames := make([]string, 0, len(ws))
@@ -276,6 +325,15 @@ func configureSelectors(opts *manager.Options, ws []watches.Watch, sch *apimachr
 			return fmt.Errorf("unable to parse watch selector for %s: %v", w.GroupVersionKind, err)
 		}
 		selectorsByObject[crObj] = cache.ByObject{Label: sel}
+		if w.GroupVersionKind.Kind == "ModelServer" {
+			// the model repositories are not labeled by a chart
+			for _, gvk := range controller.ModelRepositoryGVKs(w.GroupVersionKind) {
+				sch.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+				repoObj := &unstructured.Unstructured{}
+				repoObj.SetGroupVersionKind(gvk)
+				selectorsByObject[repoObj] = cache.ByObject{Label: labels.Everything()}
+			}
+		}
 
 		chrt, err := loader.LoadDir(w.ChartDir)
 		if err != nil {
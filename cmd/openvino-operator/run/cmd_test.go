@@ -0,0 +1,219 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package run
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/controller"
+	"github.com/openvinotoolkit/operator/pkg/helm/release"
+	"github.com/openvinotoolkit/operator/pkg/helm/watches"
+	"github.com/openvinotoolkit/operator/pkg/util/k8sutil"
+)
+
+// testAPIServer serves the discovery and empty lists of the resources
+// watched by the operator, and records the label selector of each list. The
+// selectors of the metadata-only lists are recorded with the suffix
+// " metadata".
+type testAPIServer struct {
+	*httptest.Server
+	resources map[string]metaResource
+
+	mu        sync.Mutex
+	selectors map[string]string
+}
+
+type metaResource struct {
+	groupVersion string
+	kind         string
+	namespaced   bool
+}
+
+func newTestAPIServer() *testAPIServer {
+	s := &testAPIServer{
+		resources: map[string]metaResource{
+			"configmaps":               {"v1", "ConfigMap", true},
+			"secrets":                  {"v1", "Secret", true},
+			"namespaces":               {"v1", "Namespace", false},
+			"nodes":                    {"v1", "Node", false},
+			"modelservers":             {"intel.com/v1alpha1", "ModelServer", true},
+			"modelrepositories":        {"intel.com/v1alpha1", "ModelRepository", true},
+			"clustermodelrepositories": {"intel.com/v1alpha1", "ClusterModelRepository", false},
+		},
+		selectors: map[string]string{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *testAPIServer) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/api":
+		fmt.Fprint(w, `{"kind":"APIVersions","versions":["v1"]}`)
+		return
+	case "/apis":
+		fmt.Fprint(w, `{"kind":"APIGroupList","apiVersion":"v1","groups":[{"name":"intel.com",`+
+			`"versions":[{"groupVersion":"intel.com/v1alpha1","version":"v1alpha1"}],`+
+			`"preferredVersion":{"groupVersion":"intel.com/v1alpha1","version":"v1alpha1"}}]}`)
+		return
+	case "/api/v1", "/apis/intel.com/v1alpha1":
+		groupVersion := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/"), "/apis/")
+		var resources []string
+		for name, resource := range s.resources {
+			if resource.groupVersion == groupVersion {
+				resources = append(resources, fmt.Sprintf(`{"name":%q,"kind":%q,"namespaced":%t,"verbs":["get","list","watch"]}`,
+					name, resource.kind, resource.namespaced))
+			}
+		}
+		fmt.Fprintf(w, `{"kind":"APIResourceList","apiVersion":"v1","groupVersion":%q,"resources":[%s]}`,
+			groupVersion, strings.Join(resources, ","))
+		return
+	}
+
+	parts := strings.Split(r.URL.Path, "/")
+	name := parts[len(parts)-1]
+	resource, ok := s.resources[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.URL.Query().Get("watch") == "true" {
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		return
+	}
+	metadata := strings.Contains(r.Header.Get("Accept"), "as=PartialObjectMetadataList")
+	s.mu.Lock()
+	if metadata {
+		s.selectors[name+" metadata"] = r.URL.Query().Get("labelSelector")
+	} else {
+		s.selectors[name] = r.URL.Query().Get("labelSelector")
+	}
+	s.mu.Unlock()
+	if metadata {
+		fmt.Fprint(w, `{"kind":"PartialObjectMetadataList","apiVersion":"meta.k8s.io/v1","metadata":{"resourceVersion":"1"},"items":[]}`)
+		return
+	}
+	fmt.Fprintf(w, `{"kind":"%sList","apiVersion":%q,"metadata":{"resourceVersion":"1"},"items":[]}`,
+		resource.kind, resource.groupVersion)
+}
+
+// listSelectors returns the label selectors of the resources listed so far.
+func (s *testAPIServer) listSelectors() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	selectors := map[string]string{}
+	for name, selector := range s.selectors {
+		selectors[name] = selector
+	}
+	return selectors
+}
+
+func TestModelServerControllerCache(t *testing.T) {
+	server := newTestAPIServer()
+	defer server.Close()
+	t.Setenv(k8sutil.WatchNamespaceEnvVar, "ns")
+
+	gvk := schema.GroupVersionKind{Group: "intel.com", Version: "v1alpha1", Kind: "ModelServer"}
+	chartDir := "../../../helm-charts/ovms"
+	options := manager.Options{Metrics: metricsserver.Options{BindAddress: "0"}}
+	assert.NoError(t, configureScheme(&options))
+	configureWatchNamespaces(&options, logr.Discard())
+	ws := []watches.Watch{{GroupVersionKind: gvk, ChartDir: chartDir}}
+	assert.NoError(t, configureSelectors(&options, ws, options.Scheme))
+
+	mgr, err := manager.New(&rest.Config{Host: server.URL}, options)
+	assert.NoError(t, err)
+	referenceCache, err := newReferenceCache(mgr, options)
+	assert.NoError(t, err)
+	assert.NoError(t, controller.Add(mgr, controller.WatchOptions{
+		GVK:            gvk,
+		ManagerFactory: release.NewManagerFactory(mgr, nil, chartDir),
+		ReferenceCache: referenceCache,
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- mgr.Start(ctx)
+	}()
+	synced := func() bool {
+		selectors := server.listSelectors()
+		_, configMaps := selectors["configmaps metadata"]
+		_, secrets := selectors["secrets metadata"]
+		return configMaps && secrets
+	}
+	deadline := time.After(30 * time.Second)
+	for !synced() {
+		select {
+		case err := <-errs:
+			cancel()
+			assert.Fail(t, "the manager stopped", "%v", err)
+			return
+		case <-deadline:
+			cancel()
+			assert.Fail(t, "the ConfigMaps and Secrets were not listed")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// the reconcilers read the built-in kinds with the API reader
+	reader := mgr.GetAPIReader()
+	key := client.ObjectKey{Namespace: "ns", Name: "sample"}
+	for _, obj := range []client.Object{&corev1.Secret{}, &corev1.ConfigMap{}} {
+		assert.True(t, apierrors.IsNotFound(reader.Get(ctx, key, obj)), "%T", obj)
+	}
+	assert.True(t, apierrors.IsNotFound(reader.Get(ctx, client.ObjectKey{Name: "ns"}, &corev1.Namespace{})))
+	assert.NoError(t, reader.List(ctx, &corev1.NodeList{}))
+
+	// the ConfigMaps and Secrets created by the charts are read from the
+	// cache of the manager
+	for _, list := range []client.ObjectList{&corev1.SecretList{}, &corev1.ConfigMapList{}} {
+		assert.NoError(t, mgr.GetClient().List(ctx, list, client.InNamespace("ns")), "%T", list)
+	}
+
+	cancel()
+	assert.NoError(t, <-errs)
+
+	// the objects created by the users are not labeled with the chart
+	selectors := server.listSelectors()
+	assert.Equal(t, "", selectors["configmaps metadata"])
+	assert.Equal(t, "", selectors["secrets metadata"])
+	assert.Equal(t, "helm.sdk.operatorframework.io/chart in (ovms)", selectors["configmaps"])
+	assert.Equal(t, "helm.sdk.operatorframework.io/chart in (ovms)", selectors["secrets"])
+	assert.Equal(t, "", selectors["modelservers"])
+	assert.Equal(t, "", selectors["modelrepositories"])
+}
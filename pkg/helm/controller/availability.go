@@ -0,0 +1,231 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Anti-affinity modes of the deployment_parameters.anti_affinity value,
+// spreading the model server pods over the nodes.
+const (
+	antiAffinityPreferred = "preferred"
+	antiAffinityRequired  = "required"
+)
+
+// lowestReplicas returns the lowest number of replicas of the model server
+// Deployment, which is the lower autoscaling bound if enabled.
+func lowestReplicas(values map[string]interface{}) int64 {
+	if enabled, _, _ := unstructured.NestedBool(values, "autoscaling", "enabled"); enabled {
+		minReplicas, found, _ := unstructured.NestedInt64(values, "autoscaling", "min_replicas")
+		if !found {
+			minReplicas = 1
+		}
+		return minReplicas
+	}
+	replicas, found, _ := unstructured.NestedInt64(values, "deployment_parameters", "replicas")
+	if !found {
+		replicas = 1
+	}
+	return replicas
+}
+
+// renderAvailability validates the scheduling settings of the model server
+// pods and computes the PodDisruptionBudget of the Deployment. Without
+// min_available or max_unavailable, the budget lets one pod be evicted at a
+// time, and is omitted below two replicas since it would either block the
+// node drains or protect nothing.
+func (r HelmOperatorReconciler) renderAvailability(ctx context.Context, values, generated map[string]interface{}) error {
+	deployment, _, _ := unstructured.NestedMap(values, "deployment_parameters")
+	switch mode, _ := deployment["anti_affinity"].(string); mode {
+	case "":
+	case antiAffinityPreferred, antiAffinityRequired:
+		if deployment["pod_antiaffinity"] != nil {
+			return errors.New("deployment_parameters.anti_affinity and pod_antiaffinity cannot be used together")
+		}
+	default:
+		return fmt.Errorf("invalid deployment_parameters.anti_affinity %q, expected preferred or required", mode)
+	}
+	if err := validateTopologySpread(deployment); err != nil {
+		return err
+	}
+	if _, found := deployment["termination_grace_period_seconds"]; found {
+		seconds, _, err := unstructured.NestedInt64(deployment, "termination_grace_period_seconds")
+		if err != nil || seconds < 0 {
+			return errors.New("deployment_parameters.termination_grace_period_seconds must be a non-negative integer")
+		}
+	}
+	if name, _ := deployment["priority_class_name"].(string); name != "" {
+		found, err := r.getReferenced(ctx, "", name, &schedulingv1.PriorityClass{})
+		if err != nil {
+			return fmt.Errorf("failed to get the PriorityClass %q: %w", name, err)
+		}
+		if !found {
+			return fmt.Errorf("PriorityClass %q set in deployment_parameters.priority_class_name not found", name)
+		}
+	}
+
+	budget, err := podDisruptionBudgetFor(values)
+	if err != nil {
+		return err
+	}
+	if budget != nil {
+		generated["pod_disruption_budget"] = budget
+	}
+	return nil
+}
+
+// validateTopologySpread checks the topology spread constraints of the
+// model server pods, rendered by the chart with a label selector matching
+// the pods of the Deployment.
+func validateTopologySpread(deployment map[string]interface{}) error {
+	constraints, _, err := unstructured.NestedSlice(deployment, "topology_spread_constraints")
+	if err != nil {
+		return errors.New("deployment_parameters.topology_spread_constraints must be a list")
+	}
+	for i, c := range constraints {
+		constraint, _ := c.(map[string]interface{})
+		field := fmt.Sprintf("deployment_parameters.topology_spread_constraints[%d]", i)
+		if key, _ := constraint["topology_key"].(string); key == "" {
+			return fmt.Errorf("%s requires a topology_key", field)
+		}
+		if _, found := constraint["max_skew"]; found {
+			skew, _, err := unstructured.NestedInt64(constraint, "max_skew")
+			if err != nil || skew < 1 {
+				return fmt.Errorf("%s.max_skew must be a positive integer", field)
+			}
+		}
+		when, _ := constraint["when_unsatisfiable"].(string)
+		if when != "" && when != "DoNotSchedule" && when != "ScheduleAnyway" {
+			return fmt.Errorf("invalid %s.when_unsatisfiable %q, expected DoNotSchedule or ScheduleAnyway", field, when)
+		}
+		if _, found := constraint["min_domains"]; found {
+			domains, _, err := unstructured.NestedInt64(constraint, "min_domains")
+			if err != nil || domains < 1 {
+				return fmt.Errorf("%s.min_domains must be a positive integer", field)
+			}
+			if when != "DoNotSchedule" {
+				return fmt.Errorf("%s.min_domains requires when_unsatisfiable DoNotSchedule", field)
+			}
+		}
+	}
+	return nil
+}
+
+// podDisruptionBudgetFor returns the min_available or max_unavailable
+// values of the PodDisruptionBudget, or nil if it is not rendered. The
+// budget must allow evicting a pod when the Deployment runs its lowest
+// number of replicas, otherwise the nodes cannot be drained.
+func podDisruptionBudgetFor(values map[string]interface{}) (map[string]interface{}, error) {
+	pdb, _, _ := unstructured.NestedMap(values, "pod_disruption_budget")
+	if enabled, _ := pdb["enabled"].(bool); !enabled {
+		return nil, nil
+	}
+	minAvailable, err := intOrStringValue(pdb["min_available"], "pod_disruption_budget.min_available")
+	if err != nil {
+		return nil, err
+	}
+	maxUnavailable, err := intOrStringValue(pdb["max_unavailable"], "pod_disruption_budget.max_unavailable")
+	if err != nil {
+		return nil, err
+	}
+	if minAvailable != nil && maxUnavailable != nil {
+		return nil, errors.New("pod_disruption_budget.min_available and max_unavailable cannot be used together")
+	}
+	replicas := lowestReplicas(values)
+	replicasField := "deployment_parameters.replicas"
+	if enabled, _, _ := unstructured.NestedBool(values, "autoscaling", "enabled"); enabled {
+		replicasField = "autoscaling.min_replicas"
+	}
+
+	switch {
+	case minAvailable != nil:
+		available, err := intstr.GetScaledValueFromIntOrPercent(minAvailable, int(replicas), true)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pod_disruption_budget.min_available: %w", err)
+		}
+		if int64(available) >= replicas {
+			return nil, fmt.Errorf("pod_disruption_budget.min_available %s must be lower than the %d replicas of %s, "+
+				"otherwise no pod can be evicted", minAvailable.String(), replicas, replicasField)
+		}
+		return map[string]interface{}{"min_available": intOrStringToValue(minAvailable)}, nil
+	case maxUnavailable != nil:
+		unavailable, err := intstr.GetScaledValueFromIntOrPercent(maxUnavailable, int(replicas), true)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pod_disruption_budget.max_unavailable: %w", err)
+		}
+		if unavailable < 1 {
+			return nil, fmt.Errorf("pod_disruption_budget.max_unavailable %s allows no eviction with the %d replicas of %s",
+				maxUnavailable.String(), replicas, replicasField)
+		}
+		return map[string]interface{}{"max_unavailable": intOrStringToValue(maxUnavailable)}, nil
+	}
+	if replicas < 2 {
+		log.V(1).Info("Skipping the PodDisruptionBudget of a single replica", "field", replicasField)
+		return nil, nil
+	}
+	return map[string]interface{}{"max_unavailable": int64(1)}, nil
+}
+
+// intOrStringValue converts a number or a percentage of the values, or
+// returns nil if it is not set.
+func intOrStringValue(v interface{}, field string) (*intstr.IntOrString, error) {
+	var value intstr.IntOrString
+	switch n := v.(type) {
+	case nil:
+		return nil, nil
+	case int64:
+		value = intstr.FromInt32(int32(n))
+	case float64:
+		if n != float64(int32(n)) {
+			return nil, fmt.Errorf("%s must be an integer or a percentage", field)
+		}
+		value = intstr.FromInt32(int32(n))
+	case string:
+		if n == "" {
+			return nil, nil
+		}
+		if i, err := strconv.Atoi(n); err == nil {
+			value = intstr.FromInt32(int32(i))
+		} else if strings.HasSuffix(n, "%") {
+			value = intstr.FromString(n)
+		} else {
+			return nil, fmt.Errorf("%s must be an integer or a percentage", field)
+		}
+	default:
+		return nil, fmt.Errorf("%s must be an integer or a percentage", field)
+	}
+	if value.Type == intstr.Int && value.IntVal < 0 {
+		return nil, fmt.Errorf("%s must not be negative", field)
+	}
+	return &value, nil
+}
+
+func intOrStringToValue(v *intstr.IntOrString) interface{} {
+	if v.Type == intstr.Int {
+		return int64(v.IntVal)
+	}
+	return v.StrVal
+}
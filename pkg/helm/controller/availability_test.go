@@ -0,0 +1,211 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRenderAvailability(t *testing.T) {
+	priorityClass := &schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "inference"}, Value: 1000}
+	deployment := func(replicas int64, fields ...interface{}) map[string]interface{} {
+		out := map[string]interface{}{"replicas": replicas}
+		for i := 0; i < len(fields); i += 2 {
+			out[fields[i].(string)] = fields[i+1]
+		}
+		return out
+	}
+	pdb := func(fields ...interface{}) map[string]interface{} {
+		out := map[string]interface{}{"enabled": true}
+		for i := 0; i < len(fields); i += 2 {
+			out[fields[i].(string)] = fields[i+1]
+		}
+		return out
+	}
+
+	tests := []struct {
+		name       string
+		values     map[string]interface{}
+		expected   map[string]interface{}
+		errMessage string
+	}{
+		{
+			name:   "defaults",
+			values: map[string]interface{}{},
+		},
+		{
+			name: "scheduling",
+			values: map[string]interface{}{
+				"deployment_parameters": deployment(2,
+					"anti_affinity", "required",
+					"priority_class_name", "inference",
+					"termination_grace_period_seconds", int64(60),
+					"topology_spread_constraints", []interface{}{
+						map[string]interface{}{
+							"topology_key":       "topology.kubernetes.io/zone",
+							"when_unsatisfiable": "DoNotSchedule",
+							"min_domains":        int64(2),
+						},
+					}),
+			},
+		},
+		{
+			name: "automatic budget",
+			values: map[string]interface{}{
+				"deployment_parameters": deployment(3),
+				"pod_disruption_budget": pdb(),
+			},
+			expected: map[string]interface{}{"max_unavailable": int64(1)},
+		},
+		{
+			name: "single replica",
+			values: map[string]interface{}{
+				"deployment_parameters": deployment(1),
+				"pod_disruption_budget": pdb(),
+			},
+		},
+		{
+			name: "autoscaling bounds",
+			values: map[string]interface{}{
+				"deployment_parameters": deployment(1),
+				"autoscaling":           map[string]interface{}{"enabled": true, "min_replicas": int64(4), "max_replicas": int64(8)},
+				"pod_disruption_budget": pdb("min_available", "75%"),
+			},
+			expected: map[string]interface{}{"min_available": "75%"},
+		},
+		{
+			name: "max unavailable",
+			values: map[string]interface{}{
+				"deployment_parameters": deployment(4),
+				"pod_disruption_budget": pdb("max_unavailable", "2", "min_available", ""),
+			},
+			expected: map[string]interface{}{"max_unavailable": int64(2)},
+		},
+		{
+			name: "min available blocking evictions",
+			values: map[string]interface{}{
+				"deployment_parameters": deployment(3),
+				"pod_disruption_budget": pdb("min_available", int64(3)),
+			},
+			errMessage: "pod_disruption_budget.min_available 3 must be lower than the 3 replicas of " +
+				"deployment_parameters.replicas, otherwise no pod can be evicted",
+		},
+		{
+			name: "percentage blocking evictions",
+			values: map[string]interface{}{
+				"autoscaling":           map[string]interface{}{"enabled": true, "min_replicas": int64(2), "max_replicas": int64(8)},
+				"pod_disruption_budget": pdb("min_available", "60%"),
+			},
+			errMessage: "pod_disruption_budget.min_available 60% must be lower than the 2 replicas of " +
+				"autoscaling.min_replicas, otherwise no pod can be evicted",
+		},
+		{
+			name: "max unavailable zero",
+			values: map[string]interface{}{
+				"deployment_parameters": deployment(3),
+				"pod_disruption_budget": pdb("max_unavailable", "0%"),
+			},
+			errMessage: "pod_disruption_budget.max_unavailable 0% allows no eviction with the 3 replicas of " +
+				"deployment_parameters.replicas",
+		},
+		{
+			name: "conflicting budget",
+			values: map[string]interface{}{
+				"pod_disruption_budget": pdb("max_unavailable", int64(1), "min_available", int64(1)),
+			},
+			errMessage: "pod_disruption_budget.min_available and max_unavailable cannot be used together",
+		},
+		{
+			name: "invalid budget",
+			values: map[string]interface{}{
+				"pod_disruption_budget": pdb("max_unavailable", "one"),
+			},
+			errMessage: "pod_disruption_budget.max_unavailable must be an integer or a percentage",
+		},
+		{
+			name: "conflicting anti-affinity",
+			values: map[string]interface{}{
+				"deployment_parameters": deployment(2, "anti_affinity", "preferred", "pod_antiaffinity", map[string]interface{}{}),
+			},
+			errMessage: "deployment_parameters.anti_affinity and pod_antiaffinity cannot be used together",
+		},
+		{
+			name: "invalid anti-affinity",
+			values: map[string]interface{}{
+				"deployment_parameters": deployment(2, "anti_affinity", "always"),
+			},
+			errMessage: `invalid deployment_parameters.anti_affinity "always", expected preferred or required`,
+		},
+		{
+			name: "min domains",
+			values: map[string]interface{}{
+				"deployment_parameters": deployment(2, "topology_spread_constraints", []interface{}{
+					map[string]interface{}{"topology_key": "kubernetes.io/hostname", "min_domains": int64(2)},
+				}),
+			},
+			errMessage: "deployment_parameters.topology_spread_constraints[0].min_domains requires when_unsatisfiable DoNotSchedule",
+		},
+		{
+			name: "missing topology key",
+			values: map[string]interface{}{
+				"deployment_parameters": deployment(2, "topology_spread_constraints", []interface{}{
+					map[string]interface{}{"max_skew": int64(1)},
+				}),
+			},
+			errMessage: "deployment_parameters.topology_spread_constraints[0] requires a topology_key",
+		},
+		{
+			name: "negative grace period",
+			values: map[string]interface{}{
+				"deployment_parameters": deployment(2, "termination_grace_period_seconds", int64(-1)),
+			},
+			errMessage: "deployment_parameters.termination_grace_period_seconds must be a non-negative integer",
+		},
+		{
+			name: "missing priority class",
+			values: map[string]interface{}{
+				"deployment_parameters": deployment(2, "priority_class_name", "batch"),
+			},
+			errMessage: `PriorityClass "batch" set in deployment_parameters.priority_class_name not found`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cl := fake.NewClientBuilder().WithObjects(priorityClass).Build()
+			r := HelmOperatorReconciler{Client: cachedClient(cl), APIReader: cl}
+			generated := map[string]interface{}{}
+			err := r.renderAvailability(context.TODO(), test.values, generated)
+			if test.errMessage != "" {
+				assert.EqualError(t, err, test.errMessage)
+				return
+			}
+			assert.NoError(t, err)
+			if test.expected == nil {
+				assert.NotContains(t, generated, "pod_disruption_budget")
+				return
+			}
+			assert.Equal(t, test.expected, generated["pod_disruption_budget"])
+		})
+	}
+}
@@ -0,0 +1,180 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+const testChartDir = "../../../helm-charts/ovms"
+
+// renderChart renders the ovms chart with the values, and returns the
+// rendered manifests by template name.
+func renderChart(t *testing.T, values map[string]interface{}, apiVersions ...string) map[string]string {
+	c, err := loader.LoadDir(testChartDir)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	caps := chartutil.DefaultCapabilities.Copy()
+	caps.APIVersions = append(caps.APIVersions, apiVersions...)
+	renderValues, err := chartutil.ToRenderValues(c, values,
+		chartutil.ReleaseOptions{Name: "sample", Namespace: "default"}, caps)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	manifests, err := engine.Render(c, renderValues)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return manifests
+}
+
+// decodeManifests decodes the documents of a rendered template.
+func decodeManifests(t *testing.T, manifest string) []*unstructured.Unstructured {
+	var objects []*unstructured.Unstructured
+	for _, doc := range strings.Split(manifest, "\n---") {
+		o := map[string]interface{}{}
+		assert.NoError(t, yaml.Unmarshal([]byte(doc), &o))
+		if len(o) > 0 {
+			objects = append(objects, &unstructured.Unstructured{Object: o})
+		}
+	}
+	return objects
+}
+
+// renderedObject returns the object of the kind rendered by the template.
+func renderedObject(t *testing.T, manifests map[string]string, template, kind string, obj interface{}) bool {
+	for _, o := range decodeManifests(t, manifests["ovms/templates/"+template]) {
+		if o.GetKind() != kind {
+			continue
+		}
+		return assert.NoError(t, decodeStrict(o.Object, obj))
+	}
+	return assert.Fail(t, "object not rendered", "%s in %s", kind, template)
+}
+
+func TestChartMonitoringWithTLS(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		tls      map[string]interface{}
+		port     string
+		endpoint []string
+	}{
+		{
+			name:     "service monitor",
+			kind:     "ServiceMonitor",
+			port:     "rest",
+			endpoint: []string{"spec", "endpoints"},
+		},
+		{
+			name:     "service monitor with mutual TLS",
+			kind:     "ServiceMonitor",
+			tls:      map[string]interface{}{"server_secret": "sample-tls", "client_ca_secret": "clients"},
+			port:     "metrics",
+			endpoint: []string{"spec", "endpoints"},
+		},
+		{
+			name:     "pod monitor with TLS",
+			kind:     "PodMonitor",
+			tls:      map[string]interface{}{"server_secret": "sample-tls"},
+			port:     "metrics",
+			endpoint: []string{"spec", "podMetricsEndpoints"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values := map[string]interface{}{
+				"models_settings": map[string]interface{}{"model_name": "resnet", "model_path": "gs://models/resnet"},
+				"monitoring":      map[string]interface{}{"metrics_enable": true, "monitor_kind": test.kind},
+			}
+			if test.tls != nil {
+				values["generated"] = map[string]interface{}{"tls": test.tls}
+			}
+			manifests := renderChart(t, values, "monitoring.coreos.com/v1/"+test.kind)
+
+			monitors := decodeManifests(t, manifests["ovms/templates/monitor.yaml"])
+			if !assert.Len(t, monitors, 1) {
+				return
+			}
+			endpoints, _, _ := unstructured.NestedSlice(monitors[0].Object, test.endpoint...)
+			if !assert.Len(t, endpoints, 1) {
+				return
+			}
+			assert.Equal(t, test.port, endpoints[0].(map[string]interface{})["port"])
+			assert.Equal(t, "/metrics", endpoints[0].(map[string]interface{})["path"])
+			// the metrics are scraped over plain HTTP, without a client certificate
+			assert.NotContains(t, endpoints[0], "scheme")
+
+			service := &corev1.Service{}
+			if !renderedObject(t, manifests, "service.yaml", "Service", service) {
+				return
+			}
+			deployment := &appsv1.Deployment{}
+			if !renderedObject(t, manifests, "deployment.yaml", "Deployment", deployment) {
+				return
+			}
+			containers := deployment.Spec.Template.Spec.Containers
+			if test.tls == nil {
+				assert.Len(t, containers, 1)
+				assert.Len(t, service.Spec.Ports, 2)
+				return
+			}
+			if !assert.Len(t, containers, 2) {
+				return
+			}
+			assert.Contains(t, containers[1].Ports, corev1.ContainerPort{Name: "metrics", ContainerPort: 8082})
+			assert.Contains(t, service.Spec.Ports, corev1.ServicePort{
+				Name: "metrics", Protocol: corev1.ProtocolTCP, Port: 8082, TargetPort: intstr.FromInt32(8082),
+			})
+			config := &corev1.ConfigMap{}
+			if !renderedObject(t, manifests, "tls.yaml", "ConfigMap", config) {
+				return
+			}
+			nginx := config.Data["nginx.conf"]
+			metrics := nginx[strings.Index(nginx, "listen 8082;"):]
+			assert.Contains(t, metrics, "location = /metrics {\n      proxy_pass http://127.0.0.1:9001;\n    }")
+			assert.NotContains(t, metrics, "ssl")
+		})
+	}
+}
+
+func TestChartTLSWithoutMonitoring(t *testing.T) {
+	manifests := renderChart(t, map[string]interface{}{
+		"models_settings": map[string]interface{}{"model_name": "resnet", "model_path": "gs://models/resnet"},
+		"generated":       map[string]interface{}{"tls": map[string]interface{}{"server_secret": "sample-tls"}},
+	}, "monitoring.coreos.com/v1/ServiceMonitor")
+
+	assert.Empty(t, decodeManifests(t, manifests["ovms/templates/monitor.yaml"]))
+	service := &corev1.Service{}
+	if renderedObject(t, manifests, "service.yaml", "Service", service) {
+		assert.Len(t, service.Spec.Ports, 2)
+	}
+	assert.NotContains(t, manifests["ovms/templates/tls.yaml"], "listen 8082")
+}
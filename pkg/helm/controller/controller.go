@@ -42,6 +42,7 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	crthandler "sigs.k8s.io/controller-runtime/pkg/handler"
@@ -51,6 +52,10 @@ import (
 	"sigs.k8s.io/yaml"
 
 	"github.com/openvinotoolkit/operator/pkg/helm/release"
+	"github.com/openvinotoolkit/operator/pkg/imagepolicy"
+	"github.com/openvinotoolkit/operator/pkg/maintenance"
+	"github.com/openvinotoolkit/operator/pkg/modelregistry"
+	"github.com/openvinotoolkit/operator/pkg/ovms"
 	"github.com/openvinotoolkit/operator/pkg/util/k8sutil"
 	libhandler "github.com/operator-framework/operator-lib/handler"
 	"github.com/operator-framework/operator-lib/predicate"
@@ -69,6 +74,15 @@ type WatchOptions struct {
 	SuppressOverrideValues  bool
 	MaxConcurrentReconciles int
 	Selector                metav1.LabelSelector
+	ReleaseWait             ReleaseWaitOptions
+	ReleaseTest             ReleaseTestOptions
+	NodeFeatureLabels       map[string]string
+	ImagePolicy             *imagepolicy.Policy
+	MaintenanceWindow       *maintenance.Window
+	// ReferenceCache caches the metadata of the ConfigMaps and Secrets
+	// referenced by the ModelServers. It defaults to the cache of the manager,
+	// whose selectors must then not filter them out.
+	ReferenceCache cache.Cache
 }
 
 // Add creates a new helm operator controller and adds it to the manager
@@ -77,13 +91,38 @@ func Add(mgr manager.Manager, options WatchOptions) error {
 
 	r := &HelmOperatorReconciler{
 		Client:                 mgr.GetClient(),
+		APIReader:              mgr.GetAPIReader(),
 		EventRecorder:          mgr.GetEventRecorderFor(controllerName),
 		GVK:                    options.GVK,
 		ManagerFactory:         options.ManagerFactory,
 		ReconcilePeriod:        options.ReconcilePeriod,
 		OverrideValues:         options.OverrideValues,
 		SuppressOverrideValues: options.SuppressOverrideValues,
+		ReleaseWait:            options.ReleaseWait,
+		ReleaseTest:            options.ReleaseTest,
+		ModelClient:            ovms.NewClient(nil),
+		ModelChecks:            NewModelChecks(),
+		ModelRegistry:          modelregistry.NewMLflowResolver(nil),
+		NodeFeatureLabels:      options.NodeFeatureLabels,
+		MaintenanceWindow:      options.MaintenanceWindow,
 	}
+	if options.GVK.Kind == "ModelServer" {
+		// the NetworkPolicies of the model servers allow the operator to
+		// query them
+		ns, err := k8sutil.GetOperatorNamespace()
+		if err != nil {
+			log.Info("Model server NetworkPolicies do not allow ingress from the operator", "reason", err.Error())
+		}
+		r.OperatorNamespace = ns
+	}
+	var insecureRegistries []string
+	if options.ImagePolicy != nil {
+		r.ImagePolicy, r.ImageVerifier = imagePolicyFor(options.ImagePolicy)
+		if options.ImagePolicy.Verification != nil {
+			insecureRegistries = options.ImagePolicy.Verification.InsecureRegistries
+		}
+	}
+	r.ImageResolver = imagepolicy.NewRegistry(nil, insecureRegistries)
 
 	c, err := controller.New(controllerName, mgr, controller.Options{
 		Reconciler:              r,
@@ -103,6 +142,25 @@ func Add(mgr manager.Manager, options WatchOptions) error {
 		watchDependentResources(mgr, r, c)
 	}
 
+	if options.GVK.Kind == "ModelServer" {
+		referenceCache := options.ReferenceCache
+		if referenceCache == nil {
+			referenceCache = mgr.GetCache()
+		}
+		if err := watchReferencedResources(mgr, referenceCache, c, options.GVK); err != nil {
+			return err
+		}
+		if err := addIdleController(mgr, options.GVK); err != nil {
+			return err
+		}
+		if err := watchModelRepositories(mgr, c, options.GVK); err != nil {
+			return err
+		}
+		if err := addModelRepositoryControllers(mgr, options.GVK); err != nil {
+			return err
+		}
+	}
+
 	log.Info("Watching resource", "apiVersion", options.GVK.GroupVersion(), "kind",
 		options.GVK.Kind, "reconcilePeriod", options.ReconcilePeriod.String())
 	return nil
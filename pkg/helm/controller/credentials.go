@@ -0,0 +1,159 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+)
+
+// gcpCredentialsKey is the key of the Google Cloud credentials file in the
+// Secret named by models_repository.gcp_creds_secret_name.
+const gcpCredentialsKey = "gcp-creds.json"
+
+// deprecatedCredentialFields maps the model repository credentials passed in
+// clear text in the ModelServer spec to the secret references replacing them.
+var deprecatedCredentialFields = map[string]string{
+	"aws_access_key_id":               "aws_access_key_id_secret_ref",
+	"aws_secret_access_key":           "aws_secret_access_key_secret_ref",
+	"azure_storage_connection_string": "azure_storage_connection_string_secret_ref",
+}
+
+// workloadIdentityAnnotations maps the storage types to the service account
+// annotation binding it to a cloud identity.
+var workloadIdentityAnnotations = map[string]string{
+	"S3":     "eks.amazonaws.com/role-arn",
+	"google": "iam.gke.io/gcp-service-account",
+	"azure":  "azure.workload.identity/client-id",
+}
+
+// resolveRepositoryCredentials checks that the Secrets and the service
+// account referenced in the models_repository section of the ModelServer
+// values exist in the namespace and include the referenced keys.
+func (r HelmOperatorReconciler) resolveRepositoryCredentials(ctx context.Context, namespace string,
+	values map[string]interface{}) error {
+
+	repository, _, _ := unstructured.NestedMap(values, "models_repository")
+	for _, field := range sortedKeys(deprecatedCredentialFields) {
+		ref := deprecatedCredentialFields[field]
+		selector, found, err := unstructured.NestedStringMap(repository, ref)
+		if err != nil {
+			return fmt.Errorf("invalid models_repository.%s: %w", ref, err)
+		}
+		if !found {
+			continue
+		}
+		if plain, _, _ := unstructured.NestedString(repository, field); plain != "" {
+			return fmt.Errorf("models_repository.%s and %s cannot be set together", field, ref)
+		}
+		if selector["name"] == "" || selector["key"] == "" {
+			return fmt.Errorf("models_repository.%s requires name and key", ref)
+		}
+		if err := r.checkSecretKey(ctx, namespace, selector["name"], selector["key"]); err != nil {
+			return fmt.Errorf("invalid models_repository.%s: %w", ref, err)
+		}
+	}
+
+	if name, _, _ := unstructured.NestedString(repository, "gcp_creds_secret_name"); name != "" {
+		if err := r.checkSecretKey(ctx, namespace, name, gcpCredentialsKey); err != nil {
+			return fmt.Errorf("invalid models_repository.gcp_creds_secret_name: %w", err)
+		}
+	}
+
+	name, _, _ := unstructured.NestedString(repository, "workload_identity_service_account")
+	if name == "" {
+		return nil
+	}
+	sa := &corev1.ServiceAccount{}
+	if err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, sa); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("service account %q set in models_repository.workload_identity_service_account not found", name)
+		}
+		return err
+	}
+	storageType, _, _ := unstructured.NestedString(repository, "storage_type")
+	annotation, ok := workloadIdentityAnnotations[storageType]
+	if !ok {
+		return fmt.Errorf("workload identity is not supported with storage_type %q", storageType)
+	}
+	if sa.GetAnnotations()[annotation] == "" {
+		return fmt.Errorf("service account %q is not bound to a cloud identity: missing annotation %q", name, annotation)
+	}
+	return nil
+}
+
+func (r HelmOperatorReconciler) checkSecretKey(ctx context.Context, namespace, name, key string) error {
+	secret := &corev1.Secret{}
+	if err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("secret %q not found", name)
+		}
+		return err
+	}
+	if _, ok := secret.Data[key]; !ok {
+		return fmt.Errorf("secret %q does not include the key %q", name, key)
+	}
+	return nil
+}
+
+// warnDeprecatedValues sets the Deprecated condition when model repository
+// credentials are passed in clear text in the ModelServer spec, and records
+// a warning event when the condition is added or changed.
+func (r HelmOperatorReconciler) warnDeprecatedValues(o *unstructured.Unstructured, status *types.HelmAppStatus,
+	values map[string]interface{}) {
+
+	var messages []string
+	for _, field := range sortedKeys(deprecatedCredentialFields) {
+		if plain, _, _ := unstructured.NestedString(values, "models_repository", field); plain != "" {
+			messages = append(messages, fmt.Sprintf("models_repository.%s is deprecated, use models_repository.%s",
+				field, deprecatedCredentialFields[field]))
+		}
+	}
+	if len(messages) == 0 {
+		status.RemoveCondition(types.ConditionDeprecated)
+		return
+	}
+
+	message := strings.Join(messages, "; ")
+	if c := status.GetCondition(types.ConditionDeprecated); c == nil || c.Message != message {
+		r.EventRecorder.Event(o, "Warning", string(types.ReasonDeprecatedFieldsInUse), message)
+	}
+	status.SetCondition(types.HelmAppCondition{
+		Type:    types.ConditionDeprecated,
+		Status:  types.StatusTrue,
+		Reason:  types.ReasonDeprecatedFieldsInUse,
+		Message: message,
+	})
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,153 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+)
+
+func TestResolveRepositoryCredentials(t *testing.T) {
+	cl := fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "s3-creds"},
+			Data:       map[string][]byte{"access_key_id": []byte("id"), "secret_access_key": []byte("key")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "gcp-creds"},
+			Data:       map[string][]byte{"gcp-creds.json": []byte("{}")},
+		},
+		&corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "ovms", Annotations: map[string]string{
+				"iam.gke.io/gcp-service-account": "ovms@project.iam.gserviceaccount.com",
+			}},
+		},
+	).Build()
+	r := HelmOperatorReconciler{Client: cachedClient(cl), APIReader: cl}
+	ref := func(name, key string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "key": key}
+	}
+
+	tests := []struct {
+		name       string
+		repository map[string]interface{}
+		wantErr    string
+	}{
+		{
+			name:       "no credentials",
+			repository: map[string]interface{}{"storage_type": "google"},
+		},
+		{
+			name: "secret references",
+			repository: map[string]interface{}{
+				"storage_type":                     "S3",
+				"aws_access_key_id_secret_ref":     ref("s3-creds", "access_key_id"),
+				"aws_secret_access_key_secret_ref": ref("s3-creds", "secret_access_key"),
+			},
+		},
+		{
+			name:       "missing secret",
+			repository: map[string]interface{}{"aws_secret_access_key_secret_ref": ref("missing", "key")},
+			wantErr:    `invalid models_repository.aws_secret_access_key_secret_ref: secret "missing" not found`,
+		},
+		{
+			name:       "missing key",
+			repository: map[string]interface{}{"aws_secret_access_key_secret_ref": ref("s3-creds", "key")},
+			wantErr:    `invalid models_repository.aws_secret_access_key_secret_ref: secret "s3-creds" does not include the key "key"`,
+		},
+		{
+			name:       "incomplete reference",
+			repository: map[string]interface{}{"azure_storage_connection_string_secret_ref": ref("azure-creds", "")},
+			wantErr:    "models_repository.azure_storage_connection_string_secret_ref requires name and key",
+		},
+		{
+			name: "plain value and reference",
+			repository: map[string]interface{}{
+				"aws_access_key_id":            "id",
+				"aws_access_key_id_secret_ref": ref("s3-creds", "access_key_id"),
+			},
+			wantErr: "models_repository.aws_access_key_id and aws_access_key_id_secret_ref cannot be set together",
+		},
+		{
+			name:       "gcp credentials",
+			repository: map[string]interface{}{"gcp_creds_secret_name": "gcp-creds"},
+		},
+		{
+			name:       "gcp credentials without key",
+			repository: map[string]interface{}{"gcp_creds_secret_name": "s3-creds"},
+			wantErr:    `invalid models_repository.gcp_creds_secret_name: secret "s3-creds" does not include the key "gcp-creds.json"`,
+		},
+		{
+			name:       "workload identity",
+			repository: map[string]interface{}{"storage_type": "google", "workload_identity_service_account": "ovms"},
+		},
+		{
+			name:       "workload identity without binding",
+			repository: map[string]interface{}{"storage_type": "S3", "workload_identity_service_account": "ovms"},
+			wantErr:    `service account "ovms" is not bound to a cloud identity: missing annotation "eks.amazonaws.com/role-arn"`,
+		},
+		{
+			name:       "missing service account",
+			repository: map[string]interface{}{"storage_type": "google", "workload_identity_service_account": "missing"},
+			wantErr:    `service account "missing" set in models_repository.workload_identity_service_account not found`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := r.resolveRepositoryCredentials(context.TODO(), "ns",
+				map[string]interface{}{"models_repository": test.repository})
+			if test.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestWarnDeprecatedValues(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	r := HelmOperatorReconciler{EventRecorder: recorder}
+	o := &unstructured.Unstructured{}
+	status := &types.HelmAppStatus{}
+
+	values := map[string]interface{}{"models_repository": map[string]interface{}{
+		"aws_access_key_id":     "id",
+		"aws_secret_access_key": "key",
+	}}
+	r.warnDeprecatedValues(o, status, values)
+	r.warnDeprecatedValues(o, status, values)
+	condition := status.GetCondition(types.ConditionDeprecated)
+	assert.Equal(t, types.StatusTrue, condition.Status)
+	assert.Equal(t, "models_repository.aws_access_key_id is deprecated, use models_repository.aws_access_key_id_secret_ref; "+
+		"models_repository.aws_secret_access_key is deprecated, use models_repository.aws_secret_access_key_secret_ref",
+		condition.Message)
+	assert.Len(t, recorder.Events, 1)
+
+	r.warnDeprecatedValues(o, status, map[string]interface{}{})
+	assert.Nil(t, status.GetCondition(types.ConditionDeprecated))
+}
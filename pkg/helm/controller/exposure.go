@@ -0,0 +1,308 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	rpb "helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/manifestutil"
+)
+
+// Exposure types of the exposure section of the ModelServer values. Auto
+// selects the first API reported by the discovery among Route, Gateway and
+// Ingress.
+const (
+	exposureAuto    = "Auto"
+	exposureIngress = "Ingress"
+	exposureRoute   = "Route"
+	exposureGateway = "Gateway"
+)
+
+var (
+	ingressGVK     = schema.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}
+	routeGVK       = schema.GroupVersionKind{Group: "route.openshift.io", Version: "v1", Kind: "Route"}
+	httpRouteGVK   = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "HTTPRoute"}
+	grpcRouteGVK   = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "GRPCRoute"}
+	gatewayGVK     = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "Gateway"}
+	certificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+	// exposureAPIs are the APIs required by each exposure type, in the
+	// order of preference of the Auto type.
+	exposureAPIs = []struct {
+		exposure string
+		gvk      schema.GroupVersionKind
+	}{
+		{exposureRoute, routeGVK},
+		{exposureGateway, httpRouteGVK},
+		{exposureIngress, ingressGVK},
+	}
+)
+
+// apiAvailable reports whether the API server serves the kind, based on the
+// discovery information of the client.
+func (r HelmOperatorReconciler) apiAvailable(gvk schema.GroupVersionKind) bool {
+	_, err := r.Client.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	return err == nil
+}
+
+// certificateSecretName is the name of the Secret issued by cert-manager for
+// the exposure of the ModelServer.
+func certificateSecretName(name string) string {
+	return name + "-tls"
+}
+
+// exposureSecrets returns the names of the TLS Secrets used by the exposure
+// of the ModelServer, whose changes are reconciled.
+func exposureSecrets(name string, values map[string]interface{}) []string {
+	var secrets []string
+	if secret, _, _ := unstructured.NestedString(values, "exposure", "tls", "secret_name"); secret != "" {
+		secrets = append(secrets, secret)
+	}
+	if issuer, _, _ := unstructured.NestedString(values, "exposure", "tls", "cert_manager_issuer", "name"); issuer != "" {
+		secrets = append(secrets, certificateSecretName(name))
+	}
+	return secrets
+}
+
+// renderExposure resolves the exposure type of the ModelServer and the TLS
+// settings of the generated Ingress, Route or Gateway API routes.
+func (r HelmOperatorReconciler) renderExposure(ctx context.Context, o *unstructured.Unstructured,
+	values, generated map[string]interface{}) error {
+
+	exposure, _, _ := unstructured.NestedMap(values, "exposure")
+	kind, _ := exposure["type"].(string)
+	if kind == "" {
+		return nil
+	}
+	resolved := ""
+	for _, api := range exposureAPIs {
+		if kind != exposureAuto && kind != api.exposure {
+			continue
+		}
+		if r.apiAvailable(api.gvk) {
+			resolved = api.exposure
+			break
+		}
+		if kind != exposureAuto {
+			return fmt.Errorf("exposure type %s requires the %s API, which is not available", kind, api.gvk.GroupVersion())
+		}
+	}
+	if resolved == "" {
+		if kind != exposureAuto {
+			return fmt.Errorf("invalid exposure.type %q, expected Auto, Ingress, Route or Gateway", kind)
+		}
+		return errors.New("exposure type Auto found none of the Route, Gateway API or Ingress APIs")
+	}
+
+	host, _ := exposure["host"].(string)
+	grpcHost, _ := exposure["grpc_host"].(string)
+	if host == "" && resolved != exposureRoute {
+		return fmt.Errorf("exposure.host is required with the %s exposure type", resolved)
+	}
+	if grpcHost != "" && resolved == exposureGateway && !r.apiAvailable(grpcRouteGVK) {
+		return fmt.Errorf("exposure.grpc_host requires the %s GRPCRoute API, which is not available",
+			grpcRouteGVK.GroupVersion())
+	}
+	if grpcHost != "" && resolved != exposureGateway {
+		return errors.New("exposure.grpc_host is supported only with the Gateway exposure type")
+	}
+	out := map[string]interface{}{"type": resolved, "scheme": "http"}
+
+	tls, _, _ := unstructured.NestedMap(exposure, "tls")
+	secret, _ := tls["secret_name"].(string)
+	issuer, _, _ := unstructured.NestedString(tls, "cert_manager_issuer", "name")
+	if enabled, _ := tls["enabled"].(bool); enabled || secret != "" || issuer != "" {
+		if secret != "" && issuer != "" {
+			return errors.New("exposure.tls.secret_name and cert_manager_issuer cannot be used together")
+		}
+		if resolved == exposureGateway && (secret != "" || issuer != "") {
+			return errors.New("with the Gateway exposure type, TLS is configured on the listener of the Gateway")
+		}
+		if issuer != "" {
+			if !r.apiAvailable(certificateGVK) {
+				return fmt.Errorf("exposure.tls.cert_manager_issuer requires the %s API, which is not available",
+					certificateGVK.GroupVersion())
+			}
+			if host == "" {
+				return errors.New("exposure.tls.cert_manager_issuer requires exposure.host")
+			}
+			secret = certificateSecretName(o.GetName())
+			out["certificate_secret"] = secret
+		}
+		out["scheme"] = "https"
+		out["tls_secret"] = secret
+		if resolved == exposureRoute && secret != "" {
+			// Routes embed the certificate instead of referencing a Secret
+			certificate, err := r.routeCertificate(ctx, o.GetNamespace(), secret, issuer == "")
+			if err != nil {
+				return err
+			}
+			if certificate != nil {
+				out["route_certificate"] = certificate
+			}
+		}
+	}
+	if resolved == exposureRoute && generated["tls"] != nil {
+		// the Route re-encrypts the traffic to the TLS port of the model
+		// server
+		out["scheme"] = "https"
+	}
+	if resolved == exposureGateway {
+		scheme, err := r.gatewayScheme(ctx, o.GetNamespace(), exposure)
+		if err != nil {
+			return err
+		}
+		out["scheme"] = scheme
+	}
+	generated["exposure"] = out
+	return nil
+}
+
+// routeCertificate returns the certificate, key and CA certificate of the
+// TLS Secret in the format of the Route tls settings. A missing Secret is an
+// error if required, otherwise the Route uses the default certificate of
+// the router until the Secret is issued.
+func (r HelmOperatorReconciler) routeCertificate(ctx context.Context, namespace, name string,
+	required bool) (map[string]interface{}, error) {
+
+	secret := &corev1.Secret{}
+	found, err := r.getReferenced(ctx, namespace, name, secret)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		if required {
+			return nil, fmt.Errorf("TLS secret %q not found", name)
+		}
+		return nil, nil
+	}
+	cert, key := secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]
+	if len(cert) == 0 || len(key) == 0 {
+		if required {
+			return nil, fmt.Errorf("TLS secret %q must include the keys %s and %s", name,
+				corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+		}
+		return nil, nil
+	}
+	certificate := map[string]interface{}{"certificate": string(cert), "key": string(key)}
+	if ca := secret.Data["ca.crt"]; len(ca) > 0 {
+		certificate["ca_certificate"] = string(ca)
+	}
+	return certificate, nil
+}
+
+// gatewayScheme returns https if the listener of the parent Gateway used by
+// the routes terminates TLS.
+func (r HelmOperatorReconciler) gatewayScheme(ctx context.Context, namespace string,
+	exposure map[string]interface{}) (string, error) {
+
+	name, _, _ := unstructured.NestedString(exposure, "gateway", "name")
+	if name == "" {
+		return "", errors.New("exposure.gateway.name is required with the Gateway exposure type")
+	}
+	if ns, _, _ := unstructured.NestedString(exposure, "gateway", "namespace"); ns != "" {
+		namespace = ns
+	}
+	section, _, _ := unstructured.NestedString(exposure, "gateway", "section_name")
+
+	gateway := &unstructured.Unstructured{}
+	gateway.SetGroupVersionKind(gatewayGVK)
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, gateway); err != nil {
+		// the Gateway may be managed in a namespace the operator cannot read
+		log.V(1).Info("Failed to get the parent Gateway", "namespace", namespace, "name", name, "error", err.Error())
+		return "http", nil
+	}
+	listeners, _, _ := unstructured.NestedSlice(gateway.Object, "spec", "listeners")
+	for _, l := range listeners {
+		listener, _ := l.(map[string]interface{})
+		if section != "" && listener["name"] != section {
+			continue
+		}
+		if listener["protocol"] == "HTTPS" {
+			return "https", nil
+		}
+	}
+	return "http", nil
+}
+
+// exposureURLs returns the external URLs of the REST and gRPC APIs of the
+// ModelServer, or empty strings if it is not exposed. The host of a Route
+// without a configured host is read from the Route status.
+func (r HelmOperatorReconciler) exposureURLs(ctx context.Context, rel *rpb.Release,
+	values map[string]interface{}) (string, string) {
+
+	exposure, _, _ := unstructured.NestedMap(values, generatedValuesKey, "exposure")
+	if exposure == nil {
+		return "", ""
+	}
+	scheme, _ := exposure["scheme"].(string)
+	host, _, _ := unstructured.NestedString(values, "exposure", "host")
+	path, _, _ := unstructured.NestedString(values, "exposure", "path")
+	if path == "/" {
+		path = ""
+	}
+	if host == "" && exposure["type"] == exposureRoute {
+		host = r.routeHost(ctx, rel)
+	}
+	url := ""
+	if host != "" {
+		url = fmt.Sprintf("%s://%s%s", scheme, host, path)
+	}
+	grpcURL := ""
+	if grpcHost, _, _ := unstructured.NestedString(values, "exposure", "grpc_host"); grpcHost != "" {
+		port := "80"
+		if scheme == "https" {
+			port = "443"
+		}
+		grpcURL = fmt.Sprintf("%s:%s", grpcHost, port)
+	}
+	return url, grpcURL
+}
+
+func (r HelmOperatorReconciler) routeHost(ctx context.Context, rel *rpb.Release) string {
+	routes, err := manifestutil.ObjectsOfKind(rel.Manifest, routeGVK.Kind)
+	if err != nil || len(routes) == 0 {
+		return ""
+	}
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(routeGVK)
+	key := client.ObjectKey{Namespace: rel.Namespace, Name: routes[0].GetName()}
+	if err := r.Client.Get(ctx, key, route); err != nil {
+		if !meta.IsNoMatchError(err) {
+			log.V(1).Info("Failed to get the Route", "name", key.Name, "error", err.Error())
+		}
+		return ""
+	}
+	ingress, _, _ := unstructured.NestedSlice(route.Object, "status", "ingress")
+	for _, i := range ingress {
+		status, _ := i.(map[string]interface{})
+		if host, _, _ := unstructured.NestedString(status, "host"); host != "" {
+			return host
+		}
+	}
+	host, _, _ := unstructured.NestedString(route.Object, "spec", "host")
+	return host
+}
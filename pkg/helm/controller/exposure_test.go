@@ -0,0 +1,265 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rpb "helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// exposureReconciler returns a reconciler whose client serves the passed
+// kinds, in addition to Secrets.
+func exposureReconciler(objects []client.Object, gvks ...schema.GroupVersionKind) HelmOperatorReconciler {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Secret"), meta.RESTScopeNamespace)
+	for _, gvk := range gvks {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+	cl := fake.NewClientBuilder().WithRESTMapper(mapper).WithObjects(objects...).Build()
+	return HelmOperatorReconciler{Client: cachedClient(cl), APIReader: cl, GVK: schema.GroupVersionKind{Kind: "ModelServer"}}
+}
+
+func TestRenderExposure(t *testing.T) {
+	tlsSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "ovms-cert"},
+		Data:       map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key")},
+	}
+	gateway := &unstructured.Unstructured{}
+	gateway.SetGroupVersionKind(gatewayGVK)
+	gateway.SetNamespace("infra")
+	gateway.SetName("public")
+	_ = unstructured.SetNestedSlice(gateway.Object, []interface{}{
+		map[string]interface{}{"name": "http", "protocol": "HTTP"},
+		map[string]interface{}{"name": "https", "protocol": "HTTPS"},
+	}, "spec", "listeners")
+
+	tests := []struct {
+		name     string
+		gvks     []schema.GroupVersionKind
+		exposure map[string]interface{}
+		want     map[string]interface{}
+		wantErr  string
+	}{
+		{
+			name:     "disabled",
+			gvks:     []schema.GroupVersionKind{ingressGVK},
+			exposure: map[string]interface{}{"type": ""},
+		},
+		{
+			name:     "auto prefers routes",
+			gvks:     []schema.GroupVersionKind{ingressGVK, routeGVK},
+			exposure: map[string]interface{}{"type": "Auto"},
+			want:     map[string]interface{}{"type": "Route", "scheme": "http"},
+		},
+		{
+			name:     "auto without APIs",
+			exposure: map[string]interface{}{"type": "Auto", "host": "ovms.example.com"},
+			wantErr:  "exposure type Auto found none of the Route, Gateway API or Ingress APIs",
+		},
+		{
+			name:     "missing API",
+			gvks:     []schema.GroupVersionKind{ingressGVK},
+			exposure: map[string]interface{}{"type": "Route"},
+			wantErr:  "exposure type Route requires the route.openshift.io/v1 API, which is not available",
+		},
+		{
+			name:     "invalid type",
+			gvks:     []schema.GroupVersionKind{ingressGVK},
+			exposure: map[string]interface{}{"type": "LoadBalancer"},
+			wantErr:  `invalid exposure.type "LoadBalancer", expected Auto, Ingress, Route or Gateway`,
+		},
+		{
+			name:     "ingress without host",
+			gvks:     []schema.GroupVersionKind{ingressGVK},
+			exposure: map[string]interface{}{"type": "Ingress"},
+			wantErr:  "exposure.host is required with the Ingress exposure type",
+		},
+		{
+			name: "ingress with TLS secret",
+			gvks: []schema.GroupVersionKind{ingressGVK},
+			exposure: map[string]interface{}{"type": "Ingress", "host": "ovms.example.com",
+				"tls": map[string]interface{}{"enabled": true, "secret_name": "ovms-cert"}},
+			want: map[string]interface{}{"type": "Ingress", "scheme": "https", "tls_secret": "ovms-cert"},
+		},
+		{
+			name: "ingress with gRPC host",
+			gvks: []schema.GroupVersionKind{ingressGVK},
+			exposure: map[string]interface{}{"type": "Ingress", "host": "ovms.example.com",
+				"grpc_host": "grpc.example.com"},
+			wantErr: "exposure.grpc_host is supported only with the Gateway exposure type",
+		},
+		{
+			name: "issuer without cert-manager",
+			gvks: []schema.GroupVersionKind{ingressGVK},
+			exposure: map[string]interface{}{"type": "Ingress", "host": "ovms.example.com",
+				"tls": map[string]interface{}{"cert_manager_issuer": map[string]interface{}{"name": "ca"}}},
+			wantErr: "exposure.tls.cert_manager_issuer requires the cert-manager.io/v1 API, which is not available",
+		},
+		{
+			name: "issuer with secret",
+			gvks: []schema.GroupVersionKind{ingressGVK, certificateGVK},
+			exposure: map[string]interface{}{"type": "Ingress", "host": "ovms.example.com",
+				"tls": map[string]interface{}{"secret_name": "ovms-cert",
+					"cert_manager_issuer": map[string]interface{}{"name": "ca"}}},
+			wantErr: "exposure.tls.secret_name and cert_manager_issuer cannot be used together",
+		},
+		{
+			name: "ingress with issuer",
+			gvks: []schema.GroupVersionKind{ingressGVK, certificateGVK},
+			exposure: map[string]interface{}{"type": "Ingress", "host": "ovms.example.com",
+				"tls": map[string]interface{}{"cert_manager_issuer": map[string]interface{}{"name": "ca"}}},
+			want: map[string]interface{}{"type": "Ingress", "scheme": "https",
+				"tls_secret": "sample-tls", "certificate_secret": "sample-tls"},
+		},
+		{
+			name: "route with TLS secret",
+			gvks: []schema.GroupVersionKind{routeGVK},
+			exposure: map[string]interface{}{"type": "Route",
+				"tls": map[string]interface{}{"enabled": true, "secret_name": "ovms-cert"}},
+			want: map[string]interface{}{"type": "Route", "scheme": "https", "tls_secret": "ovms-cert",
+				"route_certificate": map[string]interface{}{"certificate": "cert", "key": "key"}},
+		},
+		{
+			name: "route with missing TLS secret",
+			gvks: []schema.GroupVersionKind{routeGVK},
+			exposure: map[string]interface{}{"type": "Route",
+				"tls": map[string]interface{}{"enabled": true, "secret_name": "missing"}},
+			wantErr: `TLS secret "missing" not found`,
+		},
+		{
+			name: "route with issuer not issued yet",
+			gvks: []schema.GroupVersionKind{routeGVK, certificateGVK},
+			exposure: map[string]interface{}{"type": "Route", "host": "ovms.example.com",
+				"tls": map[string]interface{}{"cert_manager_issuer": map[string]interface{}{"name": "ca"}}},
+			want: map[string]interface{}{"type": "Route", "scheme": "https",
+				"tls_secret": "sample-tls", "certificate_secret": "sample-tls"},
+		},
+		{
+			name:     "gateway without name",
+			gvks:     []schema.GroupVersionKind{httpRouteGVK},
+			exposure: map[string]interface{}{"type": "Gateway", "host": "ovms.example.com"},
+			wantErr:  "exposure.gateway.name is required with the Gateway exposure type",
+		},
+		{
+			name: "gateway with HTTPS listener",
+			gvks: []schema.GroupVersionKind{httpRouteGVK, grpcRouteGVK, gatewayGVK},
+			exposure: map[string]interface{}{"type": "Gateway", "host": "ovms.example.com",
+				"grpc_host": "grpc.example.com",
+				"gateway":   map[string]interface{}{"name": "public", "namespace": "infra", "section_name": "https"}},
+			want: map[string]interface{}{"type": "Gateway", "scheme": "https"},
+		},
+		{
+			name: "gateway with HTTP listener",
+			gvks: []schema.GroupVersionKind{httpRouteGVK, gatewayGVK},
+			exposure: map[string]interface{}{"type": "Gateway", "host": "ovms.example.com",
+				"gateway": map[string]interface{}{"name": "public", "namespace": "infra", "section_name": "http"}},
+			want: map[string]interface{}{"type": "Gateway", "scheme": "http"},
+		},
+		{
+			name: "gateway without GRPCRoute",
+			gvks: []schema.GroupVersionKind{httpRouteGVK, gatewayGVK},
+			exposure: map[string]interface{}{"type": "Gateway", "host": "ovms.example.com",
+				"grpc_host": "grpc.example.com", "gateway": map[string]interface{}{"name": "public"}},
+			wantErr: "exposure.grpc_host requires the gateway.networking.k8s.io/v1 GRPCRoute API, which is not available",
+		},
+		{
+			name: "gateway with TLS secret",
+			gvks: []schema.GroupVersionKind{httpRouteGVK, gatewayGVK},
+			exposure: map[string]interface{}{"type": "Gateway", "host": "ovms.example.com",
+				"gateway": map[string]interface{}{"name": "public"},
+				"tls":     map[string]interface{}{"secret_name": "ovms-cert"}},
+			wantErr: "with the Gateway exposure type, TLS is configured on the listener of the Gateway",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := exposureReconciler([]client.Object{tlsSecret, gateway}, tt.gvks...)
+			generated := map[string]interface{}{}
+			err := r.renderExposure(context.TODO(), testModelServer("ns"),
+				map[string]interface{}{"exposure": tt.exposure}, generated)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			if tt.want == nil {
+				assert.NotContains(t, generated, "exposure")
+				return
+			}
+			assert.Equal(t, tt.want, generated["exposure"])
+		})
+	}
+}
+
+func TestExposureSecrets(t *testing.T) {
+	assert.Empty(t, exposureSecrets("sample", map[string]interface{}{}))
+	assert.Equal(t, []string{"ovms-cert"}, exposureSecrets("sample", map[string]interface{}{
+		"exposure": map[string]interface{}{"tls": map[string]interface{}{"secret_name": "ovms-cert"}},
+	}))
+	assert.Equal(t, []string{"sample-tls"}, exposureSecrets("sample", map[string]interface{}{
+		"exposure": map[string]interface{}{"tls": map[string]interface{}{
+			"cert_manager_issuer": map[string]interface{}{"name": "ca"},
+		}},
+	}))
+}
+
+func TestExposureURLs(t *testing.T) {
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(routeGVK)
+	route.SetNamespace("ns")
+	route.SetName("sample-ovms")
+	_ = unstructured.SetNestedSlice(route.Object, []interface{}{
+		map[string]interface{}{"host": "sample-ovms-ns.apps.example.com"},
+	}, "status", "ingress")
+	r := exposureReconciler([]client.Object{route}, routeGVK)
+	rel := &rpb.Release{Namespace: "ns", Manifest: `---
+# Source: ovms/templates/exposure.yaml
+apiVersion: route.openshift.io/v1
+kind: Route
+metadata:
+  name: sample-ovms
+`}
+
+	url, grpcURL := r.exposureURLs(context.TODO(), rel, map[string]interface{}{})
+	assert.Empty(t, url)
+	assert.Empty(t, grpcURL)
+
+	url, grpcURL = r.exposureURLs(context.TODO(), rel, map[string]interface{}{
+		"exposure":         map[string]interface{}{"path": "/"},
+		generatedValuesKey: map[string]interface{}{"exposure": map[string]interface{}{"type": "Route", "scheme": "https"}},
+	})
+	assert.Equal(t, "https://sample-ovms-ns.apps.example.com", url)
+	assert.Empty(t, grpcURL)
+
+	url, grpcURL = r.exposureURLs(context.TODO(), rel, map[string]interface{}{
+		"exposure": map[string]interface{}{"host": "ovms.example.com", "path": "/ovms",
+			"grpc_host": "grpc.example.com"},
+		generatedValuesKey: map[string]interface{}{"exposure": map[string]interface{}{"type": "Gateway", "scheme": "http"}},
+	})
+	assert.Equal(t, "http://ovms.example.com/ovms", url)
+	assert.Equal(t, "grpc.example.com:80", grpcURL)
+}
@@ -0,0 +1,373 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	rpb "helm.sh/helm/v3/pkg/release"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	crpredicate "sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/ovms"
+)
+
+const (
+	// wakeUpAnnotation scales an idle ModelServer back up. The annotation
+	// is removed once the ModelServer is woken up.
+	wakeUpAnnotation = "intel.com/wake-up"
+
+	// idleCheckPeriod is how often the request counters are checked.
+	idleCheckPeriod   = 30 * time.Second
+	defaultIdlePeriod = time.Hour
+
+	// activatorTrack labels the Deployment and Service of the activator,
+	// which receives the traffic while the model server is scaled to zero.
+	activatorTrack          = "activator"
+	activatorStatusPortName = "status"
+)
+
+// IdlePolicy scales a ModelServer to zero replicas when it serves no
+// inference requests, set in the idle_policy section of the values.
+type IdlePolicy struct {
+	Enabled bool
+	// IdlePeriod is the time without requests before the model server is
+	// scaled to zero.
+	IdlePeriod time.Duration
+}
+
+func idlePolicyFor(values map[string]interface{}) (IdlePolicy, error) {
+	p := IdlePolicy{IdlePeriod: defaultIdlePeriod}
+	p.Enabled, _, _ = unstructured.NestedBool(values, "idle_policy", "enabled")
+	if period, _, _ := unstructured.NestedString(values, "idle_policy", "idle_period"); period != "" {
+		d, err := time.ParseDuration(period)
+		if err != nil || d <= 0 {
+			return p, fmt.Errorf("invalid idle_policy.idle_period %q", period)
+		}
+		p.IdlePeriod = d
+	}
+	return p, nil
+}
+
+// requestCounters are the counters of the model server metrics summed to
+// detect inference requests.
+var requestCounters = []string{"ovms_requests_success", "ovms_requests_fail"}
+
+// enableRequestMetrics enables the model server metrics required by the idle
+// policy, including the request counters if the metrics are filtered.
+func enableRequestMetrics(values map[string]interface{}) {
+	monitoring := map[string]interface{}{}
+	if current, ok := values["monitoring"].(map[string]interface{}); ok {
+		for k, v := range current {
+			monitoring[k] = v
+		}
+	}
+	monitoring["metrics_enable"] = true
+	if list, _ := monitoring["metrics_list"].(string); list != "" {
+		for _, name := range requestCounters {
+			if !strings.Contains(","+strings.ReplaceAll(list, " ", "")+",", ","+name+",") {
+				list += "," + name
+			}
+		}
+		monitoring["metrics_list"] = list
+	}
+	values["monitoring"] = monitoring
+}
+
+// idleActivity is the last observed state of the request counters of a
+// ModelServer. It is kept in memory, so a restart of the operator restarts
+// the idle period.
+type idleActivity struct {
+	requests          int64
+	activatorRequests int64
+	lastActivity      time.Time
+}
+
+// IdleReconciler implements the idle policy of ModelServers alongside the
+// Helm reconciler. It polls the request counters of the model server and
+// sets the Idle condition when none were served for the idle period. The
+// Helm reconciler then scales the Deployment to zero and routes the Service
+// to the activator. Requests received by the activator, or the
+// intel.com/wake-up annotation, clear the condition.
+type IdleReconciler struct {
+	Client client.Client
+	// APIReader reads the TLS Secrets, which are not cached.
+	APIReader     client.Reader
+	EventRecorder record.EventRecorder
+	GVK           schema.GroupVersionKind
+	ModelClient   ovms.Client
+	// ActivatorRequests returns the number of requests served by the
+	// activator at the endpoint.
+	ActivatorRequests func(ctx context.Context, endpoint string) (int64, error)
+
+	mu       sync.Mutex
+	activity map[apitypes.NamespacedName]*idleActivity
+}
+
+var _ reconcile.Reconciler = &IdleReconciler{}
+
+// addIdleController adds the controller of the idle policy to the manager.
+func addIdleController(mgr manager.Manager, gvk schema.GroupVersionKind) error {
+	controllerName := fmt.Sprintf("%v-idle-controller", strings.ToLower(gvk.Kind))
+	r := &IdleReconciler{
+		Client:        mgr.GetClient(),
+		APIReader:     mgr.GetAPIReader(),
+		EventRecorder: mgr.GetEventRecorderFor(controllerName),
+		GVK:           gvk,
+		ModelClient:   ovms.NewClient(nil),
+	}
+	c, err := controller.New(controllerName, mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+	o := &unstructured.Unstructured{}
+	o.SetGroupVersionKind(gvk)
+	// status updates are ignored, the counters are polled periodically
+	return c.Watch(source.Kind(mgr.GetCache(), client.Object(o), &handler.EnqueueRequestForObject{},
+		crpredicate.Or[client.Object](crpredicate.GenerationChangedPredicate{}, crpredicate.AnnotationChangedPredicate{})))
+}
+
+// Reconcile checks the request counters of a ModelServer with an idle policy.
+func (r *IdleReconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	o := &unstructured.Unstructured{}
+	o.SetGroupVersionKind(r.GVK)
+	if err := r.Client.Get(ctx, request.NamespacedName, o); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.forget(request.NamespacedName)
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	if o.GetDeletionTimestamp() != nil {
+		r.forget(request.NamespacedName)
+		return reconcile.Result{}, nil
+	}
+
+	status := types.StatusFor(o)
+	spec, _, _ := unstructured.NestedMap(o.Object, "spec")
+	policy, err := idlePolicyFor(spec)
+	if err != nil || !policy.Enabled {
+		// invalid policies are reported by the Helm reconciler
+		r.forget(request.NamespacedName)
+		if status.GetCondition(types.ConditionIdle) != nil {
+			status.RemoveCondition(types.ConditionIdle)
+			return reconcile.Result{}, r.updateStatus(ctx, o, status)
+		}
+		return reconcile.Result{}, nil
+	}
+	if status.DeployedRelease == nil {
+		return reconcile.Result{RequeueAfter: idleCheckPeriod}, nil
+	}
+
+	_, wakeUp := o.GetAnnotations()[wakeUpAnnotation]
+	rel := &rpb.Release{Namespace: o.GetNamespace(), Manifest: status.DeployedRelease.Manifest}
+	if r.checkActivity(ctx, o, status, rel, policy, wakeUp, time.Now()) {
+		if err := r.updateStatus(ctx, o, status); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	if wakeUp {
+		annotations := o.GetAnnotations()
+		delete(annotations, wakeUpAnnotation)
+		o.SetAnnotations(annotations)
+		if err := r.Client.Update(ctx, o); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	return reconcile.Result{RequeueAfter: idleCheckPeriod}, nil
+}
+
+// checkActivity updates the Idle condition from the request counters. It
+// returns true if the status changed.
+func (r *IdleReconciler) checkActivity(ctx context.Context, o *unstructured.Unstructured, status *types.HelmAppStatus,
+	rel *rpb.Release, policy IdlePolicy, wakeUp bool, now time.Time) bool {
+
+	key := apitypes.NamespacedName{Namespace: o.GetNamespace(), Name: o.GetName()}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.activity == nil {
+		r.activity = map[apitypes.NamespacedName]*idleActivity{}
+	}
+	a, ok := r.activity[key]
+	if !ok {
+		a = &idleActivity{requests: -1, activatorRequests: -1, lastActivity: now}
+		r.activity[key] = a
+	}
+
+	if status.IsIdle() {
+		if wakeUp {
+			return r.wakeUp(o, status, a, types.ReasonWakeUpRequested, "Wake-up requested with the "+wakeUpAnnotation+" annotation", now)
+		}
+		endpoint, err := servicePortEndpoint(rel, activatorTrack, activatorStatusPortName)
+		if err != nil {
+			return false
+		}
+		requests, err := r.activatorRequests(ctx, endpoint)
+		if err != nil {
+			log.V(1).Info("Failed to get activator requests", "name", o.GetName(), "error", err.Error())
+			return false
+		}
+		// each check is counted as a request by the activator
+		received := a.activatorRequests >= 0 && requests > a.activatorRequests+1
+		a.activatorRequests = requests
+		if received {
+			return r.wakeUp(o, status, a, types.ReasonRequestsReceived, "Requests received by the activator", now)
+		}
+		return false
+	}
+
+	a.activatorRequests = -1
+	if wakeUp {
+		a.lastActivity = now
+	}
+	endpoint, err := modelServerEndpoint(rel)
+	if err != nil {
+		return false
+	}
+	var metrics map[string]float64
+	client, err := modelClientFor(ctx, r.APIReader, r.ModelClient, rel.Namespace, status.TLS)
+	if err == nil {
+		metrics, err = client.Metrics(ctx, endpoint)
+	}
+	if err != nil {
+		// the model server is not available, so it cannot be idle
+		log.V(1).Info("Failed to get model server metrics", "name", o.GetName(), "error", err.Error())
+		a.lastActivity = now
+		return false
+	}
+	var requests int64
+	for _, name := range requestCounters {
+		requests += int64(metrics[name])
+	}
+	if requests != a.requests {
+		a.requests = requests
+		a.lastActivity = now
+	}
+	if now.Sub(a.lastActivity) < policy.IdlePeriod || status.Rollout.Active() {
+		if c := status.GetCondition(types.ConditionIdle); c == nil {
+			status.SetCondition(types.HelmAppCondition{
+				Type:   types.ConditionIdle,
+				Status: types.StatusFalse,
+				Reason: types.ReasonRequestsReceived,
+			})
+			return true
+		}
+		return false
+	}
+
+	message := fmt.Sprintf("No inference requests since %s", a.lastActivity.UTC().Format(time.RFC3339))
+	status.SetCondition(types.HelmAppCondition{
+		Type:    types.ConditionIdle,
+		Status:  types.StatusTrue,
+		Reason:  types.ReasonNoRequests,
+		Message: message,
+	})
+	r.EventRecorder.Event(o, "Normal", "ScaledToZero", message)
+	return true
+}
+
+func (r *IdleReconciler) wakeUp(o *unstructured.Unstructured, status *types.HelmAppStatus, a *idleActivity,
+	reason types.HelmAppConditionReason, message string, now time.Time) bool {
+
+	a.requests = -1
+	a.activatorRequests = -1
+	a.lastActivity = now
+	status.SetCondition(types.HelmAppCondition{
+		Type:    types.ConditionIdle,
+		Status:  types.StatusFalse,
+		Reason:  reason,
+		Message: message,
+	})
+	r.EventRecorder.Event(o, "Normal", "ScaledUp", message)
+	return true
+}
+
+func (r *IdleReconciler) forget(key apitypes.NamespacedName) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.activity, key)
+}
+
+func (r *IdleReconciler) updateStatus(ctx context.Context, o *unstructured.Unstructured, status *types.HelmAppStatus) error {
+	m, err := status.ToMap()
+	if err != nil {
+		return err
+	}
+	o.Object["status"] = m
+	return r.Client.Status().Update(ctx, o)
+}
+
+func (r *IdleReconciler) activatorRequests(ctx context.Context, endpoint string) (int64, error) {
+	if r.ActivatorRequests != nil {
+		return r.ActivatorRequests(ctx, endpoint)
+	}
+	return activatorRequests(ctx, http.DefaultClient, endpoint)
+}
+
+// activatorRequests returns the number of requests handled by the activator,
+// read from its nginx stub_status page.
+func activatorRequests(ctx context.Context, httpClient *http.Client, endpoint string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/status", nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get activator status: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected response to activator status request: %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	// Active connections: 1
+	// server accepts handled requests
+	//  16 16 18
+	lines := strings.Split(string(body), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "server accepts handled requests") && i+1 < len(lines) {
+			fields := strings.Fields(lines[i+1])
+			if len(fields) == 3 {
+				return strconv.ParseInt(fields[2], 10, 64)
+			}
+		}
+	}
+	return 0, fmt.Errorf("unexpected activator status %q", string(body))
+}
@@ -0,0 +1,185 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	rpb "helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+)
+
+const testActivatorManifest = testModelServerManifest + `---
+# Source: ovms/templates/activator.yaml
+kind: Service
+apiVersion: v1
+metadata:
+  name: sample-ovms-activator
+  labels:
+    track: activator
+spec:
+  ports:
+    - port: 8082
+      name: status
+`
+
+func TestIdlePolicyFor(t *testing.T) {
+	p, err := idlePolicyFor(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, IdlePolicy{IdlePeriod: defaultIdlePeriod}, p)
+
+	p, err = idlePolicyFor(map[string]interface{}{
+		"idle_policy": map[string]interface{}{"enabled": true, "idle_period": "30m"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, IdlePolicy{Enabled: true, IdlePeriod: 30 * time.Minute}, p)
+
+	_, err = idlePolicyFor(map[string]interface{}{"idle_policy": map[string]interface{}{"idle_period": "0s"}})
+	assert.EqualError(t, err, `invalid idle_policy.idle_period "0s"`)
+}
+
+func TestEnableRequestMetrics(t *testing.T) {
+	monitoring := map[string]interface{}{"metrics_enable": false, "metrics_list": "ovms_requests_success, ovms_streams"}
+	values := map[string]interface{}{"monitoring": monitoring}
+	enableRequestMetrics(values)
+	assert.Equal(t, map[string]interface{}{
+		"metrics_enable": true,
+		"metrics_list":   "ovms_requests_success, ovms_streams,ovms_requests_fail",
+	}, values["monitoring"])
+	assert.Equal(t, false, monitoring["metrics_enable"], "the values of the custom resource are not modified")
+
+	values = map[string]interface{}{}
+	enableRequestMetrics(values)
+	assert.Equal(t, map[string]interface{}{"metrics_enable": true}, values["monitoring"])
+}
+
+func TestCheckActivity(t *testing.T) {
+	client := &fakeModelClient{metrics: map[string]float64{"ovms_requests_success": 10}}
+	activator := int64(0)
+	recorder := record.NewFakeRecorder(10)
+	r := &IdleReconciler{ModelClient: client, EventRecorder: recorder,
+		ActivatorRequests: func(_ context.Context, endpoint string) (int64, error) {
+			assert.Equal(t, "http://sample-ovms-activator.ns.svc:8082", endpoint)
+			return activator, nil
+		}}
+	o := &unstructured.Unstructured{}
+	o.SetNamespace("ns")
+	o.SetName("sample")
+	rel := &rpb.Release{Namespace: "ns", Manifest: testActivatorManifest}
+	policy := IdlePolicy{Enabled: true, IdlePeriod: time.Hour}
+	start := time.Now()
+	status := &types.HelmAppStatus{}
+
+	assert.True(t, r.checkActivity(context.TODO(), o, status, rel, policy, false, start))
+	assert.Equal(t, types.StatusFalse, status.GetCondition(types.ConditionIdle).Status)
+	assert.False(t, r.checkActivity(context.TODO(), o, status, rel, policy, false, start.Add(30*time.Minute)))
+
+	// a request restarts the idle period
+	client.metrics["ovms_requests_fail"] = 1
+	assert.False(t, r.checkActivity(context.TODO(), o, status, rel, policy, false, start.Add(50*time.Minute)))
+	assert.False(t, r.checkActivity(context.TODO(), o, status, rel, policy, false, start.Add(time.Hour)))
+
+	assert.True(t, r.checkActivity(context.TODO(), o, status, rel, policy, false, start.Add(110*time.Minute)))
+	assert.True(t, status.IsIdle())
+	assert.Equal(t, types.ReasonNoRequests, status.GetCondition(types.ConditionIdle).Reason)
+	assert.Contains(t, <-recorder.Events, "Normal ScaledToZero No inference requests since")
+
+	// the checks of the activator are counted as requests
+	activator = 5
+	assert.False(t, r.checkActivity(context.TODO(), o, status, rel, policy, false, start.Add(2*time.Hour)))
+	activator = 6
+	assert.False(t, r.checkActivity(context.TODO(), o, status, rel, policy, false, start.Add(2*time.Hour)))
+	activator = 9
+	assert.True(t, r.checkActivity(context.TODO(), o, status, rel, policy, false, start.Add(2*time.Hour)))
+	assert.False(t, status.IsIdle())
+	assert.Equal(t, types.ReasonRequestsReceived, status.GetCondition(types.ConditionIdle).Reason)
+	assert.Equal(t, "Normal ScaledUp Requests received by the activator", <-recorder.Events)
+
+	// the idle period restarts after the wake-up
+	assert.False(t, r.checkActivity(context.TODO(), o, status, rel, policy, false, start.Add(170*time.Minute)))
+	status.SetCondition(types.HelmAppCondition{Type: types.ConditionIdle, Status: types.StatusTrue})
+	assert.True(t, r.checkActivity(context.TODO(), o, status, rel, policy, true, start.Add(3*time.Hour)))
+	assert.Equal(t, types.ReasonWakeUpRequested, status.GetCondition(types.ConditionIdle).Reason)
+}
+
+func TestIdleReconcile(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "intel.com", Version: "v1alpha1", Kind: "ModelServer"}
+	o := &unstructured.Unstructured{}
+	o.SetGroupVersionKind(gvk)
+	o.SetNamespace("ns")
+	o.SetName("sample")
+	o.SetAnnotations(map[string]string{wakeUpAnnotation: "now"})
+	o.Object["spec"] = map[string]interface{}{"idle_policy": map[string]interface{}{"enabled": true}}
+	o.Object["status"] = map[string]interface{}{
+		"conditions": []interface{}{map[string]interface{}{"type": "Idle", "status": "True"}},
+		"deployedRelease": map[string]interface{}{
+			"name": "sample", "manifest": testActivatorManifest,
+		},
+	}
+	cl := fake.NewClientBuilder().WithObjects(o).WithStatusSubresource(o).Build()
+	r := &IdleReconciler{Client: cl, GVK: gvk, EventRecorder: record.NewFakeRecorder(10),
+		ModelClient: &fakeModelClient{}}
+
+	key := apitypes.NamespacedName{Namespace: "ns", Name: "sample"}
+	result, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: key})
+	assert.NoError(t, err)
+	assert.Equal(t, idleCheckPeriod, result.RequeueAfter)
+
+	updated := &unstructured.Unstructured{}
+	updated.SetGroupVersionKind(gvk)
+	assert.NoError(t, cl.Get(context.TODO(), key, updated))
+	assert.NotContains(t, updated.GetAnnotations(), wakeUpAnnotation)
+	assert.False(t, types.StatusFor(updated).IsIdle())
+
+	// disabling the policy removes the condition
+	updated.Object["spec"] = map[string]interface{}{}
+	assert.NoError(t, cl.Update(context.TODO(), updated))
+	_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: key})
+	assert.NoError(t, err)
+	assert.NoError(t, cl.Get(context.TODO(), key, updated))
+	assert.Nil(t, types.StatusFor(updated).GetCondition(types.ConditionIdle))
+}
+
+func TestActivatorRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("Active connections: 1 \nserver accepts handled requests\n 16 16 18 \nReading: 0 Writing: 1 Waiting: 0 \n"))
+	}))
+	defer server.Close()
+
+	requests, err := activatorRequests(context.TODO(), server.Client(), server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(18), requests)
+
+	_, err = activatorRequests(context.TODO(), server.Client(), server.URL+"/missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,405 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/helm/release"
+	"github.com/openvinotoolkit/operator/pkg/imagepolicy"
+	"github.com/openvinotoolkit/operator/pkg/util/k8sutil"
+)
+
+// imageVerificationTTL is the period after which a verified image is
+// verified again.
+const imageVerificationTTL = 10 * time.Minute
+
+// The images deployed by the ovms chart when they are not set in the values.
+const (
+	defaultProxyImage     = "nginxinc/nginx-unprivileged:1.27-alpine"
+	defaultActivatorImage = "nginxinc/nginx-unprivileged:1.27-alpine"
+	defaultTestImage      = "curlimages/curl:8.7.1"
+)
+
+// releaseImage is a field of the values setting an image deployed by the
+// release, or run by a job of the operator.
+type releaseImage struct {
+	values map[string]interface{}
+	path   []string
+}
+
+// defaultImage returns the field of the values, set to the default image of
+// the chart if it is empty, so that the default image is checked and pinned.
+func defaultImage(values map[string]interface{}, image string, path ...string) releaseImage {
+	i := releaseImage{values: values, path: path}
+	if i.get() == "" {
+		i.set(image)
+	}
+	return i
+}
+
+func (i releaseImage) get() string {
+	image, _, _ := unstructured.NestedString(i.values, i.path...)
+	return image
+}
+
+// set sets the image, copying the section of the values holding it.
+func (i releaseImage) set(image string) {
+	if len(i.path) == 1 {
+		i.values[i.path[0]] = image
+		return
+	}
+	section := copyValues(i.values[i.path[0]])
+	section[i.path[1]] = image
+	i.values[i.path[0]] = section
+}
+
+// imagePolicyFor returns the image policy of a watch, with the public keys
+// Secret defaulting to the namespace of the operator, and the verifier of
+// the image signatures if they are verified.
+func imagePolicyFor(policy *imagepolicy.Policy) (*imagepolicy.Policy, imagepolicy.Verifier) {
+	if policy.Verification == nil {
+		return policy, nil
+	}
+	p := *policy
+	verification := *policy.Verification
+	if verification.PublicKeysSecret.Namespace == "" {
+		ns, err := k8sutil.GetOperatorNamespace()
+		if err != nil {
+			log.Info("The image policy public keys Secret has no namespace", "reason", err.Error())
+		}
+		verification.PublicKeysSecret.Namespace = ns
+	}
+	p.Verification = &verification
+	registry := imagepolicy.NewRegistry(nil, verification.InsecureRegistries)
+	return &p, imagepolicy.NewCachingVerifier(imagepolicy.NewCosignVerifier(registry), imageVerificationTTL)
+}
+
+// releaseImages returns the fields of the values setting the images
+// deployed by the release: the model server images of the values and of a
+// canary, the TLS proxy, the activator of the idle policy, the chart tests,
+// and the image of the model cache sync job. The Notebook chart deploys
+// image_name only if the image is not built in the cluster.
+func (r HelmOperatorReconciler) releaseImages(values, generated map[string]interface{}) ([]releaseImage, error) {
+	if r.GVK.Kind != "ModelServer" {
+		if build, _ := values["build_locally"].(string); build == "" || build == "true" {
+			return nil, nil
+		}
+	}
+	if image, _ := values["image_name"].(string); image == "" {
+		return nil, errors.New("image_name must be set to be checked against the image policy")
+	}
+	images := []releaseImage{{values: values, path: []string{"image_name"}}}
+	canary, _ := generated["canary"].(map[string]interface{})
+	if canaryValues, _ := canary["values"].(map[string]interface{}); canaryValues != nil {
+		if image, _ := canaryValues["image_name"].(string); image != "" {
+			images = append(images, releaseImage{values: canaryValues, path: []string{"image_name"}})
+		}
+	}
+	if r.GVK.Kind != "ModelServer" {
+		return images, nil
+	}
+	if generated["tls"] != nil {
+		images = append(images, defaultImage(values, defaultProxyImage, "tls", "proxy_image"))
+	}
+	if idle, _, _ := unstructured.NestedBool(values, "idle_policy", "enabled"); idle {
+		images = append(images, defaultImage(values, defaultActivatorImage, "idle_policy", "activator_image"))
+	}
+	images = append(images, defaultImage(values, defaultTestImage, "tests", "image"))
+	if cache, _, _ := unstructured.NestedBool(values, "model_cache", "enabled"); cache {
+		images = append(images, defaultImage(values, defaultModelCacheImage, "model_cache", "image"))
+	}
+	return images, nil
+}
+
+// imageNames returns the distinct images set in the values.
+func imageNames(images []releaseImage) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, i := range images {
+		image := i.get()
+		if !seen[image] {
+			seen[image] = true
+			names = append(names, image)
+		}
+	}
+	return names
+}
+
+// verifiedImage returns the image pinned to the digest it was verified with.
+func verifiedImage(verified types.VerifiedImageStatus) string {
+	parsed, err := imagepolicy.ParseImage(verified.Image)
+	if err != nil {
+		return ""
+	}
+	return parsed.Repository() + "@" + verified.Digest
+}
+
+// unpinImage returns the image which was verified and pinned to the image,
+// or the image itself.
+func unpinImage(verified []types.VerifiedImageStatus, image string) string {
+	for _, v := range verified {
+		if verifiedImage(v) == image {
+			return v.Image
+		}
+	}
+	return image
+}
+
+// pinImages replaces the images of the values, as pinned with the previous
+// verified digests, by the digests they were verified with. It returns true
+// if an image changed.
+func pinImages(verified, previous []types.VerifiedImageStatus, images []releaseImage) bool {
+	changed := false
+	for _, i := range images {
+		image := i.get()
+		original := unpinImage(previous, image)
+		for _, v := range verified {
+			if v.Image == original {
+				if pinned := verifiedImage(v); pinned != image {
+					i.set(pinned)
+					changed = true
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// checkImagePolicy checks the images of the release against the allowed
+// repositories and the digest requirement of the image policy of the watch.
+// With signature verification, the images are replaced by the digests they
+// were verified with, recorded in the status by verifyImages before the
+// release was installed or upgraded, so that the registries are not reached
+// on each reconciliation. A rejected image is recorded in the ImageVerified
+// condition and fails the release.
+func (r HelmOperatorReconciler) checkImagePolicy(status *types.HelmAppStatus, values,
+	generated map[string]interface{}) error {
+
+	policy := r.ImagePolicy
+	if policy == nil {
+		status.RemoveCondition(types.ConditionImageVerified)
+		status.VerifiedImages = nil
+		return nil
+	}
+	images, err := r.releaseImages(values, generated)
+	if err != nil {
+		return rejectImage(status, err)
+	}
+	if len(images) == 0 {
+		status.RemoveCondition(types.ConditionImageVerified)
+		status.VerifiedImages = nil
+		return nil
+	}
+
+	names := imageNames(images)
+	for _, image := range names {
+		if _, err := policy.Check(image); err != nil {
+			return rejectImage(status, err)
+		}
+	}
+	if policy.Verification == nil {
+		status.VerifiedImages = nil
+		status.SetCondition(types.HelmAppCondition{
+			Type:    types.ConditionImageVerified,
+			Status:  types.StatusTrue,
+			Reason:  types.ReasonImageAllowed,
+			Message: fmt.Sprintf("Image %s is allowed by the image policy", strings.Join(names, ", ")),
+		})
+		return nil
+	}
+	pinImages(status.VerifiedImages, nil, images)
+	return nil
+}
+
+// verifyImages verifies the signatures of the images of the release with a
+// public key of the image policy Secret, before the release is installed or
+// upgraded. The verified digests are recorded in the status and replace the
+// images of the values. It returns true if the values changed.
+func (r HelmOperatorReconciler) verifyImages(ctx context.Context, status *types.HelmAppStatus,
+	values map[string]interface{}) (bool, error) {
+
+	policy := r.ImagePolicy
+	if policy == nil || policy.Verification == nil {
+		return false, nil
+	}
+	generated, _ := values[generatedValuesKey].(map[string]interface{})
+	images, err := r.releaseImages(values, generated)
+	if err != nil || len(images) == 0 {
+		return false, err
+	}
+	keys, err := r.imageVerificationKeys(ctx, policy)
+	if err != nil {
+		return false, rejectImage(status, err)
+	}
+
+	var verified []types.VerifiedImageStatus
+	var pinned []string
+	for _, image := range imageNames(images) {
+		v, err := r.verifyImage(ctx, keys, unpinImage(status.VerifiedImages, image))
+		if err != nil {
+			return false, rejectImage(status, err)
+		}
+		verified = append(verified, v)
+		pinned = append(pinned, verifiedImage(v))
+	}
+	changed := pinImages(verified, status.VerifiedImages, images)
+	status.VerifiedImages = verified
+	status.SetCondition(types.HelmAppCondition{
+		Type:    types.ConditionImageVerified,
+		Status:  types.StatusTrue,
+		Reason:  types.ReasonImageSignatureVerified,
+		Message: fmt.Sprintf("Image %s is signed with a trusted key", strings.Join(pinned, ", ")),
+	})
+	return changed, nil
+}
+
+// imageVerificationKeys returns the public keys verifying the signatures of
+// the images.
+func (r HelmOperatorReconciler) imageVerificationKeys(ctx context.Context,
+	policy *imagepolicy.Policy) ([]crypto.PublicKey, error) {
+
+	keys, err := r.imagePublicKeys(ctx, policy.Verification.PublicKeysSecret)
+	if err != nil {
+		return nil, err
+	}
+	if r.ImageVerifier == nil {
+		return nil, errors.New("no image signature verifier is configured")
+	}
+	return keys, nil
+}
+
+// verifyImage verifies the signature of the image, and returns the digest
+// it was verified with.
+func (r HelmOperatorReconciler) verifyImage(ctx context.Context, keys []crypto.PublicKey,
+	image string) (types.VerifiedImageStatus, error) {
+
+	parsed, err := imagepolicy.ParseImage(image)
+	if err != nil {
+		return types.VerifiedImageStatus{}, err
+	}
+	d, err := r.ImageVerifier.Verify(ctx, parsed, keys)
+	if err != nil {
+		return types.VerifiedImageStatus{}, fmt.Errorf("signature verification failed: %w", err)
+	}
+	return types.VerifiedImageStatus{Image: image, Digest: d}, nil
+}
+
+// checkModelCacheImage checks the image of the model cache sync job against
+// the image policy before the job is created, which happens before the
+// release is installed or upgraded. With signature verification, the image
+// is verified and pinned at once, and recorded with the verified images of
+// the release.
+func (r HelmOperatorReconciler) checkModelCacheImage(ctx context.Context, status *types.HelmAppStatus,
+	values map[string]interface{}) error {
+
+	policy := r.ImagePolicy
+	if cache, _, _ := unstructured.NestedBool(values, "model_cache", "enabled"); policy == nil || !cache {
+		return nil
+	}
+	i := defaultImage(values, defaultModelCacheImage, "model_cache", "image")
+	image := i.get()
+	if _, err := policy.Check(image); err != nil {
+		return rejectImage(status, err)
+	}
+	if policy.Verification == nil {
+		return nil
+	}
+	original := unpinImage(status.VerifiedImages, image)
+	for _, v := range status.VerifiedImages {
+		if v.Image == original {
+			i.set(verifiedImage(v))
+			return nil
+		}
+	}
+	keys, err := r.imageVerificationKeys(ctx, policy)
+	if err != nil {
+		return rejectImage(status, err)
+	}
+	v, err := r.verifyImage(ctx, keys, original)
+	if err != nil {
+		return rejectImage(status, err)
+	}
+	status.VerifiedImages = append(status.VerifiedImages, v)
+	i.set(verifiedImage(v))
+	return nil
+}
+
+// verifyReleaseImages verifies the images of the release, and syncs the
+// manager again when they are pinned to new digests, so that the verified
+// digests are installed.
+func (r HelmOperatorReconciler) verifyReleaseImages(ctx context.Context, status *types.HelmAppStatus,
+	manager release.Manager) error {
+
+	changed, err := r.verifyImages(ctx, status, manager.GetValues())
+	if err != nil || !changed {
+		return err
+	}
+	return manager.Sync(ctx)
+}
+
+// rejectImage records the rejection of an image in the ImageVerified
+// condition.
+func rejectImage(status *types.HelmAppStatus, err error) error {
+	status.SetCondition(types.HelmAppCondition{
+		Type:    types.ConditionImageVerified,
+		Status:  types.StatusFalse,
+		Reason:  types.ReasonImageRejected,
+		Message: err.Error(),
+	})
+	return err
+}
+
+// imagePublicKeys returns the public keys of all the entries of the Secret
+// of the image policy.
+func (r HelmOperatorReconciler) imagePublicKeys(ctx context.Context,
+	ref imagepolicy.SecretReference) ([]crypto.PublicKey, error) {
+
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}
+	if err := r.APIReader.Get(ctx, key, secret); err != nil {
+		return nil, fmt.Errorf("failed to get the image signing keys: %w", err)
+	}
+	names := make([]string, 0, len(secret.Data))
+	for name := range secret.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var keys []crypto.PublicKey
+	for _, name := range names {
+		k, err := imagepolicy.ParsePublicKeys(secret.Data[name])
+		if err != nil {
+			return nil, fmt.Errorf("secret %s/%s key %q: %w", ref.Namespace, ref.Name, name, err)
+		}
+		keys = append(keys, k...)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("secret %s/%s holds no PEM encoded public key", ref.Namespace, ref.Name)
+	}
+	return keys, nil
+}
@@ -0,0 +1,404 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/imagepolicy"
+)
+
+// testVerifier accepts the images of the signed repositories if they are
+// verified with a single key.
+type testVerifier struct {
+	signed   map[string]bool
+	verified []string
+}
+
+func (v *testVerifier) Verify(_ context.Context, image imagepolicy.Image, keys []crypto.PublicKey) (string, error) {
+	v.verified = append(v.verified, image.String())
+	if !v.signed[image.Repository()] || len(keys) != 1 {
+		return "", errors.New("image " + image.Repository() + " is not signed")
+	}
+	return "sha256:0d3e36b4b3a5a8e0e1e3d1c9a4d3f1b5c2e6a7b8c9d0e1f2a3b4c5d6e7f8a9b0", nil
+}
+
+func TestCheckImagePolicy(t *testing.T) {
+	allowed := &imagepolicy.Policy{AllowedRepositories: []string{"docker.io/openvino/", "curlimages/curl"}}
+	verified := &imagepolicy.Policy{
+		AllowedRepositories: []string{"docker.io/openvino/", "curlimages/curl"},
+		Verification: &imagepolicy.Verification{
+			PublicKeysSecret: imagepolicy.SecretReference{Namespace: "operators", Name: "image-signing-keys"},
+		},
+	}
+	const sha = "sha256:0d3e36b4b3a5a8e0e1e3d1c9a4d3f1b5c2e6a7b8c9d0e1f2a3b4c5d6e7f8a9b0"
+	previous := types.HelmAppCondition{
+		Type:    types.ConditionImageVerified,
+		Status:  types.StatusTrue,
+		Reason:  types.ReasonImageSignatureVerified,
+		Message: "Image docker.io/openvino/model_server@" + sha + " is signed with a trusted key",
+	}
+
+	tests := []struct {
+		name           string
+		kind           string
+		policy         *imagepolicy.Policy
+		values         map[string]interface{}
+		generated      map[string]interface{}
+		verifiedImages []types.VerifiedImageStatus
+		image          string
+		canaryImage    string
+		testImage      string
+		status         types.ConditionStatus
+		reason         types.HelmAppConditionReason
+		message        string
+		errMessage     string
+	}{
+		{
+			name:   "no policy",
+			values: map[string]interface{}{"image_name": "quay.io/other/model_server"},
+			image:  "quay.io/other/model_server",
+		},
+		{
+			name:    "allowed",
+			policy:  allowed,
+			values:  map[string]interface{}{"image_name": "openvino/model_server:2022.1"},
+			image:   "openvino/model_server:2022.1",
+			status:  types.StatusTrue,
+			reason:  types.ReasonImageAllowed,
+			message: "Image openvino/model_server:2022.1, curlimages/curl:8.7.1 is allowed by the image policy",
+		},
+		{
+			name:   "tls proxy not allowed",
+			policy: allowed,
+			values: map[string]interface{}{"image_name": "openvino/model_server:2022.1"},
+			generated: map[string]interface{}{
+				"tls": map[string]interface{}{"server_secret": "sample-tls"},
+			},
+			status: types.StatusFalse,
+			reason: types.ReasonImageRejected,
+			errMessage: `image "nginxinc/nginx-unprivileged:1.27-alpine" is not in an allowed repository: ` +
+				"docker.io/openvino/, curlimages/curl",
+		},
+		{
+			name:   "activator not allowed",
+			policy: allowed,
+			values: map[string]interface{}{
+				"image_name":  "openvino/model_server:2022.1",
+				"idle_policy": map[string]interface{}{"enabled": true, "activator_image": "quay.io/other/nginx"},
+			},
+			status:     types.StatusFalse,
+			reason:     types.ReasonImageRejected,
+			errMessage: `image "quay.io/other/nginx" is not in an allowed repository: docker.io/openvino/, curlimages/curl`,
+		},
+		{
+			name:   "test image not allowed",
+			policy: allowed,
+			values: map[string]interface{}{
+				"image_name": "openvino/model_server:2022.1",
+				"tests":      map[string]interface{}{"image": "quay.io/other/curl"},
+			},
+			status:     types.StatusFalse,
+			reason:     types.ReasonImageRejected,
+			errMessage: `image "quay.io/other/curl" is not in an allowed repository: docker.io/openvino/, curlimages/curl`,
+		},
+		{
+			name:       "not allowed",
+			policy:     allowed,
+			values:     map[string]interface{}{"image_name": "quay.io/other/model_server"},
+			status:     types.StatusFalse,
+			reason:     types.ReasonImageRejected,
+			errMessage: `image "quay.io/other/model_server" is not in an allowed repository: docker.io/openvino/, curlimages/curl`,
+		},
+		{
+			name:       "no image",
+			policy:     allowed,
+			values:     map[string]interface{}{},
+			status:     types.StatusFalse,
+			reason:     types.ReasonImageRejected,
+			errMessage: "image_name must be set to be checked against the image policy",
+		},
+		{
+			name:   "canary not allowed",
+			policy: allowed,
+			values: map[string]interface{}{"image_name": "openvino/model_server:2022.1"},
+			generated: map[string]interface{}{"canary": map[string]interface{}{
+				"values": map[string]interface{}{"image_name": "quay.io/other/model_server:2022.2"},
+			}},
+			status:     types.StatusFalse,
+			reason:     types.ReasonImageRejected,
+			errMessage: `image "quay.io/other/model_server:2022.2" is not in an allowed repository: docker.io/openvino/, curlimages/curl`,
+		},
+		{
+			name:   "verified",
+			policy: verified,
+			values: map[string]interface{}{"image_name": "openvino/model_server:2022.1"},
+			generated: map[string]interface{}{"canary": map[string]interface{}{
+				"values": map[string]interface{}{"image_name": "openvino/model_server:2022.1"},
+			}},
+			verifiedImages: []types.VerifiedImageStatus{
+				{Image: "openvino/model_server:2022.1", Digest: sha},
+				{Image: "curlimages/curl:8.7.1", Digest: sha},
+			},
+			image:       "docker.io/openvino/model_server@" + sha,
+			canaryImage: "docker.io/openvino/model_server@" + sha,
+			testImage:   "docker.io/curlimages/curl@" + sha,
+			status:      types.StatusTrue,
+			reason:      types.ReasonImageSignatureVerified,
+			message:     previous.Message,
+		},
+		{
+			name:   "not verified yet",
+			policy: verified,
+			values: map[string]interface{}{"image_name": "openvino/model_server:2022.1"},
+			generated: map[string]interface{}{"canary": map[string]interface{}{
+				"values": map[string]interface{}{"image_name": "openvino/model_server:2022.2"},
+			}},
+			verifiedImages: []types.VerifiedImageStatus{{Image: "openvino/model_server:2022.1", Digest: sha}},
+			image:          "docker.io/openvino/model_server@" + sha,
+			canaryImage:    "openvino/model_server:2022.2",
+			status:         types.StatusTrue,
+			reason:         types.ReasonImageSignatureVerified,
+			message:        previous.Message,
+		},
+		{
+			name:   "notebook built locally",
+			kind:   "Notebook",
+			policy: allowed,
+			values: map[string]interface{}{"image_name": "quay.io/other/notebooks"},
+			image:  "quay.io/other/notebooks",
+		},
+		{
+			name:       "notebook image",
+			kind:       "Notebook",
+			policy:     allowed,
+			values:     map[string]interface{}{"image_name": "quay.io/other/notebooks", "build_locally": "false"},
+			status:     types.StatusFalse,
+			reason:     types.ReasonImageRejected,
+			errMessage: `image "quay.io/other/notebooks" is not in an allowed repository: docker.io/openvino/, curlimages/curl`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kind := test.kind
+			if kind == "" {
+				kind = "ModelServer"
+			}
+			r := HelmOperatorReconciler{
+				GVK:         schema.GroupVersionKind{Group: "intel.com", Version: "v1alpha1", Kind: kind},
+				ImagePolicy: test.policy,
+			}
+			status := &types.HelmAppStatus{VerifiedImages: test.verifiedImages}
+			status.SetCondition(previous)
+			generated := test.generated
+			if generated == nil {
+				generated = map[string]interface{}{}
+			}
+
+			err := r.checkImagePolicy(status, test.values, generated)
+			if test.errMessage != "" {
+				assert.EqualError(t, err, test.errMessage)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, test.image, test.values["image_name"])
+			}
+			if test.canaryImage != "" {
+				image, _, _ := unstructured.NestedString(generated, "canary", "values", "image_name")
+				assert.Equal(t, test.canaryImage, image)
+			}
+			if test.testImage != "" {
+				image, _, _ := unstructured.NestedString(test.values, "tests", "image")
+				assert.Equal(t, test.testImage, image)
+			}
+			c := status.GetCondition(types.ConditionImageVerified)
+			if test.status == "" {
+				assert.Nil(t, c)
+				return
+			}
+			if assert.NotNil(t, c) {
+				assert.Equal(t, test.status, c.Status)
+				assert.Equal(t, test.reason, c.Reason)
+				if test.errMessage != "" {
+					assert.Equal(t, test.errMessage, c.Message)
+				} else {
+					assert.Equal(t, test.message, c.Message)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyImages(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	keys := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "operators", Name: "image-signing-keys"},
+		Data:       map[string][]byte{"cosign.pub": pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})},
+	}
+	policy := func(secret string) *imagepolicy.Policy {
+		return &imagepolicy.Policy{Verification: &imagepolicy.Verification{
+			PublicKeysSecret: imagepolicy.SecretReference{Namespace: "operators", Name: secret},
+		}}
+	}
+	const sha = "sha256:0d3e36b4b3a5a8e0e1e3d1c9a4d3f1b5c2e6a7b8c9d0e1f2a3b4c5d6e7f8a9b0"
+	cl := fake.NewClientBuilder().WithObjects(keys).Build()
+	verifier := &testVerifier{signed: map[string]bool{
+		"docker.io/openvino/model_server":       true,
+		"docker.io/nginxinc/nginx-unprivileged": true,
+		"docker.io/curlimages/curl":             true,
+	}}
+	r := HelmOperatorReconciler{
+		Client:        cachedClient(cl),
+		APIReader:     cl,
+		GVK:           schema.GroupVersionKind{Group: "intel.com", Version: "v1alpha1", Kind: "ModelServer"},
+		ImagePolicy:   policy("image-signing-keys"),
+		ImageVerifier: verifier,
+	}
+	newValues := func(image string) map[string]interface{} {
+		return map[string]interface{}{
+			"image_name": image,
+			generatedValuesKey: map[string]interface{}{
+				"canary": map[string]interface{}{"values": map[string]interface{}{"image_name": image}},
+				"tls":    map[string]interface{}{"server_secret": "sample-tls"},
+			},
+		}
+	}
+
+	// the signed image is pinned to the verified digest
+	status := &types.HelmAppStatus{}
+	values := newValues("openvino/model_server:2022.1")
+	changed, err := r.verifyImages(context.TODO(), status, values)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []string{
+		"docker.io/openvino/model_server:2022.1",
+		"docker.io/nginxinc/nginx-unprivileged:1.27-alpine",
+		"docker.io/curlimages/curl:8.7.1",
+	}, verifier.verified)
+	assert.Equal(t, []types.VerifiedImageStatus{
+		{Image: "openvino/model_server:2022.1", Digest: sha},
+		{Image: "nginxinc/nginx-unprivileged:1.27-alpine", Digest: sha},
+		{Image: "curlimages/curl:8.7.1", Digest: sha},
+	}, status.VerifiedImages)
+	assert.Equal(t, "docker.io/openvino/model_server@"+sha, values["image_name"])
+	proxy, _, _ := unstructured.NestedString(values, "tls", "proxy_image")
+	assert.Equal(t, "docker.io/nginxinc/nginx-unprivileged@"+sha, proxy)
+	canary, _, _ := unstructured.NestedString(values, generatedValuesKey, "canary", "values", "image_name")
+	assert.Equal(t, "docker.io/openvino/model_server@"+sha, canary)
+	c := status.GetCondition(types.ConditionImageVerified)
+	if assert.NotNil(t, c) {
+		assert.Equal(t, types.ReasonImageSignatureVerified, c.Reason)
+		assert.Equal(t, "Image docker.io/openvino/model_server@"+sha+", docker.io/nginxinc/nginx-unprivileged@"+sha+
+			", docker.io/curlimages/curl@"+sha+" is signed with a trusted key", c.Message)
+	}
+
+	// the image set in the values is verified again before an upgrade
+	values = newValues("openvino/model_server:2022.1")
+	assert.NoError(t, r.checkImagePolicy(status, values, values[generatedValuesKey].(map[string]interface{})))
+	assert.Equal(t, "docker.io/openvino/model_server@"+sha, values["image_name"])
+	changed, err = r.verifyImages(context.TODO(), status, values)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Len(t, verifier.verified, 6)
+	assert.Equal(t, "docker.io/openvino/model_server:2022.1", verifier.verified[3])
+
+	// an unsigned image is rejected and the verified digests are kept
+	values = newValues("openvino/model_server_gpu@" + sha)
+	_, err = r.verifyImages(context.TODO(), status, values)
+	assert.EqualError(t, err, "signature verification failed: image docker.io/openvino/model_server_gpu is not signed")
+	assert.Equal(t, types.ReasonImageRejected, status.GetCondition(types.ConditionImageVerified).Reason)
+	assert.Len(t, status.VerifiedImages, 3)
+	assert.Equal(t, "openvino/model_server_gpu@"+sha, values["image_name"])
+
+	r.ImagePolicy = policy("missing")
+	_, err = r.verifyImages(context.TODO(), status, newValues("openvino/model_server:2022.1"))
+	assert.EqualError(t, err, `failed to get the image signing keys: secrets "missing" not found`)
+
+	// images are not verified without verification
+	r.ImagePolicy = &imagepolicy.Policy{}
+	changed, err = r.verifyImages(context.TODO(), status, newValues("openvino/model_server:2022.1"))
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Len(t, verifier.verified, 7)
+}
+
+func TestCheckModelCacheImage(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	cl := fake.NewClientBuilder().WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "operators", Name: "image-signing-keys"},
+		Data:       map[string][]byte{"cosign.pub": pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})},
+	}).Build()
+	const sha = "sha256:0d3e36b4b3a5a8e0e1e3d1c9a4d3f1b5c2e6a7b8c9d0e1f2a3b4c5d6e7f8a9b0"
+	verifier := &testVerifier{signed: map[string]bool{"docker.io/rclone/rclone": true}}
+	r := HelmOperatorReconciler{
+		Client:    cachedClient(cl),
+		APIReader: cl,
+		GVK:       schema.GroupVersionKind{Group: "intel.com", Version: "v1alpha1", Kind: "ModelServer"},
+		ImagePolicy: &imagepolicy.Policy{
+			AllowedRepositories: []string{"rclone/rclone"},
+			Verification: &imagepolicy.Verification{
+				PublicKeysSecret: imagepolicy.SecretReference{Namespace: "operators", Name: "image-signing-keys"},
+			},
+		},
+		ImageVerifier: verifier,
+	}
+	newValues := func(image string) map[string]interface{} {
+		cache := map[string]interface{}{"enabled": true}
+		if image != "" {
+			cache["image"] = image
+		}
+		return map[string]interface{}{"model_cache": cache}
+	}
+
+	// the default image is verified and pinned before the sync job is created
+	status := &types.HelmAppStatus{}
+	values := newValues("")
+	assert.NoError(t, r.checkModelCacheImage(context.TODO(), status, values))
+	image, _, _ := unstructured.NestedString(values, "model_cache", "image")
+	assert.Equal(t, "docker.io/rclone/rclone@"+sha, image)
+	assert.Equal(t, []types.VerifiedImageStatus{{Image: defaultModelCacheImage, Digest: sha}}, status.VerifiedImages)
+	assert.Len(t, verifier.verified, 1)
+
+	// the verified digest is reused
+	values = newValues(defaultModelCacheImage)
+	assert.NoError(t, r.checkModelCacheImage(context.TODO(), status, values))
+	image, _, _ = unstructured.NestedString(values, "model_cache", "image")
+	assert.Equal(t, "docker.io/rclone/rclone@"+sha, image)
+	assert.Len(t, verifier.verified, 1)
+
+	err := r.checkModelCacheImage(context.TODO(), status, newValues("quay.io/other/rclone:1.68"))
+	assert.EqualError(t, err, `image "quay.io/other/rclone:1.68" is not in an allowed repository: rclone/rclone`)
+	assert.Equal(t, types.ReasonImageRejected, status.GetCondition(types.ConditionImageVerified).Reason)
+}
@@ -0,0 +1,261 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/imagepolicy"
+	"github.com/openvinotoolkit/operator/pkg/maintenance"
+)
+
+// Image update modes of the ModelServer values. Pinned resolves the tag of
+// image_name to a digest once, Automatic also polls the registry and
+// upgrades the release when the tag moves.
+const (
+	imageUpdatePinned    = "Pinned"
+	imageUpdateAutomatic = "Automatic"
+
+	defaultImageCheckInterval = time.Hour
+	minImageCheckInterval     = 5 * time.Minute
+)
+
+// ImageUpdateOptions are the settings of the image_update section of the
+// ModelServer values, which pins the tag of image_name to the digest of its
+// manifest.
+type ImageUpdateOptions struct {
+	Mode string
+	// Interval is the period after which the tag is resolved again in the
+	// Automatic mode.
+	Interval time.Duration
+	// Window restricts the deployment of the updates found by polling, any
+	// time if nil.
+	Window *maintenance.Window
+}
+
+// imageUpdateOptionsFor returns the image update settings of the
+// ModelServer values, or nil if the image is not pinned.
+func imageUpdateOptionsFor(values map[string]interface{}) (*ImageUpdateOptions, error) {
+	update, _, _ := unstructured.NestedMap(values, "image_update")
+	mode, _ := update["mode"].(string)
+	switch mode {
+	case "":
+		return nil, nil
+	case imageUpdatePinned, imageUpdateAutomatic:
+	default:
+		return nil, fmt.Errorf("invalid image_update.mode %q, expected Pinned or Automatic", mode)
+	}
+	o := &ImageUpdateOptions{Mode: mode, Interval: defaultImageCheckInterval}
+	if interval, _ := update["interval"].(string); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d < minImageCheckInterval {
+			return nil, fmt.Errorf("invalid image_update.interval %q, expected a duration of at least 5m", interval)
+		}
+		o.Interval = d
+	}
+	window, _, _ := unstructured.NestedMap(update, "maintenance_window")
+	w, err := imageUpdateWindow(window)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image_update.maintenance_window: %w", err)
+	}
+	o.Window = w
+	return o, nil
+}
+
+// imageUpdateWindow returns the maintenance window of the image updates,
+// set with a cron schedule and a duration like the other maintenance
+// windows, or nil if it is not set. The deprecated form with the start time
+// of day and the weekdays is converted to a schedule.
+func imageUpdateWindow(window map[string]interface{}) (*maintenance.Window, error) {
+	schedule, _ := window["schedule"].(string)
+	start, _ := window["start"].(string)
+	if schedule == "" && start == "" {
+		return nil, nil
+	}
+	if schedule != "" && start != "" {
+		return nil, errors.New("schedule and start cannot be set together, start is deprecated")
+	}
+	d := maintenance.DefaultDuration
+	if duration, _ := window["duration"].(string); duration != "" {
+		// the windows starting at a time of day last one day at most
+		expected := "of at least 1m"
+		if start != "" {
+			expected = "between 1m and 24h"
+		}
+		var err error
+		d, err = time.ParseDuration(duration)
+		if err != nil || d < time.Minute || (start != "" && d > 24*time.Hour) {
+			return nil, fmt.Errorf("invalid duration %q, expected a duration %s", duration, expected)
+		}
+	}
+	if schedule != "" {
+		return maintenance.NewWindow(schedule, d)
+	}
+	days, _, _ := unstructured.NestedStringSlice(window, "days")
+	return maintenance.NewDailyWindow(start, days, d)
+}
+
+// resolveImageDigest pins image_name to the digest of the manifest its tag
+// references, so that all the nodes run the same image. The digest is
+// recorded in the status and reused until image_name changes. In the
+// Automatic mode, the tag is resolved again after the interval and a new
+// digest upgrades the release in the next maintenance window. If the
+// registry cannot be reached, the digest resolved before is kept.
+func (r HelmOperatorReconciler) resolveImageDigest(ctx context.Context, o *unstructured.Unstructured,
+	status *types.HelmAppStatus, values map[string]interface{}, now time.Time) error {
+
+	options, err := imageUpdateOptionsFor(values)
+	if err != nil {
+		return err
+	}
+	if options == nil {
+		status.Image = nil
+		status.RemoveCondition(types.ConditionImagePinned)
+		return nil
+	}
+	name, _ := values["image_name"].(string)
+	if name == "" {
+		return errors.New("image_update requires image_name")
+	}
+	image, err := imagepolicy.ParseImage(name)
+	if err != nil {
+		return err
+	}
+	if d := image.Digest(); d != "" {
+		// the image is pinned in the spec
+		status.Image = &types.ImageStatus{Image: name, Digest: d}
+		status.SetCondition(types.HelmAppCondition{
+			Type:    types.ConditionImagePinned,
+			Status:  types.StatusTrue,
+			Reason:  types.ReasonImageDigestResolved,
+			Message: fmt.Sprintf("Image %s is referenced by digest", name),
+		})
+		return nil
+	}
+
+	resolved := status.Image
+	if resolved != nil && resolved.Image != name {
+		resolved = nil
+	}
+	checked := false
+	if resolved == nil || (options.Mode == imageUpdateAutomatic && imageCheckDelay(resolved, options, now) == 0) {
+		d, err := r.resolveImage(ctx, image)
+		switch {
+		case err != nil && resolved == nil:
+			return fmt.Errorf("failed to resolve the digest of image %s: %w", name, err)
+		case err != nil:
+			// the release keeps running the digest resolved before
+			status.SetCondition(types.HelmAppCondition{
+				Type:    types.ConditionImagePinned,
+				Status:  types.StatusFalse,
+				Reason:  types.ReasonImageResolutionFailed,
+				Message: err.Error(),
+			})
+			resolved.LastCheckTime = &metav1.Time{Time: now}
+		case resolved == nil:
+			resolved = &types.ImageStatus{Image: name, Digest: d, LastCheckTime: &metav1.Time{Time: now}}
+			checked = true
+		default:
+			resolved.LastCheckTime = &metav1.Time{Time: now}
+			resolved.PendingDigest = ""
+			if d != resolved.Digest {
+				resolved.PendingDigest = d
+			}
+			checked = true
+		}
+		status.Image = resolved
+	}
+
+	if resolved.PendingDigest != "" && options.Window.Open(now) {
+		r.EventRecorder.Eventf(o, "Normal", "ImageUpdated", "Image %s moved to %s, previously %s",
+			name, resolved.PendingDigest, resolved.Digest)
+		resolved.Digest = resolved.PendingDigest
+		resolved.PendingDigest = ""
+		checked = true
+	}
+	if checked {
+		condition := types.HelmAppCondition{
+			Type:    types.ConditionImagePinned,
+			Status:  types.StatusTrue,
+			Reason:  types.ReasonImageDigestResolved,
+			Message: fmt.Sprintf("Image %s is pinned to %s", name, resolved.Digest),
+		}
+		if resolved.PendingDigest != "" {
+			condition.Reason = types.ReasonImageUpdatePending
+			condition.Message = fmt.Sprintf("Image %s moved to %s, which is deployed in the maintenance window %s, "+
+				"which never opens", name, resolved.PendingDigest, options.Window)
+			if next := options.Window.Next(now); !next.IsZero() {
+				condition.Message = fmt.Sprintf("Image %s moved to %s, which is deployed in the maintenance window starting at %s",
+					name, resolved.PendingDigest, next.UTC().Format(time.RFC3339))
+			}
+		}
+		status.SetCondition(condition)
+	}
+
+	values["image_name"] = name + "@" + resolved.Digest
+	return nil
+}
+
+// resolveImage returns the digest of the manifest referenced by the tag of
+// the image.
+func (r HelmOperatorReconciler) resolveImage(ctx context.Context, image imagepolicy.Image) (string, error) {
+	if r.ImageResolver == nil {
+		return "", errors.New("no image resolver is configured")
+	}
+	return r.ImageResolver.Digest(ctx, image)
+}
+
+// imageCheckDelay returns the time left before the tag of the image is
+// resolved again, or zero if it is due.
+func imageCheckDelay(resolved *types.ImageStatus, options *ImageUpdateOptions, now time.Time) time.Duration {
+	if resolved.LastCheckTime == nil {
+		return 0
+	}
+	if delay := resolved.LastCheckTime.Add(options.Interval).Sub(now); delay > 0 {
+		return delay
+	}
+	return 0
+}
+
+// imageUpdateRequeue returns the period after which the ModelServer must be
+// reconciled to resolve the tag of its image again or to deploy a pending
+// update in the maintenance window, or zero if the image is not polled.
+func imageUpdateRequeue(status *types.HelmAppStatus, values map[string]interface{}, now time.Time) time.Duration {
+	options, err := imageUpdateOptionsFor(values)
+	if err != nil || options == nil || options.Mode != imageUpdateAutomatic || status.Image == nil ||
+		status.Image.LastCheckTime == nil {
+		return 0
+	}
+	delay := imageCheckDelay(status.Image, options, now)
+	if status.Image.PendingDigest != "" && options.Window != nil {
+		if next := options.Window.Next(now); !next.IsZero() && next.Sub(now) < delay {
+			delay = next.Sub(now)
+		}
+	}
+	if delay <= 0 {
+		// the next reconciliation resolves the tag without delay
+		return time.Second
+	}
+	return delay
+}
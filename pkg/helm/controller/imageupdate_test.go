@@ -0,0 +1,270 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/imagepolicy"
+)
+
+const (
+	testDigest1 = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	testDigest2 = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+// testImageRegistry serves the digest of the latest tag of the
+// openvino/model_server repository, which can be moved by the tests.
+type testImageRegistry struct {
+	*httptest.Server
+	latest   string
+	requests int
+}
+
+func newTestImageRegistry() *testImageRegistry {
+	registry := &testImageRegistry{latest: testDigest1}
+	registry.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registry.requests++
+		if r.Method != http.MethodHead || r.URL.Path != "/v2/openvino/model_server/manifests/latest" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", registry.latest)
+	}))
+	return registry
+}
+
+func TestImageUpdateOptionsFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		update     map[string]interface{}
+		expected   *ImageUpdateOptions
+		errMessage string
+	}{
+		{name: "disabled", update: map[string]interface{}{"interval": "1h"}},
+		{
+			name:     "pinned",
+			update:   map[string]interface{}{"mode": "Pinned"},
+			expected: &ImageUpdateOptions{Mode: imageUpdatePinned, Interval: time.Hour},
+		},
+		{
+			name: "automatic",
+			update: map[string]interface{}{
+				"mode":     "Automatic",
+				"interval": "30m",
+				"maintenance_window": map[string]interface{}{
+					"start": "02:30", "duration": "2h", "days": []interface{}{"sat", "Sunday"},
+				},
+			},
+			expected: &ImageUpdateOptions{Mode: imageUpdateAutomatic, Interval: 30 * time.Minute,
+				Window: testWindow("30 2 * * 0,6", 2*time.Hour)},
+		},
+		{
+			name: "schedule",
+			update: map[string]interface{}{
+				"mode":               "Automatic",
+				"maintenance_window": map[string]interface{}{"schedule": "0 22 * * SAT", "duration": "48h"},
+			},
+			expected: &ImageUpdateOptions{Mode: imageUpdateAutomatic, Interval: time.Hour,
+				Window: testWindow("0 22 * * SAT", 48*time.Hour)},
+		},
+		{
+			name: "schedule and start",
+			update: map[string]interface{}{"mode": "Automatic", "maintenance_window": map[string]interface{}{
+				"schedule": "0 22 * * SAT", "start": "22:00",
+			}},
+			errMessage: "invalid image_update.maintenance_window: schedule and start cannot be set together, start is deprecated",
+		},
+		{
+			name: "invalid schedule",
+			update: map[string]interface{}{"mode": "Automatic", "maintenance_window": map[string]interface{}{
+				"schedule": "@every 1h",
+			}},
+			errMessage: `invalid image_update.maintenance_window: invalid schedule "@every 1h": @every is not supported`,
+		},
+		{
+			name:       "invalid mode",
+			update:     map[string]interface{}{"mode": "Always"},
+			errMessage: `invalid image_update.mode "Always", expected Pinned or Automatic`,
+		},
+		{
+			name:       "short interval",
+			update:     map[string]interface{}{"mode": "Automatic", "interval": "1m"},
+			errMessage: `invalid image_update.interval "1m", expected a duration of at least 5m`,
+		},
+		{
+			name: "invalid start",
+			update: map[string]interface{}{"mode": "Automatic", "maintenance_window": map[string]interface{}{
+				"start": "2am",
+			}},
+			errMessage: `invalid image_update.maintenance_window: invalid start "2am", expected HH:MM`,
+		},
+		{
+			name: "invalid day",
+			update: map[string]interface{}{"mode": "Automatic", "maintenance_window": map[string]interface{}{
+				"start": "02:00", "days": []interface{}{"Weekend"},
+			}},
+			errMessage: `invalid image_update.maintenance_window: invalid day "Weekend"`,
+		},
+		{
+			name: "long window",
+			update: map[string]interface{}{"mode": "Automatic", "maintenance_window": map[string]interface{}{
+				"start": "02:00", "duration": "48h",
+			}},
+			errMessage: `invalid image_update.maintenance_window: invalid duration "48h", expected a duration between 1m and 24h`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options, err := imageUpdateOptionsFor(map[string]interface{}{"image_update": test.update})
+			if test.errMessage != "" {
+				assert.EqualError(t, err, test.errMessage)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, options)
+		})
+	}
+}
+
+func TestResolveImageDigest(t *testing.T) {
+	registry := newTestImageRegistry()
+	defer registry.Close()
+	host := strings.TrimPrefix(registry.URL, "http://")
+	recorder := record.NewFakeRecorder(10)
+	r := HelmOperatorReconciler{
+		EventRecorder: recorder,
+		ImageResolver: imagepolicy.NewRegistry(registry.Client(), []string{host}),
+	}
+	o := testModelServer("ns")
+	image := host + "/openvino/model_server:latest"
+	newValues := func() map[string]interface{} {
+		return map[string]interface{}{
+			"image_name": image,
+			"image_update": map[string]interface{}{
+				"mode":               "Automatic",
+				"interval":           "1h",
+				"maintenance_window": map[string]interface{}{"start": "02:00", "duration": "2h"},
+			},
+		}
+	}
+	// 00:30 UTC, before the maintenance window
+	start := time.Date(2022, 6, 1, 0, 30, 0, 0, time.UTC)
+	status := &types.HelmAppStatus{}
+
+	values := newValues()
+	assert.NoError(t, r.resolveImageDigest(context.TODO(), o, status, values, start))
+	assert.Equal(t, image+"@"+testDigest1, values["image_name"])
+	if assert.NotNil(t, status.Image) {
+		assert.Equal(t, image, status.Image.Image)
+		assert.Equal(t, testDigest1, status.Image.Digest)
+	}
+	assert.Equal(t, types.ReasonImageDigestResolved, status.GetCondition(types.ConditionImagePinned).Reason)
+	assert.Equal(t, time.Hour, imageUpdateRequeue(status, values, start))
+
+	// the tag is not resolved again before the interval elapses
+	registry.latest = testDigest2
+	requests := registry.requests
+	values = newValues()
+	assert.NoError(t, r.resolveImageDigest(context.TODO(), o, status, values, start.Add(time.Minute)))
+	assert.Equal(t, image+"@"+testDigest1, values["image_name"])
+	assert.Equal(t, requests, registry.requests)
+
+	// the moved tag waits for the maintenance window
+	values = newValues()
+	assert.NoError(t, r.resolveImageDigest(context.TODO(), o, status, values, start.Add(time.Hour)))
+	assert.Equal(t, image+"@"+testDigest1, values["image_name"])
+	assert.Equal(t, testDigest2, status.Image.PendingDigest)
+	c := status.GetCondition(types.ConditionImagePinned)
+	assert.Equal(t, types.ReasonImageUpdatePending, c.Reason)
+	assert.Equal(t, "Image "+image+" moved to "+testDigest2+
+		", which is deployed in the maintenance window starting at 2022-06-01T02:00:00Z", c.Message)
+	assert.Equal(t, 30*time.Minute, imageUpdateRequeue(status, values, start.Add(time.Hour)))
+	assert.Empty(t, recorder.Events)
+
+	// the pending update is deployed when the window opens
+	requests = registry.requests
+	values = newValues()
+	assert.NoError(t, r.resolveImageDigest(context.TODO(), o, status, values, start.Add(90*time.Minute)))
+	assert.Equal(t, requests, registry.requests)
+	assert.Equal(t, image+"@"+testDigest2, values["image_name"])
+	assert.Equal(t, testDigest2, status.Image.Digest)
+	assert.Empty(t, status.Image.PendingDigest)
+	assert.Equal(t, types.ReasonImageDigestResolved, status.GetCondition(types.ConditionImagePinned).Reason)
+	assert.Equal(t, "Normal ImageUpdated Image "+image+" moved to "+testDigest2+", previously "+testDigest1, <-recorder.Events)
+
+	// the pinned digest is kept while the registry is unavailable
+	registry.Close()
+	values = newValues()
+	assert.NoError(t, r.resolveImageDigest(context.TODO(), o, status, values, start.Add(2*time.Hour)))
+	assert.Equal(t, image+"@"+testDigest2, values["image_name"])
+	c = status.GetCondition(types.ConditionImagePinned)
+	assert.Equal(t, types.StatusFalse, c.Status)
+	assert.Equal(t, types.ReasonImageResolutionFailed, c.Reason)
+
+	// a changed image is resolved again, without falling back to the digest
+	// of the previous image
+	values = newValues()
+	values["image_name"] = host + "/openvino/model_server:2022.1"
+	assert.Error(t, r.resolveImageDigest(context.TODO(), o, status, values, start.Add(3*time.Hour)))
+
+	// an image referenced by digest is deployed as is
+	values = newValues()
+	values["image_name"] = "openvino/model_server@" + testDigest1
+	assert.NoError(t, r.resolveImageDigest(context.TODO(), o, status, values, start))
+	assert.Equal(t, "openvino/model_server@"+testDigest1, values["image_name"])
+	assert.Equal(t, testDigest1, status.Image.Digest)
+	assert.Equal(t, time.Duration(0), imageUpdateRequeue(status, values, start))
+
+	values = map[string]interface{}{"image_name": image}
+	assert.NoError(t, r.resolveImageDigest(context.TODO(), o, status, values, start))
+	assert.Equal(t, image, values["image_name"])
+	assert.Nil(t, status.Image)
+	assert.Nil(t, status.GetCondition(types.ConditionImagePinned))
+}
+
+func TestResolveImageDigestPinned(t *testing.T) {
+	registry := newTestImageRegistry()
+	defer registry.Close()
+	host := strings.TrimPrefix(registry.URL, "http://")
+	r := HelmOperatorReconciler{ImageResolver: imagepolicy.NewRegistry(registry.Client(), []string{host})}
+	image := host + "/openvino/model_server:latest"
+	values := func() map[string]interface{} {
+		return map[string]interface{}{"image_name": image, "image_update": map[string]interface{}{"mode": "Pinned"}}
+	}
+	start := time.Now()
+	status := &types.HelmAppStatus{}
+
+	assert.NoError(t, r.resolveImageDigest(context.TODO(), testModelServer("ns"), status, values(), start))
+	assert.Equal(t, testDigest1, status.Image.Digest)
+
+	// the digest pinned at install time is kept when the tag moves
+	registry.latest = testDigest2
+	v := values()
+	assert.NoError(t, r.resolveImageDigest(context.TODO(), testModelServer("ns"), status, v, start.Add(24*time.Hour)))
+	assert.Equal(t, image+"@"+testDigest1, v["image_name"])
+	assert.Equal(t, time.Duration(0), imageUpdateRequeue(status, v, start))
+}
@@ -0,0 +1,189 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	rpb "helm.sh/helm/v3/pkg/release"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/helm/manifestutil"
+)
+
+// Serving platforms of the ModelServer values. Deployment renders the model
+// server Deployment and Service, KServe a ServingRuntime and an
+// InferenceService, and Auto selects KServe if its API is served.
+const (
+	servingDeployment = "Deployment"
+	servingKServe     = "KServe"
+	servingAuto       = "Auto"
+
+	kserveRawDeployment = "RawDeployment"
+	kserveServerless    = "Serverless"
+
+	defaultKServeModelFormat = "openvino_ir"
+)
+
+var (
+	inferenceServiceGVK = schema.GroupVersionKind{Group: "serving.kserve.io", Version: "v1beta1", Kind: "InferenceService"}
+	servingRuntimeGVK   = schema.GroupVersionKind{Group: "serving.kserve.io", Version: "v1alpha1", Kind: "ServingRuntime"}
+)
+
+// renderKServe resolves the serving platform of the ModelServer and the
+// settings of the KServe InferenceService replacing the model server
+// Deployment. It runs after the other generated values are rendered, to
+// reject the features relying on the Deployment and Service of the chart.
+func (r HelmOperatorReconciler) renderKServe(values, generated map[string]interface{},
+	rollout RolloutOptions, idle IdlePolicy) error {
+
+	platform, _ := values["serving_platform"].(string)
+	switch platform {
+	case "", servingDeployment:
+		return nil
+	case servingKServe, servingAuto:
+	default:
+		return fmt.Errorf("invalid serving_platform %q, expected Deployment, KServe or Auto", platform)
+	}
+	if !r.apiAvailable(inferenceServiceGVK) || !r.apiAvailable(servingRuntimeGVK) {
+		if platform == servingAuto {
+			return nil
+		}
+		return fmt.Errorf("serving_platform KServe requires the %s API, which is not available",
+			inferenceServiceGVK.GroupVersion())
+	}
+
+	if single, _, _ := unstructured.NestedBool(values, "models_settings", "single_model_mode"); !single {
+		return errors.New("the KServe serving platform requires models_settings.single_model_mode")
+	}
+	if idle.Enabled {
+		return errors.New("idle_policy is not supported with the KServe serving platform, " +
+			"use the Serverless deployment mode to scale to zero")
+	}
+	if rollout.Enabled() {
+		return fmt.Errorf("the %s rollout strategy is not supported with the KServe serving platform", rollout.Strategy)
+	}
+	for _, feature := range []struct {
+		key, field string
+	}{
+		{"tls", "tls"},
+		{"exposure", "exposure"},
+		{"network_policy", "network_policy"},
+		{"pod_disruption_budget", "pod_disruption_budget"},
+	} {
+		if generated[feature.key] != nil {
+			return fmt.Errorf("%s is not supported with the KServe serving platform", feature.field)
+		}
+	}
+
+	kserve, _, _ := unstructured.NestedMap(values, "kserve")
+	mode, _ := kserve["deployment_mode"].(string)
+	switch mode {
+	case "":
+		mode = kserveRawDeployment
+	case kserveRawDeployment, kserveServerless:
+	default:
+		return fmt.Errorf("invalid kserve.deployment_mode %q, expected RawDeployment or Serverless", mode)
+	}
+	format, _ := kserve["model_format"].(string)
+	if format == "" {
+		format = defaultKServeModelFormat
+	}
+
+	// the predictor replaces the HorizontalPodAutoscaler of the chart
+	minReplicas := lowestReplicas(values)
+	maxReplicas := minReplicas
+	if enabled, _, _ := unstructured.NestedBool(values, "autoscaling", "enabled"); enabled {
+		if n, found, _ := unstructured.NestedInt64(values, "autoscaling", "max_replicas"); found {
+			maxReplicas = n
+		}
+	}
+	generated["kserve"] = map[string]interface{}{
+		"deployment_mode": mode,
+		"model_format":    format,
+		"min_replicas":    minReplicas,
+		"max_replicas":    maxReplicas,
+	}
+	return nil
+}
+
+// kserveEnabled reports whether the ModelServer is served by a KServe
+// InferenceService.
+func kserveEnabled(values map[string]interface{}) bool {
+	kserve, _, _ := unstructured.NestedMap(values, generatedValuesKey, "kserve")
+	return kserve != nil
+}
+
+// mirrorInferenceService copies the URL and the readiness of the
+// InferenceService of the release to the status of the ModelServer. It
+// returns false if the InferenceService is not ready yet.
+func (r HelmOperatorReconciler) mirrorInferenceService(ctx context.Context, status *types.HelmAppStatus,
+	rel *rpb.Release) bool {
+
+	setReady := func(conditionStatus types.ConditionStatus, reason types.HelmAppConditionReason, message string) bool {
+		status.SetCondition(types.HelmAppCondition{
+			Type:    types.ConditionInferenceServiceReady,
+			Status:  conditionStatus,
+			Reason:  reason,
+			Message: message,
+		})
+		return conditionStatus == types.StatusTrue
+	}
+
+	services, err := manifestutil.ObjectsOfKind(rel.Manifest, inferenceServiceGVK.Kind)
+	if err != nil || len(services) == 0 {
+		return setReady(types.StatusUnknown, types.ReasonInferenceServiceNotReady,
+			"The release does not include an InferenceService")
+	}
+	isvc := &unstructured.Unstructured{}
+	isvc.SetGroupVersionKind(inferenceServiceGVK)
+	key := client.ObjectKey{Namespace: rel.Namespace, Name: services[0].GetName()}
+	if err := r.Client.Get(ctx, key, isvc); err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.V(1).Info("Failed to get the InferenceService", "name", key.Name, "error", err.Error())
+		}
+		return setReady(types.StatusUnknown, types.ReasonInferenceServiceNotReady, err.Error())
+	}
+
+	status.URL, _, _ = unstructured.NestedString(isvc.Object, "status", "url")
+	conditions, _, _ := unstructured.NestedSlice(isvc.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, _ := c.(map[string]interface{})
+		if condition["type"] != "Ready" {
+			continue
+		}
+		message, _ := condition["message"].(string)
+		if condition["status"] == string(types.StatusTrue) {
+			if message == "" {
+				message = fmt.Sprintf("InferenceService %s is ready", key.Name)
+			}
+			return setReady(types.StatusTrue, types.ReasonInferenceServiceReady, message)
+		}
+		if reason, _ := condition["reason"].(string); message == "" {
+			message = reason
+		}
+		return setReady(types.StatusFalse, types.ReasonInferenceServiceNotReady, message)
+	}
+	return setReady(types.StatusUnknown, types.ReasonInferenceServiceNotReady,
+		fmt.Sprintf("InferenceService %s reports no readiness yet", key.Name))
+}
@@ -0,0 +1,250 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rpb "helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+)
+
+func TestRenderKServe(t *testing.T) {
+	singleModel := map[string]interface{}{"single_model_mode": true, "model_name": "resnet"}
+	kserveAPIs := []schema.GroupVersionKind{inferenceServiceGVK, servingRuntimeGVK}
+
+	tests := []struct {
+		name       string
+		values     map[string]interface{}
+		generated  map[string]interface{}
+		rollout    RolloutOptions
+		idle       IdlePolicy
+		apis       []schema.GroupVersionKind
+		expected   map[string]interface{}
+		errMessage string
+	}{
+		{
+			name:   "deployment",
+			values: map[string]interface{}{"models_settings": singleModel},
+			apis:   kserveAPIs,
+		},
+		{
+			name:   "auto without the API",
+			values: map[string]interface{}{"serving_platform": "Auto", "models_settings": singleModel},
+		},
+		{
+			name:   "auto",
+			values: map[string]interface{}{"serving_platform": "Auto", "models_settings": singleModel},
+			apis:   kserveAPIs,
+			expected: map[string]interface{}{
+				"deployment_mode": "RawDeployment", "model_format": "openvino_ir",
+				"min_replicas": int64(1), "max_replicas": int64(1),
+			},
+		},
+		{
+			name: "serverless with autoscaling",
+			values: map[string]interface{}{
+				"serving_platform": "KServe",
+				"models_settings":  singleModel,
+				"kserve":           map[string]interface{}{"deployment_mode": "Serverless", "model_format": "onnx"},
+				"autoscaling": map[string]interface{}{
+					"enabled": true, "min_replicas": int64(2), "max_replicas": int64(5),
+				},
+			},
+			apis: kserveAPIs,
+			expected: map[string]interface{}{
+				"deployment_mode": "Serverless", "model_format": "onnx",
+				"min_replicas": int64(2), "max_replicas": int64(5),
+			},
+		},
+		{
+			name: "replicas",
+			values: map[string]interface{}{
+				"serving_platform":      "KServe",
+				"models_settings":       singleModel,
+				"deployment_parameters": map[string]interface{}{"replicas": int64(3)},
+			},
+			apis: kserveAPIs,
+			expected: map[string]interface{}{
+				"deployment_mode": "RawDeployment", "model_format": "openvino_ir",
+				"min_replicas": int64(3), "max_replicas": int64(3),
+			},
+		},
+		{
+			name:       "missing API",
+			values:     map[string]interface{}{"serving_platform": "KServe", "models_settings": singleModel},
+			apis:       []schema.GroupVersionKind{servingRuntimeGVK},
+			errMessage: "serving_platform KServe requires the serving.kserve.io/v1beta1 API, which is not available",
+		},
+		{
+			name:       "invalid platform",
+			values:     map[string]interface{}{"serving_platform": "Knative"},
+			errMessage: `invalid serving_platform "Knative", expected Deployment, KServe or Auto`,
+		},
+		{
+			name: "invalid deployment mode",
+			values: map[string]interface{}{
+				"serving_platform": "KServe",
+				"models_settings":  singleModel,
+				"kserve":           map[string]interface{}{"deployment_mode": "ModelMesh"},
+			},
+			apis:       kserveAPIs,
+			errMessage: `invalid kserve.deployment_mode "ModelMesh", expected RawDeployment or Serverless`,
+		},
+		{
+			name: "multiple models",
+			values: map[string]interface{}{
+				"serving_platform": "KServe",
+				"models_settings":  map[string]interface{}{"single_model_mode": false},
+			},
+			apis:       kserveAPIs,
+			errMessage: "the KServe serving platform requires models_settings.single_model_mode",
+		},
+		{
+			name:       "idle policy",
+			values:     map[string]interface{}{"serving_platform": "KServe", "models_settings": singleModel},
+			idle:       IdlePolicy{Enabled: true},
+			apis:       kserveAPIs,
+			errMessage: "idle_policy is not supported with the KServe serving platform, use the Serverless deployment mode to scale to zero",
+		},
+		{
+			name:       "canary",
+			values:     map[string]interface{}{"serving_platform": "KServe", "models_settings": singleModel},
+			rollout:    RolloutOptions{Strategy: rolloutCanary},
+			apis:       kserveAPIs,
+			errMessage: "the Canary rollout strategy is not supported with the KServe serving platform",
+		},
+		{
+			name:       "tls",
+			values:     map[string]interface{}{"serving_platform": "KServe", "models_settings": singleModel},
+			generated:  map[string]interface{}{"tls": map[string]interface{}{"server_secret": "sample-tls"}},
+			apis:       kserveAPIs,
+			errMessage: "tls is not supported with the KServe serving platform",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := exposureReconciler(nil, test.apis...)
+			generated := test.generated
+			if generated == nil {
+				generated = map[string]interface{}{}
+			}
+			err := r.renderKServe(test.values, generated, test.rollout, test.idle)
+			if test.errMessage != "" {
+				assert.EqualError(t, err, test.errMessage)
+				return
+			}
+			assert.NoError(t, err)
+			if test.expected == nil {
+				assert.NotContains(t, generated, "kserve")
+				return
+			}
+			assert.Equal(t, test.expected, generated["kserve"])
+			assert.True(t, kserveEnabled(map[string]interface{}{generatedValuesKey: generated}))
+		})
+	}
+}
+
+func TestMirrorInferenceService(t *testing.T) {
+	rel := &rpb.Release{Namespace: "ns", Manifest: `---
+# Source: ovms/templates/kserve.yaml
+apiVersion: serving.kserve.io/v1beta1
+kind: InferenceService
+metadata:
+  name: sample-ovms
+`}
+	inferenceService := func(conditions ...interface{}) *unstructured.Unstructured {
+		o := &unstructured.Unstructured{}
+		o.SetGroupVersionKind(inferenceServiceGVK)
+		o.SetNamespace("ns")
+		o.SetName("sample-ovms")
+		if len(conditions) > 0 {
+			_ = unstructured.SetNestedField(o.Object, "http://sample-ovms.ns.example.com", "status", "url")
+			_ = unstructured.SetNestedSlice(o.Object, conditions, "status", "conditions")
+		}
+		return o
+	}
+
+	tests := []struct {
+		name    string
+		objects []client.Object
+		ready   bool
+		status  types.ConditionStatus
+		reason  types.HelmAppConditionReason
+		message string
+		url     string
+	}{
+		{
+			name: "ready",
+			objects: []client.Object{inferenceService(
+				map[string]interface{}{"type": "PredictorReady", "status": "True"},
+				map[string]interface{}{"type": "Ready", "status": "True"},
+			)},
+			ready:   true,
+			status:  types.StatusTrue,
+			reason:  types.ReasonInferenceServiceReady,
+			message: "InferenceService sample-ovms is ready",
+			url:     "http://sample-ovms.ns.example.com",
+		},
+		{
+			name: "not ready",
+			objects: []client.Object{inferenceService(
+				map[string]interface{}{"type": "Ready", "status": "False", "reason": "PredictorNotReady"},
+			)},
+			status:  types.StatusFalse,
+			reason:  types.ReasonInferenceServiceNotReady,
+			message: "PredictorNotReady",
+			url:     "http://sample-ovms.ns.example.com",
+		},
+		{
+			name:    "no status",
+			objects: []client.Object{inferenceService()},
+			status:  types.StatusUnknown,
+			reason:  types.ReasonInferenceServiceNotReady,
+			message: "InferenceService sample-ovms reports no readiness yet",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := exposureReconciler(test.objects, inferenceServiceGVK)
+			status := &types.HelmAppStatus{URL: "http://stale"}
+			assert.Equal(t, test.ready, r.mirrorInferenceService(context.TODO(), status, rel))
+			assert.Equal(t, test.url, status.URL)
+			c := status.GetCondition(types.ConditionInferenceServiceReady)
+			if assert.NotNil(t, c) {
+				assert.Equal(t, test.status, c.Status)
+				assert.Equal(t, test.reason, c.Reason)
+				assert.Equal(t, test.message, c.Message)
+			}
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		r := exposureReconciler(nil, inferenceServiceGVK)
+		status := &types.HelmAppStatus{}
+		assert.False(t, r.mirrorInferenceService(context.TODO(), status, rel))
+		assert.Equal(t, types.StatusUnknown, status.GetCondition(types.ConditionInferenceServiceReady).Status)
+	})
+}
@@ -0,0 +1,340 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// layoutCheckLabel is set to the ModelServer name on the jobs checking
+	// the layout of its local model paths.
+	layoutCheckLabel  = "intel.com/model-layout-check"
+	layoutCheckSuffix = "-layout-check"
+	// modelsMountPath is the directory of the model server container where
+	// the models volume is mounted.
+	modelsMountPath = "/models"
+
+	// layoutCheckJobTTL is the time a finished layout check job is kept. A
+	// failed check is reported until the job is deleted, then the layout is
+	// checked again.
+	layoutCheckJobTTL = 5 * time.Minute
+	// layoutCheckDeadline limits the duration of a layout check job,
+	// including pulling the image and mounting the volume.
+	layoutCheckDeadline = 10 * time.Minute
+)
+
+// errLayoutCheckPending is returned while a job checks the layout of the
+// local model paths.
+var errLayoutCheckPending = errors.New("the layout of the local model paths is being checked")
+
+// checkModelVolume checks the layout of the local model paths with a job
+// running the model server image, with the models volume mounted like in the
+// model server pods. It returns errLayoutCheckPending until the job
+// completes. The reasons why the paths cannot be checked are reported with
+// skip.
+func (r HelmOperatorReconciler) checkModelVolume(ctx context.Context, o *unstructured.Unstructured,
+	values map[string]interface{}, paths []string, skip func(format string, args ...interface{})) error {
+
+	repository, _, _ := unstructured.NestedMap(values, "models_repository")
+	claim, _, _ := unstructured.NestedString(repository, "models_volume_claim")
+	hostPath, _, _ := unstructured.NestedString(repository, "models_host_path")
+	node := ""
+	if claim != "" && hostPath == "" {
+		pvc := &corev1.PersistentVolumeClaim{}
+		if err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: o.GetNamespace(), Name: claim}, pvc); err != nil {
+			if apierrors.IsNotFound(err) {
+				return fmt.Errorf("persistent volume claim %q set in models_repository.models_volume_claim not found", claim)
+			}
+			return err
+		}
+		var err error
+		var exclusive bool
+		node, exclusive, err = r.claimNode(ctx, pvc)
+		if err != nil {
+			return err
+		}
+		if exclusive {
+			skip("The layout of models in persistent volume claim %q is not checked while it is mounted, "+
+				"its access mode is %s", claim, corev1.ReadWriteOncePod)
+			return nil
+		}
+	}
+	image, _, _ := unstructured.NestedString(values, "image_name")
+	if image == "" {
+		skip("The layout of the local model paths is not checked without image_name")
+		return nil
+	}
+
+	job, err := layoutCheckJob(o, values, paths, node)
+	if err != nil {
+		return err
+	}
+	// the jobs are not cached, they are not labeled with the chart
+	existing := &batchv1.Job{}
+	err = r.APIReader.Get(ctx, client.ObjectKeyFromObject(job), existing)
+	switch {
+	case apierrors.IsNotFound(err):
+		if err := r.deleteLayoutCheckJobs(ctx, o, job.Name); err != nil {
+			return err
+		}
+		if err := r.Client.Create(ctx, job); err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create the model layout check job %q: %w", job.Name, err)
+		}
+		log.Info("Checking the layout of the local model paths", "job", job.Name)
+		return errLayoutCheckPending
+	case err != nil:
+		return fmt.Errorf("failed to get the model layout check job %q: %w", job.Name, err)
+	case jobCondition(existing, batchv1.JobComplete):
+		// the layout is checked again before the next upgrade
+		if err := r.Client.Delete(ctx, existing, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+			!apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete the model layout check job %q: %w", job.Name, err)
+		}
+		return nil
+	case jobCondition(existing, batchv1.JobFailed):
+		return r.layoutCheckFailure(ctx, existing)
+	}
+	return errLayoutCheckPending
+}
+
+// claimNode returns the node of a pod mounting the persistent volume claim
+// if the claim can only be mounted from a single node, so that a job
+// mounting it is scheduled on that node. It reports whether the claim is
+// mounted with the ReadWriteOncePod access mode, which prevents another pod
+// from mounting it.
+func (r HelmOperatorReconciler) claimNode(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (string, bool, error) {
+	modes := pvc.Status.AccessModes
+	if len(modes) == 0 {
+		modes = pvc.Spec.AccessModes
+	}
+	for _, mode := range modes {
+		if mode == corev1.ReadWriteMany || mode == corev1.ReadOnlyMany {
+			return "", false, nil
+		}
+	}
+	pods := &corev1.PodList{}
+	if err := r.APIReader.List(ctx, pods, client.InNamespace(pvc.Namespace)); err != nil {
+		return "", false, fmt.Errorf("failed to list the pods mounting persistent volume claim %q: %w", pvc.Name, err)
+	}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == pvc.Name {
+				for _, mode := range modes {
+					if mode == corev1.ReadWriteOncePod {
+						return pod.Spec.NodeName, true, nil
+					}
+				}
+				return pod.Spec.NodeName, false, nil
+			}
+		}
+	}
+	return "", false, nil
+}
+
+// nodeNameAffinity returns the affinity scheduling a pod on the node.
+func nodeNameAffinity(node string) *corev1.Affinity {
+	return &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+			MatchFields: []corev1.NodeSelectorRequirement{{
+				Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{node},
+			}},
+		}}},
+	}}
+}
+
+// layoutCheckFailure returns the error reported by the failed layout check
+// job, read from the termination message of its pod.
+func (r HelmOperatorReconciler) layoutCheckFailure(ctx context.Context, job *batchv1.Job) error {
+	message := ""
+	pods := &corev1.PodList{}
+	if err := r.APIReader.List(ctx, pods, client.InNamespace(job.Namespace),
+		client.MatchingLabels{"job-name": job.Name}); err != nil {
+		log.V(1).Info("Failed to list the pods of the model layout check job", "job", job.Name, "error", err.Error())
+	}
+	for _, pod := range pods.Items {
+		for _, s := range pod.Status.ContainerStatuses {
+			if s.State.Terminated != nil && strings.TrimSpace(s.State.Terminated.Message) != "" {
+				message = strings.TrimSpace(s.State.Terminated.Message)
+			}
+		}
+	}
+	if message == "" {
+		message = fmt.Sprintf("the model layout check job %q failed", job.Name)
+		for _, c := range job.Status.Conditions {
+			if c.Type == batchv1.JobFailed && c.Message != "" {
+				message += ": " + c.Message
+			}
+		}
+	}
+	return errors.New(message)
+}
+
+// deleteLayoutCheckJobs deletes the layout check jobs of the ModelServer
+// other than the named one.
+func (r HelmOperatorReconciler) deleteLayoutCheckJobs(ctx context.Context, o *unstructured.Unstructured, keep string) error {
+	jobs := &batchv1.JobList{}
+	if err := r.APIReader.List(ctx, jobs, client.InNamespace(o.GetNamespace()),
+		client.MatchingLabels{layoutCheckLabel: o.GetName()}); err != nil {
+		return fmt.Errorf("failed to list the model layout check jobs: %w", err)
+	}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if job.Name == keep {
+			continue
+		}
+		if err := r.Client.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+			!apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete the model layout check job %q: %w", job.Name, err)
+		}
+	}
+	return nil
+}
+
+// layoutCheckScript returns the shell script checking that each model path
+// includes a version directory, reporting the first invalid path like the
+// check of the model storages.
+func layoutCheckScript(paths []string) string {
+	var script strings.Builder
+	script.WriteString(`check() {
+  if [ ! -d "$1" ]; then
+    echo "model path $1: not found"
+    exit 1
+  fi
+  for d in "$1"/*/; do
+    v=$(basename "$d")
+    case "$v" in *[!0-9]*) continue ;; esac
+    [ "$v" -gt 0 ] && return 0
+  done
+  echo "model path $1 does not include any version directory, expected the model files in $1/<version>/"
+  exit 1
+}
+`)
+	for _, p := range paths {
+		fmt.Fprintf(&script, "check %s\n", shellQuote(strings.TrimSuffix(p, "/")))
+	}
+	return script.String()
+}
+
+// layoutCheckJob returns the job checking the layout of the local model
+// paths. It runs the model server image with the identity of the model
+// server, on the node of the pods mounting the models volume if it can only
+// be mounted from a single node, or else with the node affinity of the model
+// server pods. The job name includes a hash of its pod, so that a change of
+// the paths, the volume or the image is checked again.
+func layoutCheckJob(o *unstructured.Unstructured, values map[string]interface{}, paths []string,
+	node string) (*batchv1.Job, error) {
+
+	repository, _, _ := unstructured.NestedMap(values, "models_repository")
+	image, _, _ := unstructured.NestedString(values, "image_name")
+	pod := corev1.PodSpec{
+		RestartPolicy: corev1.RestartPolicyNever,
+		Containers: []corev1.Container{{
+			Name:                     "check",
+			Image:                    image,
+			Command:                  []string{"/bin/sh", "-c", layoutCheckScript(paths)},
+			TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+		}},
+	}
+	claim, _, _ := unstructured.NestedString(repository, "models_volume_claim")
+	hostPath, _, _ := unstructured.NestedString(repository, "models_host_path")
+	switch {
+	case hostPath != "":
+		directory := corev1.HostPathDirectory
+		pod.Volumes = []corev1.Volume{{Name: "models", VolumeSource: corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{Path: hostPath, Type: &directory},
+		}}}
+	case claim != "":
+		pod.Volumes = []corev1.Volume{{Name: "models", VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim, ReadOnly: true},
+		}}}
+	}
+	if len(pod.Volumes) > 0 {
+		pod.Containers[0].VolumeMounts = []corev1.VolumeMount{{Name: "models", MountPath: modelsMountPath, ReadOnly: true}}
+	}
+
+	switch affinity, found, _ := unstructured.NestedFieldNoCopy(values, "generated", "node_affinity"); {
+	case node != "":
+		pod.Affinity = nodeNameAffinity(node)
+	default:
+		if !found || affinity == nil {
+			affinity, found, _ = unstructured.NestedFieldNoCopy(values, "deployment_parameters", "node_affinity")
+		}
+		if found && affinity != nil {
+			nodeAffinity := &corev1.NodeAffinity{}
+			if err := decodeStrict(affinity, nodeAffinity); err != nil {
+				return nil, fmt.Errorf("invalid deployment_parameters.node_affinity: %w", err)
+			}
+			pod.Affinity = &corev1.Affinity{NodeAffinity: nodeAffinity}
+		}
+	}
+	securityContext, err := repositorySecurityContext(repository)
+	if err != nil {
+		return nil, err
+	}
+	pod.SecurityContext = securityContext
+
+	spec, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(spec)
+	suffix := layoutCheckSuffix + "-" + hex.EncodeToString(sum[:])[:10]
+	name := o.GetName()
+	if len(name)+len(suffix) > 63 {
+		name = strings.TrimSuffix(name[:63-len(suffix)], "-")
+	}
+
+	backoffLimit := int32(0)
+	deadline := int64(layoutCheckDeadline.Seconds())
+	ttl := int32(layoutCheckJobTTL.Seconds())
+	labels := map[string]string{layoutCheckLabel: o.GetName()}
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       o.GetNamespace(),
+			Name:            name + suffix,
+			Labels:          labels,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(o, o.GroupVersionKind())},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			ActiveDeadlineSeconds:   &deadline,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       pod,
+			},
+		},
+	}, nil
+}
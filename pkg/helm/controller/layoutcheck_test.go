@@ -0,0 +1,178 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func layoutValues(image string) map[string]interface{} {
+	return map[string]interface{}{
+		"image_name": image,
+		"models_repository": map[string]interface{}{
+			"models_volume_claim": "models",
+			"runAsUser":           int64(5000),
+		},
+	}
+}
+
+func TestCheckModelVolume(t *testing.T) {
+	cl := fake.NewClientBuilder().WithStatusSubresource(&batchv1.Job{}).WithObjects(
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "models"},
+			Spec:       corev1.PersistentVolumeClaimSpec{AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "sample-ovms"},
+			Spec: corev1.PodSpec{
+				NodeName: "node-1",
+				Volumes: []corev1.Volume{{Name: "models", VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "models"},
+				}}},
+			},
+		},
+	).Build()
+	r := HelmOperatorReconciler{Client: cachedClient(cl), APIReader: cl}
+	o := testModelServer("default")
+	o.SetGroupVersionKind(testModelServerGVK)
+	paths := []string{"/models/resnet", "/models/face"}
+	skip := func(format string, args ...interface{}) { t.Errorf(format, args...) }
+
+	// a job mounting the claim is created on the node of the model server
+	err := r.checkModelVolume(context.TODO(), o, layoutValues("openvino/model_server:2024.0"), paths, skip)
+	assert.ErrorIs(t, err, errLayoutCheckPending)
+	jobs := &batchv1.JobList{}
+	assert.NoError(t, cl.List(context.TODO(), jobs))
+	if !assert.Len(t, jobs.Items, 1) {
+		return
+	}
+	job := jobs.Items[0]
+	assert.Equal(t, "sample", job.Labels[layoutCheckLabel])
+	pod := job.Spec.Template.Spec
+	assert.Equal(t, "openvino/model_server:2024.0", pod.Containers[0].Image)
+	assert.Contains(t, pod.Containers[0].Command[2], "check '/models/resnet'\ncheck '/models/face'\n")
+	assert.Equal(t, "models", pod.Volumes[0].PersistentVolumeClaim.ClaimName)
+	assert.Equal(t, []corev1.VolumeMount{{Name: "models", MountPath: "/models", ReadOnly: true}},
+		pod.Containers[0].VolumeMounts)
+	assert.Equal(t, int64(5000), *pod.SecurityContext.RunAsUser)
+	assert.Equal(t, []string{"node-1"},
+		pod.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchFields[0].Values)
+
+	err = r.checkModelVolume(context.TODO(), o, layoutValues("openvino/model_server:2024.0"), paths, skip)
+	assert.ErrorIs(t, err, errLayoutCheckPending)
+
+	// the completed job is deleted
+	completeJob(t, cl, job.Name, batchv1.JobComplete)
+	err = r.checkModelVolume(context.TODO(), o, layoutValues("openvino/model_server:2024.0"), paths, skip)
+	assert.NoError(t, err)
+	err = cl.Get(context.TODO(), client.ObjectKeyFromObject(&job), &batchv1.Job{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// a new image is checked again, the failure is read from the job pod
+	err = r.checkModelVolume(context.TODO(), o, layoutValues("openvino/model_server:2024.1"), paths, skip)
+	assert.ErrorIs(t, err, errLayoutCheckPending)
+	assert.NoError(t, cl.List(context.TODO(), jobs))
+	if !assert.Len(t, jobs.Items, 1) {
+		return
+	}
+	assert.NotEqual(t, job.Name, jobs.Items[0].Name)
+	job = jobs.Items[0]
+	message := "model path /models/face does not include any version directory, " +
+		"expected the model files in /models/face/<version>/"
+	assert.NoError(t, cl.Create(context.TODO(), &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: job.Name + "-x", Labels: map[string]string{"job-name": job.Name}},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodFailed,
+			ContainerStatuses: []corev1.ContainerStatus{{State: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Message: message + "\n"},
+			}}},
+		},
+	}))
+	completeJob(t, cl, job.Name, batchv1.JobFailed)
+	err = r.checkModelVolume(context.TODO(), o, layoutValues("openvino/model_server:2024.1"), paths, skip)
+	assert.EqualError(t, err, message)
+}
+
+func TestCheckModelVolumeReadWriteOncePod(t *testing.T) {
+	cl := fake.NewClientBuilder().WithObjects(
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "models"},
+			Spec:       corev1.PersistentVolumeClaimSpec{AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "sample-ovms"},
+			Spec: corev1.PodSpec{
+				NodeName: "node-1",
+				Volumes: []corev1.Volume{{Name: "models", VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "models"},
+				}}},
+			},
+		},
+	).Build()
+	r := HelmOperatorReconciler{Client: cachedClient(cl), APIReader: cl}
+	o := testModelServer("default")
+	o.SetGroupVersionKind(testModelServerGVK)
+	var skipped []string
+	skip := func(format string, args ...interface{}) { skipped = append(skipped, format) }
+
+	err := r.checkModelVolume(context.TODO(), o, layoutValues("openvino/model_server:2024.0"),
+		[]string{"/models/resnet"}, skip)
+	assert.NoError(t, err)
+	assert.Len(t, skipped, 1)
+	jobs := &batchv1.JobList{}
+	assert.NoError(t, cl.List(context.TODO(), jobs))
+	assert.Empty(t, jobs.Items)
+}
+
+func TestLayoutCheckJobAffinity(t *testing.T) {
+	o := testModelServer("default")
+	o.SetGroupVersionKind(testModelServerGVK)
+	values := map[string]interface{}{
+		"image_name":        "openvino/model_server:2024.0",
+		"models_repository": map[string]interface{}{"models_host_path": "/opt/models"},
+		"deployment_parameters": map[string]interface{}{"node_affinity": map[string]interface{}{
+			"requiredDuringSchedulingIgnoredDuringExecution": map[string]interface{}{
+				"nodeSelectorTerms": []interface{}{map[string]interface{}{
+					"matchExpressions": []interface{}{map[string]interface{}{
+						"key": "models", "operator": "Exists",
+					}},
+				}},
+			},
+		}},
+	}
+	job, err := layoutCheckJob(o, values, []string{"/models/resnet"}, "")
+	assert.NoError(t, err)
+	pod := job.Spec.Template.Spec
+	assert.Equal(t, "/opt/models", pod.Volumes[0].HostPath.Path)
+	assert.Nil(t, pod.SecurityContext)
+	assert.Equal(t, "models",
+		pod.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions[0].Key)
+
+	o.SetName("a-very-long-model-server-name-exceeding-the-length-of-job-names")
+	job, err = layoutCheckJob(o, values, []string{"/models/resnet"}, "")
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, len(job.Name), 63)
+}
@@ -0,0 +1,142 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/helm/release"
+	"github.com/openvinotoolkit/operator/pkg/maintenance"
+)
+
+const (
+	// maintenanceWindowAnnotation sets the cron schedule of the maintenance
+	// window of a custom resource, or of all the custom resources of a
+	// namespace when set on the Namespace.
+	maintenanceWindowAnnotation         = "intel.com/maintenance-window"
+	maintenanceWindowDurationAnnotation = "intel.com/maintenance-window-duration"
+)
+
+// maintenanceWindowFor returns the maintenance window of the custom
+// resource, set with annotations on the custom resource, else on its
+// namespace, else in the watch. It returns nil if no window is set, so that
+// upgrades are performed at any time.
+func (r HelmOperatorReconciler) maintenanceWindowFor(ctx context.Context, o *unstructured.Unstructured) (*maintenance.Window, error) {
+	w, err := annotatedMaintenanceWindow(o.GetAnnotations())
+	if err != nil {
+		return nil, fmt.Errorf("invalid maintenance window of %s %s: %w", o.GetKind(), o.GetName(), err)
+	}
+	if w != nil {
+		return w, nil
+	}
+	// the namespaces are not cached
+	ns := &corev1.Namespace{}
+	if err := r.APIReader.Get(ctx, client.ObjectKey{Name: o.GetNamespace()}, ns); err != nil {
+		if apierrors.IsNotFound(err) {
+			return r.MaintenanceWindow, nil
+		}
+		return nil, fmt.Errorf("failed to get the maintenance window of namespace %s: %w", o.GetNamespace(), err)
+	}
+	w, err = annotatedMaintenanceWindow(ns.GetAnnotations())
+	if err != nil {
+		return nil, fmt.Errorf("invalid maintenance window of namespace %s: %w", ns.GetName(), err)
+	}
+	if w != nil {
+		return w, nil
+	}
+	return r.MaintenanceWindow, nil
+}
+
+// annotatedMaintenanceWindow returns the maintenance window set by the
+// annotations, or nil if the schedule annotation is not set.
+func annotatedMaintenanceWindow(annotations map[string]string) (*maintenance.Window, error) {
+	schedule := annotations[maintenanceWindowAnnotation]
+	if schedule == "" {
+		return nil, nil
+	}
+	duration := maintenance.DefaultDuration
+	if value := annotations[maintenanceWindowDurationAnnotation]; value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid annotation %s: %w", maintenanceWindowDurationAnnotation, err)
+		}
+		duration = d
+	}
+	return maintenance.NewWindow(schedule, duration)
+}
+
+// upgradeRequested reports whether the custom resource changed since its
+// release was deployed. Releases deployed before the generation was
+// recorded are considered unchanged.
+func upgradeRequested(o *unstructured.Unstructured, status *types.HelmAppStatus) bool {
+	return status.DeployedRelease != nil && status.DeployedRelease.Generation != 0 &&
+		status.DeployedRelease.Generation != o.GetGeneration()
+}
+
+// deferUpgrade defers the upgrade of a release not requested by a change of
+// the custom resource, like the upgrade to the chart of a new operator
+// version or to an updated image, until the maintenance window of the custom
+// resource opens. A deferred upgrade is reported by the UpgradePending
+// condition. It returns whether the upgrade is deferred and the time left
+// before the window opens.
+func (r HelmOperatorReconciler) deferUpgrade(ctx context.Context, o *unstructured.Unstructured,
+	status *types.HelmAppStatus, manager release.Manager, now time.Time) (bool, time.Duration, error) {
+
+	if !manager.IsUpgradeRequired() || upgradeRequested(o, status) {
+		status.RemoveCondition(types.ConditionUpgradePending)
+		return false, 0, nil
+	}
+	window, err := r.maintenanceWindowFor(ctx, o)
+	if err != nil {
+		return false, 0, err
+	}
+	if window.Open(now) {
+		status.RemoveCondition(types.ConditionUpgradePending)
+		return false, 0, nil
+	}
+
+	upgrade := "The upgrade of the release"
+	if manager.IsChartChanged() {
+		upgrade = "The upgrade to a new chart"
+	}
+	next := window.Next(now)
+	message := fmt.Sprintf("%s waits for the maintenance window %s, which never opens", upgrade, window)
+	delay := time.Duration(0)
+	if !next.IsZero() {
+		message = fmt.Sprintf("%s waits for the maintenance window %s, opening at %s",
+			upgrade, window, next.UTC().Format(time.RFC3339))
+		delay = next.Sub(now)
+	}
+	if status.GetCondition(types.ConditionUpgradePending) == nil {
+		r.EventRecorder.Event(o, "Normal", "UpgradeDeferred", message)
+	}
+	status.SetCondition(types.HelmAppCondition{
+		Type:    types.ConditionUpgradePending,
+		Status:  types.StatusTrue,
+		Reason:  types.ReasonMaintenanceWindowClosed,
+		Message: message,
+	})
+	return true, delay, nil
+}
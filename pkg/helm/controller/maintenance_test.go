@@ -0,0 +1,275 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/helm/release"
+	"github.com/openvinotoolkit/operator/pkg/maintenance"
+)
+
+func testWindow(schedule string, duration time.Duration) *maintenance.Window {
+	w, err := maintenance.NewWindow(schedule, duration)
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
+
+// upgradeManager is a release manager reporting the upgrade to perform.
+type upgradeManager struct {
+	release.Manager
+	upgradeRequired bool
+	chartChanged    bool
+}
+
+func (m upgradeManager) IsUpgradeRequired() bool { return m.upgradeRequired }
+func (m upgradeManager) IsChartChanged() bool    { return m.chartChanged }
+
+func TestMaintenanceWindowFor(t *testing.T) {
+	crWindow := map[string]string{maintenanceWindowAnnotation: "0 2 * * *", maintenanceWindowDurationAnnotation: "2h"}
+	nsWindow := map[string]string{maintenanceWindowAnnotation: "0 3 * * sat"}
+
+	tests := []struct {
+		name          string
+		annotations   map[string]string
+		nsAnnotations map[string]string
+		watch         *maintenance.Window
+		getErr        error
+		expected      *maintenance.Window
+		errMessage    string
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:     "watch",
+			watch:    testWindow("0 4 * * *", time.Hour),
+			expected: testWindow("0 4 * * *", time.Hour),
+		},
+		{
+			name:          "namespace",
+			nsAnnotations: nsWindow,
+			watch:         testWindow("0 4 * * *", time.Hour),
+			expected:      testWindow("0 3 * * sat", maintenance.DefaultDuration),
+		},
+		{
+			name:     "namespace not found",
+			getErr:   apierrors.NewNotFound(schema.GroupResource{Resource: "namespaces"}, "ns"),
+			watch:    testWindow("0 4 * * *", time.Hour),
+			expected: testWindow("0 4 * * *", time.Hour),
+		},
+		{
+			name:       "namespace forbidden",
+			getErr:     apierrors.NewForbidden(schema.GroupResource{Resource: "namespaces"}, "ns", errors.New("denied")),
+			watch:      testWindow("0 4 * * *", time.Hour),
+			errMessage: `failed to get the maintenance window of namespace ns: namespaces "ns" is forbidden: denied`,
+		},
+		{
+			name:          "custom resource",
+			annotations:   crWindow,
+			nsAnnotations: nsWindow,
+			watch:         testWindow("0 4 * * *", time.Hour),
+			expected:      testWindow("0 2 * * *", 2*time.Hour),
+		},
+		{
+			name:        "invalid schedule",
+			annotations: map[string]string{maintenanceWindowAnnotation: "0 25 * * *"},
+			errMessage:  "invalid maintenance window of ModelServer sample: invalid schedule: end of range (25) above maximum (23): 25",
+		},
+		{
+			name: "invalid duration",
+			nsAnnotations: map[string]string{
+				maintenanceWindowAnnotation:         "0 3 * * *",
+				maintenanceWindowDurationAnnotation: "1 hour",
+			},
+			errMessage: `invalid maintenance window of namespace ns: invalid annotation intel.com/maintenance-window-duration: time: unknown unit " hour" in duration "1 hour"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns", Annotations: test.nsAnnotations}}
+			cl := fake.NewClientBuilder().WithObjects(ns).Build()
+			reader := interceptor.NewClient(cl, interceptor.Funcs{
+				Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object,
+					opts ...client.GetOption) error {
+					if test.getErr != nil {
+						return test.getErr
+					}
+					return c.Get(ctx, key, obj, opts...)
+				},
+			})
+			r := HelmOperatorReconciler{
+				Client:            cachedClient(cl),
+				APIReader:         reader,
+				MaintenanceWindow: test.watch,
+			}
+			o := testModelServer("ns")
+			o.SetKind("ModelServer")
+			o.SetAnnotations(test.annotations)
+
+			w, err := r.maintenanceWindowFor(context.TODO(), o)
+			if test.errMessage != "" {
+				assert.EqualError(t, err, test.errMessage)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, w)
+		})
+	}
+}
+
+func TestDeferUpgrade(t *testing.T) {
+	// Saturday, outside of the window opening at 02:00 every day
+	now := time.Date(2022, 6, 4, 12, 0, 0, 0, time.UTC)
+	deployed := func(generation int64) *types.HelmAppStatus {
+		return &types.HelmAppStatus{DeployedRelease: &types.HelmAppRelease{Name: "sample", Generation: generation}}
+	}
+
+	tests := []struct {
+		name       string
+		manager    upgradeManager
+		status     *types.HelmAppStatus
+		generation int64
+		window     *maintenance.Window
+		deferred   bool
+		delay      time.Duration
+		message    string
+	}{
+		{
+			name:    "no window",
+			manager: upgradeManager{upgradeRequired: true, chartChanged: true},
+			status:  deployed(1),
+		},
+		{
+			name:    "no upgrade",
+			manager: upgradeManager{chartChanged: true},
+			status:  deployed(1),
+			window:  testWindow("0 2 * * *", time.Hour),
+		},
+		{
+			name:     "values changed",
+			manager:  upgradeManager{upgradeRequired: true},
+			status:   deployed(1),
+			window:   testWindow("0 2 * * *", time.Hour),
+			deferred: true,
+			delay:    14 * time.Hour,
+			message:  `The upgrade of the release waits for the maintenance window "0 2 * * *" for 1h0m0s, opening at 2022-06-05T02:00:00Z`,
+		},
+		{
+			name:       "values changed with the custom resource",
+			manager:    upgradeManager{upgradeRequired: true},
+			status:     deployed(1),
+			generation: 2,
+			window:     testWindow("0 2 * * *", time.Hour),
+		},
+		{
+			name:       "custom resource changed",
+			manager:    upgradeManager{upgradeRequired: true, chartChanged: true},
+			status:     deployed(1),
+			generation: 2,
+			window:     testWindow("0 2 * * *", time.Hour),
+		},
+		{
+			name:    "window open",
+			manager: upgradeManager{upgradeRequired: true, chartChanged: true},
+			status:  deployed(1),
+			window:  testWindow("0 11 * * *", 2*time.Hour),
+		},
+		{
+			name:     "window closed",
+			manager:  upgradeManager{upgradeRequired: true, chartChanged: true},
+			status:   deployed(1),
+			window:   testWindow("0 2 * * *", time.Hour),
+			deferred: true,
+			delay:    14 * time.Hour,
+		},
+		{
+			name:       "release without generation",
+			manager:    upgradeManager{upgradeRequired: true, chartChanged: true},
+			status:     deployed(0),
+			generation: 3,
+			window:     testWindow("0 2 * * *", time.Hour),
+			deferred:   true,
+			delay:      14 * time.Hour,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(2)
+			cl := fake.NewClientBuilder().Build()
+			r := HelmOperatorReconciler{
+				Client:            cachedClient(cl),
+				APIReader:         cl,
+				EventRecorder:     recorder,
+				MaintenanceWindow: test.window,
+			}
+			o := testModelServer("ns")
+			o.SetGeneration(test.generation)
+			if o.GetGeneration() == 0 {
+				o.SetGeneration(1)
+			}
+			if !test.deferred {
+				// a pending upgrade is no longer reported
+				test.status.SetCondition(types.HelmAppCondition{Type: types.ConditionUpgradePending, Status: types.StatusTrue})
+			}
+
+			deferred, delay, err := r.deferUpgrade(context.TODO(), o, test.status, test.manager, now)
+			assert.NoError(t, err)
+			assert.Equal(t, test.deferred, deferred)
+			assert.Equal(t, test.delay, delay)
+			c := test.status.GetCondition(types.ConditionUpgradePending)
+			if !test.deferred {
+				assert.Nil(t, c)
+				assert.Empty(t, recorder.Events)
+				return
+			}
+			if assert.NotNil(t, c) {
+				assert.Equal(t, types.ReasonMaintenanceWindowClosed, c.Reason)
+				message := `The upgrade to a new chart waits for the maintenance window "0 2 * * *" for 1h0m0s, opening at 2022-06-05T02:00:00Z`
+				if test.message != "" {
+					message = test.message
+				}
+				assert.Equal(t, message, c.Message)
+			}
+			assert.Len(t, recorder.Events, 1)
+
+			// the event is recorded once while the upgrade is pending
+			deferred, _, err = r.deferUpgrade(context.TODO(), o, test.status, test.manager, now.Add(time.Hour))
+			assert.NoError(t, err)
+			assert.True(t, deferred)
+			assert.Len(t, recorder.Events, 1)
+		})
+	}
+}
@@ -0,0 +1,752 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/modelrepo"
+)
+
+const (
+	modelCacheSuffix = "-model-cache"
+	// modelCacheMountPath is the directory of the model server container
+	// where the model cache is mounted.
+	modelCacheMountPath = "/models-cache"
+	// modelCacheJobMountPath is the directory of the sync job container
+	// where the model cache is mounted.
+	modelCacheJobMountPath = "/cache"
+
+	// modelCacheLabel is set to the ModelServer name on its sync jobs, and
+	// modelCacheVersionLabel to the version they sync.
+	modelCacheLabel        = "intel.com/model-cache"
+	modelCacheVersionLabel = "intel.com/model-cache-version"
+
+	defaultModelCacheSize  = "10Gi"
+	defaultModelCacheImage = "rclone/rclone:1.68"
+	// modelCacheSyncPeriod is the period of the checks of a running sync
+	// job.
+	modelCacheSyncPeriod = 15 * time.Second
+	// modelCacheJobTTL is the time a finished sync job is kept. A failed
+	// job is retried once it is deleted.
+	modelCacheJobTTL = time.Hour
+	// rcloneRemote is the name of the rclone remote of the model storage,
+	// configured with RCLONE_CONFIG_STORAGE_* environment variables.
+	rcloneRemote = "storage"
+)
+
+// ModelCacheOptions configures the model cache of a ModelServer, set in the
+// model_cache section of the values. The models are synced from the cloud
+// storage into a persistent volume claim by a job, and the model server
+// reads them from the claim.
+type ModelCacheOptions struct {
+	Enabled          bool
+	Size             string
+	StorageClassName string
+	AccessMode       corev1.PersistentVolumeAccessMode
+	// KeepVersions is the number of versions kept in the cache, including
+	// the version being synced.
+	KeepVersions int64
+	Image        string
+	// Revision is included in the version of the models, so that changing
+	// it syncs the models again.
+	Revision string
+}
+
+func modelCacheOptionsFor(values map[string]interface{}) (ModelCacheOptions, error) {
+	opts := ModelCacheOptions{
+		Size:         defaultModelCacheSize,
+		AccessMode:   corev1.ReadWriteOnce,
+		KeepVersions: 2,
+		Image:        defaultModelCacheImage,
+	}
+	cache, _, _ := unstructured.NestedMap(values, "model_cache")
+	opts.Enabled, _ = cache["enabled"].(bool)
+	if !opts.Enabled {
+		return opts, nil
+	}
+	if size, _ := cache["size"].(string); size != "" {
+		if _, err := resource.ParseQuantity(size); err != nil {
+			return opts, fmt.Errorf("invalid model_cache.size %q", size)
+		}
+		opts.Size = size
+	}
+	opts.StorageClassName, _ = cache["storage_class_name"].(string)
+	if mode, _ := cache["access_mode"].(string); mode != "" {
+		opts.AccessMode = corev1.PersistentVolumeAccessMode(mode)
+		switch opts.AccessMode {
+		case corev1.ReadWriteOnce, corev1.ReadWriteMany:
+		case corev1.ReadWriteOncePod:
+			// the sync job mounts the claim while the model server pods serve
+			// the previous version
+			return opts, fmt.Errorf("invalid model_cache.access_mode %q, the claim is mounted by the sync job "+
+				"and the model server pods, expected ReadWriteOnce or ReadWriteMany", mode)
+		default:
+			return opts, fmt.Errorf("invalid model_cache.access_mode %q, expected ReadWriteOnce or ReadWriteMany", mode)
+		}
+	}
+	if v, found := cache["keep_versions"]; found {
+		opts.KeepVersions = int64Value(v)
+		if opts.KeepVersions < 2 {
+			return opts, errors.New("model_cache.keep_versions must be at least 2, to keep the version served " +
+				"during the rollout of a new one")
+		}
+	}
+	if image, _ := cache["image"].(string); image != "" {
+		opts.Image = image
+	}
+	if revision, found := cache["revision"]; found && revision != nil {
+		opts.Revision = fmt.Sprint(revision)
+	}
+	return opts, nil
+}
+
+// cacheSource is a model path in the cloud storage synced into the cache,
+// under the directory named by its index in the models.
+type cacheSource struct {
+	index    int
+	location modelrepo.Location
+}
+
+// cacheSources returns the model paths of the ModelServer values in the
+// cloud storage. Models in a volume or a host path are not cached.
+func cacheSources(values map[string]interface{}) ([]cacheSource, error) {
+	if name, _, _ := unstructured.NestedString(values, "models_settings", "config_configmap_name"); name != "" {
+		return nil, errors.New("model_cache cannot be used with config_configmap_name")
+	}
+	var paths []string
+	if multiModel(values) {
+		models, _, _ := unstructured.NestedSlice(values, "models_settings", "models")
+		for _, m := range models {
+			model, _ := m.(map[string]interface{})
+			basePath, _ := model["base_path"].(string)
+			paths = append(paths, basePath)
+		}
+	} else {
+		modelPath, _, _ := unstructured.NestedString(values, "models_settings", "model_path")
+		paths = append(paths, modelPath)
+	}
+	var sources []cacheSource
+	for i, p := range paths {
+		if location, ok := modelrepo.ParseLocation(p); ok {
+			sources = append(sources, cacheSource{index: i, location: location})
+		}
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("model_cache requires models in S3, Google Cloud Storage or Azure Blob Storage")
+	}
+	for _, s := range sources[1:] {
+		if s.location.Scheme != sources[0].location.Scheme {
+			return nil, errors.New("model_cache requires all the models in the same storage type")
+		}
+	}
+	return sources, nil
+}
+
+// multiModel reports whether the ModelServer values serve the models listed
+// in models_settings.models rather than a single model.
+func multiModel(values map[string]interface{}) bool {
+	single, found, _ := unstructured.NestedBool(values, "models_settings", "single_model_mode")
+	return found && !single
+}
+
+// cacheVersion returns the version of the models synced from the sources,
+// identifying their paths, the storage and the cache revision.
+func cacheVersion(sources []cacheSource, repository map[string]interface{}, revision string) string {
+	key := struct {
+		Paths    []string `json:"paths"`
+		Endpoint string   `json:"endpoint,omitempty"`
+		Revision string   `json:"revision,omitempty"`
+	}{Revision: revision}
+	for _, s := range sources {
+		key.Paths = append(key.Paths, fmt.Sprintf("%d=%s", s.index, s.location))
+	}
+	key.Endpoint, _ = repository["s3_compat_api_endpoint"].(string)
+	b, _ := json.Marshal(key)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])[:10]
+}
+
+// renderModelCache syncs the models of the ModelServer into its model cache
+// and serves them from the cache once synced. A version of the models is
+// synced by a job into a directory of the cache named after it, and the
+// release is upgraded to the new version only when the job succeeds, so that
+// new pods start from the cached models. The status records the sync until
+// then.
+func (r HelmOperatorReconciler) renderModelCache(ctx context.Context, o *unstructured.Unstructured,
+	status *types.HelmAppStatus, values, generated map[string]interface{}) error {
+
+	opts, err := modelCacheOptionsFor(values)
+	if err != nil {
+		return err
+	}
+	if !opts.Enabled {
+		if status.ModelCache != nil {
+			if err := r.deleteModelCache(ctx, o, status.ModelCache.ClaimName); err != nil {
+				return err
+			}
+		}
+		status.ModelCache = nil
+		status.RemoveCondition(types.ConditionModelCached)
+		status.RemoveCondition(types.ConditionCacheClaimUpdated)
+		return nil
+	}
+
+	sources, err := cacheSources(values)
+	if err != nil {
+		return err
+	}
+	repository, _, _ := unstructured.NestedMap(values, "models_repository")
+	env, err := rcloneEnv(sources[0].location.Scheme, repository)
+	if err != nil {
+		return err
+	}
+	version := cacheVersion(sources, repository, opts.Revision)
+	cache := status.ModelCache
+	if cache == nil {
+		cache = &types.ModelCacheStatus{}
+	}
+	cache.ClaimName = o.GetName() + modelCacheSuffix
+	status.ModelCache = cache
+	pvc, err := r.ensureModelCacheClaim(ctx, o, status, cache.ClaimName, opts)
+	if err != nil {
+		return err
+	}
+
+	cached := false
+	for _, v := range cache.CachedVersions {
+		cached = cached || v == version
+	}
+	if cached && cache.SyncJob != "" {
+		// the spec went back to a cached version during a sync
+		if err := r.deleteModelCacheJobs(ctx, o, version); err != nil {
+			return err
+		}
+	}
+	if !cached {
+		if err := r.syncModelCache(ctx, o, status, pvc, sources, env, repository, version, opts); err != nil {
+			return err
+		}
+		if cache.SyncVersion != "" {
+			return nil
+		}
+	}
+	cache.CachedVersions = keptVersions(version, cache.CachedVersions, opts.KeepVersions)
+	cache.Version = version
+	cache.SyncVersion, cache.SyncJob = "", ""
+	status.SetCondition(types.HelmAppCondition{
+		Type:    types.ConditionModelCached,
+		Status:  types.StatusTrue,
+		Reason:  types.ReasonCacheSynced,
+		Message: fmt.Sprintf("Serving version %s of the models from the persistent volume claim %s", version, cache.ClaimName),
+	})
+
+	settings := copyValues(values["models_settings"])
+	models, _ := settings["models"].([]interface{})
+	models = append([]interface{}{}, models...)
+	for _, s := range sources {
+		cachedPath := path.Join(modelCacheMountPath, version, strconv.Itoa(s.index))
+		if !multiModel(values) {
+			settings["model_path"] = cachedPath
+			continue
+		}
+		model := copyValues(models[s.index])
+		model["base_path"] = cachedPath
+		models[s.index] = model
+	}
+	if multiModel(values) {
+		settings["models"] = models
+	}
+	values["models_settings"] = settings
+	generated["model_cache"] = map[string]interface{}{
+		"claim_name": cache.ClaimName,
+		"mount_path": modelCacheMountPath,
+	}
+	return nil
+}
+
+// syncModelCache starts the job syncing the version of the models into the
+// cache, or checks the job started by a previous reconciliation. The job
+// runs on the node of the model server pods when the claim can only be
+// mounted from a single node. The sync version is cleared from the status
+// when the job succeeds.
+func (r HelmOperatorReconciler) syncModelCache(ctx context.Context, o *unstructured.Unstructured,
+	status *types.HelmAppStatus, pvc *corev1.PersistentVolumeClaim, sources []cacheSource, env []corev1.EnvVar,
+	repository map[string]interface{}, version string, opts ModelCacheOptions) error {
+
+	cache := status.ModelCache
+	name := modelCacheJobName(o.GetName(), version)
+	cache.SyncVersion, cache.SyncJob = version, name
+	condition := types.HelmAppCondition{
+		Type:    types.ConditionModelCached,
+		Status:  types.StatusFalse,
+		Reason:  types.ReasonCacheSyncing,
+		Message: fmt.Sprintf("Syncing version %s of the models with Job %s", version, name),
+	}
+
+	// the jobs and the claim are not cached, they are not labeled with the
+	// chart
+	job := &batchv1.Job{}
+	err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: o.GetNamespace(), Name: name}, job)
+	switch {
+	case apierrors.IsNotFound(err):
+		if err := r.deleteModelCacheJobs(ctx, o, version); err != nil {
+			return err
+		}
+		node, _, err := r.claimNode(ctx, pvc)
+		if err != nil {
+			return err
+		}
+		keep := keptVersions(version, cache.CachedVersions, opts.KeepVersions)
+		job, err = modelCacheJob(o, name, cache.ClaimName, node, sources, env, repository, keep, opts.Image)
+		if err != nil {
+			return err
+		}
+		err = r.Client.Create(ctx, job)
+		if apierrors.IsAlreadyExists(err) {
+			// created since it was read, it is checked on the next reconciliation
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create the model cache sync job %q: %w", name, err)
+		}
+		r.EventRecorder.Eventf(o, "Normal", string(types.ReasonCacheSyncing),
+			"Syncing version %s of the models into the cache with Job %s", version, name)
+	case err != nil:
+		return fmt.Errorf("failed to get the model cache sync job %q: %w", name, err)
+	case jobCondition(job, batchv1.JobComplete):
+		now := metav1.Now()
+		cache.LastSyncTime = &now
+		cache.SyncVersion, cache.SyncJob = "", ""
+		r.EventRecorder.Eventf(o, "Normal", string(types.ReasonCacheSynced), "Synced version %s of the models into the cache", version)
+		return nil
+	case jobCondition(job, batchv1.JobFailed):
+		condition.Reason = types.ReasonCacheSyncFailed
+		condition.Message = fmt.Sprintf("Job %s failed to sync version %s of the models, it is retried once deleted",
+			name, version)
+		for _, c := range job.Status.Conditions {
+			if c.Type == batchv1.JobFailed && c.Message != "" {
+				condition.Message += ": " + c.Message
+			}
+		}
+	}
+	status.SetCondition(condition)
+	return nil
+}
+
+// modelCacheRequeue returns the delay before checking the sync of the model
+// cache again. The release is not installed or upgraded while a version is
+// synced.
+func modelCacheRequeue(status *types.HelmAppStatus) (time.Duration, bool) {
+	if !status.ModelCache.Syncing() {
+		return 0, false
+	}
+	if c := status.GetCondition(types.ConditionModelCached); c != nil && c.Reason == types.ReasonCacheSyncFailed {
+		return modelCacheJobTTL, true
+	}
+	return modelCacheSyncPeriod, true
+}
+
+// keptVersions returns the versions kept in the cache once the version is
+// synced, the most recent first.
+func keptVersions(version string, cached []string, keep int64) []string {
+	out := []string{version}
+	for _, v := range cached {
+		if v != version && int64(len(out)) < keep {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func jobCondition(job *batchv1.Job, conditionType batchv1.JobConditionType) bool {
+	for _, c := range job.Status.Conditions {
+		if c.Type == conditionType && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// modelCacheJobName returns the name of the job syncing the version of the
+// models, short enough for the job-name label of its pods.
+func modelCacheJobName(name, version string) string {
+	suffix := modelCacheSuffix + "-" + version
+	if len(name)+len(suffix) > 63 {
+		name = strings.TrimSuffix(name[:63-len(suffix)], "-")
+	}
+	return name + suffix
+}
+
+// ensureModelCacheClaim creates the persistent volume claim of the model
+// cache, or expands it when the size grows. The changes that cannot be
+// applied to an existing claim, shrinking it or changing its access mode or
+// storage class, are reported in the CacheClaimUpdated condition.
+func (r HelmOperatorReconciler) ensureModelCacheClaim(ctx context.Context, o *unstructured.Unstructured,
+	status *types.HelmAppStatus, name string, opts ModelCacheOptions) (*corev1.PersistentVolumeClaim, error) {
+
+	size := resource.MustParse(opts.Size)
+	pvc := &corev1.PersistentVolumeClaim{}
+	err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: o.GetNamespace(), Name: name}, pvc)
+	if err == nil {
+		return pvc, r.updateModelCacheClaim(ctx, o, status, pvc, size, opts)
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	status.RemoveCondition(types.ConditionCacheClaimUpdated)
+	pvc = &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       o.GetNamespace(),
+			Name:            name,
+			Labels:          map[string]string{modelCacheLabel: o.GetName()},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(o, o.GroupVersionKind())},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{opts.AccessMode},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+			},
+		},
+	}
+	if opts.StorageClassName != "" {
+		pvc.Spec.StorageClassName = &opts.StorageClassName
+	}
+	if err := r.Client.Create(ctx, pvc); err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("failed to create the model cache persistent volume claim %q: %w", name, err)
+	}
+	return pvc, nil
+}
+
+// updateModelCacheClaim expands the claim of the model cache to the size of
+// the options. The size of a claim cannot decrease, and its access mode and
+// storage class are immutable: these changes are reported until the claim is
+// deleted, or the cache disabled, to recreate it.
+func (r HelmOperatorReconciler) updateModelCacheClaim(ctx context.Context, o *unstructured.Unstructured,
+	status *types.HelmAppStatus, pvc *corev1.PersistentVolumeClaim, size resource.Quantity,
+	opts ModelCacheOptions) error {
+
+	var immutable []string
+	requested := pvc.Spec.Resources.Requests.Storage()
+	if size.Cmp(*requested) < 0 {
+		immutable = append(immutable, fmt.Sprintf("model_cache.size %s is smaller than the %s requested by the claim",
+			size.String(), requested.String()))
+	}
+	if len(pvc.Spec.AccessModes) != 1 || pvc.Spec.AccessModes[0] != opts.AccessMode {
+		immutable = append(immutable, fmt.Sprintf("model_cache.access_mode %s differs from the access modes %v of the claim",
+			opts.AccessMode, pvc.Spec.AccessModes))
+	}
+	if opts.StorageClassName != "" && (pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName != opts.StorageClassName) {
+		immutable = append(immutable, fmt.Sprintf("model_cache.storage_class_name %s differs from the storage class of the claim",
+			opts.StorageClassName))
+	}
+	if len(immutable) > 0 {
+		status.SetCondition(types.HelmAppCondition{
+			Type:   types.ConditionCacheClaimUpdated,
+			Status: types.StatusFalse,
+			Reason: types.ReasonCacheClaimImmutable,
+			Message: fmt.Sprintf("The persistent volume claim %s cannot be updated: %s; delete the claim to recreate it",
+				pvc.Name, strings.Join(immutable, "; ")),
+		})
+		return nil
+	}
+
+	if size.Cmp(*requested) > 0 {
+		patch := client.MergeFrom(pvc.DeepCopy())
+		if pvc.Spec.Resources.Requests == nil {
+			pvc.Spec.Resources.Requests = corev1.ResourceList{}
+		}
+		pvc.Spec.Resources.Requests[corev1.ResourceStorage] = size
+		if err := r.Client.Patch(ctx, pvc, patch); err != nil {
+			if apierrors.IsInvalid(err) || apierrors.IsForbidden(err) {
+				// the storage class does not allow volume expansion
+				status.SetCondition(types.HelmAppCondition{
+					Type:   types.ConditionCacheClaimUpdated,
+					Status: types.StatusFalse,
+					Reason: types.ReasonCacheClaimResizeFailed,
+					Message: fmt.Sprintf("Failed to expand the persistent volume claim %s to %s: %v",
+						pvc.Name, size.String(), err),
+				})
+				return nil
+			}
+			return fmt.Errorf("failed to expand the model cache persistent volume claim %q: %w", pvc.Name, err)
+		}
+		r.EventRecorder.Eventf(o, "Normal", "CacheClaimExpanded",
+			"Expanded the persistent volume claim %s of the model cache from %s to %s", pvc.Name,
+			requested.String(), size.String())
+	}
+	status.RemoveCondition(types.ConditionCacheClaimUpdated)
+	return nil
+}
+
+// deleteModelCacheJobs deletes the sync jobs of the ModelServer other than
+// the job of the version, which would otherwise clean up its directory.
+func (r HelmOperatorReconciler) deleteModelCacheJobs(ctx context.Context, o *unstructured.Unstructured,
+	version string) error {
+
+	jobs := &batchv1.JobList{}
+	if err := r.APIReader.List(ctx, jobs, client.InNamespace(o.GetNamespace()),
+		client.MatchingLabels{modelCacheLabel: o.GetName()}); err != nil {
+		return fmt.Errorf("failed to list the model cache sync jobs: %w", err)
+	}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if job.Labels[modelCacheVersionLabel] == version {
+			continue
+		}
+		if err := r.Client.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+			!apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete the model cache sync job %q: %w", job.Name, err)
+		}
+	}
+	return nil
+}
+
+// deleteModelCache deletes the sync jobs and the persistent volume claim of
+// a disabled model cache.
+func (r HelmOperatorReconciler) deleteModelCache(ctx context.Context, o *unstructured.Unstructured, claim string) error {
+	if err := r.deleteModelCacheJobs(ctx, o, ""); err != nil {
+		return err
+	}
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: o.GetNamespace(), Name: claim}}
+	if err := r.Client.Delete(ctx, pvc); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete the model cache persistent volume claim %q: %w", claim, err)
+	}
+	return nil
+}
+
+// rcloneEnv returns the environment variables configuring the rclone remote
+// of the model storage of the URL scheme with the models_repository
+// settings. The credentials are read from the referenced Secrets, or from
+// the workload identity.
+func rcloneEnv(scheme string, repository map[string]interface{}) ([]corev1.EnvVar, error) {
+	for _, field := range sortedKeys(deprecatedCredentialFields) {
+		if plain, _ := repository[field].(string); plain != "" {
+			return nil, fmt.Errorf("model_cache requires models_repository.%s instead of %s",
+				deprecatedCredentialFields[field], field)
+		}
+	}
+	prefix := "RCLONE_CONFIG_" + strings.ToUpper(rcloneRemote) + "_"
+	env := []corev1.EnvVar{}
+	add := func(name, value string) {
+		env = append(env, corev1.EnvVar{Name: prefix + name, Value: value})
+	}
+	addSecret := func(name string, ref map[string]interface{}) {
+		refName, _ := ref["name"].(string)
+		key, _ := ref["key"].(string)
+		env = append(env, corev1.EnvVar{Name: name, ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: refName}, Key: key},
+		}})
+	}
+
+	switch scheme {
+	case "s3":
+		add("TYPE", "s3")
+		endpoint, _ := repository["s3_compat_api_endpoint"].(string)
+		if endpoint != "" {
+			add("PROVIDER", "Other")
+			add("ENDPOINT", endpoint)
+		} else {
+			add("PROVIDER", "AWS")
+		}
+		if region, _ := repository["aws_region"].(string); region != "" {
+			add("REGION", region)
+		}
+		accessKeyID, _ := repository["aws_access_key_id_secret_ref"].(map[string]interface{})
+		secretAccessKey, _ := repository["aws_secret_access_key_secret_ref"].(map[string]interface{})
+		if len(accessKeyID) == 0 || len(secretAccessKey) == 0 {
+			add("ENV_AUTH", "true")
+			break
+		}
+		addSecret(prefix+"ACCESS_KEY_ID", accessKeyID)
+		addSecret(prefix+"SECRET_ACCESS_KEY", secretAccessKey)
+	case "gs":
+		add("TYPE", "google cloud storage")
+		if name, _ := repository["gcp_creds_secret_name"].(string); name != "" {
+			add("SERVICE_ACCOUNT_FILE", "/secret/"+gcpCredentialsKey)
+		} else {
+			add("ENV_AUTH", "true")
+		}
+	case "az":
+		connectionString, _ := repository["azure_storage_connection_string_secret_ref"].(map[string]interface{})
+		if len(connectionString) == 0 {
+			return nil, errors.New("model_cache with Azure Blob Storage requires " +
+				"models_repository.azure_storage_connection_string_secret_ref")
+		}
+		add("TYPE", "azureblob")
+		addSecret("AZURE_STORAGE_CONNECTION_STRING", connectionString)
+	}
+
+	for _, field := range []string{"http_proxy", "https_proxy", "no_proxy"} {
+		if proxy, _ := repository[field].(string); proxy != "" {
+			env = append(env, corev1.EnvVar{Name: field, Value: proxy})
+		}
+	}
+	return env, nil
+}
+
+// rcloneAzurePrelude sets the account, key and endpoint of the rclone remote
+// from the Azure Storage connection string, which rclone does not read.
+const rcloneAzurePrelude = `conn() { echo "$AZURE_STORAGE_CONNECTION_STRING" | tr ';' '\n' | sed -n "s/^$1=//p"; }
+export RCLONE_CONFIG_STORAGE_ACCOUNT="$(conn AccountName)" RCLONE_CONFIG_STORAGE_KEY="$(conn AccountKey)"
+if [ -n "$(conn BlobEndpoint)" ]; then export RCLONE_CONFIG_STORAGE_ENDPOINT="$(conn BlobEndpoint)"; fi
+`
+
+// modelCacheScript returns the shell script of the sync job. The sources are
+// synced into a partial directory renamed after the version once complete,
+// and the directories of the versions which are not kept are deleted.
+func modelCacheScript(sources []cacheSource, version string, keep []string) string {
+	var script strings.Builder
+	script.WriteString("set -e\n")
+	if sources[0].location.Scheme == "az" {
+		script.WriteString(rcloneAzurePrelude)
+	}
+	partial := path.Join(modelCacheJobMountPath, version+".partial")
+	for _, s := range sources {
+		remote := fmt.Sprintf("%s:%s/%s", rcloneRemote, s.location.Bucket, s.location.Prefix)
+		fmt.Fprintf(&script, "rclone sync %s %s\n", shellQuote(strings.TrimSuffix(remote, "/")),
+			shellQuote(path.Join(partial, strconv.Itoa(s.index))))
+	}
+	target := path.Join(modelCacheJobMountPath, version)
+	fmt.Fprintf(&script, "rm -rf %s\nmv %s %s\n", shellQuote(target), shellQuote(partial), shellQuote(target))
+	fmt.Fprintf(&script, "cd %s\nfor d in *; do\n  case \"$d\" in\n    %s|lost+found) ;;\n    *) rm -rf \"$d\" ;;\n  esac\ndone\n",
+		modelCacheJobMountPath, strings.Join(keep, "|"))
+	return script.String()
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// repositorySecurityContext returns the security context of the pods reading
+// the model repository with the identity of the model server, or nil if the
+// identity is not set in the models_repository values.
+func repositorySecurityContext(repository map[string]interface{}) (*corev1.PodSecurityContext, error) {
+	securityContext := &corev1.PodSecurityContext{}
+	for _, id := range []struct {
+		field  string
+		target **int64
+	}{
+		{"runAsUser", &securityContext.RunAsUser},
+		{"runAsGroup", &securityContext.RunAsGroup},
+		{"runAsGroup", &securityContext.FSGroup},
+	} {
+		raw := fmt.Sprint(repository[id.field])
+		if repository[id.field] == nil || raw == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid models_repository.%s %q", id.field, raw)
+		}
+		*id.target = &n
+	}
+	if securityContext.RunAsUser == nil && securityContext.RunAsGroup == nil {
+		return nil, nil
+	}
+	return securityContext, nil
+}
+
+// modelCacheJob returns the job syncing the version of the models into the
+// cache with rclone. It runs with the identity and the security context of
+// the model server pods, on the node if set.
+func modelCacheJob(o *unstructured.Unstructured, name, claim, node string, sources []cacheSource, env []corev1.EnvVar,
+	repository map[string]interface{}, keep []string, image string) (*batchv1.Job, error) {
+
+	scheme := sources[0].location.Scheme
+	version := keep[0]
+	labels := map[string]string{modelCacheLabel: o.GetName(), modelCacheVersionLabel: version}
+	backoffLimit := int32(3)
+	ttl := int32(modelCacheJobTTL.Seconds())
+
+	pod := corev1.PodSpec{
+		RestartPolicy: corev1.RestartPolicyNever,
+		Containers: []corev1.Container{{
+			Name:    "sync",
+			Image:   image,
+			Command: []string{"/bin/sh", "-c", modelCacheScript(sources, version, keep)},
+			Env:     env,
+			VolumeMounts: []corev1.VolumeMount{
+				{Name: "cache", MountPath: modelCacheJobMountPath},
+			},
+		}},
+		Volumes: []corev1.Volume{{
+			Name: "cache",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+			},
+		}},
+	}
+	if secret, _ := repository["gcp_creds_secret_name"].(string); secret != "" && scheme == "gs" {
+		pod.Containers[0].VolumeMounts = append(pod.Containers[0].VolumeMounts,
+			corev1.VolumeMount{Name: "gcpcreds", MountPath: "/secret", ReadOnly: true})
+		pod.Volumes = append(pod.Volumes, corev1.Volume{
+			Name:         "gcpcreds",
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: secret}},
+		})
+	}
+	podLabels := map[string]string{modelCacheLabel: o.GetName()}
+	if sa, _ := repository["workload_identity_service_account"].(string); sa != "" {
+		pod.ServiceAccountName = sa
+		if scheme == "az" {
+			podLabels["azure.workload.identity/use"] = "true"
+		}
+	}
+	securityContext, err := repositorySecurityContext(repository)
+	if err != nil {
+		return nil, err
+	}
+	pod.SecurityContext = securityContext
+	if node != "" {
+		pod.Affinity = nodeNameAffinity(node)
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       o.GetNamespace(),
+			Name:            name,
+			Labels:          labels,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(o, o.GroupVersionKind())},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: podLabels},
+				Spec:       pod,
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,357 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+)
+
+func cacheValues(modelPath string) map[string]interface{} {
+	return map[string]interface{}{
+		"model_cache": map[string]interface{}{"enabled": true, "storage_class_name": "fast"},
+		"models_settings": map[string]interface{}{
+			"single_model_mode": true,
+			"model_path":        modelPath,
+		},
+		"models_repository": map[string]interface{}{
+			"storage_type":           "S3",
+			"s3_compat_api_endpoint": "http://minio.storage:9000",
+			"aws_access_key_id_secret_ref": map[string]interface{}{
+				"name": "s3", "key": "id",
+			},
+			"aws_secret_access_key_secret_ref": map[string]interface{}{
+				"name": "s3", "key": "secret",
+			},
+		},
+	}
+}
+
+func completeJob(t *testing.T, cl client.Client, name string, conditionType batchv1.JobConditionType) {
+	job := &batchv1.Job{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: name}, job))
+	job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{
+		Type: conditionType, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded",
+	})
+	assert.NoError(t, cl.Status().Update(context.TODO(), job))
+}
+
+func TestRenderModelCache(t *testing.T) {
+	cl := fake.NewClientBuilder().WithStatusSubresource(&batchv1.Job{}).Build()
+	r := HelmOperatorReconciler{Client: cachedClient(cl), APIReader: cl, EventRecorder: record.NewFakeRecorder(10)}
+	o := testModelServer("default")
+	o.SetGroupVersionKind(testModelServerGVK)
+	status := &types.HelmAppStatus{}
+
+	// the first version is synced before the release is installed
+	values := cacheValues("s3://models/resnet")
+	generated := map[string]interface{}{}
+	assert.NoError(t, r.renderModelCache(context.TODO(), o, status, values, generated))
+	cache := status.ModelCache
+	if !assert.NotNil(t, cache) {
+		return
+	}
+	first := cache.SyncVersion
+	assert.Equal(t, "sample-model-cache", cache.ClaimName)
+	assert.Equal(t, "sample-model-cache-"+first, cache.SyncJob)
+	assert.Equal(t, "s3://models/resnet", values["models_settings"].(map[string]interface{})["model_path"])
+	assert.Empty(t, generated)
+	requeue, syncing := modelCacheRequeue(status)
+	assert.True(t, syncing)
+	assert.Equal(t, modelCacheSyncPeriod, requeue)
+	assert.Equal(t, types.ReasonCacheSyncing, status.GetCondition(types.ConditionModelCached).Reason)
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: cache.ClaimName}, pvc))
+	assert.Equal(t, "fast", *pvc.Spec.StorageClassName)
+	assert.Equal(t, []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}, pvc.Spec.AccessModes)
+	assert.Equal(t, "10Gi", pvc.Spec.Resources.Requests.Storage().String())
+
+	job := &batchv1.Job{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: cache.SyncJob}, job))
+	container := job.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, defaultModelCacheImage, container.Image)
+	assert.Contains(t, container.Command[2], "rclone sync 'storage:models/resnet' '/cache/"+first+".partial/0'\n")
+	assert.Contains(t, container.Command[2], "    "+first+"|lost+found) ;;\n")
+	assert.Contains(t, container.Env, corev1.EnvVar{Name: "RCLONE_CONFIG_STORAGE_ENDPOINT", Value: "http://minio.storage:9000"})
+	assert.Equal(t, "sample-model-cache", job.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim.ClaimName)
+
+	// the claim and the job created since they were read are kept
+	stale := HelmOperatorReconciler{Client: cl, APIReader: cachedClient(cl), EventRecorder: record.NewFakeRecorder(10)}
+	assert.NoError(t, stale.renderModelCache(context.TODO(), o, status, cacheValues("s3://models/resnet"), generated))
+	assert.Equal(t, first, cache.SyncVersion)
+	assert.Equal(t, types.ReasonCacheSyncing, status.GetCondition(types.ConditionModelCached).Reason)
+
+	// the release serves the cached models once the job succeeds
+	completeJob(t, cl, cache.SyncJob, batchv1.JobComplete)
+	values = cacheValues("s3://models/resnet")
+	assert.NoError(t, r.renderModelCache(context.TODO(), o, status, values, generated))
+	_, syncing = modelCacheRequeue(status)
+	assert.False(t, syncing)
+	assert.Equal(t, first, cache.Version)
+	assert.Equal(t, []string{first}, cache.CachedVersions)
+	assert.NotNil(t, cache.LastSyncTime)
+	assert.Equal(t, "/models-cache/"+first+"/0", values["models_settings"].(map[string]interface{})["model_path"])
+	assert.Equal(t, map[string]interface{}{"claim_name": "sample-model-cache", "mount_path": "/models-cache"},
+		generated["model_cache"])
+	assert.Equal(t, types.StatusTrue, status.GetCondition(types.ConditionModelCached).Status)
+
+	// a new model path is synced while the cached version is kept
+	values = cacheValues("s3://models/resnet-v2")
+	generated = map[string]interface{}{}
+	assert.NoError(t, r.renderModelCache(context.TODO(), o, status, values, generated))
+	second := cache.SyncVersion
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, first, cache.Version)
+	assert.Empty(t, generated)
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: cache.SyncJob}, job))
+	assert.Contains(t, job.Spec.Template.Spec.Containers[0].Command[2], "    "+second+"|"+first+"|lost+found) ;;\n")
+
+	completeJob(t, cl, cache.SyncJob, batchv1.JobFailed)
+	assert.NoError(t, r.renderModelCache(context.TODO(), o, status, values, generated))
+	requeue, syncing = modelCacheRequeue(status)
+	assert.True(t, syncing)
+	assert.Equal(t, modelCacheJobTTL, requeue)
+	c := status.GetCondition(types.ConditionModelCached)
+	assert.Equal(t, types.ReasonCacheSyncFailed, c.Reason)
+	assert.Contains(t, c.Message, "BackoffLimitExceeded")
+
+	// going back to the cached version does not wait for the failed sync
+	values = cacheValues("s3://models/resnet")
+	assert.NoError(t, r.renderModelCache(context.TODO(), o, status, values, generated))
+	_, syncing = modelCacheRequeue(status)
+	assert.False(t, syncing)
+	err := cl.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "sample-model-cache-" + second}, job)
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// disabling the cache deletes the claim
+	values = cacheValues("s3://models/resnet")
+	values["model_cache"] = map[string]interface{}{"enabled": false}
+	assert.NoError(t, r.renderModelCache(context.TODO(), o, status, values, generated))
+	assert.Nil(t, status.ModelCache)
+	assert.Nil(t, status.GetCondition(types.ConditionModelCached))
+	err = cl.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "sample-model-cache"}, pvc)
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestRenderModelCacheModels(t *testing.T) {
+	cl := fake.NewClientBuilder().WithStatusSubresource(&batchv1.Job{}).Build()
+	r := HelmOperatorReconciler{Client: cachedClient(cl), APIReader: cl, EventRecorder: record.NewFakeRecorder(10)}
+	o := testModelServer("default")
+	o.SetGroupVersionKind(testModelServerGVK)
+	models := []interface{}{
+		map[string]interface{}{"name": "local", "base_path": "/models/local"},
+		map[string]interface{}{"name": "resnet", "base_path": "gs://models/resnet"},
+	}
+	values := map[string]interface{}{
+		"model_cache": map[string]interface{}{"enabled": true, "revision": "1"},
+		"models_settings": map[string]interface{}{
+			"single_model_mode": false,
+			"models":            models,
+		},
+		"models_repository": map[string]interface{}{"gcp_creds_secret_name": "gcp"},
+	}
+	sources, err := cacheSources(values)
+	assert.NoError(t, err)
+	version := cacheVersion(sources, nil, "1")
+	status := &types.HelmAppStatus{ModelCache: &types.ModelCacheStatus{CachedVersions: []string{version}}}
+
+	generated := map[string]interface{}{}
+	assert.NoError(t, r.renderModelCache(context.TODO(), o, status, values, generated))
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "local", "base_path": "/models/local"},
+		map[string]interface{}{"name": "resnet", "base_path": "/models-cache/" + version + "/1"},
+	}, values["models_settings"].(map[string]interface{})["models"])
+	assert.Equal(t, "gs://models/resnet", models[1].(map[string]interface{})["base_path"])
+}
+
+func TestModelCacheErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		values     map[string]interface{}
+		errMessage string
+	}{
+		{
+			name:       "invalid size",
+			values:     map[string]interface{}{"model_cache": map[string]interface{}{"enabled": true, "size": "large"}},
+			errMessage: `invalid model_cache.size "large"`,
+		},
+		{
+			name: "read write once pod",
+			values: map[string]interface{}{
+				"model_cache": map[string]interface{}{"enabled": true, "access_mode": "ReadWriteOncePod"},
+			},
+			errMessage: `invalid model_cache.access_mode "ReadWriteOncePod", the claim is mounted by the sync job ` +
+				"and the model server pods, expected ReadWriteOnce or ReadWriteMany",
+		},
+		{
+			name: "one version kept",
+			values: map[string]interface{}{
+				"model_cache": map[string]interface{}{"enabled": true, "keep_versions": int64(1)},
+			},
+			errMessage: "model_cache.keep_versions must be at least 2, to keep the version served during the rollout of a new one",
+		},
+		{
+			name: "local models",
+			values: map[string]interface{}{
+				"model_cache":     map[string]interface{}{"enabled": true},
+				"models_settings": map[string]interface{}{"model_path": "/models/resnet"},
+			},
+			errMessage: "model_cache requires models in S3, Google Cloud Storage or Azure Blob Storage",
+		},
+		{
+			name: "config map",
+			values: map[string]interface{}{
+				"model_cache":     map[string]interface{}{"enabled": true},
+				"models_settings": map[string]interface{}{"config_configmap_name": "config"},
+			},
+			errMessage: "model_cache cannot be used with config_configmap_name",
+		},
+		{
+			name: "clear text credentials",
+			values: map[string]interface{}{
+				"model_cache":       map[string]interface{}{"enabled": true},
+				"models_settings":   map[string]interface{}{"model_path": "s3://models/resnet"},
+				"models_repository": map[string]interface{}{"aws_access_key_id": "id"},
+			},
+			errMessage: "model_cache requires models_repository.aws_access_key_id_secret_ref instead of aws_access_key_id",
+		},
+		{
+			name: "azure without connection string",
+			values: map[string]interface{}{
+				"model_cache":     map[string]interface{}{"enabled": true},
+				"models_settings": map[string]interface{}{"model_path": "az://models/resnet"},
+			},
+			errMessage: "model_cache with Azure Blob Storage requires models_repository.azure_storage_connection_string_secret_ref",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := HelmOperatorReconciler{Client: fake.NewClientBuilder().Build()}
+			err := r.renderModelCache(context.TODO(), testModelServer("default"), &types.HelmAppStatus{}, test.values,
+				map[string]interface{}{})
+			assert.EqualError(t, err, test.errMessage)
+		})
+	}
+}
+
+func TestModelCacheClaimUpdate(t *testing.T) {
+	cl := fake.NewClientBuilder().WithStatusSubresource(&batchv1.Job{}).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := HelmOperatorReconciler{Client: cachedClient(cl), APIReader: cl, EventRecorder: recorder}
+	o := testModelServer("default")
+	o.SetGroupVersionKind(testModelServerGVK)
+	status := &types.HelmAppStatus{}
+	withCache := func(settings map[string]interface{}) map[string]interface{} {
+		values := cacheValues("s3://models/resnet")
+		cache := values["model_cache"].(map[string]interface{})
+		for k, v := range settings {
+			cache[k] = v
+		}
+		return values
+	}
+	claimSize := func() string {
+		pvc := &corev1.PersistentVolumeClaim{}
+		assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "sample-model-cache"}, pvc))
+		return pvc.Spec.Resources.Requests.Storage().String()
+	}
+
+	assert.NoError(t, r.renderModelCache(context.TODO(), o, status, withCache(nil), map[string]interface{}{}))
+	assert.Equal(t, "10Gi", claimSize())
+	assert.Nil(t, status.GetCondition(types.ConditionCacheClaimUpdated))
+	assert.Contains(t, <-recorder.Events, "Normal CacheSyncing")
+
+	// the claim is expanded when the size grows
+	assert.NoError(t, r.renderModelCache(context.TODO(), o, status, withCache(map[string]interface{}{"size": "20Gi"}),
+		map[string]interface{}{}))
+	assert.Equal(t, "20Gi", claimSize())
+	assert.Nil(t, status.GetCondition(types.ConditionCacheClaimUpdated))
+	assert.Equal(t, "Normal CacheClaimExpanded Expanded the persistent volume claim sample-model-cache of the model cache "+
+		"from 10Gi to 20Gi", <-recorder.Events)
+
+	// the changes that cannot be applied are reported
+	values := withCache(map[string]interface{}{"size": "5Gi", "access_mode": "ReadWriteMany"})
+	assert.NoError(t, r.renderModelCache(context.TODO(), o, status, values, map[string]interface{}{}))
+	assert.Equal(t, "20Gi", claimSize())
+	c := status.GetCondition(types.ConditionCacheClaimUpdated)
+	if assert.NotNil(t, c) {
+		assert.Equal(t, types.StatusFalse, c.Status)
+		assert.Equal(t, types.ReasonCacheClaimImmutable, c.Reason)
+		assert.Equal(t, "The persistent volume claim sample-model-cache cannot be updated: "+
+			"model_cache.size 5Gi is smaller than the 20Gi requested by the claim; "+
+			"model_cache.access_mode ReadWriteMany differs from the access modes [ReadWriteOnce] of the claim; "+
+			"delete the claim to recreate it", c.Message)
+	}
+
+	assert.NoError(t, r.renderModelCache(context.TODO(), o, status, withCache(map[string]interface{}{"size": "20Gi"}),
+		map[string]interface{}{}))
+	assert.Nil(t, status.GetCondition(types.ConditionCacheClaimUpdated))
+}
+
+func TestModelCacheJobNode(t *testing.T) {
+	cl := fake.NewClientBuilder().WithStatusSubresource(&batchv1.Job{}).WithObjects(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "sample-ovms"},
+		Spec: corev1.PodSpec{
+			NodeName: "node-1",
+			Volumes: []corev1.Volume{{Name: "cache", VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "sample-model-cache"},
+			}}},
+		},
+	}).Build()
+	r := HelmOperatorReconciler{Client: cachedClient(cl), APIReader: cl, EventRecorder: record.NewFakeRecorder(10)}
+	o := testModelServer("default")
+	o.SetGroupVersionKind(testModelServerGVK)
+
+	for _, mode := range []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce, corev1.ReadWriteMany} {
+		status := &types.HelmAppStatus{}
+		values := cacheValues("s3://models/resnet")
+		values["model_cache"].(map[string]interface{})["access_mode"] = string(mode)
+		assert.NoError(t, r.renderModelCache(context.TODO(), o, status, values, map[string]interface{}{}))
+		job := &batchv1.Job{}
+		key := client.ObjectKey{Namespace: "default", Name: status.ModelCache.SyncJob}
+		if !assert.NoError(t, cl.Get(context.TODO(), key, job)) {
+			return
+		}
+		affinity := job.Spec.Template.Spec.Affinity
+		if mode == corev1.ReadWriteMany {
+			assert.Nil(t, affinity)
+			continue
+		}
+		// the sync job mounts the claim on the node of the model server pods
+		assert.Equal(t, nodeNameAffinity("node-1"), affinity)
+		assert.NoError(t, r.deleteModelCache(context.TODO(), o, status.ModelCache.ClaimName))
+	}
+}
+
+func TestModelCacheJobName(t *testing.T) {
+	assert.Equal(t, "sample-model-cache-0123456789", modelCacheJobName("sample", "0123456789"))
+	name := modelCacheJobName("a-very-long-model-server-name-serving-the-resnet-model", "0123456789")
+	assert.Equal(t, "a-very-long-model-server-name-serving-th-model-cache-0123456789", name)
+	assert.LessOrEqual(t, len(name), 63)
+}
@@ -0,0 +1,317 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	rpb "helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/helm/manifestutil"
+	"github.com/openvinotoolkit/operator/pkg/ovms"
+)
+
+const (
+	// verifyModelAnnotation enables verifying that the models of a
+	// ModelServer are served once its pods are available.
+	verifyModelAnnotation = "intel.com/verify-model"
+
+	restPortName = "rest"
+)
+
+// modelServerEndpoint returns the base URL of the REST API of the model
+// server, based on the Service included in the release manifest.
+func modelServerEndpoint(rel *rpb.Release) (string, error) {
+	return serviceEndpoint(rel, "")
+}
+
+// serviceEndpoint returns the base URL of the REST API exposed by the first
+// Service of the release manifest with the rollout track label, or without
+// the label if track is empty.
+func serviceEndpoint(rel *rpb.Release, track string) (string, error) {
+	return servicePortEndpoint(rel, track, restPortName)
+}
+
+// servicePortEndpoint returns the base URL of the named port of the first
+// Service of the release manifest with the track label. The scheme is https
+// if the appProtocol of the port is https.
+func servicePortEndpoint(rel *rpb.Release, track, portName string) (string, error) {
+	services, err := manifestutil.ObjectsOfKind(rel.Manifest, "Service")
+	if err != nil {
+		return "", fmt.Errorf("failed to parse release manifest: %w", err)
+	}
+	var svc *unstructured.Unstructured
+	for _, s := range services {
+		if s.GetLabels()[trackLabel] == track {
+			svc = s
+			break
+		}
+	}
+	if svc == nil {
+		return "", errors.New("release does not include a Service")
+	}
+	ports, _, _ := unstructured.NestedSlice(svc.Object, "spec", "ports")
+	for _, p := range ports {
+		port, ok := p.(map[string]interface{})
+		if !ok || port["name"] != portName {
+			continue
+		}
+		number, _, _ := unstructured.NestedFieldNoCopy(port, "port")
+		namespace := svc.GetNamespace()
+		if namespace == "" {
+			namespace = rel.Namespace
+		}
+		scheme := "http"
+		if port["appProtocol"] == "https" {
+			scheme = "https"
+		}
+		return fmt.Sprintf("%s://%s.%s.svc:%v", scheme, svc.GetName(), namespace, number), nil
+	}
+	return "", fmt.Errorf("service %q does not expose the %q port", svc.GetName(), portName)
+}
+
+// chatCompletionsURL returns the URL of the OpenAI-compatible chat
+// completions endpoint of a ModelServer serving LLMs, based on its external
+// URL if it is exposed, or an empty string if it serves no LLM.
+func chatCompletionsURL(rel *rpb.Release, values map[string]interface{}, url string) string {
+	config, err := ovms.ConfigFromValues(values)
+	if err != nil || config == nil || len(config.LLMs) == 0 {
+		return ""
+	}
+	if url == "" {
+		endpoint, err := modelServerEndpoint(rel)
+		if err != nil {
+			log.V(1).Info("Failed to get the model server endpoint", "error", err.Error())
+			return ""
+		}
+		url = endpoint
+	}
+	return strings.TrimSuffix(url, "/") + ovms.ChatCompletionsPath
+}
+
+// servedModelNames returns the names of the models configured in the
+// ModelServer values.
+func servedModelNames(values map[string]interface{}) []string {
+	single, found, _ := unstructured.NestedBool(values, "models_settings", "single_model_mode")
+	if found && !single {
+		config, err := ovms.ConfigFromValues(values)
+		if err != nil || config == nil {
+			return nil
+		}
+		return config.Names()
+	}
+	name, _, _ := unstructured.NestedString(values, "models_settings", "model_name")
+	if name == "" {
+		return nil
+	}
+	return []string{name}
+}
+
+// modelCheckTimeout limits the duration of all the requests checking the
+// models of a model server.
+const modelCheckTimeout = 30 * time.Second
+
+// ModelChecks runs the checks of the models served by model servers in the
+// background, so that slow or unresponsive model servers never block a
+// reconcile. A reconcile starts the check of a release and a later reconcile
+// records its result.
+type ModelChecks struct {
+	mu     sync.Mutex
+	checks map[string]*modelCheck
+}
+
+// modelCheck is a check of the models of a release, identified by the
+// endpoint, the models and the version of the release it checks.
+type modelCheck struct {
+	key    string
+	done   chan struct{}
+	result modelCheckResult
+}
+
+// modelCheckResult is the outcome of a model check.
+type modelCheckResult struct {
+	ready   bool
+	message string
+	models  []types.ModelStatus
+}
+
+// NewModelChecks returns a ModelChecks without running checks.
+func NewModelChecks() *ModelChecks {
+	return &ModelChecks{checks: map[string]*modelCheck{}}
+}
+
+// result returns the result of the check of the release with the key and
+// reports whether it completed. It starts the check if it is not running,
+// replacing a check of the release with another key. A completed check is
+// forgotten, so that the next call starts a new one. The check runs inline
+// if c is nil.
+func (c *ModelChecks) result(release, key string, check func(context.Context) modelCheckResult) (modelCheckResult, bool) {
+	if c == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), modelCheckTimeout)
+		defer cancel()
+		return check(ctx), true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	running, ok := c.checks[release]
+	if ok && running.key == key {
+		select {
+		case <-running.done:
+			delete(c.checks, release)
+			return running.result, true
+		default:
+			return modelCheckResult{}, false
+		}
+	}
+
+	started := &modelCheck{key: key, done: make(chan struct{})}
+	c.checks[release] = started
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), modelCheckTimeout)
+		defer cancel()
+		started.result = check(ctx)
+		close(started.done)
+	}()
+	return modelCheckResult{}, false
+}
+
+// forget drops the check of the release, if any.
+func (c *ModelChecks) forget(release string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.checks, release)
+}
+
+// verifyModels checks over the REST API of the model server that the
+// configured models are ready, records their versions and input and output
+// shapes in the status and sets the ModelReady condition. The requests run
+// in the background: it returns the period after which the models should be
+// checked again, which is short while a check is running, or zero once all
+// models are ready.
+func (r HelmOperatorReconciler) verifyModels(ctx context.Context, status *types.HelmAppStatus,
+	rel *rpb.Release, values map[string]interface{}) time.Duration {
+
+	setModelReady := func(ready bool, reason types.HelmAppConditionReason, message string) time.Duration {
+		conditionStatus := types.StatusFalse
+		if ready {
+			conditionStatus = types.StatusTrue
+		}
+		status.SetCondition(types.HelmAppCondition{
+			Type:    types.ConditionModelReady,
+			Status:  conditionStatus,
+			Reason:  reason,
+			Message: message,
+		})
+		if ready {
+			return 0
+		}
+		return r.ReconcilePeriod
+	}
+
+	releaseKey := rel.Namespace + "/" + rel.Name
+	names := servedModelNames(values)
+	if len(names) == 0 {
+		r.ModelChecks.forget(releaseKey)
+		status.RemoveCondition(types.ConditionModelReady)
+		status.Models = nil
+		return 0
+	}
+	if status.Replicas == 0 {
+		r.ModelChecks.forget(releaseKey)
+		return setModelReady(false, types.ReasonModelUnavailable, "No model server replicas are available")
+	}
+	endpoint, err := modelServerEndpoint(rel)
+	if err != nil {
+		return setModelReady(false, types.ReasonModelUnavailable, err.Error())
+	}
+
+	client, err := modelClientFor(ctx, r.APIReader, r.ModelClient, rel.Namespace, status.TLS)
+	if err != nil {
+		return setModelReady(false, types.ReasonModelUnavailable, err.Error())
+	}
+	key := fmt.Sprintf("%s %s %d", endpoint, strings.Join(names, ","), rel.Version)
+	result, completed := r.ModelChecks.result(releaseKey, key, func(ctx context.Context) modelCheckResult {
+		return checkModels(ctx, client, endpoint, names)
+	})
+	if !completed {
+		if status.GetCondition(types.ConditionModelReady) == nil {
+			setModelReady(false, types.ReasonModelUnavailable, "Models are being checked")
+		}
+		return releaseProgressPeriod
+	}
+	if result.models != nil {
+		status.Models = result.models
+	}
+	if !result.ready {
+		return setModelReady(false, types.ReasonModelUnavailable, result.message)
+	}
+	return setModelReady(true, types.ReasonModelAvailable, result.message)
+}
+
+// checkModels checks over the REST API of the model server at the endpoint
+// that the models are ready and gets their metadata.
+func checkModels(ctx context.Context, client ovms.Client, endpoint string, names []string) modelCheckResult {
+	models := make([]types.ModelStatus, 0, len(names))
+	var notReady []string
+	for _, name := range names {
+		ready, err := client.ModelReady(ctx, endpoint, name)
+		if err != nil {
+			return modelCheckResult{message: err.Error()}
+		}
+		if !ready {
+			notReady = append(notReady, name)
+			continue
+		}
+		metadata, err := client.ModelMetadata(ctx, endpoint, name)
+		if err != nil {
+			return modelCheckResult{message: err.Error()}
+		}
+		models = append(models, modelStatusFor(name, metadata))
+	}
+	if len(notReady) > 0 {
+		return modelCheckResult{models: models,
+			message: fmt.Sprintf("Models not ready: %s", strings.Join(notReady, ", "))}
+	}
+	return modelCheckResult{ready: true, models: models, message: fmt.Sprintf("Models served at %s", endpoint)}
+}
+
+func modelStatusFor(name string, metadata *ovms.ModelMetadata) types.ModelStatus {
+	tensors := func(in []ovms.TensorMetadata) []types.TensorStatus {
+		var out []types.TensorStatus
+		for _, t := range in {
+			out = append(out, types.TensorStatus{Name: t.Name, Datatype: t.Datatype, Shape: t.Shape})
+		}
+		return out
+	}
+	return types.ModelStatus{
+		Name:    name,
+		Version: metadata.LatestVersion(),
+		Inputs:  tensors(metadata.Inputs),
+		Outputs: tensors(metadata.Outputs),
+	}
+}
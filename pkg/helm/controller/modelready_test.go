@@ -0,0 +1,193 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	rpb "helm.sh/helm/v3/pkg/release"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/ovms"
+)
+
+const testModelServerManifest = `---
+# Source: ovms/templates/service.yaml
+kind: Service
+apiVersion: v1
+metadata:
+  name: sample-ovms
+spec:
+  ports:
+    - port: 9000
+      name: grpc
+    - port: 9001
+      name: rest
+`
+
+type fakeModelClient struct {
+	endpoint string
+	ready    map[string]bool
+	metrics  map[string]float64
+	// block delays the readiness responses until it is closed
+	block chan struct{}
+	mu    sync.Mutex
+}
+
+func (c *fakeModelClient) ModelReady(_ context.Context, endpoint, model string) (bool, error) {
+	if c.block != nil {
+		<-c.block
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.endpoint = endpoint
+	return c.ready[model], nil
+}
+
+func (c *fakeModelClient) ModelMetadata(_ context.Context, _, model string) (*ovms.ModelMetadata, error) {
+	return &ovms.ModelMetadata{
+		Name:     model,
+		Versions: []string{"1", "2"},
+		Inputs:   []ovms.TensorMetadata{{Name: "0", Datatype: "FP32", Shape: []int64{1, 3, 224, 224}}},
+		Outputs:  []ovms.TensorMetadata{{Name: "1463", Datatype: "FP32", Shape: []int64{1, 1000}}},
+	}, nil
+}
+
+func (c *fakeModelClient) Metrics(_ context.Context, endpoint string) (map[string]float64, error) {
+	c.endpoint = endpoint
+	return c.metrics, nil
+}
+
+func TestModelServerEndpoint(t *testing.T) {
+	endpoint, err := modelServerEndpoint(&rpb.Release{Namespace: "ns", Manifest: testModelServerManifest})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://sample-ovms.ns.svc:9001", endpoint)
+
+	_, err = modelServerEndpoint(&rpb.Release{Namespace: "ns"})
+	assert.Error(t, err)
+
+	endpoint, err = modelServerEndpoint(&rpb.Release{Namespace: "ns",
+		Manifest: testModelServerManifest + "      appProtocol: https\n"})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://sample-ovms.ns.svc:9001", endpoint)
+}
+
+func TestChatCompletionsURL(t *testing.T) {
+	rel := &rpb.Release{Namespace: "ns", Manifest: testModelServerManifest}
+	llms := map[string]interface{}{"models_settings": map[string]interface{}{
+		"single_model_mode": false,
+		"llms":              []interface{}{map[string]interface{}{"name": "llama", "model_path": "/models/llama"}},
+	}}
+	assert.Equal(t, "http://sample-ovms.ns.svc:9001/v3/chat/completions", chatCompletionsURL(rel, llms, ""))
+	assert.Equal(t, "https://models.example.com/llama/v3/chat/completions",
+		chatCompletionsURL(rel, llms, "https://models.example.com/llama"))
+	assert.Empty(t, chatCompletionsURL(rel, map[string]interface{}{
+		"models_settings": map[string]interface{}{"single_model_mode": true, "model_name": "resnet"},
+	}, ""))
+}
+
+func TestServedModelNames(t *testing.T) {
+	assert.Equal(t, []string{"resnet"}, servedModelNames(map[string]interface{}{
+		"models_settings": map[string]interface{}{"single_model_mode": true, "model_name": "resnet"},
+	}))
+	assert.Empty(t, servedModelNames(map[string]interface{}{
+		"models_settings": map[string]interface{}{"single_model_mode": false, "model_name": "resnet"},
+	}))
+	assert.Equal(t, []string{"resnet", "face", "pipeline"}, servedModelNames(map[string]interface{}{
+		"models_settings": map[string]interface{}{
+			"single_model_mode": false,
+			"models": []interface{}{
+				map[string]interface{}{"name": "resnet", "base_path": "gs://models/resnet"},
+				map[string]interface{}{"name": "face", "base_path": "/models/face"},
+			},
+			"mediapipe_graphs": []interface{}{
+				map[string]interface{}{"name": "pipeline", "graph_path": "/models/graph.pbtxt"},
+			},
+		},
+	}))
+}
+
+func TestVerifyModels(t *testing.T) {
+	values := map[string]interface{}{
+		"models_settings": map[string]interface{}{"single_model_mode": true, "model_name": "resnet"},
+	}
+	rel := &rpb.Release{Namespace: "ns", Manifest: testModelServerManifest}
+
+	client := &fakeModelClient{ready: map[string]bool{}}
+	r := HelmOperatorReconciler{ModelClient: client, ReconcilePeriod: time.Minute}
+
+	status := &types.HelmAppStatus{}
+	assert.Equal(t, time.Minute, r.verifyModels(context.TODO(), status, rel, values))
+	assert.Equal(t, types.StatusFalse, status.GetCondition(types.ConditionModelReady).Status)
+
+	status.Replicas = 1
+	assert.Equal(t, time.Minute, r.verifyModels(context.TODO(), status, rel, values))
+	assert.Equal(t, "http://sample-ovms.ns.svc:9001", client.endpoint)
+	assert.Equal(t, "Models not ready: resnet", status.GetCondition(types.ConditionModelReady).Message)
+
+	client.ready["resnet"] = true
+	assert.Zero(t, r.verifyModels(context.TODO(), status, rel, values))
+	assert.Equal(t, types.StatusTrue, status.GetCondition(types.ConditionModelReady).Status)
+	assert.Equal(t, []types.ModelStatus{{
+		Name:    "resnet",
+		Version: "2",
+		Inputs:  []types.TensorStatus{{Name: "0", Datatype: "FP32", Shape: []int64{1, 3, 224, 224}}},
+		Outputs: []types.TensorStatus{{Name: "1463", Datatype: "FP32", Shape: []int64{1, 1000}}},
+	}}, status.Models)
+}
+
+func TestVerifyModelsInBackground(t *testing.T) {
+	values := map[string]interface{}{
+		"models_settings": map[string]interface{}{"single_model_mode": true, "model_name": "resnet"},
+	}
+	rel := &rpb.Release{Name: "sample", Namespace: "ns", Version: 1, Manifest: testModelServerManifest}
+
+	client := &fakeModelClient{ready: map[string]bool{"resnet": true}, block: make(chan struct{})}
+	r := HelmOperatorReconciler{ModelClient: client, ModelChecks: NewModelChecks(), ReconcilePeriod: time.Minute}
+	status := &types.HelmAppStatus{Replicas: 1}
+
+	// the reconcile does not wait for the model server
+	assert.Equal(t, releaseProgressPeriod, r.verifyModels(context.TODO(), status, rel, values))
+	assert.Equal(t, "Models are being checked", status.GetCondition(types.ConditionModelReady).Message)
+	assert.Equal(t, releaseProgressPeriod, r.verifyModels(context.TODO(), status, rel, values))
+	assert.Len(t, r.ModelChecks.checks, 1)
+
+	close(client.block)
+	assert.Eventually(t, func() bool {
+		return r.verifyModels(context.TODO(), status, rel, values) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, types.StatusTrue, status.GetCondition(types.ConditionModelReady).Status)
+	assert.Equal(t, "resnet", status.Models[0].Name)
+	assert.Empty(t, r.ModelChecks.checks)
+
+	// the previous result is kept while the models are checked again
+	assert.Equal(t, releaseProgressPeriod, r.verifyModels(context.TODO(), status, rel, values))
+	assert.Equal(t, types.StatusTrue, status.GetCondition(types.ConditionModelReady).Status)
+
+	// a new release version replaces the running check
+	upgraded := *rel
+	upgraded.Version = 2
+	assert.Equal(t, releaseProgressPeriod, r.verifyModels(context.TODO(), status, &upgraded, values))
+	assert.Contains(t, r.ModelChecks.checks["ns/sample"].key, " 2")
+
+	r.ModelChecks.forget("ns/sample")
+	assert.Empty(t, r.ModelChecks.checks)
+}
@@ -0,0 +1,232 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/modelregistry"
+	"github.com/openvinotoolkit/operator/pkg/modelrepo"
+)
+
+// defaultResolvePeriod is the period after which the latest model version
+// in a stage is resolved again.
+const defaultResolvePeriod = 10 * time.Minute
+
+// ModelRegistryOptions are the settings of the model_registry section of the
+// ModelServer values, which resolves models_settings.model_path from a
+// model registry.
+type ModelRegistryOptions struct {
+	Reference modelregistry.Reference
+	// Path is the directory of the model in the OpenVINO layout under the
+	// artifacts of the model version.
+	Path string
+	// TokenSecret and TokenKey select the Secret key holding the bearer
+	// token of the registry, if any.
+	TokenSecret string
+	TokenKey    string
+	// ResolvePeriod is the period after which the latest version in the
+	// stage is resolved again.
+	ResolvePeriod time.Duration
+}
+
+// modelRegistryOptionsFor returns the model registry settings of the
+// ModelServer values, or nil if model_registry is not set.
+func modelRegistryOptionsFor(values map[string]interface{}) (*ModelRegistryOptions, error) {
+	registry, _, _ := unstructured.NestedMap(values, "model_registry")
+	url, _ := registry["url"].(string)
+	if url == "" {
+		return nil, nil
+	}
+	o := &ModelRegistryOptions{ResolvePeriod: defaultResolvePeriod}
+	o.Reference.URL = url
+	o.Reference.Name, _ = registry["model_name"].(string)
+	o.Reference.Version, _ = registry["version"].(string)
+	o.Reference.Stage, _ = registry["stage"].(string)
+	if err := o.Reference.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid model_registry: %w", err)
+	}
+	o.Path, _ = registry["path"].(string)
+	o.Path = strings.Trim(o.Path, "/")
+	if strings.Contains("/"+o.Path+"/", "/../") {
+		return nil, fmt.Errorf("invalid model_registry.path %q", o.Path)
+	}
+	o.TokenSecret, _, _ = unstructured.NestedString(registry, "token_secret_ref", "name")
+	o.TokenKey, _, _ = unstructured.NestedString(registry, "token_secret_ref", "key")
+	if (o.TokenSecret == "") != (o.TokenKey == "") {
+		return nil, errors.New("model_registry.token_secret_ref requires a name and a key")
+	}
+	if period, _ := registry["resolve_period"].(string); period != "" {
+		d, err := time.ParseDuration(period)
+		if err != nil || d < time.Minute {
+			return nil, fmt.Errorf("invalid model_registry.resolve_period %q, expected a duration of at least 1m", period)
+		}
+		o.ResolvePeriod = d
+	}
+	return o, nil
+}
+
+// resolveModelRegistry sets models_settings.model_path to the storage URI
+// of the model version referenced in the model_registry values. The
+// resolved version is recorded in the status and reused until the
+// reference changes, or, for the latest version in a stage, until the
+// resolve period elapses. A changed version upgrades the release. If the
+// registry cannot be reached, the version resolved before is kept.
+func (r HelmOperatorReconciler) resolveModelRegistry(ctx context.Context, o *unstructured.Unstructured,
+	status *types.HelmAppStatus, values map[string]interface{}, now time.Time) error {
+
+	options, err := modelRegistryOptionsFor(values)
+	if err != nil {
+		return err
+	}
+	if options == nil {
+		status.ModelRegistry = nil
+		status.RemoveCondition(types.ConditionModelResolved)
+		return nil
+	}
+	if single, _, _ := unstructured.NestedBool(values, "models_settings", "single_model_mode"); !single {
+		return errors.New("model_registry requires models_settings.single_model_mode")
+	}
+
+	ref := options.Reference
+	resolved := status.ModelRegistry
+	if resolved != nil && resolved.Reference != ref.String() {
+		resolved = nil
+	}
+	if resolved == nil || modelRegistryResolveDelay(resolved, options, now) == 0 {
+		model, err := r.resolveModelVersion(ctx, o.GetNamespace(), options)
+		if err != nil {
+			if resolved == nil {
+				return fmt.Errorf("failed to resolve the model from model_registry: %w", err)
+			}
+			// the release keeps serving the version resolved before
+			status.SetCondition(types.HelmAppCondition{
+				Type:    types.ConditionModelResolved,
+				Status:  types.StatusFalse,
+				Reason:  types.ReasonModelResolutionFailed,
+				Message: err.Error(),
+			})
+			resolved.LastResolveTime = &metav1.Time{Time: now}
+		} else {
+			if resolved != nil && resolved.Version != model.Version {
+				r.EventRecorder.Eventf(o, "Normal", string(types.ReasonModelVersionResolved),
+					"Model %s resolved to version %s, previously %s", ref.Name, model.Version, resolved.Version)
+			}
+			resolved = &types.ModelRegistryStatus{
+				Reference:       ref.String(),
+				Version:         model.Version,
+				ModelPath:       model.URI,
+				LastResolveTime: &metav1.Time{Time: now},
+			}
+			status.SetCondition(types.HelmAppCondition{
+				Type:    types.ConditionModelResolved,
+				Status:  types.StatusTrue,
+				Reason:  types.ReasonModelVersionResolved,
+				Message: fmt.Sprintf("Version %s of model %s is served from %s", model.Version, ref.Name, model.URI),
+			})
+		}
+		status.ModelRegistry = resolved
+	}
+
+	settings := copyValues(values["models_settings"])
+	settings["model_path"] = resolved.ModelPath
+	values["models_settings"] = settings
+	return nil
+}
+
+// resolveModelVersion queries the registry for the model version and
+// returns it with the model path served by the model server.
+func (r HelmOperatorReconciler) resolveModelVersion(ctx context.Context, namespace string,
+	options *ModelRegistryOptions) (*modelregistry.Model, error) {
+
+	if r.ModelRegistry == nil {
+		return nil, errors.New("no model registry resolver is configured")
+	}
+	token := ""
+	if options.TokenSecret != "" {
+		secret := &corev1.Secret{}
+		if err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: options.TokenSecret}, secret); err != nil {
+			return nil, fmt.Errorf("failed to get model_registry.token_secret_ref: %w", err)
+		}
+		token = strings.TrimSpace(string(secret.Data[options.TokenKey]))
+		if token == "" {
+			return nil, fmt.Errorf("secret %q has no key %q set in model_registry.token_secret_ref",
+				options.TokenSecret, options.TokenKey)
+		}
+	}
+	model, err := r.ModelRegistry.Resolve(ctx, options.Reference, token)
+	if err != nil {
+		return nil, err
+	}
+	uri := strings.TrimSuffix(model.URI, "/")
+	if options.Path != "" {
+		uri = uri + "/" + options.Path
+	}
+	if _, ok := modelrepo.ParseLocation(uri); !ok && !path.IsAbs(uri) {
+		return nil, fmt.Errorf("version %s of model %s is stored at %q, which the model server cannot read",
+			model.Version, options.Reference.Name, uri)
+	}
+	return &modelregistry.Model{Version: model.Version, URI: uri}, nil
+}
+
+// modelRegistryResolveDelay returns the time left before the latest model
+// version in the stage is resolved again, or zero if it is due. Pinned
+// versions are not resolved again.
+func modelRegistryResolveDelay(resolved *types.ModelRegistryStatus, options *ModelRegistryOptions,
+	now time.Time) time.Duration {
+
+	if options.Reference.Pinned() {
+		return -1
+	}
+	if resolved.LastResolveTime == nil {
+		return 0
+	}
+	if delay := resolved.LastResolveTime.Add(options.ResolvePeriod).Sub(now); delay > 0 {
+		return delay
+	}
+	return 0
+}
+
+// modelRegistryRequeue returns the period after which the ModelServer must
+// be reconciled to resolve the latest model version in the stage again, or
+// zero if it is not resolved periodically.
+func modelRegistryRequeue(status *types.HelmAppStatus, values map[string]interface{}, now time.Time) time.Duration {
+	options, err := modelRegistryOptionsFor(values)
+	if err != nil || options == nil || status.ModelRegistry == nil {
+		return 0
+	}
+	delay := modelRegistryResolveDelay(status.ModelRegistry, options, now)
+	if delay < 0 {
+		return 0
+	}
+	if delay == 0 {
+		// the next reconciliation resolves the version without delay
+		return time.Second
+	}
+	return delay
+}
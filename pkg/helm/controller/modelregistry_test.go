@@ -0,0 +1,257 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/modelregistry"
+)
+
+// testRegistry serves the MLflow model registry API for the resnet model,
+// whose production version can be changed by the tests.
+type testRegistry struct {
+	*httptest.Server
+	production string
+	requests   int
+}
+
+func newTestRegistry() *testRegistry {
+	registry := &testRegistry{production: "1"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/2.0/mlflow/registered-models/get-latest-versions", func(w http.ResponseWriter, r *http.Request) {
+		registry.requests++
+		if r.Header.Get("Authorization") != "Bearer secret-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"model_versions": []interface{}{
+			map[string]string{"name": "resnet", "version": registry.production, "current_stage": "Production", "status": "READY"},
+		}})
+	})
+	mux.HandleFunc("/api/2.0/mlflow/model-versions/get", func(w http.ResponseWriter, r *http.Request) {
+		registry.requests++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"model_version": map[string]string{
+			"name": "resnet", "version": r.URL.Query().Get("version"), "status": "READY",
+		}})
+	})
+	mux.HandleFunc("/api/2.0/mlflow/model-versions/get-download-uri", func(w http.ResponseWriter, r *http.Request) {
+		uri := "s3://mlflow/artifacts/resnet/" + r.URL.Query().Get("version")
+		if r.URL.Query().Get("version") == "9" {
+			uri = "mlflow-artifacts:/resnet/9"
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"artifact_uri": uri})
+	})
+	registry.Server = httptest.NewServer(mux)
+	return registry
+}
+
+func TestResolveModelRegistry(t *testing.T) {
+	registry := newTestRegistry()
+	defer registry.Close()
+	token := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "mlflow"},
+		Data:       map[string][]byte{"token": []byte("secret-token\n")},
+	}
+	recorder := record.NewFakeRecorder(10)
+	cl := fake.NewClientBuilder().WithObjects(token).Build()
+	r := HelmOperatorReconciler{
+		Client:        cachedClient(cl),
+		APIReader:     cl,
+		EventRecorder: recorder,
+		ModelRegistry: modelregistry.NewMLflowResolver(registry.Client()),
+	}
+	o := testModelServer("ns")
+	newValues := func() map[string]interface{} {
+		return map[string]interface{}{
+			"models_settings": map[string]interface{}{"single_model_mode": true, "model_path": "gs://bucket/model"},
+			"model_registry": map[string]interface{}{
+				"url":              registry.URL,
+				"model_name":       "resnet",
+				"stage":            "Production",
+				"path":             "/ovms/",
+				"token_secret_ref": map[string]interface{}{"name": "mlflow", "key": "token"},
+				"resolve_period":   "5m",
+			},
+		}
+	}
+	modelPath := func(values map[string]interface{}) interface{} {
+		return values["models_settings"].(map[string]interface{})["model_path"]
+	}
+	start := time.Now()
+	status := &types.HelmAppStatus{}
+
+	values := newValues()
+	settings := values["models_settings"]
+	assert.NoError(t, r.resolveModelRegistry(context.TODO(), o, status, values, start))
+	assert.Equal(t, "s3://mlflow/artifacts/resnet/1/ovms", modelPath(values))
+	assert.Equal(t, "gs://bucket/model", settings.(map[string]interface{})["model_path"])
+	if assert.NotNil(t, status.ModelRegistry) {
+		assert.Equal(t, registry.URL+"/resnet@Production", status.ModelRegistry.Reference)
+		assert.Equal(t, "1", status.ModelRegistry.Version)
+		assert.Equal(t, "s3://mlflow/artifacts/resnet/1/ovms", status.ModelRegistry.ModelPath)
+	}
+	assert.Equal(t, types.ReasonModelVersionResolved, status.GetCondition(types.ConditionModelResolved).Reason)
+	assert.Equal(t, 5*time.Minute, modelRegistryRequeue(status, values, start))
+
+	// the stage is not resolved again before the period elapses
+	registry.production = "2"
+	requests := registry.requests
+	values = newValues()
+	assert.NoError(t, r.resolveModelRegistry(context.TODO(), o, status, values, start.Add(time.Minute)))
+	assert.Equal(t, "s3://mlflow/artifacts/resnet/1/ovms", modelPath(values))
+	assert.Equal(t, requests, registry.requests)
+	assert.Equal(t, 4*time.Minute, modelRegistryRequeue(status, values, start.Add(time.Minute)))
+	assert.Empty(t, recorder.Events)
+
+	values = newValues()
+	assert.NoError(t, r.resolveModelRegistry(context.TODO(), o, status, values, start.Add(5*time.Minute)))
+	assert.Equal(t, "s3://mlflow/artifacts/resnet/2/ovms", modelPath(values))
+	assert.Equal(t, "2", status.ModelRegistry.Version)
+	assert.Equal(t, "Normal ModelVersionResolved Model resnet resolved to version 2, previously 1", <-recorder.Events)
+
+	// the resolved version is kept while the registry is unavailable
+	registry.Close()
+	values = newValues()
+	assert.NoError(t, r.resolveModelRegistry(context.TODO(), o, status, values, start.Add(10*time.Minute)))
+	assert.Equal(t, "s3://mlflow/artifacts/resnet/2/ovms", modelPath(values))
+	c := status.GetCondition(types.ConditionModelResolved)
+	assert.Equal(t, types.StatusFalse, c.Status)
+	assert.Equal(t, types.ReasonModelResolutionFailed, c.Reason)
+	assert.Equal(t, 5*time.Minute, modelRegistryRequeue(status, values, start.Add(10*time.Minute)))
+
+	// a changed reference is resolved again, without falling back to the
+	// version of the previous reference
+	values = newValues()
+	values["model_registry"].(map[string]interface{})["version"] = "3"
+	assert.Error(t, r.resolveModelRegistry(context.TODO(), o, status, values, start.Add(11*time.Minute)))
+
+	values = map[string]interface{}{"models_settings": map[string]interface{}{"single_model_mode": true}}
+	assert.NoError(t, r.resolveModelRegistry(context.TODO(), o, status, values, start))
+	assert.Nil(t, status.ModelRegistry)
+	assert.Nil(t, status.GetCondition(types.ConditionModelResolved))
+}
+
+func TestResolveModelRegistryVersion(t *testing.T) {
+	registry := newTestRegistry()
+	defer registry.Close()
+	r := HelmOperatorReconciler{
+		Client:        fake.NewClientBuilder().Build(),
+		EventRecorder: record.NewFakeRecorder(10),
+		ModelRegistry: modelregistry.NewMLflowResolver(registry.Client()),
+	}
+	newValues := func(version string) map[string]interface{} {
+		return map[string]interface{}{
+			"models_settings": map[string]interface{}{"single_model_mode": true},
+			"model_registry": map[string]interface{}{
+				"url": registry.URL, "model_name": "resnet", "version": version,
+			},
+		}
+	}
+	start := time.Now()
+	status := &types.HelmAppStatus{}
+
+	values := newValues("4")
+	assert.NoError(t, r.resolveModelRegistry(context.TODO(), testModelServer("ns"), status, values, start))
+	assert.Equal(t, "s3://mlflow/artifacts/resnet/4", values["models_settings"].(map[string]interface{})["model_path"])
+	assert.Equal(t, time.Duration(0), modelRegistryRequeue(status, values, start))
+
+	// pinned versions are not resolved again
+	requests := registry.requests
+	assert.NoError(t, r.resolveModelRegistry(context.TODO(), testModelServer("ns"), status, newValues("4"), start.Add(time.Hour)))
+	assert.Equal(t, requests, registry.requests)
+
+	err := r.resolveModelRegistry(context.TODO(), testModelServer("ns"), status, newValues("9"), start)
+	assert.EqualError(t, err, `failed to resolve the model from model_registry: version 9 of model resnet is stored at `+
+		`"mlflow-artifacts:/resnet/9", which the model server cannot read`)
+}
+
+func TestModelRegistryOptionsErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		registry   map[string]interface{}
+		single     bool
+		errMessage string
+	}{
+		{
+			name:       "missing model name",
+			registry:   map[string]interface{}{"url": "http://mlflow:5000", "stage": "Production"},
+			single:     true,
+			errMessage: "invalid model_registry: model_name is required",
+		},
+		{
+			name:       "missing version",
+			registry:   map[string]interface{}{"url": "http://mlflow:5000", "model_name": "resnet"},
+			single:     true,
+			errMessage: "invalid model_registry: version or stage is required",
+		},
+		{
+			name: "short resolve period",
+			registry: map[string]interface{}{
+				"url": "http://mlflow:5000", "model_name": "resnet", "stage": "Production", "resolve_period": "10s",
+			},
+			single:     true,
+			errMessage: `invalid model_registry.resolve_period "10s", expected a duration of at least 1m`,
+		},
+		{
+			name: "token secret without key",
+			registry: map[string]interface{}{
+				"url": "http://mlflow:5000", "model_name": "resnet", "stage": "Production",
+				"token_secret_ref": map[string]interface{}{"name": "mlflow"},
+			},
+			single:     true,
+			errMessage: "model_registry.token_secret_ref requires a name and a key",
+		},
+		{
+			name: "path outside the artifacts",
+			registry: map[string]interface{}{
+				"url": "http://mlflow:5000", "model_name": "resnet", "stage": "Production", "path": "../other",
+			},
+			single:     true,
+			errMessage: `invalid model_registry.path "../other"`,
+		},
+		{
+			name:       "multiple models",
+			registry:   map[string]interface{}{"url": "http://mlflow:5000", "model_name": "resnet", "version": "1"},
+			errMessage: "model_registry requires models_settings.single_model_mode",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := HelmOperatorReconciler{Client: fake.NewClientBuilder().Build()}
+			values := map[string]interface{}{
+				"models_settings": map[string]interface{}{"single_model_mode": test.single},
+				"model_registry":  test.registry,
+			}
+			err := r.resolveModelRegistry(context.TODO(), testModelServer("ns"), &types.HelmAppStatus{}, values, time.Now())
+			assert.EqualError(t, err, test.errMessage)
+		})
+	}
+}
@@ -0,0 +1,336 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	crthandler "sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	crpredicate "sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+)
+
+const (
+	modelRepositoryKind        = "ModelRepository"
+	clusterModelRepositoryKind = "ClusterModelRepository"
+
+	// repositoryCheckPeriod is the period of the reachability checks of the
+	// model repositories.
+	repositoryCheckPeriod = 5 * time.Minute
+)
+
+// storageSchemes maps the storage types of a model repository to the URL
+// scheme of its model paths. The cluster storage has no remote endpoint.
+var storageSchemes = map[string]string{
+	"S3":     "s3",
+	"google": "gs",
+	"azure":  "az",
+}
+
+// ModelRepositoryGVKs returns the kinds of the model repositories which can
+// be referenced by the ModelServers of the given kind, in the same API group
+// and version.
+func ModelRepositoryGVKs(modelServer schema.GroupVersionKind) []schema.GroupVersionKind {
+	return []schema.GroupVersionKind{
+		modelServer.GroupVersion().WithKind(modelRepositoryKind),
+		modelServer.GroupVersion().WithKind(clusterModelRepositoryKind),
+	}
+}
+
+// repositoryRef returns the kind and the name of the model repository
+// referenced in the ModelServer values, or an empty name if none is.
+func repositoryRef(values map[string]interface{}) (string, string, error) {
+	ref, found, err := unstructured.NestedMap(values, "repository_ref")
+	if err != nil {
+		return "", "", errors.New("repository_ref must be an object")
+	}
+	if !found {
+		return "", "", nil
+	}
+	name, _ := ref["name"].(string)
+	if name == "" {
+		return "", "", errors.New("repository_ref requires a name")
+	}
+	kind, _ := ref["kind"].(string)
+	switch kind {
+	case "":
+		kind = modelRepositoryKind
+	case modelRepositoryKind, clusterModelRepositoryKind:
+	default:
+		return "", "", fmt.Errorf("invalid repository_ref.kind %q, expected %s or %s", kind,
+			modelRepositoryKind, clusterModelRepositoryKind)
+	}
+	return kind, name, nil
+}
+
+// resolveModelRepository sets the storage settings of the model repository
+// referenced in the ModelServer values into its models_repository values.
+func (r HelmOperatorReconciler) resolveModelRepository(ctx context.Context, namespace string,
+	values map[string]interface{}) error {
+
+	return resolveRepositoryRef(ctx, r.Client, r.GVK, namespace, values)
+}
+
+// resolveRepositoryRef reads the ModelRepository in the namespace, or the
+// ClusterModelRepository, referenced in the values of a ModelServer of the
+// given kind. The fields of its spec replace the same fields of the
+// models_repository values, and the other fields are kept.
+func resolveRepositoryRef(ctx context.Context, cl client.Reader, gvk schema.GroupVersionKind, namespace string,
+	values map[string]interface{}) error {
+
+	kind, name, err := repositoryRef(values)
+	if err != nil || name == "" {
+		return err
+	}
+	repo := &unstructured.Unstructured{}
+	repo.SetGroupVersionKind(gvk.GroupVersion().WithKind(kind))
+	key := client.ObjectKey{Name: name}
+	if kind == modelRepositoryKind {
+		key.Namespace = namespace
+	}
+	if err := cl.Get(ctx, key, repo); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("%s %q set in repository_ref not found", kind, name)
+		}
+		return fmt.Errorf("failed to get the %s %q: %w", kind, name, err)
+	}
+	spec, _, _ := unstructured.NestedMap(repo.Object, "spec")
+	repository := copyValues(values["models_repository"])
+	for k, v := range spec {
+		repository[k] = v
+	}
+	values["models_repository"] = repository
+	return nil
+}
+
+// watchModelRepositories enqueues the ModelServers referencing a model
+// repository when it changes, so that its settings are rolled out.
+func watchModelRepositories(mgr manager.Manager, c controller.Controller, gvk schema.GroupVersionKind) error {
+	cl := mgr.GetClient()
+	for _, repoGVK := range ModelRepositoryGVKs(gvk) {
+		kind := repoGVK.Kind
+		mapFunc := func(ctx context.Context, obj client.Object) []reconcile.Request {
+			list := &unstructured.UnstructuredList{}
+			list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+			var opts []client.ListOption
+			if kind == modelRepositoryKind {
+				opts = append(opts, client.InNamespace(obj.GetNamespace()))
+			}
+			if err := cl.List(ctx, list, opts...); err != nil {
+				log.Error(err, "Failed to list resources referencing the model repository", "kind", kind,
+					"namespace", obj.GetNamespace(), "name", obj.GetName())
+				return nil
+			}
+			var requests []reconcile.Request
+			for _, item := range list.Items {
+				spec, _, _ := unstructured.NestedMap(item.Object, "spec")
+				if refKind, name, _ := repositoryRef(spec); refKind == kind && name == obj.GetName() {
+					requests = append(requests, reconcile.Request{NamespacedName: apitypes.NamespacedName{
+						Namespace: item.GetNamespace(),
+						Name:      item.GetName(),
+					}})
+				}
+			}
+			return requests
+		}
+		o := &unstructured.Unstructured{}
+		o.SetGroupVersionKind(repoGVK)
+		if err := c.Watch(source.Kind(mgr.GetCache(), client.Object(o), crthandler.EnqueueRequestsFromMapFunc(mapFunc),
+			crpredicate.GenerationChangedPredicate{})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ModelRepositoryReconciler periodically checks that the storage of a
+// ModelRepository or a ClusterModelRepository is reachable from the
+// operator, and records the result in the Reachable condition of its
+// status.
+type ModelRepositoryReconciler struct {
+	Client client.Client
+	// APIReader reads the Secrets and persistent volume claims of the users,
+	// which are not cached.
+	APIReader client.Reader
+	GVK       schema.GroupVersionKind
+	// CheckEndpoint sends a request to a storage endpoint with the HTTP
+	// client of the repository, returning an error if it gets no response.
+	CheckEndpoint func(ctx context.Context, httpClient *http.Client, endpoint string) error
+	CheckPeriod   time.Duration
+}
+
+var _ reconcile.Reconciler = &ModelRepositoryReconciler{}
+
+// addModelRepositoryControllers adds the controllers of the model
+// repositories referenced by the ModelServers of the given kind.
+func addModelRepositoryControllers(mgr manager.Manager, gvk schema.GroupVersionKind) error {
+	for _, repoGVK := range ModelRepositoryGVKs(gvk) {
+		r := &ModelRepositoryReconciler{
+			Client:        mgr.GetClient(),
+			APIReader:     mgr.GetAPIReader(),
+			GVK:           repoGVK,
+			CheckEndpoint: checkEndpoint,
+			CheckPeriod:   repositoryCheckPeriod,
+		}
+		c, err := controller.New(fmt.Sprintf("%v-controller", strings.ToLower(repoGVK.Kind)), mgr,
+			controller.Options{Reconciler: r})
+		if err != nil {
+			return err
+		}
+		o := &unstructured.Unstructured{}
+		o.SetGroupVersionKind(repoGVK)
+		// status updates are ignored, the storage is checked periodically
+		if err := c.Watch(source.Kind(mgr.GetCache(), client.Object(o), &crthandler.EnqueueRequestForObject{},
+			crpredicate.GenerationChangedPredicate{})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reconcile checks the storage of a model repository.
+func (r *ModelRepositoryReconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	o := &unstructured.Unstructured{}
+	o.SetGroupVersionKind(r.GVK)
+	if err := r.Client.Get(ctx, request.NamespacedName, o); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+
+	status := types.ModelRepositoryStatusFor(o)
+	spec, _, _ := unstructured.NestedMap(o.Object, "spec")
+	endpoints, condition := r.check(ctx, o.GetNamespace(), spec)
+	status.SetCondition(condition)
+	status.Endpoints = endpoints
+	status.ObservedGeneration = o.GetGeneration()
+	now := metav1.Now()
+	status.LastCheckTime = &now
+
+	statusMap, err := status.ToMap()
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	o.Object["status"] = statusMap
+	if err := r.Client.Status().Update(ctx, o); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	return reconcile.Result{RequeueAfter: r.CheckPeriod}, nil
+}
+
+// check returns the endpoints of the storage of a model repository, and the
+// Reachable condition reporting whether they respond. A persistent volume
+// claim is checked for existence in the namespace of a ModelRepository.
+// Settings read from the namespace of each ModelServer are not checked for a
+// ClusterModelRepository.
+func (r *ModelRepositoryReconciler) check(ctx context.Context, namespace string,
+	spec map[string]interface{}) ([]string, types.HelmAppCondition) {
+
+	condition := func(status types.ConditionStatus, reason types.HelmAppConditionReason,
+		format string, args ...interface{}) types.HelmAppCondition {
+
+		return types.HelmAppCondition{
+			Type:    types.ConditionReachable,
+			Status:  status,
+			Reason:  reason,
+			Message: fmt.Sprintf(format, args...),
+		}
+	}
+
+	storageType, _ := spec["storage_type"].(string)
+	scheme, ok := storageSchemes[storageType]
+	if !ok {
+		if hostPath, _ := spec["models_host_path"].(string); hostPath != "" {
+			return nil, condition(types.StatusUnknown, types.ReasonNotChecked, "Models in a host path are not checked")
+		}
+		claim, _ := spec["models_volume_claim"].(string)
+		if claim == "" {
+			return nil, condition(types.StatusUnknown, types.ReasonNotChecked, "The repository has no storage endpoint")
+		}
+		if namespace == "" {
+			return nil, condition(types.StatusUnknown, types.ReasonNotChecked,
+				"The persistent volume claim %q is read from the namespace of each ModelServer", claim)
+		}
+		pvc := &corev1.PersistentVolumeClaim{}
+		if err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: claim}, pvc); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, condition(types.StatusFalse, types.ReasonVolumeClaimNotFound,
+					"Persistent volume claim %q not found", claim)
+			}
+			return nil, condition(types.StatusUnknown, types.ReasonNotChecked,
+				"Failed to get the persistent volume claim %q: %v", claim, err)
+		}
+		return nil, condition(types.StatusTrue, types.ReasonVolumeClaimFound, "Persistent volume claim %q found", claim)
+	}
+	if _, found := spec[deprecatedCredentialFields["azure_storage_connection_string"]]; found && scheme == "az" &&
+		namespace == "" {
+		return nil, condition(types.StatusUnknown, types.ReasonNotChecked,
+			"The Azure endpoint is read from a Secret in the namespace of each ModelServer")
+	}
+
+	urls, err := storageEndpoints(ctx, r.APIReader, namespace, spec, map[string]bool{scheme: true})
+	if err != nil {
+		return nil, condition(types.StatusFalse, types.ReasonInvalidSpec, "%v", err)
+	}
+	httpClient, err := repositoryHTTPClient(spec)
+	if err != nil {
+		return nil, condition(types.StatusFalse, types.ReasonInvalidSpec, "%v", err)
+	}
+	var endpoints []string
+	for _, u := range urls {
+		endpoints = append(endpoints, u.String())
+	}
+	for _, endpoint := range endpoints {
+		if err := r.CheckEndpoint(ctx, httpClient, endpoint); err != nil {
+			return endpoints, condition(types.StatusFalse, types.ReasonEndpointUnreachable,
+				"Failed to reach %s: %v", endpoint, err)
+		}
+	}
+	return endpoints, condition(types.StatusTrue, types.ReasonEndpointReachable, "Reached %s", strings.Join(endpoints, ", "))
+}
+
+// checkEndpoint sends a HEAD request to the endpoint. Any response, even
+// an error status for the missing credentials, shows the storage is
+// reachable.
+func checkEndpoint(ctx context.Context, httpClient *http.Client, endpoint string) error {
+	ctx, cancel := context.WithTimeout(ctx, repositoryCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,249 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+)
+
+var (
+	testModelServerGVK            = schema.GroupVersionKind{Group: "intel.com", Version: "v1alpha1", Kind: "ModelServer"}
+	testModelRepositoryGVK        = testModelServerGVK.GroupVersion().WithKind(modelRepositoryKind)
+	testClusterModelRepositoryGVK = testModelServerGVK.GroupVersion().WithKind(clusterModelRepositoryKind)
+)
+
+func testRepository(gvk schema.GroupVersionKind, namespace, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	o := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	o.SetGroupVersionKind(gvk)
+	o.SetNamespace(namespace)
+	o.SetName(name)
+	o.SetGeneration(1)
+	return o
+}
+
+func repositoryClient(objects ...client.Object) client.WithWatch {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("PersistentVolumeClaim"), meta.RESTScopeNamespace)
+	mapper.Add(testModelRepositoryGVK, meta.RESTScopeNamespace)
+	mapper.Add(testClusterModelRepositoryGVK, meta.RESTScopeRoot)
+	var status []client.Object
+	for _, o := range objects {
+		if _, ok := o.(*unstructured.Unstructured); ok {
+			status = append(status, o)
+		}
+	}
+	return fake.NewClientBuilder().WithRESTMapper(mapper).WithObjects(objects...).WithStatusSubresource(status...).Build()
+}
+
+func TestResolveRepositoryRef(t *testing.T) {
+	cl := repositoryClient(
+		testRepository(testModelRepositoryGVK, "ns", "minio", map[string]interface{}{
+			"storage_type":           "S3",
+			"s3_compat_api_endpoint": "minio.storage:9000",
+			"aws_access_key_id_secret_ref": map[string]interface{}{
+				"name": "minio", "key": "accesskey",
+			},
+		}),
+		testRepository(testClusterModelRepositoryGVK, "", "models", map[string]interface{}{
+			"storage_type": "google",
+			"https_proxy":  "http://proxy.infra.svc:3128",
+		}),
+	)
+
+	tests := []struct {
+		name       string
+		values     map[string]interface{}
+		expected   map[string]interface{}
+		errMessage string
+	}{
+		{
+			name:     "no reference",
+			values:   map[string]interface{}{"models_repository": map[string]interface{}{"storage_type": "azure"}},
+			expected: map[string]interface{}{"storage_type": "azure"},
+		},
+		{
+			name: "namespaced",
+			values: map[string]interface{}{
+				"repository_ref":    map[string]interface{}{"name": "minio"},
+				"models_repository": map[string]interface{}{"storage_type": "google", "runAsUser": "1000"},
+			},
+			expected: map[string]interface{}{
+				"storage_type":           "S3",
+				"s3_compat_api_endpoint": "minio.storage:9000",
+				"aws_access_key_id_secret_ref": map[string]interface{}{
+					"name": "minio", "key": "accesskey",
+				},
+				"runAsUser": "1000",
+			},
+		},
+		{
+			name: "cluster-scoped",
+			values: map[string]interface{}{
+				"repository_ref": map[string]interface{}{"kind": "ClusterModelRepository", "name": "models"},
+			},
+			expected: map[string]interface{}{
+				"storage_type": "google",
+				"https_proxy":  "http://proxy.infra.svc:3128",
+			},
+		},
+		{
+			name:       "not found",
+			values:     map[string]interface{}{"repository_ref": map[string]interface{}{"name": "models"}},
+			errMessage: `ModelRepository "models" set in repository_ref not found`,
+		},
+		{
+			name:       "missing name",
+			values:     map[string]interface{}{"repository_ref": map[string]interface{}{"kind": "ModelRepository"}},
+			errMessage: "repository_ref requires a name",
+		},
+		{
+			name: "invalid kind",
+			values: map[string]interface{}{
+				"repository_ref": map[string]interface{}{"kind": "Repository", "name": "models"},
+			},
+			errMessage: `invalid repository_ref.kind "Repository", expected ModelRepository or ClusterModelRepository`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := resolveRepositoryRef(context.TODO(), cl, testModelServerGVK, "ns", test.values)
+			if test.errMessage != "" {
+				assert.EqualError(t, err, test.errMessage)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, test.values["models_repository"])
+		})
+	}
+}
+
+func TestModelRepositoryReconcile(t *testing.T) {
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer storage.Close()
+	stopped := httptest.NewServer(http.NotFoundHandler())
+	stopped.Close()
+	claim := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "models"}}
+
+	tests := []struct {
+		name      string
+		repo      *unstructured.Unstructured
+		endpoints []string
+		status    types.ConditionStatus
+		reason    types.HelmAppConditionReason
+	}{
+		{
+			name: "reachable",
+			repo: testRepository(testModelRepositoryGVK, "ns", "minio", map[string]interface{}{
+				"storage_type": "S3", "s3_compat_api_endpoint": storage.URL,
+			}),
+			endpoints: []string{storage.URL},
+			status:    types.StatusTrue,
+			reason:    types.ReasonEndpointReachable,
+		},
+		{
+			name: "unreachable",
+			repo: testRepository(testClusterModelRepositoryGVK, "", "minio", map[string]interface{}{
+				"storage_type": "S3", "s3_compat_api_endpoint": stopped.URL,
+			}),
+			endpoints: []string{stopped.URL},
+			status:    types.StatusFalse,
+			reason:    types.ReasonEndpointUnreachable,
+		},
+		{
+			name: "volume claim",
+			repo: testRepository(testModelRepositoryGVK, "ns", "pvc", map[string]interface{}{
+				"storage_type": "cluster", "models_volume_claim": "models",
+			}),
+			status: types.StatusTrue,
+			reason: types.ReasonVolumeClaimFound,
+		},
+		{
+			name: "missing volume claim",
+			repo: testRepository(testModelRepositoryGVK, "ns", "pvc", map[string]interface{}{
+				"storage_type": "cluster", "models_volume_claim": "llms",
+			}),
+			status: types.StatusFalse,
+			reason: types.ReasonVolumeClaimNotFound,
+		},
+		{
+			name: "cluster-scoped volume claim",
+			repo: testRepository(testClusterModelRepositoryGVK, "", "pvc", map[string]interface{}{
+				"storage_type": "cluster", "models_volume_claim": "models",
+			}),
+			status: types.StatusUnknown,
+			reason: types.ReasonNotChecked,
+		},
+		{
+			name: "invalid proxy",
+			repo: testRepository(testModelRepositoryGVK, "ns", "gcs", map[string]interface{}{
+				"storage_type": "google", "https_proxy": "http://proxy:port",
+			}),
+			status: types.StatusFalse,
+			reason: types.ReasonInvalidSpec,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cl := repositoryClient(test.repo, claim)
+			r := &ModelRepositoryReconciler{
+				Client:        cachedClient(cl),
+				APIReader:     cl,
+				GVK:           test.repo.GroupVersionKind(),
+				CheckEndpoint: checkEndpoint,
+				CheckPeriod:   time.Minute,
+			}
+			key := apitypes.NamespacedName{Namespace: test.repo.GetNamespace(), Name: test.repo.GetName()}
+			result, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: key})
+			assert.NoError(t, err)
+			assert.Equal(t, time.Minute, result.RequeueAfter)
+
+			o := &unstructured.Unstructured{}
+			o.SetGroupVersionKind(test.repo.GroupVersionKind())
+			assert.NoError(t, r.Client.Get(context.TODO(), key, o))
+			status := types.ModelRepositoryStatusFor(o)
+			assert.Equal(t, test.endpoints, status.Endpoints)
+			assert.Equal(t, int64(1), status.ObservedGeneration)
+			assert.NotNil(t, status.LastCheckTime)
+			c := status.GetCondition(types.ConditionReachable)
+			if assert.NotNil(t, c) {
+				assert.Equal(t, test.status, c.Status)
+				assert.Equal(t, test.reason, c.Reason, c.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,390 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openvinotoolkit/operator/pkg/modelrepo"
+)
+
+// namespaceNameLabel is the label set by the API server on every namespace
+// with its name.
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+var (
+	gcsEndpoints   = []string{"https://storage.googleapis.com", "https://oauth2.googleapis.com"}
+	azureEndpoint  = "https://blob.core.windows.net"
+	protocolTCP    = corev1.ProtocolTCP
+	protocolUDP    = corev1.ProtocolUDP
+	dnsPorts       = []int{53, 5353}
+	dnsProtocols   = []*corev1.Protocol{&protocolUDP, &protocolTCP}
+	errPeerMissing = errors.New("must set namespaceSelector, podSelector or ipBlock")
+)
+
+// renderNetworkPolicy validates the network_policy values of the ModelServer
+// and computes the ingress and egress rules of its NetworkPolicy. Ingress to
+// the gRPC and REST ports is allowed from the peers of allow_from and from
+// the operator, and egress to DNS and to the endpoints of the model
+// repository. Pods of the release can always reach each other.
+func (r HelmOperatorReconciler) renderNetworkPolicy(ctx context.Context, o *unstructured.Unstructured,
+	values, generated map[string]interface{}) error {
+
+	policy, _, _ := unstructured.NestedMap(values, "network_policy")
+	if enabled, _ := policy["enabled"].(bool); !enabled {
+		return nil
+	}
+	from, err := networkPolicyPeers(policy["allow_from"], "network_policy.allow_from")
+	if err != nil {
+		return err
+	}
+	var cidrs []networkingv1.NetworkPolicyPeer
+	list, _ := policy["repository_cidrs"].([]interface{})
+	for i, c := range list {
+		cidr, _ := c.(string)
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid network_policy.repository_cidrs[%d]: %w", i, err)
+		}
+		cidrs = append(cidrs, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+	}
+	egress, err := r.repositoryEgress(ctx, o.GetNamespace(), values, cidrs)
+	if err != nil {
+		return err
+	}
+	var extra []networkingv1.NetworkPolicyEgressRule
+	if err := decodeStrict(policy["egress"], &extra); err != nil {
+		return fmt.Errorf("invalid network_policy.egress: %w", err)
+	}
+	for i, rule := range extra {
+		if err := validatePeers(rule.To, fmt.Sprintf("network_policy.egress[%d].to", i)); err != nil {
+			return err
+		}
+	}
+	egress = append(egress, extra...)
+
+	out := map[string]interface{}{"egress": toValues(egress)}
+	if len(from) > 0 {
+		out["ingress_from"] = toValues(from)
+	}
+	if r.OperatorNamespace != "" {
+		out["operator_namespace"] = r.OperatorNamespace
+	}
+	generated["network_policy"] = out
+	return nil
+}
+
+// networkPolicyPeers decodes and validates a list of NetworkPolicy peers
+// set in the values.
+func networkPolicyPeers(in interface{}, field string) ([]networkingv1.NetworkPolicyPeer, error) {
+	var peers []networkingv1.NetworkPolicyPeer
+	if err := decodeStrict(in, &peers); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", field, err)
+	}
+	if err := validatePeers(peers, field); err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+// decodeStrict decodes values into an API object, rejecting unknown fields.
+func decodeStrict(in interface{}, out interface{}) error {
+	if in == nil {
+		return nil
+	}
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(b)))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(out)
+}
+
+func validatePeers(peers []networkingv1.NetworkPolicyPeer, field string) error {
+	for i, peer := range peers {
+		if peer.NamespaceSelector == nil && peer.PodSelector == nil && peer.IPBlock == nil {
+			return fmt.Errorf("invalid %s[%d]: %w", field, i, errPeerMissing)
+		}
+		for _, selector := range []*metav1.LabelSelector{peer.NamespaceSelector, peer.PodSelector} {
+			if _, err := metav1.LabelSelectorAsSelector(selector); err != nil {
+				return fmt.Errorf("invalid %s[%d]: %w", field, i, err)
+			}
+		}
+		if peer.IPBlock == nil {
+			continue
+		}
+		if peer.NamespaceSelector != nil || peer.PodSelector != nil {
+			return fmt.Errorf("invalid %s[%d]: ipBlock cannot be combined with selectors", field, i)
+		}
+		if _, _, err := net.ParseCIDR(peer.IPBlock.CIDR); err != nil {
+			return fmt.Errorf("invalid %s[%d].ipBlock: %w", field, i, err)
+		}
+		for _, except := range peer.IPBlock.Except {
+			if _, _, err := net.ParseCIDR(except); err != nil {
+				return fmt.Errorf("invalid %s[%d].ipBlock.except: %w", field, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// repositoryEgress returns the egress rules to DNS and to the endpoints of
+// the model repository. Endpoints served by a Service of the cluster are
+// reached through the pods it selects, and endpoints with an IP address
+// through the address. Other hosts require the CIDRs of the repository,
+// since NetworkPolicies cannot select hostnames.
+func (r HelmOperatorReconciler) repositoryEgress(ctx context.Context, namespace string,
+	values map[string]interface{}, cidrs []networkingv1.NetworkPolicyPeer) ([]networkingv1.NetworkPolicyEgressRule, error) {
+
+	var dns []networkingv1.NetworkPolicyPort
+	for _, port := range dnsPorts {
+		for _, protocol := range dnsProtocols {
+			dns = append(dns, networkingv1.NetworkPolicyPort{Protocol: protocol, Port: intPort(port)})
+		}
+	}
+	rules := []networkingv1.NetworkPolicyEgressRule{{Ports: dns}}
+
+	endpoints, err := r.repositoryEndpoints(ctx, namespace, values)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, endpoint := range endpoints {
+		rule, err := r.endpointEgress(ctx, namespace, endpoint, cidrs)
+		if err != nil {
+			return nil, err
+		}
+		key, _ := json.Marshal(rule)
+		if !seen[string(key)] {
+			seen[string(key)] = true
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// repositoryEndpoints returns the URLs reached by the model server to pull
+// the models: the proxy, if configured, or the endpoints of the cloud
+// storages of the model paths. Models in a volume need no endpoint.
+func (r HelmOperatorReconciler) repositoryEndpoints(ctx context.Context, namespace string,
+	values map[string]interface{}) ([]*url.URL, error) {
+
+	repository, _, _ := unstructured.NestedMap(values, "models_repository")
+	var raw []string
+	for _, field := range []string{"https_proxy", "http_proxy"} {
+		if proxy, _ := repository[field].(string); proxy != "" {
+			raw = []string{proxy}
+			break
+		}
+	}
+	schemes := map[string]bool{}
+	for _, path := range modelPaths(values) {
+		if location, ok := modelrepo.ParseLocation(path); ok {
+			schemes[location.Scheme] = true
+		}
+	}
+	if len(raw) == 0 {
+		return storageEndpoints(ctx, r.APIReader, namespace, repository, schemes)
+	}
+	if len(schemes) == 0 {
+		// the proxy is used only for cloud storages
+		return nil, nil
+	}
+	return parseEndpoints(raw)
+}
+
+// storageEndpoints returns the endpoints of the cloud storages of the URL
+// schemes, configured in the models_repository values. The Azure endpoint
+// is read from the connection string if it is set.
+func storageEndpoints(ctx context.Context, cl client.Reader, namespace string,
+	repository map[string]interface{}, schemes map[string]bool) ([]*url.URL, error) {
+
+	var raw []string
+	if schemes["s3"] {
+		endpoint, _ := repository["s3_compat_api_endpoint"].(string)
+		if endpoint == "" {
+			region, _ := repository["aws_region"].(string)
+			endpoint = modelrepo.S3Endpoint(region)
+		}
+		raw = append(raw, endpoint)
+	}
+	if schemes["gs"] {
+		raw = append(raw, gcsEndpoints...)
+	}
+	if schemes["az"] {
+		connectionString, err := repositoryCredential(ctx, cl, namespace, repository, "azure_storage_connection_string")
+		if err != nil {
+			return nil, err
+		}
+		endpoint := azureEndpoint
+		if connectionString != "" {
+			if endpoint, err = modelrepo.AzureBlobEndpoint(connectionString); err != nil {
+				return nil, fmt.Errorf("invalid models_repository.azure_storage_connection_string: %w", err)
+			}
+		}
+		raw = append(raw, endpoint)
+	}
+	return parseEndpoints(raw)
+}
+
+func parseEndpoints(raw []string) ([]*url.URL, error) {
+	var endpoints []*url.URL
+	for _, e := range raw {
+		if !strings.Contains(e, "://") {
+			e = "http://" + e
+		}
+		u, err := url.Parse(e)
+		if err != nil || u.Hostname() == "" {
+			return nil, fmt.Errorf("invalid model repository endpoint %q", e)
+		}
+		endpoints = append(endpoints, u)
+	}
+	return endpoints, nil
+}
+
+// endpointEgress returns the egress rule allowing the connections to the
+// endpoint.
+func (r HelmOperatorReconciler) endpointEgress(ctx context.Context, namespace string, endpoint *url.URL,
+	cidrs []networkingv1.NetworkPolicyPeer) (networkingv1.NetworkPolicyEgressRule, error) {
+
+	host := strings.TrimSuffix(endpoint.Hostname(), ".")
+	port := 80
+	if endpoint.Scheme == "https" {
+		port = 443
+	}
+	if p := endpoint.Port(); p != "" {
+		port, _ = strconv.Atoi(p)
+	}
+	rule := networkingv1.NetworkPolicyEgressRule{
+		Ports: []networkingv1.NetworkPolicyPort{{Protocol: &protocolTCP, Port: intPort(port)}},
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		bits := 32
+		if ip.To4() == nil {
+			bits = 128
+		}
+		rule.To = []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: fmt.Sprintf("%s/%d", ip, bits)}}}
+		return rule, nil
+	}
+	if name, ns, required, ok := clusterServiceHost(host, namespace); ok {
+		svc := &corev1.Service{}
+		found, err := r.getReferenced(ctx, ns, name, svc)
+		if err != nil {
+			return rule, err
+		}
+		if found {
+			return serviceEgress(svc, host, port)
+		}
+		if required {
+			return rule, fmt.Errorf("service %s/%s of the model repository endpoint %q not found", ns, name, host)
+		}
+	}
+	if len(cidrs) == 0 {
+		return rule, fmt.Errorf("network_policy.repository_cidrs is required to reach the model repository endpoint %q", host)
+	}
+	rule.To = cidrs
+	return rule, nil
+}
+
+// clusterServiceHost returns the name and namespace of the Service of the
+// host if it is the DNS name of a Service of the cluster. A host with two
+// labels may be the Service of another namespace or an external host, so it
+// is not required to be a Service.
+func clusterServiceHost(host, namespace string) (string, string, bool, bool) {
+	labels := strings.Split(host, ".")
+	switch {
+	case len(labels) == 1:
+		return labels[0], namespace, true, true
+	case len(labels) == 2:
+		return labels[0], labels[1], false, true
+	case labels[2] == "svc":
+		return labels[0], labels[1], true, true
+	}
+	return "", "", false, false
+}
+
+// serviceEgress returns the egress rule to the pods selected by the Service
+// on the target port of its port, since NetworkPolicies apply to the
+// connections after the Service address is translated.
+func serviceEgress(svc *corev1.Service, host string, port int) (networkingv1.NetworkPolicyEgressRule, error) {
+	rule := networkingv1.NetworkPolicyEgressRule{}
+	if len(svc.Spec.Selector) == 0 {
+		return rule, fmt.Errorf("service %s/%s of the model repository endpoint %q has no selector, "+
+			"set network_policy.repository_cidrs to the addresses of its endpoints", svc.Namespace, svc.Name, host)
+	}
+	for _, p := range svc.Spec.Ports {
+		if int(p.Port) != port {
+			continue
+		}
+		target := p.TargetPort
+		if target.Type == intstr.Int && target.IntVal == 0 {
+			target = *intPort(port)
+		}
+		protocol := p.Protocol
+		if protocol == "" {
+			protocol = corev1.ProtocolTCP
+		}
+		selector := map[string]string{}
+		for k, v := range svc.Spec.Selector {
+			selector[k] = v
+		}
+		rule.Ports = []networkingv1.NetworkPolicyPort{{Protocol: &protocol, Port: &target}}
+		rule.To = []networkingv1.NetworkPolicyPeer{{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: svc.Namespace}},
+			PodSelector:       &metav1.LabelSelector{MatchLabels: selector},
+		}}
+		return rule, nil
+	}
+	return rule, fmt.Errorf("service %s/%s of the model repository endpoint %q does not expose the port %d",
+		svc.Namespace, svc.Name, host, port)
+}
+
+func intPort(port int) *intstr.IntOrString {
+	p := intstr.FromInt32(int32(port))
+	return &p
+}
+
+// toValues converts a list of API objects to chart values.
+func toValues(in interface{}) []interface{} {
+	b, _ := json.Marshal(in)
+	var out []interface{}
+	_ = json.Unmarshal(b, &out)
+	return out
+}
+
+// toValue converts an API object to chart values.
+func toValue(in interface{}) map[string]interface{} {
+	b, _ := json.Marshal(in)
+	out := map[string]interface{}{}
+	_ = json.Unmarshal(b, &out)
+	return out
+}
@@ -0,0 +1,287 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestRenderNetworkPolicy(t *testing.T) {
+	minio := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "minio"},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": "minio"},
+			Ports:    []corev1.ServicePort{{Name: "api", Port: 9000, TargetPort: intstr.FromString("api")}},
+		},
+	}
+	external := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "storage", Name: "external"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 80}}},
+	}
+	dns := map[string]interface{}{"ports": []interface{}{
+		map[string]interface{}{"protocol": "UDP", "port": float64(53)},
+		map[string]interface{}{"protocol": "TCP", "port": float64(53)},
+		map[string]interface{}{"protocol": "UDP", "port": float64(5353)},
+		map[string]interface{}{"protocol": "TCP", "port": float64(5353)},
+	}}
+	tcp := func(port interface{}) []interface{} {
+		return []interface{}{map[string]interface{}{"protocol": "TCP", "port": port}}
+	}
+
+	tests := []struct {
+		name       string
+		values     map[string]interface{}
+		expected   map[string]interface{}
+		errMessage string
+	}{
+		{
+			name:   "disabled",
+			values: map[string]interface{}{"network_policy": map[string]interface{}{"enabled": false}},
+		},
+		{
+			name: "volume",
+			values: map[string]interface{}{
+				"models_settings": map[string]interface{}{"model_path": "/models/resnet"},
+				"network_policy": map[string]interface{}{
+					"enabled": true,
+					"allow_from": []interface{}{
+						map[string]interface{}{"namespaceSelector": map[string]interface{}{
+							"matchLabels": map[string]interface{}{"team": "a"},
+						}},
+					},
+				},
+			},
+			expected: map[string]interface{}{
+				"operator_namespace": "openvino-operator",
+				"ingress_from": []interface{}{
+					map[string]interface{}{"namespaceSelector": map[string]interface{}{
+						"matchLabels": map[string]interface{}{"team": "a"},
+					}},
+				},
+				"egress": []interface{}{dns},
+			},
+		},
+		{
+			name: "service endpoint",
+			values: map[string]interface{}{
+				"models_settings":   map[string]interface{}{"model_path": "s3://models/resnet"},
+				"models_repository": map[string]interface{}{"s3_compat_api_endpoint": "minio:9000"},
+				"network_policy": map[string]interface{}{
+					"enabled": true,
+					"egress": []interface{}{
+						map[string]interface{}{"to": []interface{}{
+							map[string]interface{}{"ipBlock": map[string]interface{}{"cidr": "10.0.0.0/8"}},
+						}},
+					},
+				},
+			},
+			expected: map[string]interface{}{
+				"operator_namespace": "openvino-operator",
+				"egress": []interface{}{
+					dns,
+					map[string]interface{}{
+						"ports": tcp("api"),
+						"to": []interface{}{map[string]interface{}{
+							"namespaceSelector": map[string]interface{}{
+								"matchLabels": map[string]interface{}{namespaceNameLabel: "ns"},
+							},
+							"podSelector": map[string]interface{}{
+								"matchLabels": map[string]interface{}{"app": "minio"},
+							},
+						}},
+					},
+					map[string]interface{}{"to": []interface{}{
+						map[string]interface{}{"ipBlock": map[string]interface{}{"cidr": "10.0.0.0/8"}},
+					}},
+				},
+			},
+		},
+		{
+			name: "ip endpoint",
+			values: map[string]interface{}{
+				"models_settings":   map[string]interface{}{"model_path": "s3://models/resnet"},
+				"models_repository": map[string]interface{}{"s3_compat_api_endpoint": "https://10.1.2.3"},
+				"network_policy":    map[string]interface{}{"enabled": true},
+			},
+			expected: map[string]interface{}{
+				"operator_namespace": "openvino-operator",
+				"egress": []interface{}{
+					dns,
+					map[string]interface{}{
+						"ports": tcp(float64(443)),
+						"to": []interface{}{
+							map[string]interface{}{"ipBlock": map[string]interface{}{"cidr": "10.1.2.3/32"}},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "external endpoints",
+			values: map[string]interface{}{
+				"models_settings": map[string]interface{}{
+					"single_model_mode": false,
+					"models": []interface{}{
+						map[string]interface{}{"name": "a", "base_path": "gs://models/a"},
+						map[string]interface{}{"name": "b", "base_path": "s3://models/b"},
+					},
+				},
+				"network_policy": map[string]interface{}{
+					"enabled":          true,
+					"repository_cidrs": []interface{}{"52.216.0.0/15"},
+				},
+			},
+			expected: map[string]interface{}{
+				"operator_namespace": "openvino-operator",
+				"egress": []interface{}{
+					dns,
+					map[string]interface{}{
+						"ports": tcp(float64(443)),
+						"to": []interface{}{
+							map[string]interface{}{"ipBlock": map[string]interface{}{"cidr": "52.216.0.0/15"}},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "external endpoint without cidrs",
+			values: map[string]interface{}{
+				"models_settings": map[string]interface{}{"model_path": "s3://models/resnet"},
+				"network_policy":  map[string]interface{}{"enabled": true},
+			},
+			errMessage: `network_policy.repository_cidrs is required to reach the model repository endpoint "s3.us-east-1.amazonaws.com"`,
+		},
+		{
+			name: "missing proxy service",
+			values: map[string]interface{}{
+				"models_settings":   map[string]interface{}{"model_path": "az://models/resnet"},
+				"models_repository": map[string]interface{}{"https_proxy": "http://proxy.infra.svc:3128"},
+				"network_policy":    map[string]interface{}{"enabled": true},
+			},
+			errMessage: `service infra/proxy of the model repository endpoint "proxy.infra.svc" not found`,
+		},
+		{
+			name: "service without selector",
+			values: map[string]interface{}{
+				"models_settings":   map[string]interface{}{"model_path": "s3://models/resnet"},
+				"models_repository": map[string]interface{}{"s3_compat_api_endpoint": "external.storage"},
+				"network_policy":    map[string]interface{}{"enabled": true},
+			},
+			errMessage: `service storage/external of the model repository endpoint "external.storage" has no selector, ` +
+				"set network_policy.repository_cidrs to the addresses of its endpoints",
+		},
+		{
+			name: "empty peer",
+			values: map[string]interface{}{
+				"network_policy": map[string]interface{}{
+					"enabled":    true,
+					"allow_from": []interface{}{map[string]interface{}{}},
+				},
+			},
+			errMessage: "invalid network_policy.allow_from[0]: must set namespaceSelector, podSelector or ipBlock",
+		},
+		{
+			name: "unknown peer field",
+			values: map[string]interface{}{
+				"network_policy": map[string]interface{}{
+					"enabled":    true,
+					"allow_from": []interface{}{map[string]interface{}{"namespace": "a"}},
+				},
+			},
+			errMessage: `invalid network_policy.allow_from: json: unknown field "namespace"`,
+		},
+		{
+			name: "invalid selector",
+			values: map[string]interface{}{
+				"network_policy": map[string]interface{}{
+					"enabled": true,
+					"allow_from": []interface{}{map[string]interface{}{"podSelector": map[string]interface{}{
+						"matchExpressions": []interface{}{
+							map[string]interface{}{"key": "app", "operator": "Exists", "values": []interface{}{"a"}},
+						},
+					}}},
+				},
+			},
+			errMessage: "invalid network_policy.allow_from[0]: values: Invalid value: []string{\"a\"}: " +
+				"values set must be empty for exists and does not exist",
+		},
+		{
+			name: "invalid cidr",
+			values: map[string]interface{}{
+				"network_policy": map[string]interface{}{
+					"enabled":          true,
+					"repository_cidrs": []interface{}{"10.0.0.0"},
+				},
+			},
+			errMessage: "invalid network_policy.repository_cidrs[0]: invalid CIDR address: 10.0.0.0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := exposureReconciler([]client.Object{minio, external}, corev1.SchemeGroupVersion.WithKind("Service"))
+			r.OperatorNamespace = "openvino-operator"
+			o := &unstructured.Unstructured{}
+			o.SetNamespace("ns")
+			o.SetName("ovms")
+			generated := map[string]interface{}{}
+			err := r.renderNetworkPolicy(context.TODO(), o, test.values, generated)
+			if test.errMessage != "" {
+				assert.EqualError(t, err, test.errMessage)
+				return
+			}
+			assert.NoError(t, err)
+			if test.expected == nil {
+				assert.NotContains(t, generated, "network_policy")
+				return
+			}
+			assert.Equal(t, test.expected, generated["network_policy"])
+		})
+	}
+}
+
+func TestClusterServiceHost(t *testing.T) {
+	tests := []struct {
+		host      string
+		name      string
+		namespace string
+		required  bool
+		ok        bool
+	}{
+		{host: "minio", name: "minio", namespace: "ns", required: true, ok: true},
+		{host: "minio.storage", name: "minio", namespace: "storage", ok: true},
+		{host: "minio.storage.svc", name: "minio", namespace: "storage", required: true, ok: true},
+		{host: "minio.storage.svc.cluster.local", name: "minio", namespace: "storage", required: true, ok: true},
+		{host: "s3.us-east-1.amazonaws.com"},
+	}
+	for _, test := range tests {
+		name, namespace, required, ok := clusterServiceHost(test.host, "ns")
+		assert.Equal(t, test.name, name, test.host)
+		assert.Equal(t, test.namespace, namespace, test.host)
+		assert.Equal(t, test.required, required, test.host)
+		assert.Equal(t, test.ok, ok, test.host)
+	}
+}
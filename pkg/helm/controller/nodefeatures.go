@@ -0,0 +1,364 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/openvinotoolkit/operator/pkg/ovms"
+)
+
+// checkNodeFeaturesAnnotation disables the check that a node of the cluster
+// matches the node affinity of a ModelServer when set to false, for example
+// when the cluster autoscaler adds the nodes on demand.
+const checkNodeFeaturesAnnotation = "intel.com/check-node-features"
+
+// DefaultNodeFeatureLabels maps the target devices and the CPU features of
+// the ModelServer models to the node labels set by Node Feature Discovery
+// and the Intel device plugins rules. A value is a label key, required to
+// exist, or a key=value pair. The mapping can be overridden per watch.
+var DefaultNodeFeatureLabels = map[string]string{
+	"GPU":         "intel.feature.node.kubernetes.io/gpu=true",
+	"NPU":         "intel.feature.node.kubernetes.io/npu=true",
+	"avx2":        "feature.node.kubernetes.io/cpu-cpuid.AVX2=true",
+	"avx512":      "feature.node.kubernetes.io/cpu-cpuid.AVX512F=true",
+	"avx512_vnni": "feature.node.kubernetes.io/cpu-cpuid.AVX512VNNI=true",
+	"avx512_bf16": "feature.node.kubernetes.io/cpu-cpuid.AVX512BF16=true",
+	"amx":         "feature.node.kubernetes.io/cpu-cpuid.AMXTILE=true",
+	"amx_bf16":    "feature.node.kubernetes.io/cpu-cpuid.AMXBF16=true",
+	"amx_int8":    "feature.node.kubernetes.io/cpu-cpuid.AMXINT8=true",
+}
+
+// nodeFeatureLabels returns the default label mapping with the overrides of
+// the watch. An empty override removes the constraint of the key.
+func (r HelmOperatorReconciler) nodeFeatureLabels() map[string]string {
+	labels := map[string]string{}
+	for k, v := range DefaultNodeFeatureLabels {
+		labels[k] = v
+	}
+	for k, v := range r.NodeFeatureLabels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// requiredDevices returns the devices needed on the node to run the target
+// device of a model, as groups of which one device is needed. CPU is
+// available on every node. AUTO needs any of its devices, while MULTI and
+// HETERO need all of them.
+func requiredDevices(target string) [][]string {
+	target = strings.ToUpper(strings.TrimSpace(target))
+	mode, list, found := strings.Cut(target, ":")
+	if !found {
+		mode, list = "", target
+	}
+	var devices []string
+	for _, d := range strings.Split(list, ",") {
+		d = strings.TrimSpace(d)
+		if d == "" || strings.HasPrefix(d, "-") {
+			// devices excluded from AUTO
+			continue
+		}
+		// device index, as in GPU.1
+		d, _, _ = strings.Cut(d, ".")
+		devices = append(devices, d)
+	}
+	switch mode {
+	case "AUTO":
+		for _, d := range devices {
+			if d == "CPU" {
+				return nil
+			}
+		}
+		if len(devices) == 0 {
+			return nil
+		}
+		return [][]string{devices}
+	case "":
+		if len(devices) == 1 && devices[0] == "AUTO" {
+			return nil
+		}
+	}
+	var groups [][]string
+	for _, d := range devices {
+		if d != "CPU" {
+			groups = append(groups, []string{d})
+		}
+	}
+	return groups
+}
+
+// modelDevices returns the target devices and the CPU features of the
+// models of the ModelServer values.
+func modelDevices(values map[string]interface{}) ([]string, []string) {
+	settings, _, _ := unstructured.NestedMap(values, "models_settings")
+	var devices []string
+	if single, found := settings["single_model_mode"].(bool); found && !single {
+		config, err := ovms.ConfigFromValues(values)
+		if err == nil && config != nil {
+			for _, entry := range config.ModelConfigList {
+				devices = append(devices, entry.Config.TargetDevice)
+			}
+			for _, l := range config.LLMs {
+				devices = append(devices, l.TargetDevice)
+			}
+		}
+	} else if device, _ := settings["target_device"].(string); device != "" {
+		devices = append(devices, device)
+	}
+	features, _, _ := unstructured.NestedStringSlice(settings, "cpu_features")
+	return devices, features
+}
+
+// nodeRequirement returns the node selector requirement of a mapped label.
+func nodeRequirement(label string) corev1.NodeSelectorRequirement {
+	if key, value, found := strings.Cut(label, "="); found {
+		return corev1.NodeSelectorRequirement{Key: key, Operator: corev1.NodeSelectorOpIn, Values: []string{value}}
+	}
+	return corev1.NodeSelectorRequirement{Key: label, Operator: corev1.NodeSelectorOpExists}
+}
+
+// nodeSelectorTermsFor returns the node selector terms, one of which a node
+// must match to run the models of the values, or nil if any node can run
+// them.
+func (r HelmOperatorReconciler) nodeSelectorTermsFor(values map[string]interface{}) ([]corev1.NodeSelectorTerm, error) {
+	labels := r.nodeFeatureLabels()
+	devices, features := modelDevices(values)
+
+	lookup := func(key, field string) (*corev1.NodeSelectorRequirement, error) {
+		label, found := labels[key]
+		if !found {
+			return nil, fmt.Errorf("%s %q has no node label, set it in the nodeFeatureLabels of the watch", field, key)
+		}
+		if label == "" {
+			return nil, nil
+		}
+		requirement := nodeRequirement(label)
+		return &requirement, nil
+	}
+
+	required := map[string]corev1.NodeSelectorRequirement{}
+	var alternatives [][]corev1.NodeSelectorRequirement
+	for _, device := range devices {
+		for _, group := range requiredDevices(device) {
+			var anyOf []corev1.NodeSelectorRequirement
+			for _, d := range group {
+				requirement, err := lookup(d, "target_device")
+				if err != nil {
+					return nil, err
+				}
+				if requirement == nil {
+					// a device without constraint satisfies the group
+					anyOf = nil
+					break
+				}
+				anyOf = append(anyOf, *requirement)
+			}
+			switch len(anyOf) {
+			case 0:
+			case 1:
+				required[anyOf[0].Key+"="+strings.Join(anyOf[0].Values, ",")] = anyOf[0]
+			default:
+				alternatives = append(alternatives, anyOf)
+			}
+		}
+	}
+	for _, feature := range features {
+		requirement, err := lookup(strings.ToLower(feature), "cpu_features")
+		if err != nil {
+			return nil, err
+		}
+		if requirement != nil {
+			required[requirement.Key+"="+strings.Join(requirement.Values, ",")] = *requirement
+		}
+	}
+	if len(required) == 0 && len(alternatives) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, len(required))
+	for k := range required {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var base []corev1.NodeSelectorRequirement
+	for _, k := range keys {
+		base = append(base, required[k])
+	}
+	// a term for each combination of the alternative devices
+	terms := [][]corev1.NodeSelectorRequirement{base}
+	for _, group := range alternatives {
+		var next [][]corev1.NodeSelectorRequirement
+		for _, term := range terms {
+			for _, requirement := range group {
+				expressions := append(append([]corev1.NodeSelectorRequirement{}, term...), requirement)
+				next = append(next, expressions)
+			}
+		}
+		terms = next
+	}
+	out := make([]corev1.NodeSelectorTerm, 0, len(terms))
+	for _, expressions := range terms {
+		out = append(out, corev1.NodeSelectorTerm{MatchExpressions: expressions})
+	}
+	return out, nil
+}
+
+// renderNodeAffinity adds the node selector terms of the target devices and
+// CPU features of the models to the node affinity of the model server pods,
+// combined with the required terms of deployment_parameters.node_affinity.
+// Unless disabled with an annotation, it checks that a node of the cluster
+// matches the affinity.
+func (r HelmOperatorReconciler) renderNodeAffinity(ctx context.Context, o *unstructured.Unstructured,
+	values, generated map[string]interface{}) error {
+
+	terms, err := r.nodeSelectorTermsFor(values)
+	if err != nil || terms == nil {
+		return err
+	}
+	affinity := &corev1.NodeAffinity{}
+	if custom, found, _ := unstructured.NestedFieldNoCopy(values, "deployment_parameters", "node_affinity"); found && custom != nil {
+		if err := decodeStrict(custom, affinity); err != nil {
+			return fmt.Errorf("invalid deployment_parameters.node_affinity: %w", err)
+		}
+	}
+	if selector := affinity.RequiredDuringSchedulingIgnoredDuringExecution; selector != nil && len(selector.NodeSelectorTerms) > 0 {
+		var combined []corev1.NodeSelectorTerm
+		for _, custom := range selector.NodeSelectorTerms {
+			for _, term := range terms {
+				combined = append(combined, corev1.NodeSelectorTerm{
+					MatchExpressions: append(append([]corev1.NodeSelectorRequirement{}, custom.MatchExpressions...),
+						term.MatchExpressions...),
+					MatchFields: custom.MatchFields,
+				})
+			}
+		}
+		terms = combined
+	}
+	affinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{NodeSelectorTerms: terms}
+
+	if annotationBool(checkNodeFeaturesAnnotation, o, true) {
+		// the nodes are not cached, they are listed from the API server
+		nodes := &corev1.NodeList{}
+		if err := r.APIReader.List(ctx, nodes); err != nil {
+			return fmt.Errorf("failed to list the nodes: %w", err)
+		}
+		if !anyNodeMatches(nodes.Items, terms) {
+			return errors.New("no node of the cluster matches the target devices and CPU features of the models: " +
+				describeTerms(terms))
+		}
+	}
+	generated["node_affinity"] = toValue(affinity)
+	return nil
+}
+
+func anyNodeMatches(nodes []corev1.Node, terms []corev1.NodeSelectorTerm) bool {
+	for i := range nodes {
+		for _, term := range terms {
+			if nodeMatchesTerm(&nodes[i], term) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nodeMatchesTerm evaluates a node selector term like the scheduler does.
+func nodeMatchesTerm(node *corev1.Node, term corev1.NodeSelectorTerm) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+	for _, r := range term.MatchExpressions {
+		if !requirementMatches(r, node.Labels) {
+			return false
+		}
+	}
+	for _, r := range term.MatchFields {
+		if r.Key != "metadata.name" || !requirementMatches(r, map[string]string{r.Key: node.Name}) {
+			return false
+		}
+	}
+	return true
+}
+
+func requirementMatches(r corev1.NodeSelectorRequirement, labels map[string]string) bool {
+	value, found := labels[r.Key]
+	contains := func() bool {
+		for _, v := range r.Values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+	compare := func(less bool) bool {
+		if !found || len(r.Values) != 1 {
+			return false
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		bound, err2 := strconv.ParseInt(r.Values[0], 10, 64)
+		if err != nil || err2 != nil {
+			return false
+		}
+		if less {
+			return n < bound
+		}
+		return n > bound
+	}
+	switch r.Operator {
+	case corev1.NodeSelectorOpIn:
+		return found && contains()
+	case corev1.NodeSelectorOpNotIn:
+		return !found || !contains()
+	case corev1.NodeSelectorOpExists:
+		return found
+	case corev1.NodeSelectorOpDoesNotExist:
+		return !found
+	case corev1.NodeSelectorOpGt:
+		return compare(false)
+	case corev1.NodeSelectorOpLt:
+		return compare(true)
+	}
+	return false
+}
+
+func describeTerms(terms []corev1.NodeSelectorTerm) string {
+	var out []string
+	for _, term := range terms {
+		var expressions []string
+		for _, r := range term.MatchExpressions {
+			switch r.Operator {
+			case corev1.NodeSelectorOpIn:
+				expressions = append(expressions, fmt.Sprintf("%s in (%s)", r.Key, strings.Join(r.Values, ",")))
+			case corev1.NodeSelectorOpExists:
+				expressions = append(expressions, r.Key)
+			default:
+				expressions = append(expressions, fmt.Sprintf("%s %s (%s)", r.Key, r.Operator, strings.Join(r.Values, ",")))
+			}
+		}
+		out = append(out, strings.Join(expressions, ", "))
+	}
+	return strings.Join(out, " or ")
+}
@@ -0,0 +1,193 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRequiredDevices(t *testing.T) {
+	tests := []struct {
+		target   string
+		expected [][]string
+	}{
+		{target: ""},
+		{target: "CPU"},
+		{target: "AUTO"},
+		{target: "GPU", expected: [][]string{{"GPU"}}},
+		{target: "gpu.1", expected: [][]string{{"GPU"}}},
+		{target: "MULTI:GPU.0,GPU.1,CPU", expected: [][]string{{"GPU"}, {"GPU"}}},
+		{target: "HETERO:NPU,GPU", expected: [][]string{{"NPU"}, {"GPU"}}},
+		{target: "AUTO:GPU,CPU"},
+		{target: "AUTO:GPU,NPU", expected: [][]string{{"GPU", "NPU"}}},
+		{target: "AUTO:GPU,-CPU", expected: [][]string{{"GPU"}}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, requiredDevices(test.target), test.target)
+	}
+}
+
+func TestRenderNodeAffinity(t *testing.T) {
+	node := func(name string, labels map[string]string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	gpuNode := node("gpu", map[string]string{
+		"intel.feature.node.kubernetes.io/gpu":      "true",
+		"feature.node.kubernetes.io/cpu-cpuid.AVX2": "true",
+		"topology.kubernetes.io/zone":               "a",
+	})
+	amxNode := node("amx", map[string]string{
+		"feature.node.kubernetes.io/cpu-cpuid.AMXTILE": "true",
+		"topology.kubernetes.io/zone":                  "b",
+	})
+	gpu := map[string]interface{}{
+		"key": "intel.feature.node.kubernetes.io/gpu", "operator": "In", "values": []interface{}{"true"},
+	}
+	required := func(terms ...[]interface{}) map[string]interface{} {
+		var out []interface{}
+		for _, expressions := range terms {
+			out = append(out, map[string]interface{}{"matchExpressions": expressions})
+		}
+		return map[string]interface{}{
+			"requiredDuringSchedulingIgnoredDuringExecution": map[string]interface{}{"nodeSelectorTerms": out},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		values      map[string]interface{}
+		labels      map[string]string
+		annotations map[string]string
+		expected    map[string]interface{}
+		errMessage  string
+	}{
+		{
+			name:   "cpu",
+			values: map[string]interface{}{"models_settings": map[string]interface{}{"target_device": "CPU"}},
+		},
+		{
+			name:     "gpu",
+			values:   map[string]interface{}{"models_settings": map[string]interface{}{"target_device": "GPU"}},
+			expected: required([]interface{}{gpu}),
+		},
+		{
+			name: "models with custom affinity",
+			values: map[string]interface{}{
+				"models_settings": map[string]interface{}{
+					"single_model_mode": false,
+					"models": []interface{}{
+						map[string]interface{}{"name": "a", "base_path": "gs://models/a", "target_device": "CPU"},
+						map[string]interface{}{"name": "b", "base_path": "gs://models/b", "target_device": "AUTO:GPU,NPU"},
+					},
+				},
+				"deployment_parameters": map[string]interface{}{
+					"node_affinity": map[string]interface{}{
+						"requiredDuringSchedulingIgnoredDuringExecution": map[string]interface{}{
+							"nodeSelectorTerms": []interface{}{
+								map[string]interface{}{"matchExpressions": []interface{}{
+									map[string]interface{}{"key": "topology.kubernetes.io/zone", "operator": "In", "values": []interface{}{"a"}},
+								}},
+							},
+						},
+					},
+				},
+			},
+			expected: required(
+				[]interface{}{
+					map[string]interface{}{"key": "topology.kubernetes.io/zone", "operator": "In", "values": []interface{}{"a"}},
+					gpu,
+				},
+				[]interface{}{
+					map[string]interface{}{"key": "topology.kubernetes.io/zone", "operator": "In", "values": []interface{}{"a"}},
+					map[string]interface{}{
+						"key": "intel.feature.node.kubernetes.io/npu", "operator": "In", "values": []interface{}{"true"},
+					},
+				},
+			),
+		},
+		{
+			name: "cpu features with custom labels",
+			values: map[string]interface{}{"models_settings": map[string]interface{}{
+				"target_device": "GPU",
+				"cpu_features":  []interface{}{"AMX"},
+			}},
+			labels: map[string]string{"GPU": ""},
+			expected: required([]interface{}{
+				map[string]interface{}{
+					"key": "feature.node.kubernetes.io/cpu-cpuid.AMXTILE", "operator": "In", "values": []interface{}{"true"},
+				},
+			}),
+		},
+		{
+			name: "no matching node",
+			values: map[string]interface{}{"models_settings": map[string]interface{}{
+				"target_device": "GPU",
+				"cpu_features":  []interface{}{"amx"},
+			}},
+			errMessage: "no node of the cluster matches the target devices and CPU features of the models: " +
+				"feature.node.kubernetes.io/cpu-cpuid.AMXTILE in (true), intel.feature.node.kubernetes.io/gpu in (true)",
+		},
+		{
+			name: "check disabled",
+			values: map[string]interface{}{"models_settings": map[string]interface{}{
+				"target_device": "NPU",
+			}},
+			labels:      map[string]string{"NPU": "npu.intel.com/present"},
+			annotations: map[string]string{checkNodeFeaturesAnnotation: "false"},
+			expected: required([]interface{}{
+				map[string]interface{}{"key": "npu.intel.com/present", "operator": "Exists"},
+			}),
+		},
+		{
+			name:       "unknown device",
+			values:     map[string]interface{}{"models_settings": map[string]interface{}{"target_device": "HDDL"}},
+			errMessage: `target_device "HDDL" has no node label, set it in the nodeFeatureLabels of the watch`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cl := fake.NewClientBuilder().WithObjects(gpuNode, amxNode).Build()
+			r := HelmOperatorReconciler{
+				Client:            cachedClient(cl),
+				APIReader:         cl,
+				NodeFeatureLabels: test.labels,
+			}
+			o := &unstructured.Unstructured{}
+			o.SetAnnotations(test.annotations)
+			generated := map[string]interface{}{}
+			err := r.renderNodeAffinity(context.TODO(), o, test.values, generated)
+			if test.errMessage != "" {
+				assert.EqualError(t, err, test.errMessage)
+				return
+			}
+			assert.NoError(t, err)
+			if test.expected == nil {
+				assert.NotContains(t, generated, "node_affinity")
+				return
+			}
+			assert.Equal(t, test.expected, generated["node_affinity"])
+		})
+	}
+}
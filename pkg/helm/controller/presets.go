@@ -0,0 +1,197 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+)
+
+// performanceHintKey is the OpenVINO plugin config key set by the resource
+// presets.
+const performanceHintKey = "PERFORMANCE_HINT"
+
+// resourcePreset sizes the model server pods and tunes the inference
+// settings for a kind of workload. The CPU and memory are both requested and
+// limited, so the pods get the Guaranteed QoS class and dedicated cores with
+// the static CPU manager policy.
+type resourcePreset struct {
+	cpu             string
+	memory          string
+	nireq           int64
+	grpcWorkers     int64
+	performanceHint string
+}
+
+// resourcePresets are the presets selected with the resource_preset value.
+var resourcePresets = map[string]resourcePreset{
+	"small":      {cpu: "1", memory: "2Gi", nireq: 2, grpcWorkers: 1, performanceHint: "LATENCY"},
+	"medium":     {cpu: "4", memory: "8Gi", nireq: 4, grpcWorkers: 1, performanceHint: "LATENCY"},
+	"large":      {cpu: "8", memory: "16Gi", nireq: 8, grpcWorkers: 2, performanceHint: "THROUGHPUT"},
+	"throughput": {cpu: "16", memory: "32Gi", nireq: 32, grpcWorkers: 4, performanceHint: "THROUGHPUT"},
+	"latency":    {cpu: "8", memory: "16Gi", nireq: 2, grpcWorkers: 1, performanceHint: "LATENCY"},
+}
+
+// applyResourcePreset sets the values of the preset selected in the
+// ModelServer values which are not set in the custom resource, and records
+// the effective values in the status. The CPU and memory of the preset are
+// skipped if the custom resource sets their request or limit, and the
+// performance hint is added to the plugin config of the models which do not
+// set one.
+func applyResourcePreset(status *types.HelmAppStatus, values map[string]interface{}) error {
+	name, _ := values["resource_preset"].(string)
+	if name == "" {
+		status.Resources = nil
+		return nil
+	}
+	preset, ok := resourcePresets[name]
+	if !ok {
+		names := make([]string, 0, len(resourcePresets))
+		for n := range resourcePresets {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("invalid resource_preset %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+
+	deployment := copyValues(values["deployment_parameters"])
+	resources := copyValues(deployment["resources"])
+	requests := copyValues(resources["requests"])
+	limits := copyValues(resources["limits"])
+	for _, r := range []struct{ name, quantity string }{{"cpu", preset.cpu}, {"memory", preset.memory}} {
+		if isSetValue(requests[r.name]) || isSetValue(limits[r.name]) {
+			continue
+		}
+		requests[r.name] = r.quantity
+		limits[r.name] = r.quantity
+	}
+	resources["requests"] = requests
+	resources["limits"] = limits
+	deployment["resources"] = resources
+	values["deployment_parameters"] = deployment
+
+	settings := copyValues(values["models_settings"])
+	if _, found := settings["nireq"]; !found {
+		settings["nireq"] = preset.nireq
+	}
+	pluginConfig, err := pluginConfigWithHint(settings["plugin_config"], preset.performanceHint)
+	if err != nil {
+		return err
+	}
+	settings["plugin_config"] = pluginConfig
+	if models, ok := settings["models"].([]interface{}); ok {
+		withPreset := make([]interface{}, 0, len(models))
+		for _, m := range models {
+			model, ok := m.(map[string]interface{})
+			if !ok {
+				withPreset = append(withPreset, m)
+				continue
+			}
+			model = copyValues(model)
+			if _, found := model["nireq"]; !found {
+				model["nireq"] = preset.nireq
+			}
+			config := copyValues(model["plugin_config"])
+			if _, found := config[performanceHintKey]; !found {
+				config[performanceHintKey] = preset.performanceHint
+			}
+			model["plugin_config"] = config
+			withPreset = append(withPreset, model)
+		}
+		settings["models"] = withPreset
+	}
+	values["models_settings"] = settings
+
+	server := copyValues(values["server_settings"])
+	if _, found := server["grpc_workers"]; !found {
+		server["grpc_workers"] = preset.grpcWorkers
+	}
+	values["server_settings"] = server
+
+	status.Resources = &types.ResourcesStatus{
+		Preset:          name,
+		Requests:        quantities(requests),
+		Limits:          quantities(limits),
+		Nireq:           int64Value(settings["nireq"]),
+		GRPCWorkers:     int64Value(server["grpc_workers"]),
+		PerformanceHint: performanceHint(pluginConfig),
+	}
+	return nil
+}
+
+// pluginConfigWithHint adds the performance hint to the JSON plugin config
+// of a single model, unless it sets one.
+func pluginConfigWithHint(v interface{}, hint string) (string, error) {
+	config := map[string]interface{}{}
+	if s, _ := v.(string); strings.TrimSpace(s) != "" {
+		if err := json.Unmarshal([]byte(s), &config); err != nil {
+			return "", fmt.Errorf("models_settings.plugin_config must be a JSON object: %w", err)
+		}
+	}
+	if _, found := config[performanceHintKey]; !found {
+		config[performanceHintKey] = hint
+	}
+	b, err := json.Marshal(config)
+	return string(b), err
+}
+
+func performanceHint(pluginConfig string) string {
+	config := map[string]interface{}{}
+	_ = json.Unmarshal([]byte(pluginConfig), &config)
+	hint, _ := config[performanceHintKey].(string)
+	return hint
+}
+
+// copyValues returns a shallow copy of a map of the values, or an empty map
+// if it is not set.
+func copyValues(v interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	if m, ok := v.(map[string]interface{}); ok {
+		for k, v := range m {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func isSetValue(v interface{}) bool {
+	return v != nil && fmt.Sprint(v) != ""
+}
+
+func quantities(values map[string]interface{}) map[string]string {
+	out := map[string]string{}
+	for _, name := range []string{"cpu", "memory"} {
+		if isSetValue(values[name]) {
+			out[name] = fmt.Sprint(values[name])
+		}
+	}
+	return out
+}
+
+func int64Value(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
@@ -0,0 +1,190 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+)
+
+func TestApplyResourcePreset(t *testing.T) {
+	tests := []struct {
+		name       string
+		values     map[string]interface{}
+		expected   map[string]interface{}
+		status     *types.ResourcesStatus
+		errMessage string
+	}{
+		{
+			name:     "no preset",
+			values:   map[string]interface{}{"models_settings": map[string]interface{}{"nireq": int64(4)}},
+			expected: map[string]interface{}{"models_settings": map[string]interface{}{"nireq": int64(4)}},
+		},
+		{
+			name:   "preset",
+			values: map[string]interface{}{"resource_preset": "throughput"},
+			expected: map[string]interface{}{
+				"resource_preset": "throughput",
+				"deployment_parameters": map[string]interface{}{"resources": map[string]interface{}{
+					"requests": map[string]interface{}{"cpu": "16", "memory": "32Gi"},
+					"limits":   map[string]interface{}{"cpu": "16", "memory": "32Gi"},
+				}},
+				"models_settings": map[string]interface{}{
+					"nireq":         int64(32),
+					"plugin_config": `{"PERFORMANCE_HINT":"THROUGHPUT"}`,
+				},
+				"server_settings": map[string]interface{}{"grpc_workers": int64(4)},
+			},
+			status: &types.ResourcesStatus{
+				Preset:          "throughput",
+				Requests:        map[string]string{"cpu": "16", "memory": "32Gi"},
+				Limits:          map[string]string{"cpu": "16", "memory": "32Gi"},
+				Nireq:           32,
+				GRPCWorkers:     4,
+				PerformanceHint: "THROUGHPUT",
+			},
+		},
+		{
+			name: "overridden fields",
+			values: map[string]interface{}{
+				"resource_preset": "small",
+				"deployment_parameters": map[string]interface{}{
+					"replicas": int64(2),
+					"resources": map[string]interface{}{
+						"limits": map[string]interface{}{"memory": "6Gi", "xpu_device": "gpu.intel.com/i915"},
+					},
+				},
+				"models_settings": map[string]interface{}{
+					"nireq":         int64(0),
+					"plugin_config": `{"NUM_STREAMS":1}`,
+				},
+				"server_settings": map[string]interface{}{"grpc_workers": int64(2)},
+			},
+			expected: map[string]interface{}{
+				"resource_preset": "small",
+				"deployment_parameters": map[string]interface{}{
+					"replicas": int64(2),
+					"resources": map[string]interface{}{
+						"requests": map[string]interface{}{"cpu": "1"},
+						"limits":   map[string]interface{}{"cpu": "1", "memory": "6Gi", "xpu_device": "gpu.intel.com/i915"},
+					},
+				},
+				"models_settings": map[string]interface{}{
+					"nireq":         int64(0),
+					"plugin_config": `{"NUM_STREAMS":1,"PERFORMANCE_HINT":"LATENCY"}`,
+				},
+				"server_settings": map[string]interface{}{"grpc_workers": int64(2)},
+			},
+			status: &types.ResourcesStatus{
+				Preset:          "small",
+				Requests:        map[string]string{"cpu": "1"},
+				Limits:          map[string]string{"cpu": "1", "memory": "6Gi"},
+				GRPCWorkers:     2,
+				PerformanceHint: "LATENCY",
+			},
+		},
+		{
+			name: "models",
+			values: map[string]interface{}{
+				"resource_preset": "latency",
+				"models_settings": map[string]interface{}{
+					"single_model_mode": false,
+					"models": []interface{}{
+						map[string]interface{}{"name": "a", "base_path": "gs://models/a"},
+						map[string]interface{}{
+							"name": "b", "base_path": "gs://models/b", "nireq": int64(8),
+							"plugin_config": map[string]interface{}{"PERFORMANCE_HINT": "THROUGHPUT"},
+						},
+					},
+				},
+			},
+			expected: map[string]interface{}{
+				"resource_preset": "latency",
+				"deployment_parameters": map[string]interface{}{"resources": map[string]interface{}{
+					"requests": map[string]interface{}{"cpu": "8", "memory": "16Gi"},
+					"limits":   map[string]interface{}{"cpu": "8", "memory": "16Gi"},
+				}},
+				"models_settings": map[string]interface{}{
+					"single_model_mode": false,
+					"nireq":             int64(2),
+					"plugin_config":     `{"PERFORMANCE_HINT":"LATENCY"}`,
+					"models": []interface{}{
+						map[string]interface{}{
+							"name": "a", "base_path": "gs://models/a", "nireq": int64(2),
+							"plugin_config": map[string]interface{}{"PERFORMANCE_HINT": "LATENCY"},
+						},
+						map[string]interface{}{
+							"name": "b", "base_path": "gs://models/b", "nireq": int64(8),
+							"plugin_config": map[string]interface{}{"PERFORMANCE_HINT": "THROUGHPUT"},
+						},
+					},
+				},
+				"server_settings": map[string]interface{}{"grpc_workers": int64(1)},
+			},
+			status: &types.ResourcesStatus{
+				Preset:          "latency",
+				Requests:        map[string]string{"cpu": "8", "memory": "16Gi"},
+				Limits:          map[string]string{"cpu": "8", "memory": "16Gi"},
+				Nireq:           2,
+				GRPCWorkers:     1,
+				PerformanceHint: "LATENCY",
+			},
+		},
+		{
+			name:       "unknown preset",
+			values:     map[string]interface{}{"resource_preset": "huge"},
+			errMessage: `invalid resource_preset "huge", expected one of large, latency, medium, small, throughput`,
+		},
+		{
+			name: "invalid plugin config",
+			values: map[string]interface{}{
+				"resource_preset": "small",
+				"models_settings": map[string]interface{}{"plugin_config": "LATENCY"},
+			},
+			errMessage: "models_settings.plugin_config must be a JSON object: invalid character 'L' looking for beginning of value",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status := &types.HelmAppStatus{Resources: &types.ResourcesStatus{Preset: "medium"}}
+			err := applyResourcePreset(status, test.values)
+			if test.errMessage != "" {
+				assert.EqualError(t, err, test.errMessage)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, test.values)
+			assert.Equal(t, test.status, status.Resources)
+		})
+	}
+}
+
+func TestApplyResourcePresetKeepsSpec(t *testing.T) {
+	limits := map[string]interface{}{"memory": "6Gi"}
+	values := map[string]interface{}{
+		"resource_preset": "medium",
+		"deployment_parameters": map[string]interface{}{
+			"resources": map[string]interface{}{"limits": limits},
+		},
+	}
+	assert.NoError(t, applyResourcePreset(&types.HelmAppStatus{}, values))
+	assert.Equal(t, map[string]interface{}{"memory": "6Gi"}, limits)
+}
@@ -0,0 +1,229 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	rpb "helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/helm/release"
+)
+
+const (
+	helmWaitAnnotation        = "helm.sdk.operatorframework.io/wait"
+	helmWaitForJobsAnnotation = "helm.sdk.operatorframework.io/wait-for-jobs"
+	helmTimeoutAnnotation     = "helm.sdk.operatorframework.io/timeout"
+	helmAtomicAnnotation      = "helm.sdk.operatorframework.io/atomic"
+
+	// defaultReleaseTimeout matches the default timeout of the Helm CLI.
+	defaultReleaseTimeout = 5 * time.Minute
+	// releaseProgressPeriod is how often the readiness of a progressing
+	// release is checked.
+	releaseProgressPeriod = 5 * time.Second
+)
+
+// ReleaseWaitOptions configures how the reconciler tracks the readiness of
+// release resources after an install or upgrade. The reconciler never blocks
+// a worker while waiting; instead it sets the Progressing condition and
+// requeues the custom resource until the resources are ready or the timeout
+// expires.
+type ReleaseWaitOptions struct {
+	Wait        bool
+	WaitForJobs bool
+	Timeout     time.Duration
+	Atomic      bool
+}
+
+// Enabled reports whether the readiness of release resources is tracked.
+func (w ReleaseWaitOptions) Enabled() bool {
+	return w.Wait || w.WaitForJobs || w.Atomic
+}
+
+// releaseWaitOptionsFor returns the wait options of the watch overridden by
+// the annotations set on the custom resource.
+func releaseWaitOptionsFor(defaults ReleaseWaitOptions, o *unstructured.Unstructured) ReleaseWaitOptions {
+	opts := defaults
+	opts.Wait = annotationBool(helmWaitAnnotation, o, opts.Wait)
+	opts.WaitForJobs = annotationBool(helmWaitForJobsAnnotation, o, opts.WaitForJobs)
+	opts.Atomic = annotationBool(helmAtomicAnnotation, o, opts.Atomic)
+	if value := o.GetAnnotations()[helmTimeoutAnnotation]; value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			log.Info("Could not parse annotation as a positive duration",
+				"annotation", helmTimeoutAnnotation, "value informed", value)
+		} else {
+			opts.Timeout = timeout
+		}
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultReleaseTimeout
+	}
+	return opts
+}
+
+// annotationBool returns the boolean representation of the annotation string,
+// or def if the annotation is not set or cannot be parsed.
+func annotationBool(anno string, o *unstructured.Unstructured, def bool) bool {
+	boolStr, ok := o.GetAnnotations()[anno]
+	if !ok || boolStr == "" {
+		return def
+	}
+	value, err := strconv.ParseBool(boolStr)
+	if err != nil {
+		log.Info("Could not parse annotation as a boolean",
+			"annotation", anno, "value informed", boolStr)
+		return def
+	}
+	return value
+}
+
+// setProgressing marks the release as waiting for its resources after an
+// install or upgrade, or clears the condition if waiting is disabled.
+func setProgressing(status *types.HelmAppStatus, wait ReleaseWaitOptions) {
+	if !wait.Enabled() {
+		status.RemoveCondition(types.ConditionProgressing)
+		return
+	}
+	status.SetCondition(types.HelmAppCondition{
+		Type:    types.ConditionProgressing,
+		Status:  types.StatusTrue,
+		Reason:  types.ReasonWaitingForResources,
+		Message: fmt.Sprintf("Waiting up to %s for release resources to become ready", wait.Timeout),
+	})
+}
+
+// isProgressing reports whether the release is waiting for its resources.
+func isProgressing(status *types.HelmAppStatus) bool {
+	c := status.GetCondition(types.ConditionProgressing)
+	return c != nil && c.Status == types.StatusTrue
+}
+
+// checkReleaseProgress checks the readiness of a progressing release and
+// updates the status accordingly. It returns the release that is deployed
+// afterwards, which differs from rel if the release was rolled back and is nil
+// if a failed install was uninstalled, and the period after which the check
+// should be repeated, or zero if the release is no longer progressing.
+func (r HelmOperatorReconciler) checkReleaseProgress(ctx context.Context, o *unstructured.Unstructured,
+	manager release.Manager, status *types.HelmAppStatus, rel *rpb.Release, wait ReleaseWaitOptions) (*rpb.Release, time.Duration, error) {
+
+	ready, err := manager.IsReleaseReady(ctx, wait.WaitForJobs)
+	if err != nil {
+		return rel, 0, err
+	}
+	if ready {
+		status.SetCondition(types.HelmAppCondition{
+			Type:   types.ConditionProgressing,
+			Status: types.StatusFalse,
+			Reason: types.ReasonResourcesReady,
+		})
+		return rel, 0, nil
+	}
+
+	deadline := time.Now()
+	if rel.Info != nil && !rel.Info.LastDeployed.IsZero() {
+		deadline = rel.Info.LastDeployed.Time.Add(wait.Timeout)
+	}
+	if remaining := time.Until(deadline); remaining > 0 {
+		if remaining > releaseProgressPeriod {
+			remaining = releaseProgressPeriod
+		}
+		return rel, remaining, nil
+	}
+
+	message := fmt.Sprintf("Release resources did not become ready within %s", wait.Timeout)
+	status.SetCondition(types.HelmAppCondition{
+		Type:    types.ConditionProgressing,
+		Status:  types.StatusFalse,
+		Reason:  types.ReasonProgressDeadlineExceeded,
+		Message: message,
+	})
+	r.EventRecorder.Event(o, "Warning", string(types.ReasonProgressDeadlineExceeded), message)
+	if !wait.Atomic {
+		return rel, 0, nil
+	}
+	if rel.Version <= 1 {
+		// like helm install --atomic, a failed install is uninstalled
+		return uninstallFailedRelease(ctx, o, manager, status, rel, message)
+	}
+
+	rolledBackRelease, err := rollbackRelease(ctx, o, manager, status, rel, message)
+	return rolledBackRelease, 0, err
+}
+
+// uninstallFailedRelease uninstalls a release which failed after its first
+// install and records the failure cause in the ReleaseFailed condition. The
+// install is not retried for the current generation of the custom resource.
+func uninstallFailedRelease(ctx context.Context, o *unstructured.Unstructured, manager release.Manager,
+	status *types.HelmAppStatus, rel *rpb.Release, cause string) (*rpb.Release, time.Duration, error) {
+
+	log.Info("Uninstalling failed release", "version", rel.Version, "cause", cause)
+	if _, err := manager.UninstallRelease(ctx); err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		status.SetCondition(types.HelmAppCondition{
+			Type:    types.ConditionReleaseFailed,
+			Status:  types.StatusTrue,
+			Reason:  types.ReasonUninstallError,
+			Message: err.Error(),
+		})
+		return rel, 0, err
+	}
+	status.SetCondition(types.HelmAppCondition{
+		Type:    types.ConditionReleaseFailed,
+		Status:  types.StatusTrue,
+		Reason:  types.ReasonUninstallSuccessful,
+		Message: fmt.Sprintf("%s; uninstalled the failed release", cause),
+	})
+	status.RemoveCondition(types.ConditionDeployed)
+	status.RemoveCondition(types.ConditionTested)
+	status.DeployedRelease = nil
+	status.FailedGeneration = o.GetGeneration()
+	return nil, 0, nil
+}
+
+// rollbackRelease rolls back a release which failed after it was deployed
+// and records the failure cause in the ReleaseFailed condition. The upgrade
+// is not retried for the current generation of the custom resource.
+func rollbackRelease(ctx context.Context, o *unstructured.Unstructured, manager release.Manager,
+	status *types.HelmAppStatus, rel *rpb.Release, cause string) (*rpb.Release, error) {
+
+	log.Info("Rolling back release", "version", rel.Version, "cause", cause)
+	rolledBackRelease, err := manager.RollbackRelease(ctx)
+	if err != nil {
+		status.SetCondition(types.HelmAppCondition{
+			Type:    types.ConditionReleaseFailed,
+			Status:  types.StatusTrue,
+			Reason:  types.ReasonRollbackError,
+			Message: err.Error(),
+		})
+		return rel, err
+	}
+	status.SetCondition(types.HelmAppCondition{
+		Type:    types.ConditionReleaseFailed,
+		Status:  types.StatusTrue,
+		Reason:  types.ReasonRollbackSuccessful,
+		Message: fmt.Sprintf("%s; rolled back to the previous release", cause),
+	})
+	status.FailedGeneration = o.GetGeneration()
+	return rolledBackRelease, nil
+}
@@ -0,0 +1,215 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	rpb "helm.sh/helm/v3/pkg/release"
+	helmtime "helm.sh/helm/v3/pkg/time"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/helm/release"
+)
+
+func TestReleaseWaitOptionsFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		defaults ReleaseWaitOptions
+		input    map[string]interface{}
+		expected ReleaseWaitOptions
+	}{
+		{
+			name:     "defaults without annotations",
+			defaults: ReleaseWaitOptions{},
+			input:    map[string]interface{}{},
+			expected: ReleaseWaitOptions{Timeout: defaultReleaseTimeout},
+		},
+		{
+			name:     "watch settings without annotations",
+			defaults: ReleaseWaitOptions{Wait: true, Atomic: true, Timeout: time.Minute},
+			input:    map[string]interface{}{},
+			expected: ReleaseWaitOptions{Wait: true, Atomic: true, Timeout: time.Minute},
+		},
+		{
+			name:     "annotations override watch settings",
+			defaults: ReleaseWaitOptions{Wait: true, Atomic: true, Timeout: time.Minute},
+			input: map[string]interface{}{
+				"helm.sdk.operatorframework.io/wait":          "false",
+				"helm.sdk.operatorframework.io/wait-for-jobs": "true",
+				"helm.sdk.operatorframework.io/timeout":       "90s",
+				"helm.sdk.operatorframework.io/atomic":        "0",
+			},
+			expected: ReleaseWaitOptions{WaitForJobs: true, Timeout: 90 * time.Second},
+		},
+		{
+			name:     "invalid annotations are ignored",
+			defaults: ReleaseWaitOptions{Wait: true, Timeout: time.Minute},
+			input: map[string]interface{}{
+				"helm.sdk.operatorframework.io/wait":    "invalid",
+				"helm.sdk.operatorframework.io/timeout": "-5m",
+			},
+			expected: ReleaseWaitOptions{Wait: true, Timeout: time.Minute},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, releaseWaitOptionsFor(test.defaults, annotations(test.input)), test.name)
+	}
+}
+
+func TestSetProgressing(t *testing.T) {
+	status := &types.HelmAppStatus{}
+
+	setProgressing(status, ReleaseWaitOptions{Wait: true, Timeout: time.Minute})
+	assert.True(t, isProgressing(status))
+	assert.Equal(t, types.ReasonWaitingForResources, status.GetCondition(types.ConditionProgressing).Reason)
+
+	setProgressing(status, ReleaseWaitOptions{Timeout: time.Minute})
+	assert.False(t, isProgressing(status))
+	assert.Nil(t, status.GetCondition(types.ConditionProgressing))
+}
+
+func TestRequeuePeriod(t *testing.T) {
+	r := HelmOperatorReconciler{ReconcilePeriod: time.Minute}
+
+	assert.Equal(t, time.Minute, r.requeuePeriod(ReleaseWaitOptions{}))
+	assert.Equal(t, releaseProgressPeriod, r.requeuePeriod(ReleaseWaitOptions{Atomic: true}))
+}
+
+// progressManager is a release manager whose release resources are checked
+// for readiness.
+type progressManager struct {
+	release.Manager
+	ready       bool
+	rolledBack  *rpb.Release
+	uninstalled *bool
+}
+
+func (m progressManager) IsReleaseReady(context.Context, bool) (bool, error) {
+	return m.ready, nil
+}
+
+func (m progressManager) RollbackRelease(context.Context) (*rpb.Release, error) {
+	return m.rolledBack, nil
+}
+
+func (m progressManager) UninstallRelease(context.Context, ...release.UninstallOption) (*rpb.Release, error) {
+	*m.uninstalled = true
+	return &rpb.Release{}, nil
+}
+
+func TestCheckReleaseProgress(t *testing.T) {
+	deployed := func(version int, ago time.Duration) *rpb.Release {
+		return &rpb.Release{Name: "sample", Version: version, Info: &rpb.Info{
+			LastDeployed: helmtime.Time{Time: time.Now().Add(-ago)},
+		}}
+	}
+	previous := &rpb.Release{Name: "sample", Version: 1}
+
+	tests := []struct {
+		name        string
+		ready       bool
+		release     *rpb.Release
+		wait        ReleaseWaitOptions
+		expected    *rpb.Release
+		reason      types.HelmAppConditionReason
+		failed      types.HelmAppConditionReason
+		requeue     bool
+		uninstalled bool
+	}{
+		{
+			name:     "ready",
+			ready:    true,
+			release:  deployed(2, time.Minute),
+			wait:     ReleaseWaitOptions{Wait: true, Timeout: 5 * time.Minute},
+			expected: deployed(2, time.Minute),
+			reason:   types.ReasonResourcesReady,
+		},
+		{
+			name:     "waiting",
+			release:  deployed(2, time.Minute),
+			wait:     ReleaseWaitOptions{Wait: true, Timeout: 5 * time.Minute},
+			expected: deployed(2, time.Minute),
+			reason:   types.ReasonWaitingForResources,
+			requeue:  true,
+		},
+		{
+			name:     "deadline exceeded",
+			release:  deployed(2, 10*time.Minute),
+			wait:     ReleaseWaitOptions{Wait: true, Timeout: 5 * time.Minute},
+			expected: deployed(2, 10*time.Minute),
+			reason:   types.ReasonProgressDeadlineExceeded,
+		},
+		{
+			name:     "atomic upgrade rolled back",
+			release:  deployed(2, 10*time.Minute),
+			wait:     ReleaseWaitOptions{Atomic: true, Timeout: 5 * time.Minute},
+			expected: previous,
+			reason:   types.ReasonProgressDeadlineExceeded,
+			failed:   types.ReasonRollbackSuccessful,
+		},
+		{
+			name:        "atomic install uninstalled",
+			release:     deployed(1, 10*time.Minute),
+			wait:        ReleaseWaitOptions{Atomic: true, Timeout: 5 * time.Minute},
+			reason:      types.ReasonProgressDeadlineExceeded,
+			failed:      types.ReasonUninstallSuccessful,
+			uninstalled: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := HelmOperatorReconciler{EventRecorder: record.NewFakeRecorder(10)}
+			o := &unstructured.Unstructured{}
+			o.SetGeneration(3)
+			status := &types.HelmAppStatus{DeployedRelease: &types.HelmAppRelease{Name: "sample"}}
+			status.SetCondition(types.HelmAppCondition{Type: types.ConditionDeployed, Status: types.StatusTrue})
+			setProgressing(status, test.wait)
+			uninstalled := false
+			manager := progressManager{ready: test.ready, rolledBack: previous, uninstalled: &uninstalled}
+
+			rel, requeue, err := r.checkReleaseProgress(context.TODO(), o, manager, status, test.release, test.wait)
+			assert.NoError(t, err)
+			if test.expected == nil {
+				assert.Nil(t, rel)
+			} else {
+				assert.Equal(t, test.expected.Version, rel.Version)
+			}
+			assert.Equal(t, test.requeue, requeue > 0)
+			assert.Equal(t, test.reason, status.GetCondition(types.ConditionProgressing).Reason)
+			assert.Equal(t, test.uninstalled, uninstalled)
+			if test.failed == "" {
+				assert.Nil(t, status.GetCondition(types.ConditionReleaseFailed))
+				assert.Zero(t, status.FailedGeneration)
+				return
+			}
+			assert.Equal(t, test.failed, status.GetCondition(types.ConditionReleaseFailed).Reason)
+			assert.Equal(t, int64(3), status.FailedGeneration)
+			if test.uninstalled {
+				assert.Nil(t, status.DeployedRelease)
+				assert.Nil(t, status.GetCondition(types.ConditionDeployed))
+			}
+		})
+	}
+}
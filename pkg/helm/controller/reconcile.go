@@ -58,6 +58,10 @@ import (
 	"github.com/openvinotoolkit/operator/pkg/helm/internal/diff"
 	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
 	"github.com/openvinotoolkit/operator/pkg/helm/release"
+	"github.com/openvinotoolkit/operator/pkg/imagepolicy"
+	"github.com/openvinotoolkit/operator/pkg/maintenance"
+	"github.com/openvinotoolkit/operator/pkg/modelregistry"
+	"github.com/openvinotoolkit/operator/pkg/ovms"
 )
 
 // blank assignment to verify that HelmOperatorReconciler implements reconcile.Reconciler
@@ -67,14 +71,29 @@ var _ reconcile.Reconciler = &HelmOperatorReconciler{}
 type ReleaseHookFunc func(*rpb.Release) error
 
 // HelmOperatorReconciler reconciles custom resources as Helm releases.
+// APIReader reads the objects which are not cached, like the Secrets and
+// ConfigMaps of the users, which the cache filters out as they are not
+// labeled with a chart.
 type HelmOperatorReconciler struct {
 	Client                 client.Client
+	APIReader              client.Reader
 	EventRecorder          record.EventRecorder
 	GVK                    schema.GroupVersionKind
 	ManagerFactory         release.ManagerFactory
 	ReconcilePeriod        time.Duration
 	OverrideValues         map[string]string
 	SuppressOverrideValues bool
+	ReleaseWait            ReleaseWaitOptions
+	ReleaseTest            ReleaseTestOptions
+	ModelClient            ovms.Client
+	ModelChecks            *ModelChecks
+	ModelRegistry          modelregistry.Resolver
+	OperatorNamespace      string
+	NodeFeatureLabels      map[string]string
+	ImagePolicy            *imagepolicy.Policy
+	ImageVerifier          imagepolicy.Verifier
+	ImageResolver          imagepolicy.Resolver
+	MaintenanceWindow      *maintenance.Window
 	releaseHook            ReleaseHookFunc
 }
 
@@ -174,6 +193,7 @@ func (r HelmOperatorReconciler) Reconcile(ctx context.Context, request reconcile
 			return reconcile.Result{}, err
 		}
 		status.RemoveCondition(types.ConditionReleaseFailed)
+		r.ModelChecks.forget(request.Namespace + "/" + manager.ReleaseName())
 
 		wait := hasAnnotation(helmUninstallWaitAnnotation, o)
 		if errors.Is(err, driver.ErrReleaseNotFound) {
@@ -250,6 +270,38 @@ func (r HelmOperatorReconciler) Reconcile(ctx context.Context, request reconcile
 		Type:   types.ConditionInitialized,
 		Status: types.StatusTrue,
 	})
+	wait := releaseWaitOptionsFor(r.ReleaseWait, o)
+	test := releaseTestOptionsFor(r.ReleaseTest, o)
+	if test.Enabled {
+		// tests are run once the release resources are ready
+		wait.Wait = true
+	}
+
+	if err := r.renderValues(ctx, o, status, manager.GetValues()); err != nil {
+		log.Error(err, "Failed to render release values")
+		status.SetCondition(types.HelmAppCondition{
+			Type:    types.ConditionReleaseFailed,
+			Status:  types.StatusTrue,
+			Reason:  types.PreconditionError,
+			Message: err.Error(),
+		})
+		if err := r.updateResourceStatus(ctx, o, status); err != nil {
+			log.Error(err, "Failed to update status after render values failure")
+		}
+		return reconcile.Result{}, err
+	}
+	if r.GVK.Kind == "ModelServer" {
+		r.warnDeprecatedValues(o, status, manager.GetValues())
+		if requeueAfter, syncing := modelCacheRequeue(status); syncing {
+			// the release is installed or upgraded once the models are cached
+			log.Info("Waiting for the model cache sync", "job", status.ModelCache.SyncJob)
+			err := r.updateResourceStatus(ctx, o, status)
+			if err != nil {
+				log.Error(err, "Failed to update status during model cache sync")
+			}
+			return reconcile.Result{RequeueAfter: requeueAfter}, err
+		}
+	}
 
 	if err := manager.Sync(ctx); err != nil {
 		log.Error(err, "Failed to sync release")
@@ -266,6 +318,15 @@ func (r HelmOperatorReconciler) Reconcile(ctx context.Context, request reconcile
 	}
 	status.RemoveCondition(types.ConditionIrreconcilable)
 
+	// A release that was rolled back or uninstalled is not retried until the
+	// CR spec changes, otherwise it would be released and reverted in a loop.
+	rolledBack := status.FailedGeneration != 0 && status.FailedGeneration == o.GetGeneration()
+	if !manager.IsInstalled() && rolledBack {
+		log.Info("Skipping install of a release that was uninstalled", "generation", o.GetGeneration())
+		err = r.updateResourceStatus(ctx, o, status)
+		return reconcile.Result{}, err
+	}
+
 	if !manager.IsInstalled() {
 		for k, v := range r.OverrideValues {
 			r.EventRecorder.Eventf(o, "Warning", "OverrideValuesInUse",
@@ -273,6 +334,16 @@ func (r HelmOperatorReconciler) Reconcile(ctx context.Context, request reconcile
 		}
 
 		err = ValidateNotebook(ctx,r.GVK.Kind, request.Namespace)
+		if err == nil {
+			err = r.verifyReleaseImages(ctx, status, manager)
+		}
+		if err == nil && r.GVK.Kind == "ModelServer" {
+			err = r.checkModelRepository(ctx, o, status, manager.GetValues())
+		}
+		if errors.Is(err, errLayoutCheckPending) {
+			err = r.updateResourceStatus(ctx, o, status)
+			return reconcile.Result{RequeueAfter: releaseProgressPeriod}, err
+		}
 
 		if err != nil {
 			log.Error(err, "Failed to install release")
@@ -288,7 +359,7 @@ func (r HelmOperatorReconciler) Reconcile(ctx context.Context, request reconcile
 			return reconcile.Result{}, err
 		}
 
-		installedRelease, err := manager.InstallRelease(ctx)
+		installedRelease, err := manager.InstallRelease(ctx, release.InstallTimeout(wait.Timeout))
 		if err != nil {
 			log.Error(err, "Release failed")
 			status.SetCondition(types.HelmAppCondition{
@@ -334,9 +405,12 @@ func (r HelmOperatorReconciler) Reconcile(ctx context.Context, request reconcile
 			Message: message,
 		})
 		status.DeployedRelease = &types.HelmAppRelease{
-			Name:     installedRelease.Name,
-			Manifest: installedRelease.Manifest,
+			Name:       installedRelease.Name,
+			Manifest:   installedRelease.Manifest,
+			Generation: o.GetGeneration(),
 		}
+		setProgressing(status, wait)
+		setTestPending(status, test)
 
 		if r.GVK.Kind == "ModelServer" {
 			status.SetScaling(getReplicasStatus(ctx, manager.ReleaseName(), request.Namespace), manager.ReleaseName())
@@ -344,7 +418,7 @@ func (r HelmOperatorReconciler) Reconcile(ctx context.Context, request reconcile
 
 		err = r.updateResourceStatus(ctx, o, status)
 		time.Sleep(time.Second)  // wait 1s to reduce conflicts with concurrent updates
-		return reconcile.Result{RequeueAfter: r.ReconcilePeriod}, err
+		return reconcile.Result{RequeueAfter: r.requeuePeriod(wait)}, err
 	}
 
 	if !(controllerutil.ContainsFinalizer(o, uninstallFinalizer) ||
@@ -358,13 +432,48 @@ func (r HelmOperatorReconciler) Reconcile(ctx context.Context, request reconcile
 		}
 	}
 
-	if manager.IsUpgradeRequired() {
+	if manager.IsUpgradeRequired() && rolledBack {
+		log.Info("Skipping upgrade of a release that was rolled back", "generation", o.GetGeneration())
+	}
+
+	deferred, upgradeDelay := false, time.Duration(0)
+	if !rolledBack {
+		deferred, upgradeDelay, err = r.deferUpgrade(ctx, o, status, manager, time.Now())
+		if err != nil {
+			log.Error(err, "Failed to check the maintenance window")
+			status.SetCondition(types.HelmAppCondition{
+				Type:    types.ConditionReleaseFailed,
+				Status:  types.StatusTrue,
+				Reason:  types.PreconditionError,
+				Message: err.Error(),
+			})
+			if err := r.updateResourceStatus(ctx, o, status); err != nil {
+				log.Error(err, "Failed to update status after maintenance window failure")
+			}
+			return reconcile.Result{}, err
+		}
+		if deferred {
+			log.Info("Deferring the upgrade to the maintenance window", "delay", upgradeDelay.String())
+		}
+	}
+
+	if manager.IsUpgradeRequired() && !rolledBack && !deferred {
 		for k, v := range r.OverrideValues {
 			r.EventRecorder.Eventf(o, "Warning", "OverrideValuesInUse",
 				"Chart value %q overridden to %q by operator's watches.yaml", k, v)
 		}
 
 		err = ValidateNotebook(ctx,r.GVK.Kind, request.Namespace)
+		if err == nil {
+			err = r.verifyReleaseImages(ctx, status, manager)
+		}
+		if err == nil && r.GVK.Kind == "ModelServer" {
+			err = r.checkModelRepository(ctx, o, status, manager.GetValues())
+		}
+		if errors.Is(err, errLayoutCheckPending) {
+			err = r.updateResourceStatus(ctx, o, status)
+			return reconcile.Result{RequeueAfter: releaseProgressPeriod}, err
+		}
 
 		if err != nil {
 			log.Error(err, "Failed to upgrade release")
@@ -382,7 +491,8 @@ func (r HelmOperatorReconciler) Reconcile(ctx context.Context, request reconcile
 
 		force := hasAnnotation(helmUpgradeForceAnnotation, o)
 		log.Info("Starting upgrade")
-		previousRelease, upgradedRelease, err := manager.UpgradeRelease(ctx, release.ForceUpgrade(force))
+		previousRelease, upgradedRelease, err := manager.UpgradeRelease(ctx, release.ForceUpgrade(force),
+			release.UpgradeTimeout(wait.Timeout))
 		if err != nil {
 			log.Error(err, "Release upgrade failed")
 			status.SetCondition(types.HelmAppCondition {
@@ -423,15 +533,23 @@ func (r HelmOperatorReconciler) Reconcile(ctx context.Context, request reconcile
 			Message: message,
 		})
 		status.DeployedRelease = &types.HelmAppRelease{
-			Name:     upgradedRelease.Name,
-			Manifest: upgradedRelease.Manifest,
+			Name:       upgradedRelease.Name,
+			Manifest:   upgradedRelease.Manifest,
+			Generation: o.GetGeneration(),
 		}
+		status.FailedGeneration = 0
+		setProgressing(status, wait)
+		setTestPending(status, test)
 
 		if r.GVK.Kind == "ModelServer" {
 			status.SetScaling(getReplicasStatus(ctx, manager.ReleaseName(), request.Namespace), manager.ReleaseName())
 		}
 		log.Info("Updating status after upgrade.")
 		err = r.updateResourceStatus(ctx, o, status)
+		requeueAfter := r.requeuePeriod(wait)
+		if status.Rollout.Active() && (requeueAfter == 0 || releaseProgressPeriod < requeueAfter) {
+			requeueAfter = releaseProgressPeriod
+		}
 
 		if r.GVK.Kind == "Notebook" {
 			if gitRepositoryUpdateRequired(previousRelease.Config, upgradedRelease.Config) {
@@ -464,7 +582,7 @@ func (r HelmOperatorReconciler) Reconcile(ctx context.Context, request reconcile
 		}
 
 		time.Sleep(time.Second)  // wait 1s to reduce conflicts with concurrent updates
-		return reconcile.Result{RequeueAfter: r.ReconcilePeriod}, err
+		return reconcile.Result{RequeueAfter: requeueAfter}, err
 	}
 
 	// If a change is made to the CR spec that causes a release failure, a
@@ -472,8 +590,11 @@ func (r HelmOperatorReconciler) Reconcile(ctx context.Context, request reconcile
 	// is then reverted to its previous state, the operator will stop
 	// attempting the release and will resume reconciling. In this case, we
 	// need to remove the ConditionReleaseFailed because the failing release is
-	// no longer being attempted.
-	status.RemoveCondition(types.ConditionReleaseFailed)
+	// no longer being attempted. A rolled back release keeps the condition
+	// until the CR spec changes.
+	if !rolledBack {
+		status.RemoveCondition(types.ConditionReleaseFailed)
+	}
 
 	err = ValidateNotebook(ctx,r.GVK.Kind, request.Namespace)
 
@@ -514,6 +635,33 @@ func (r HelmOperatorReconciler) Reconcile(ctx context.Context, request reconcile
 		}
 	}
 
+	var requeueAfter time.Duration
+	if isProgressing(status) {
+		expectedRelease, requeueAfter, err = r.checkReleaseProgress(ctx, o, manager, status, expectedRelease, wait)
+		if err != nil {
+			log.Error(err, "Failed to check release progress")
+			if err := r.updateResourceStatus(ctx, o, status); err != nil {
+				log.Error(err, "Failed to update status after release progress failure")
+			}
+			return reconcile.Result{}, err
+		}
+		if expectedRelease == nil {
+			log.Info("Uninstalled failed release")
+			err = r.updateResourceStatus(ctx, o, status)
+			return reconcile.Result{}, err
+		}
+	}
+	if (isTestPending(status) || isTestRunning(status)) && !isProgressing(status) {
+		expectedRelease, requeueAfter, err = r.runReleaseTests(ctx, o, manager, status, expectedRelease, test, wait)
+		if err != nil {
+			log.Error(err, "Failed to run release tests")
+			if err := r.updateResourceStatus(ctx, o, status); err != nil {
+				log.Error(err, "Failed to update status after release test failure")
+			}
+			return reconcile.Result{}, err
+		}
+	}
+
 	log.Info("Reconciled release")
 	reason := types.ReasonUpgradeSuccessful
 	if expectedRelease.Version == 1 {
@@ -529,13 +677,71 @@ func (r HelmOperatorReconciler) Reconcile(ctx context.Context, request reconcile
 		Reason:  reason,
 		Message: message,
 	})
+	generation := o.GetGeneration()
+	if manager.IsUpgradeRequired() && status.DeployedRelease != nil {
+		// the upgrade to the custom resource is deferred or was rolled back
+		generation = status.DeployedRelease.Generation
+	}
 	status.DeployedRelease = &types.HelmAppRelease{
-		Name:     expectedRelease.Name,
-		Manifest: expectedRelease.Manifest,
+		Name:       expectedRelease.Name,
+		Manifest:   expectedRelease.Manifest,
+		Generation: generation,
 	}
 
 	if r.GVK.Kind == "ModelServer" {
 		status.SetScaling(getReplicasStatus(ctx, manager.ReleaseName(), request.Namespace), manager.ReleaseName())
+		status.URL, status.GRPCURL = r.exposureURLs(ctx, expectedRelease, manager.GetValues())
+		status.ChatCompletionsURL = chatCompletionsURL(expectedRelease, manager.GetValues(), status.URL)
+		if status.Rollout.Active() && !isProgressing(status) {
+			ready, err := manager.IsReleaseReady(ctx, false)
+			if err != nil {
+				log.Error(err, "Failed to check rollout readiness")
+				if err := r.updateResourceStatus(ctx, o, status); err != nil {
+					log.Error(err, "Failed to update status after rollout failure")
+				}
+				return reconcile.Result{}, err
+			}
+			rollout, _ := rolloutOptionsFor(manager.GetValues())
+			rolloutRequeue := r.advanceRollout(ctx, o, status, expectedRelease, rollout, ready, time.Now())
+			if requeueAfter == 0 || rolloutRequeue < requeueAfter {
+				requeueAfter = rolloutRequeue
+			}
+		}
+		if renewal := tlsRenewalDelay(status.TLS, o.GetName(), time.Now()); renewal > 0 &&
+			(requeueAfter == 0 || renewal < requeueAfter) {
+			requeueAfter = renewal
+		}
+		if resolve := modelRegistryRequeue(status, manager.GetValues(), time.Now()); resolve > 0 &&
+			(requeueAfter == 0 || resolve < requeueAfter) {
+			requeueAfter = resolve
+		}
+		if update := imageUpdateRequeue(status, manager.GetValues(), time.Now()); update > 0 &&
+			(requeueAfter == 0 || update < requeueAfter) {
+			requeueAfter = update
+		}
+		if kserveEnabled(manager.GetValues()) {
+			// the InferenceService reports the readiness of the model
+			status.RemoveCondition(types.ConditionModelReady)
+			status.Models = nil
+			if !r.mirrorInferenceService(ctx, status, expectedRelease) && requeueAfter == 0 {
+				requeueAfter = r.ReconcilePeriod
+			}
+		} else {
+			status.RemoveCondition(types.ConditionInferenceServiceReady)
+			if !hasAnnotation(verifyModelAnnotation, o) {
+				status.RemoveCondition(types.ConditionModelReady)
+				status.Models = nil
+			} else if !isProgressing(status) {
+				if verify := r.verifyModels(ctx, status, expectedRelease, manager.GetValues()); verify > 0 &&
+					(requeueAfter == 0 || verify < requeueAfter) {
+					requeueAfter = verify
+				}
+			}
+		}
+	}
+
+	if deferred && upgradeDelay > 0 && (requeueAfter == 0 || upgradeDelay < requeueAfter) {
+		requeueAfter = upgradeDelay
 	}
 
 	if r.GVK.Kind == "Notebook" {
@@ -560,7 +766,16 @@ func (r HelmOperatorReconciler) Reconcile(ctx context.Context, request reconcile
 		log.Error(err, "Failed to update resource status")
 	}
 
-	return reconcile.Result{}, err
+	return reconcile.Result{RequeueAfter: requeueAfter}, err
+}
+
+// requeuePeriod returns the period after which a CR is reconciled again
+// after an install or upgrade.
+func (r HelmOperatorReconciler) requeuePeriod(wait ReleaseWaitOptions) time.Duration {
+	if wait.Enabled() && (r.ReconcilePeriod == 0 || releaseProgressPeriod < r.ReconcilePeriod) {
+		return releaseProgressPeriod
+	}
+	return r.ReconcilePeriod
 }
 
 func gitRepositoryUpdateRequired(previousReleaseConfig map[string]interface{}, upgradedReleaseConfig map[string]interface{}) bool {
@@ -681,7 +896,7 @@ func getAPIUrl(uri string, branch string) (string, error) {
 }
 
 func getReplicasStatus(ctx context.Context, releaseName string, namespace string) int {
-	labelSelector := "release="+releaseName 
+	labelSelector := "release="+releaseName+",!track"
 	cfg, err := config.GetConfig()
 	if err != nil {
 		log.Error(err, "Can not get api config")
@@ -0,0 +1,201 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	crthandler "sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// referencedObjects returns the names of the ConfigMaps and Secrets
+// consumed by the model server pods, as set in the ModelServer values.
+func referencedObjects(values map[string]interface{}) (configMaps, secrets []string) {
+	add := func(names []string, fields ...string) []string {
+		if name, _, _ := unstructured.NestedString(values, fields...); name != "" {
+			return append(names, name)
+		}
+		return names
+	}
+	configMaps = add(configMaps, "deployment_parameters", "extra_envs_configmap")
+	configMaps = add(configMaps, "models_settings", "config_configmap_name")
+	secrets = add(secrets, "deployment_parameters", "extra_envs_secret")
+	secrets = add(secrets, "models_repository", "gcp_creds_secret_name")
+	for _, ref := range sortedKeys(deprecatedCredentialFields) {
+		secrets = add(secrets, "models_repository", deprecatedCredentialFields[ref], "name")
+	}
+	secrets = append(secrets, tlsSecrets(values)...)
+	return dedup(configMaps), dedup(secrets)
+}
+
+func dedup(names []string) []string {
+	sort.Strings(names)
+	out := names[:0]
+	for i, name := range names {
+		if i == 0 || name != names[i-1] {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// referencedChecksum returns a checksum of the content of the ConfigMaps and
+// Secrets referenced in the ModelServer values, and of the other Secrets
+// mounted by the pods, or an empty string if none is referenced. A missing
+// object contributes to the checksum too, so that creating it changes the
+// checksum.
+func (r HelmOperatorReconciler) referencedChecksum(ctx context.Context, namespace string,
+	values map[string]interface{}, mountedSecrets ...string) (string, error) {
+
+	configMaps, secrets := referencedObjects(values)
+	secrets = dedup(append(secrets, mountedSecrets...))
+	if len(configMaps) == 0 && len(secrets) == 0 {
+		return "", nil
+	}
+
+	h := sha256.New()
+	write := func(kind, name string, data map[string][]byte, found bool) {
+		fmt.Fprintf(h, "%s/%s %t\n", kind, name, found)
+		keys := make([]string, 0, len(data))
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(h, "%s=%x\n", k, data[k])
+		}
+	}
+	for _, name := range configMaps {
+		cm := &corev1.ConfigMap{}
+		found, err := r.getReferenced(ctx, namespace, name, cm)
+		if err != nil {
+			return "", err
+		}
+		data := map[string][]byte{}
+		for k, v := range cm.Data {
+			data[k] = []byte(v)
+		}
+		for k, v := range cm.BinaryData {
+			data[k] = v
+		}
+		write("configmap", name, data, found)
+	}
+	for _, name := range secrets {
+		secret := &corev1.Secret{}
+		found, err := r.getReferenced(ctx, namespace, name, secret)
+		if err != nil {
+			return "", err
+		}
+		write("secret", name, secret.Data, found)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// getReferenced reads an object referenced by a custom resource from the
+// API server. It returns false if the object does not exist.
+func (r HelmOperatorReconciler) getReferenced(ctx context.Context, namespace, name string, obj client.Object) (bool, error) {
+	err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, obj)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// watchReferencedResources enqueues the custom resources referencing a
+// ConfigMap or a Secret when it changes, including the TLS Secrets of the
+// exposure and the Secrets of the referenced model repository, so that the
+// checksum of the referenced objects is updated and the model server pods
+// are rolled out. Only the metadata of the objects is cached, in the
+// reference cache.
+func watchReferencedResources(mgr manager.Manager, referenceCache cache.Cache, c controller.Controller,
+	gvk schema.GroupVersionKind) error {
+	cl := mgr.GetClient()
+	mapFunc := func(configMap bool) crthandler.MapFunc {
+		return func(ctx context.Context, obj client.Object) []reconcile.Request {
+			list := &unstructured.UnstructuredList{}
+			list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+			if err := cl.List(ctx, list, client.InNamespace(obj.GetNamespace())); err != nil {
+				log.Error(err, "Failed to list resources referencing object", "kind", gvk.Kind,
+					"namespace", obj.GetNamespace(), "name", obj.GetName())
+				return nil
+			}
+			var requests []reconcile.Request
+			for _, item := range list.Items {
+				spec, _, _ := unstructured.NestedMap(item.Object, "spec")
+				if err := resolveRepositoryRef(ctx, cl, gvk, item.GetNamespace(), spec); err != nil {
+					log.V(1).Info("Failed to resolve the model repository", "namespace", item.GetNamespace(),
+						"name", item.GetName(), "error", err.Error())
+				}
+				configMaps, secrets := referencedObjects(spec)
+				secrets = append(secrets, exposureSecrets(item.GetName(), spec)...)
+				names := secrets
+				if configMap {
+					names = configMaps
+				}
+				for _, name := range names {
+					if name == obj.GetName() {
+						requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+							Namespace: item.GetNamespace(),
+							Name:      item.GetName(),
+						}})
+						break
+					}
+				}
+			}
+			return requests
+		}
+	}
+
+	for _, o := range ReferencedObjects() {
+		configMap := o.GetObjectKind().GroupVersionKind().Kind == "ConfigMap"
+		if err := c.Watch(source.Kind(referenceCache, client.Object(o),
+			crthandler.EnqueueRequestsFromMapFunc(mapFunc(configMap)))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReferencedObjects returns the metadata-only objects of the kinds watched
+// for the ConfigMaps and Secrets referenced by the ModelServers. They are
+// created by the users, so the reference cache must not filter them with the
+// chart label.
+func ReferencedObjects() []client.Object {
+	var objects []client.Object
+	for _, kind := range []string{"ConfigMap", "Secret"} {
+		o := &metav1.PartialObjectMetadata{}
+		o.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind(kind))
+		objects = append(objects, o)
+	}
+	return objects
+}
@@ -0,0 +1,121 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// cachedClient returns a client which reads like the client of the manager:
+// the unstructured objects are read from the API server, and the typed
+// objects from the cache, which includes only the objects labeled with a
+// chart. The objects of the tests are not, so they must be read with the
+// API reader.
+func cachedClient(cl client.WithWatch) client.WithWatch {
+	return interceptor.NewClient(cl, interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object,
+			opts ...client.GetOption) error {
+			if _, ok := obj.(*unstructured.Unstructured); ok {
+				return c.Get(ctx, key, obj, opts...)
+			}
+			return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+		},
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			if _, ok := list.(*unstructured.UnstructuredList); ok {
+				return c.List(ctx, list, opts...)
+			}
+			return nil
+		},
+	})
+}
+
+func TestReferencedObjects(t *testing.T) {
+	configMaps, secrets := referencedObjects(map[string]interface{}{
+		"deployment_parameters": map[string]interface{}{
+			"extra_envs_configmap": "envs",
+			"extra_envs_secret":    "creds",
+		},
+		"models_settings": map[string]interface{}{"config_configmap_name": "ovms-config"},
+		"models_repository": map[string]interface{}{
+			"gcp_creds_secret_name":            "gcp",
+			"aws_access_key_id_secret_ref":     map[string]interface{}{"name": "creds", "key": "id"},
+			"aws_secret_access_key_secret_ref": map[string]interface{}{"name": "creds", "key": "key"},
+		},
+	})
+	assert.Equal(t, []string{"envs", "ovms-config"}, configMaps)
+	assert.Equal(t, []string{"creds", "gcp"}, secrets)
+
+	configMaps, secrets = referencedObjects(map[string]interface{}{})
+	assert.Empty(t, configMaps)
+	assert.Empty(t, secrets)
+}
+
+func TestReferencedChecksum(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "ovms-config"},
+		Data:       map[string]string{"config.json": "{}"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(cm).Build()
+	r := HelmOperatorReconciler{Client: cachedClient(cl), APIReader: cl}
+	values := map[string]interface{}{
+		"models_settings":       map[string]interface{}{"config_configmap_name": "ovms-config"},
+		"deployment_parameters": map[string]interface{}{"extra_envs_secret": "envs"},
+	}
+
+	checksum, err := r.referencedChecksum(context.TODO(), "ns", values)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, checksum)
+
+	same, err := r.referencedChecksum(context.TODO(), "ns", values)
+	assert.NoError(t, err)
+	assert.Equal(t, checksum, same)
+
+	cm.Data["config.json"] = `{"model_config_list": []}`
+	assert.NoError(t, cl.Update(context.TODO(), cm))
+	changed, err := r.referencedChecksum(context.TODO(), "ns", values)
+	assert.NoError(t, err)
+	assert.NotEqual(t, checksum, changed)
+
+	assert.NoError(t, cl.Create(context.TODO(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "envs"}}))
+	created, err := r.referencedChecksum(context.TODO(), "ns", values)
+	assert.NoError(t, err)
+	assert.NotEqual(t, changed, created)
+
+	none, err := r.referencedChecksum(context.TODO(), "ns", map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Empty(t, none)
+}
+
+func TestReferencedObjectKinds(t *testing.T) {
+	var kinds []string
+	for _, o := range ReferencedObjects() {
+		assert.IsType(t, &metav1.PartialObjectMetadata{}, o)
+		kinds = append(kinds, o.GetObjectKind().GroupVersionKind().String())
+	}
+	assert.Equal(t, []string{"/v1, Kind=ConfigMap", "/v1, Kind=Secret"}, kinds)
+}
@@ -0,0 +1,224 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	rpb "helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/helm/release"
+)
+
+const (
+	helmTestAnnotation                  = "helm.sdk.operatorframework.io/test"
+	helmRollbackOnTestFailureAnnotation = "helm.sdk.operatorframework.io/rollback-on-test-failure"
+
+	// maxTestLogLength limits the length of the test pod logs recorded in
+	// the Tested condition.
+	maxTestLogLength = 1024
+)
+
+// ReleaseTestOptions configures running the chart tests, the hooks annotated
+// with `helm.sh/hook: test`, after each install or upgrade. The tests are run
+// once the release resources are ready.
+type ReleaseTestOptions struct {
+	Enabled           bool
+	RollbackOnFailure bool
+}
+
+// releaseTestOptionsFor returns the test options of the watch overridden by
+// the annotations set on the custom resource.
+func releaseTestOptionsFor(defaults ReleaseTestOptions, o *unstructured.Unstructured) ReleaseTestOptions {
+	opts := defaults
+	opts.Enabled = annotationBool(helmTestAnnotation, o, opts.Enabled)
+	opts.RollbackOnFailure = annotationBool(helmRollbackOnTestFailureAnnotation, o, opts.RollbackOnFailure)
+	return opts
+}
+
+// setTestPending schedules the tests of a release after an install or
+// upgrade, or clears the Tested condition if tests are disabled.
+func setTestPending(status *types.HelmAppStatus, test ReleaseTestOptions) {
+	if !test.Enabled {
+		status.RemoveCondition(types.ConditionTested)
+		return
+	}
+	status.SetCondition(types.HelmAppCondition{
+		Type:    types.ConditionTested,
+		Status:  types.StatusUnknown,
+		Reason:  types.ReasonTestPending,
+		Message: "Tests will run once the release resources are ready",
+	})
+}
+
+// isTestPending reports whether the tests of the release are scheduled.
+func isTestPending(status *types.HelmAppStatus) bool {
+	c := status.GetCondition(types.ConditionTested)
+	return c != nil && c.Reason == types.ReasonTestPending
+}
+
+// isTestRunning reports whether the tests of the release were started and
+// did not complete yet.
+func isTestRunning(status *types.HelmAppStatus) bool {
+	c := status.GetCondition(types.ConditionTested)
+	return c != nil && c.Reason == types.ReasonTestRunning
+}
+
+// runReleaseTests starts the chart tests of a release whose resources are
+// ready, and records their results in the Tested condition once they
+// complete. It does not wait for the test pods: it returns the period after
+// which the tests should be checked again, or zero once they completed, and
+// the release that is deployed afterwards, which differs from rel if the
+// release was rolled back because of failed tests.
+func (r HelmOperatorReconciler) runReleaseTests(ctx context.Context, o *unstructured.Unstructured,
+	manager release.Manager, status *types.HelmAppStatus, rel *rpb.Release,
+	test ReleaseTestOptions, wait ReleaseWaitOptions) (*rpb.Release, time.Duration, error) {
+
+	if isTestPending(status) {
+		if c := status.GetCondition(types.ConditionProgressing); c != nil && c.Reason == types.ReasonProgressDeadlineExceeded {
+			status.SetCondition(types.HelmAppCondition{
+				Type:    types.ConditionTested,
+				Status:  types.StatusFalse,
+				Reason:  types.ReasonTestSkipped,
+				Message: "Release resources did not become ready",
+			})
+			return rel, 0, nil
+		}
+		started, err := manager.StartReleaseTests(ctx)
+		if err != nil {
+			status.SetCondition(types.HelmAppCondition{
+				Type:    types.ConditionTested,
+				Status:  types.StatusFalse,
+				Reason:  types.ReasonTestFailed,
+				Message: err.Error(),
+			})
+			return rel, 0, err
+		}
+		if started {
+			log.Info("Started release tests")
+			status.SetCondition(types.HelmAppCondition{
+				Type:    types.ConditionTested,
+				Status:  types.StatusUnknown,
+				Reason:  types.ReasonTestRunning,
+				Message: "Tests are running",
+			})
+		}
+		return rel, releaseProgressPeriod, nil
+	}
+
+	testedRelease, logs, completed, err := manager.ReleaseTestResults(ctx)
+	if testedRelease == nil {
+		status.SetCondition(types.HelmAppCondition{
+			Type:    types.ConditionTested,
+			Status:  types.StatusFalse,
+			Reason:  types.ReasonTestFailed,
+			Message: err.Error(),
+		})
+		return rel, 0, err
+	}
+	if err != nil && !completed {
+		return rel, 0, err
+	}
+	if !completed {
+		deadline := testStartTime(testedRelease).Add(wait.Timeout)
+		if remaining := time.Until(deadline); remaining > 0 {
+			if remaining > releaseProgressPeriod {
+				remaining = releaseProgressPeriod
+			}
+			return rel, remaining, nil
+		}
+		err = fmt.Errorf("tests did not complete within %s", wait.Timeout)
+	}
+
+	message := testSummary(testedRelease)
+	if excerpt := logExcerpt(logs); excerpt != "" {
+		message = fmt.Sprintf("%s\n%s", message, excerpt)
+	}
+	if err == nil {
+		status.SetCondition(types.HelmAppCondition{
+			Type:    types.ConditionTested,
+			Status:  types.StatusTrue,
+			Reason:  types.ReasonTestSucceeded,
+			Message: message,
+		})
+		return rel, 0, nil
+	}
+
+	status.SetCondition(types.HelmAppCondition{
+		Type:    types.ConditionTested,
+		Status:  types.StatusFalse,
+		Reason:  types.ReasonTestFailed,
+		Message: message,
+	})
+	r.EventRecorder.Event(o, "Warning", string(types.ReasonTestFailed), err.Error())
+	if !test.RollbackOnFailure || rel.Version <= 1 {
+		return rel, 0, nil
+	}
+	rolledBackRelease, err := rollbackRelease(ctx, o, manager, status, rel, "Release tests failed")
+	return rolledBackRelease, 0, err
+}
+
+// testStartTime returns the time the first test hook of the release was
+// started.
+func testStartTime(rel *rpb.Release) time.Time {
+	var start time.Time
+	for _, h := range rel.Hooks {
+		if h.LastRun.StartedAt.IsZero() || h.LastRun.Phase == "" {
+			continue
+		}
+		for _, e := range h.Events {
+			if e == rpb.HookTest && (start.IsZero() || h.LastRun.StartedAt.Time.Before(start)) {
+				start = h.LastRun.StartedAt.Time
+			}
+		}
+	}
+	if start.IsZero() {
+		start = time.Now()
+	}
+	return start
+}
+
+// testSummary lists the phase of each test hook of the release.
+func testSummary(rel *rpb.Release) string {
+	var results []string
+	for _, h := range rel.Hooks {
+		for _, e := range h.Events {
+			if e == rpb.HookTest {
+				results = append(results, fmt.Sprintf("%s: %s", h.Name, h.LastRun.Phase))
+			}
+		}
+	}
+	if len(results) == 0 {
+		return "No tests defined in the chart"
+	}
+	return strings.Join(results, ", ")
+}
+
+// logExcerpt returns the end of the test pod logs, which usually includes
+// the cause of a failure, limited to maxTestLogLength bytes.
+func logExcerpt(logs string) string {
+	logs = strings.TrimSpace(logs)
+	if len(logs) <= maxTestLogLength {
+		return logs
+	}
+	return "..." + strings.ToValidUTF8(logs[len(logs)-maxTestLogLength:], "")
+}
@@ -0,0 +1,247 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	rpb "helm.sh/helm/v3/pkg/release"
+	helmtime "helm.sh/helm/v3/pkg/time"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/helm/release"
+)
+
+// releaseTestManager is a release manager running the tests of a release.
+type releaseTestManager struct {
+	release.Manager
+	started    bool
+	tested     *rpb.Release
+	logs       string
+	completed  bool
+	err        error
+	rolledBack *rpb.Release
+}
+
+func (m releaseTestManager) StartReleaseTests(context.Context) (bool, error) {
+	return m.started, nil
+}
+
+func (m releaseTestManager) ReleaseTestResults(context.Context) (*rpb.Release, string, bool, error) {
+	return m.tested, m.logs, m.completed, m.err
+}
+
+func (m releaseTestManager) RollbackRelease(context.Context) (*rpb.Release, error) {
+	return m.rolledBack, nil
+}
+
+func TestReleaseTestOptionsFor(t *testing.T) {
+	defaults := ReleaseTestOptions{Enabled: true}
+
+	assert.Equal(t, defaults, releaseTestOptionsFor(defaults, annotations(map[string]interface{}{})))
+	assert.Equal(t, ReleaseTestOptions{RollbackOnFailure: true}, releaseTestOptionsFor(defaults, annotations(map[string]interface{}{
+		"helm.sdk.operatorframework.io/test":                     "false",
+		"helm.sdk.operatorframework.io/rollback-on-test-failure": "true",
+	})))
+}
+
+func TestSetTestPending(t *testing.T) {
+	status := &types.HelmAppStatus{}
+
+	setTestPending(status, ReleaseTestOptions{Enabled: true})
+	assert.True(t, isTestPending(status))
+
+	setTestPending(status, ReleaseTestOptions{})
+	assert.False(t, isTestPending(status))
+	assert.Nil(t, status.GetCondition(types.ConditionTested))
+}
+
+func TestRunReleaseTests(t *testing.T) {
+	testedRelease := func(phase rpb.HookPhase, started time.Duration) *rpb.Release {
+		return &rpb.Release{Version: 2, Hooks: []*rpb.Hook{{
+			Name:   "ovms-test-model-status",
+			Events: []rpb.HookEvent{rpb.HookTest},
+			LastRun: rpb.HookExecution{
+				StartedAt: helmtime.Time{Time: time.Now().Add(-started)},
+				Phase:     phase,
+			},
+		}}}
+	}
+	rel := &rpb.Release{Name: "sample", Version: 2}
+	previous := &rpb.Release{Name: "sample", Version: 1}
+	wait := ReleaseWaitOptions{Timeout: 5 * time.Minute}
+
+	tests := []struct {
+		name       string
+		reason     types.HelmAppConditionReason
+		progress   types.HelmAppConditionReason
+		manager    releaseTestManager
+		test       ReleaseTestOptions
+		expected   types.HelmAppConditionReason
+		message    string
+		requeue    bool
+		rolledBack bool
+		event      bool
+	}{
+		{
+			name:     "previous tests deleting",
+			reason:   types.ReasonTestPending,
+			expected: types.ReasonTestPending,
+			message:  "Tests will run once the release resources are ready",
+			requeue:  true,
+		},
+		{
+			name:     "started",
+			reason:   types.ReasonTestPending,
+			manager:  releaseTestManager{started: true},
+			expected: types.ReasonTestRunning,
+			message:  "Tests are running",
+			requeue:  true,
+		},
+		{
+			name:     "resources not ready",
+			reason:   types.ReasonTestPending,
+			progress: types.ReasonProgressDeadlineExceeded,
+			manager:  releaseTestManager{started: true},
+			expected: types.ReasonTestSkipped,
+			message:  "Release resources did not become ready",
+		},
+		{
+			name:     "running",
+			reason:   types.ReasonTestRunning,
+			manager:  releaseTestManager{tested: testedRelease(rpb.HookPhaseRunning, time.Minute)},
+			expected: types.ReasonTestRunning,
+			message:  "Tests are running",
+			requeue:  true,
+		},
+		{
+			name:     "timed out",
+			reason:   types.ReasonTestRunning,
+			manager:  releaseTestManager{tested: testedRelease(rpb.HookPhaseRunning, 10*time.Minute)},
+			expected: types.ReasonTestFailed,
+			message:  "ovms-test-model-status: Running",
+			event:    true,
+		},
+		{
+			name:   "succeeded",
+			reason: types.ReasonTestRunning,
+			manager: releaseTestManager{
+				tested: testedRelease(rpb.HookPhaseSucceeded, time.Minute), completed: true,
+				logs: "POD LOGS: ovms-test-model-status\nOK",
+			},
+			expected: types.ReasonTestSucceeded,
+			message:  "ovms-test-model-status: Succeeded\nPOD LOGS: ovms-test-model-status\nOK",
+		},
+		{
+			name:   "failed",
+			reason: types.ReasonTestRunning,
+			manager: releaseTestManager{
+				tested: testedRelease(rpb.HookPhaseFailed, time.Minute), completed: true,
+				err: errors.New("failed to test release: test hooks failed: ovms-test-model-status"), rolledBack: previous,
+			},
+			expected: types.ReasonTestFailed,
+			message:  "ovms-test-model-status: Failed",
+			event:    true,
+		},
+		{
+			name:   "failed with rollback",
+			reason: types.ReasonTestRunning,
+			manager: releaseTestManager{
+				tested: testedRelease(rpb.HookPhaseFailed, time.Minute), completed: true,
+				err: errors.New("failed to test release: test hooks failed: ovms-test-model-status"), rolledBack: previous,
+			},
+			test:       ReleaseTestOptions{Enabled: true, RollbackOnFailure: true},
+			expected:   types.ReasonTestFailed,
+			message:    "ovms-test-model-status: Failed",
+			rolledBack: true,
+			event:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			r := HelmOperatorReconciler{EventRecorder: recorder}
+			status := &types.HelmAppStatus{}
+			setTestPending(status, ReleaseTestOptions{Enabled: true})
+			if test.reason == types.ReasonTestRunning {
+				status.SetCondition(types.HelmAppCondition{
+					Type: types.ConditionTested, Status: types.StatusUnknown,
+					Reason: types.ReasonTestRunning, Message: "Tests are running",
+				})
+			}
+			if test.progress != "" {
+				status.SetCondition(types.HelmAppCondition{
+					Type: types.ConditionProgressing, Status: types.StatusFalse, Reason: test.progress,
+				})
+			}
+
+			deployed, requeue, err := r.runReleaseTests(context.TODO(), &unstructured.Unstructured{}, test.manager,
+				status, rel, test.test, wait)
+			assert.NoError(t, err)
+			assert.Equal(t, test.requeue, requeue > 0)
+			assert.LessOrEqual(t, requeue, releaseProgressPeriod)
+			c := status.GetCondition(types.ConditionTested)
+			if assert.NotNil(t, c) {
+				assert.Equal(t, test.expected, c.Reason)
+				assert.Equal(t, test.message, c.Message)
+			}
+			if test.rolledBack {
+				assert.Equal(t, previous, deployed)
+				assert.Equal(t, types.ReasonRollbackSuccessful, status.GetCondition(types.ConditionReleaseFailed).Reason)
+			} else {
+				assert.Equal(t, rel, deployed)
+			}
+			assert.Equal(t, test.event, len(recorder.Events) > 0)
+		})
+	}
+}
+
+func TestTestSummary(t *testing.T) {
+	rel := &rpb.Release{
+		Hooks: []*rpb.Hook{
+			{
+				Name:    "ovms-test-model-status",
+				Events:  []rpb.HookEvent{rpb.HookTest},
+				LastRun: rpb.HookExecution{Phase: rpb.HookPhaseFailed},
+			},
+			{
+				Name:    "ovms-pre-install",
+				Events:  []rpb.HookEvent{rpb.HookPreInstall},
+				LastRun: rpb.HookExecution{Phase: rpb.HookPhaseSucceeded},
+			},
+		},
+	}
+	assert.Equal(t, "ovms-test-model-status: Failed", testSummary(rel))
+	assert.Equal(t, "No tests defined in the chart", testSummary(&rpb.Release{}))
+}
+
+func TestLogExcerpt(t *testing.T) {
+	assert.Equal(t, "POD LOGS: test", logExcerpt("POD LOGS: test\n"))
+
+	excerpt := logExcerpt(strings.Repeat("a", maxTestLogLength) + "curl: (22) 404")
+	assert.True(t, strings.HasPrefix(excerpt, "..."))
+	assert.True(t, strings.HasSuffix(excerpt, "curl: (22) 404"))
+	assert.Len(t, excerpt, maxTestLogLength+3)
+}
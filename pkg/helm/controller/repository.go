@@ -0,0 +1,254 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/modelrepo"
+	"github.com/openvinotoolkit/operator/pkg/ovms"
+)
+
+const (
+	// checkRepositoryAnnotation disables the check of the model repository
+	// before installing or upgrading a ModelServer when set to false.
+	checkRepositoryAnnotation = "intel.com/check-model-repository"
+
+	repositoryCheckTimeout = 30 * time.Second
+)
+
+// modelPaths returns the paths of the models served according to the
+// ModelServer values.
+func modelPaths(values map[string]interface{}) []string {
+	single, found, _ := unstructured.NestedBool(values, "models_settings", "single_model_mode")
+	if found && !single {
+		config, err := ovms.ConfigFromValues(values)
+		if err != nil || config == nil {
+			return nil
+		}
+		var paths []string
+		for _, entry := range config.ModelConfigList {
+			paths = append(paths, entry.Config.BasePath)
+		}
+		return paths
+	}
+	path, _, _ := unstructured.NestedString(values, "models_settings", "model_path")
+	if path == "" {
+		return nil
+	}
+	return []string{path}
+}
+
+// checkModelRepository checks that the model paths of the ModelServer can be
+// listed with the configured credentials and include version directories,
+// and reports the result in the RepositoryChecked condition. The local model
+// paths are checked by a job mounting the models volume: errLayoutCheckPending
+// is returned until the job completes.
+func (r HelmOperatorReconciler) checkModelRepository(ctx context.Context, o *unstructured.Unstructured,
+	status *types.HelmAppStatus, values map[string]interface{}) error {
+
+	if !annotationBool(checkRepositoryAnnotation, o, true) {
+		status.RemoveCondition(types.ConditionRepositoryChecked)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, repositoryCheckTimeout)
+	defer cancel()
+
+	notChecked, err := r.checkModelPaths(ctx, o, values)
+	condition := types.HelmAppCondition{
+		Type:    types.ConditionRepositoryChecked,
+		Status:  types.StatusTrue,
+		Reason:  types.ReasonModelLayoutValid,
+		Message: "The model paths include version directories",
+	}
+	switch {
+	case errors.Is(err, errLayoutCheckPending):
+		condition.Status = types.StatusUnknown
+		condition.Reason = types.ReasonModelLayoutChecking
+		condition.Message = "The layout of the local model paths is being checked"
+	case err != nil:
+		condition.Status = types.StatusFalse
+		condition.Reason = types.ReasonModelLayoutInvalid
+		condition.Message = err.Error()
+	case len(notChecked) > 0:
+		condition.Status = types.StatusUnknown
+		condition.Reason = types.ReasonNotChecked
+		condition.Message = strings.Join(notChecked, "; ")
+	}
+	status.SetCondition(condition)
+	return err
+}
+
+// checkModelPaths checks the model paths of the ModelServer values, and
+// returns the reasons why some of them were not checked.
+func (r HelmOperatorReconciler) checkModelPaths(ctx context.Context, o *unstructured.Unstructured,
+	values map[string]interface{}) ([]string, error) {
+
+	namespace := o.GetNamespace()
+	paths := modelPaths(values)
+	if len(paths) == 0 {
+		return []string{"No model path is set"}, nil
+	}
+	repository, _, _ := unstructured.NestedMap(values, "models_repository")
+	storages := map[string]modelrepo.Storage{}
+	var notChecked, localPaths []string
+	skip := func(format string, args ...interface{}) {
+		message := fmt.Sprintf(format, args...)
+		for _, m := range notChecked {
+			if m == message {
+				return
+			}
+		}
+		notChecked = append(notChecked, message)
+	}
+	for _, path := range paths {
+		location, ok := modelrepo.ParseLocation(path)
+		if !ok {
+			localPaths = append(localPaths, path)
+			continue
+		}
+		if sa, _, _ := unstructured.NestedString(repository, "workload_identity_service_account"); sa != "" {
+			log.V(1).Info("Skipping model repository check with workload identity", "path", path)
+			skip("The model paths are not listed with the workload identity of service account %q", sa)
+			continue
+		}
+
+		storage, ok := storages[location.Scheme]
+		if !ok {
+			var err error
+			storage, err = r.modelStorage(ctx, namespace, location.Scheme, repository)
+			if err != nil {
+				return nil, err
+			}
+			storages[location.Scheme] = storage
+		}
+		if err := modelrepo.CheckModel(ctx, storage, location); err != nil {
+			return nil, err
+		}
+	}
+	if len(localPaths) > 0 {
+		if err := r.checkModelVolume(ctx, o, values, localPaths, skip); err != nil {
+			return nil, err
+		}
+	}
+	return notChecked, nil
+}
+
+// modelStorage returns the storage of the URL scheme, configured with the
+// credentials of the models_repository values.
+func (r HelmOperatorReconciler) modelStorage(ctx context.Context, namespace, scheme string,
+	repository map[string]interface{}) (modelrepo.Storage, error) {
+
+	httpClient, err := repositoryHTTPClient(repository)
+	if err != nil {
+		return nil, err
+	}
+	switch scheme {
+	case "s3":
+		accessKeyID, err := r.credential(ctx, namespace, repository, "aws_access_key_id")
+		if err != nil {
+			return nil, err
+		}
+		secretAccessKey, err := r.credential(ctx, namespace, repository, "aws_secret_access_key")
+		if err != nil {
+			return nil, err
+		}
+		endpoint, _, _ := unstructured.NestedString(repository, "s3_compat_api_endpoint")
+		if endpoint != "" && !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+		region, _, _ := unstructured.NestedString(repository, "aws_region")
+		return modelrepo.NewS3(modelrepo.S3Config{
+			Endpoint:        endpoint,
+			Region:          region,
+			AccessKeyID:     accessKeyID,
+			SecretAccessKey: secretAccessKey,
+			HTTPClient:      httpClient,
+		}), nil
+	case "gs":
+		var credentials []byte
+		if name, _, _ := unstructured.NestedString(repository, "gcp_creds_secret_name"); name != "" {
+			secret := &corev1.Secret{}
+			if err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, secret); err != nil {
+				return nil, fmt.Errorf("failed to get models_repository.gcp_creds_secret_name: %w", err)
+			}
+			credentials = secret.Data[gcpCredentialsKey]
+		}
+		return modelrepo.NewGCS(ctx, modelrepo.GCSConfig{CredentialsJSON: credentials, HTTPClient: httpClient})
+	case "az":
+		connectionString, err := r.credential(ctx, namespace, repository, "azure_storage_connection_string")
+		if err != nil {
+			return nil, err
+		}
+		return modelrepo.NewAzure(modelrepo.AzureConfig{ConnectionString: connectionString, HTTPClient: httpClient})
+	}
+	return nil, fmt.Errorf("unsupported model storage %q", scheme)
+}
+
+// credential returns the value of a models_repository credential, either
+// set in clear text or referenced from a Secret.
+func (r HelmOperatorReconciler) credential(ctx context.Context, namespace string,
+	repository map[string]interface{}, field string) (string, error) {
+
+	return repositoryCredential(ctx, r.APIReader, namespace, repository, field)
+}
+
+func repositoryCredential(ctx context.Context, cl client.Reader, namespace string,
+	repository map[string]interface{}, field string) (string, error) {
+
+	ref := deprecatedCredentialFields[field]
+	selector, found, _ := unstructured.NestedStringMap(repository, ref)
+	if !found {
+		value, _, _ := unstructured.NestedString(repository, field)
+		return value, nil
+	}
+	secret := &corev1.Secret{}
+	if err := cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: selector["name"]}, secret); err != nil {
+		return "", fmt.Errorf("failed to get models_repository.%s: %w", ref, err)
+	}
+	return string(secret.Data[selector["key"]]), nil
+}
+
+// repositoryHTTPClient returns the client used to reach the model storage,
+// sending the requests through the proxy configured for the model server.
+func repositoryHTTPClient(repository map[string]interface{}) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	for _, field := range []string{"https_proxy", "http_proxy"} {
+		proxy, _, _ := unstructured.NestedString(repository, field)
+		if proxy == "" {
+			continue
+		}
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid models_repository.%s: %w", field, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+		break
+	}
+	return &http.Client{Transport: transport, Timeout: repositoryCheckTimeout}, nil
+}
@@ -0,0 +1,221 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+)
+
+func TestModelPaths(t *testing.T) {
+	assert.Equal(t, []string{"s3://models/resnet"}, modelPaths(map[string]interface{}{
+		"models_settings": map[string]interface{}{"single_model_mode": true, "model_path": "s3://models/resnet"},
+	}))
+	assert.Equal(t, []string{"gs://models/resnet", "/models/face"}, modelPaths(map[string]interface{}{
+		"models_settings": map[string]interface{}{
+			"single_model_mode": false,
+			"models": []interface{}{
+				map[string]interface{}{"name": "resnet", "base_path": "gs://models/resnet"},
+				map[string]interface{}{"name": "face", "base_path": "/models/face"},
+			},
+		},
+	}))
+}
+
+func TestCheckModelRepository(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>Access Denied.</Message></Error>`))
+			return
+		}
+		switch r.URL.Query().Get("prefix") {
+		case "resnet/":
+			_, _ = w.Write([]byte(`<ListBucketResult><CommonPrefixes><Prefix>resnet/1/</Prefix></CommonPrefixes></ListBucketResult>`))
+		case "flat/":
+			_, _ = w.Write([]byte(`<ListBucketResult><Contents><Key>flat/model.xml</Key></Contents></ListBucketResult>`))
+		default:
+			_, _ = w.Write([]byte(`<ListBucketResult></ListBucketResult>`))
+		}
+	}))
+	defer server.Close()
+
+	cl := fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "s3-creds"},
+			Data:       map[string][]byte{"access_key_id": []byte("minio"), "secret_access_key": []byte("minio123")},
+		},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "models"}},
+	).Build()
+	r := HelmOperatorReconciler{Client: cachedClient(cl), APIReader: cl}
+	s3 := map[string]interface{}{
+		"storage_type":                     "S3",
+		"s3_compat_api_endpoint":           server.URL,
+		"aws_access_key_id_secret_ref":     map[string]interface{}{"name": "s3-creds", "key": "access_key_id"},
+		"aws_secret_access_key_secret_ref": map[string]interface{}{"name": "s3-creds", "key": "secret_access_key"},
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]interface{}
+		path        string
+		repository  map[string]interface{}
+		noImage     bool
+		pending     bool
+		status      types.ConditionStatus
+		reason      types.HelmAppConditionReason
+		message     string
+		wantErr     string
+	}{
+		{
+			name:       "valid layout",
+			path:       "s3://models/resnet",
+			repository: s3,
+			status:     types.StatusTrue,
+			reason:     types.ReasonModelLayoutValid,
+			message:    "The model paths include version directories",
+		},
+		{
+			name:       "missing path",
+			path:       "s3://models/missing",
+			repository: s3,
+			wantErr:    "model path s3://models/missing: no objects under the path: not found",
+		},
+		{
+			name:       "missing version directory",
+			path:       "s3://models/flat",
+			repository: s3,
+			wantErr: "model path s3://models/flat does not include any version directory, " +
+				"expected the model files in s3://models/flat/<version>/",
+		},
+		{
+			name:       "missing credentials",
+			path:       "s3://models/resnet",
+			repository: map[string]interface{}{"s3_compat_api_endpoint": server.URL},
+			wantErr:    `failed to list model path s3://models/resnet: bucket "models": AccessDenied Access Denied.`,
+		},
+		{
+			name:        "check disabled",
+			annotations: map[string]interface{}{checkRepositoryAnnotation: "false"},
+			path:        "s3://models/missing",
+			repository:  s3,
+		},
+		{
+			name:       "workload identity",
+			path:       "s3://models/missing",
+			repository: map[string]interface{}{"workload_identity_service_account": "ovms"},
+			status:     types.StatusUnknown,
+			reason:     types.ReasonNotChecked,
+			message:    `The model paths are not listed with the workload identity of service account "ovms"`,
+		},
+		{
+			name:       "volume claim",
+			path:       "/models/resnet",
+			repository: map[string]interface{}{"models_volume_claim": "models"},
+			pending:    true,
+		},
+		{
+			name:       "missing volume claim",
+			path:       "/models/resnet",
+			repository: map[string]interface{}{"models_volume_claim": "missing"},
+			wantErr:    `persistent volume claim "missing" set in models_repository.models_volume_claim not found`,
+		},
+		{
+			name:       "host path",
+			path:       "/models/resnet",
+			repository: map[string]interface{}{"models_host_path": "/opt/models"},
+			pending:    true,
+		},
+		{
+			name:       "local path",
+			path:       "/models/resnet",
+			repository: map[string]interface{}{},
+			pending:    true,
+		},
+		{
+			name:       "local path without image",
+			path:       "/models/resnet",
+			repository: map[string]interface{}{},
+			noImage:    true,
+			status:     types.StatusUnknown,
+			reason:     types.ReasonNotChecked,
+			message:    "The layout of the local model paths is not checked without image_name",
+		},
+		{
+			name:    "no model path",
+			status:  types.StatusUnknown,
+			reason:  types.ReasonNotChecked,
+			message: "No model path is set",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o := &unstructured.Unstructured{Object: map[string]interface{}{
+				"metadata": map[string]interface{}{"namespace": "ns", "name": "ovms", "annotations": test.annotations},
+			}}
+			status := &types.HelmAppStatus{}
+			values := map[string]interface{}{
+				"image_name":        "openvino/model_server:latest",
+				"models_settings":   map[string]interface{}{"single_model_mode": true, "model_path": test.path},
+				"models_repository": test.repository,
+			}
+			if test.noImage {
+				delete(values, "image_name")
+			}
+			err := r.checkModelRepository(context.TODO(), o, status, values)
+			c := status.GetCondition(types.ConditionRepositoryChecked)
+			if test.pending {
+				assert.ErrorIs(t, err, errLayoutCheckPending)
+				if assert.NotNil(t, c) {
+					assert.Equal(t, types.StatusUnknown, c.Status)
+					assert.Equal(t, types.ReasonModelLayoutChecking, c.Reason)
+				}
+				return
+			}
+			if test.wantErr != "" {
+				assert.EqualError(t, err, test.wantErr)
+				if assert.NotNil(t, c) {
+					assert.Equal(t, types.StatusFalse, c.Status)
+					assert.Equal(t, types.ReasonModelLayoutInvalid, c.Reason)
+					assert.Equal(t, test.wantErr, c.Message)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			if test.status == "" {
+				assert.Nil(t, c)
+				return
+			}
+			if assert.NotNil(t, c) {
+				assert.Equal(t, test.status, c.Status)
+				assert.Equal(t, test.reason, c.Reason)
+				assert.Equal(t, test.message, c.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	rpb "helm.sh/helm/v3/pkg/release"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+)
+
+const (
+	rolloutRollingUpdate = "RollingUpdate"
+	rolloutCanary        = "Canary"
+	rolloutBlueGreen     = "BlueGreen"
+
+	// trackLabel marks the Deployment and Service of the canary.
+	trackLabel  = "track"
+	canaryTrack = "canary"
+
+	defaultCanaryWeight   = 20
+	defaultAnalysisPeriod = time.Minute
+	defaultRolloutTimeout = 10 * time.Minute
+	// rolloutStepPeriod is the delay before applying the next rollout phase.
+	rolloutStepPeriod = time.Second
+)
+
+// rolloutValueKeys are the ModelServer values whose changes are rolled out
+// with the canary or blue/green strategy.
+var rolloutValueKeys = []string{"image_name", "models_settings"}
+
+// RolloutOptions configures the rollout of model or image changes, set in
+// the rollout section of the ModelServer values.
+type RolloutOptions struct {
+	// Strategy is RollingUpdate, Canary or BlueGreen.
+	Strategy string
+	// CanaryWeight is the percentage of the traffic sent to the canary.
+	CanaryWeight int64
+	// AnalysisPeriod is the time the canary must stay ready before it is
+	// promoted.
+	AnalysisPeriod time.Duration
+	// Timeout is the time the canary has to become ready before the
+	// rollout is aborted.
+	Timeout time.Duration
+	// MaxErrorRate is the highest ratio of failed inference requests
+	// accepted on the canary, or nil if not checked.
+	MaxErrorRate *float64
+}
+
+// Enabled reports whether changes are rolled out alongside the stable
+// model server.
+func (o RolloutOptions) Enabled() bool {
+	return o.Strategy == rolloutCanary || o.Strategy == rolloutBlueGreen
+}
+
+func rolloutOptionsFor(values map[string]interface{}) (RolloutOptions, error) {
+	o := RolloutOptions{
+		Strategy:       rolloutRollingUpdate,
+		CanaryWeight:   defaultCanaryWeight,
+		AnalysisPeriod: defaultAnalysisPeriod,
+		Timeout:        defaultRolloutTimeout,
+	}
+	rollout, _, _ := unstructured.NestedMap(values, "rollout")
+	if strategy, ok := rollout["strategy"].(string); ok && strategy != "" {
+		o.Strategy = strategy
+	}
+	switch o.Strategy {
+	case rolloutRollingUpdate, rolloutCanary, rolloutBlueGreen:
+	default:
+		return o, fmt.Errorf("invalid rollout.strategy %q, expected RollingUpdate, Canary or BlueGreen", o.Strategy)
+	}
+	if weight, found, err := unstructured.NestedInt64(rollout, "canary_weight"); err != nil || found && (weight <= 0 || weight >= 100) {
+		return o, fmt.Errorf("invalid rollout.canary_weight, expected a percentage between 1 and 99")
+	} else if found {
+		o.CanaryWeight = weight
+	}
+	for field, d := range map[string]*time.Duration{"analysis_period": &o.AnalysisPeriod, "timeout": &o.Timeout} {
+		value, _, _ := unstructured.NestedString(rollout, field)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			return o, fmt.Errorf("invalid rollout.%s %q", field, value)
+		}
+		*d = parsed
+	}
+	if rate, found := rollout["max_error_rate"]; found {
+		var value float64
+		switch v := rate.(type) {
+		case float64:
+			value = v
+		case int64:
+			value = float64(v)
+		default:
+			return o, fmt.Errorf("invalid rollout.max_error_rate %v", rate)
+		}
+		if value < 0 || value > 1 {
+			return o, fmt.Errorf("invalid rollout.max_error_rate %v, expected a ratio between 0 and 1", value)
+		}
+		o.MaxErrorRate = &value
+	}
+	return o, nil
+}
+
+// rolloutSpec returns a copy of the values rolled out with the canary or
+// blue/green strategy.
+func rolloutSpec(values map[string]interface{}) map[string]interface{} {
+	spec := map[string]interface{}{}
+	for _, key := range rolloutValueKeys {
+		if v, ok := values[key]; ok {
+			spec[key] = v
+		}
+	}
+	b, _ := json.Marshal(spec)
+	out := map[string]interface{}{}
+	_ = json.Unmarshal(b, &out)
+	return out
+}
+
+func equalSpec(a, b map[string]interface{}) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
+
+// applyRollout advances the rollout recorded in the status when the values
+// rolled out change, and replaces them in the chart values with the ones
+// of the stable model server. It returns the values of the canary, or nil
+// if no canary is deployed.
+func applyRollout(status *types.HelmAppStatus, values map[string]interface{}, o RolloutOptions,
+	now time.Time) map[string]interface{} {
+
+	if !o.Enabled() {
+		status.Rollout = nil
+		return nil
+	}
+	desired := rolloutSpec(values)
+	ro := status.Rollout
+	if ro == nil {
+		status.Rollout = &types.RolloutStatus{Strategy: o.Strategy, Phase: types.RolloutSucceeded, Stable: desired}
+		return nil
+	}
+	ro.Strategy = o.Strategy
+
+	start := func() {
+		ro.Phase = types.RolloutProgressing
+		ro.Message = "Waiting for the canary to become ready"
+		ro.Canary = desired
+		ro.StartTime = &metav1.Time{Time: now}
+		ro.ReadySince = nil
+	}
+	switch ro.Phase {
+	case types.RolloutProgressing:
+		if equalSpec(desired, ro.Stable) {
+			ro.Phase = types.RolloutAborted
+			ro.Message = "Rollout cancelled, the spec was reverted"
+			ro.Canary = nil
+		} else if !equalSpec(desired, ro.Canary) {
+			start()
+		}
+	case types.RolloutPromoting:
+		// the promotion completes before the next change is rolled out
+	default:
+		if equalSpec(desired, ro.Stable) {
+			ro.Canary = nil
+		} else if ro.Phase != types.RolloutAborted || !equalSpec(desired, ro.Canary) {
+			start()
+		}
+	}
+
+	stable := ro.Stable
+	if ro.Phase == types.RolloutPromoting {
+		stable = ro.Canary
+	}
+	for _, key := range rolloutValueKeys {
+		delete(values, key)
+		if v, ok := stable[key]; ok {
+			values[key] = v
+		}
+	}
+	if !ro.Active() {
+		return nil
+	}
+	canary := map[string]interface{}{}
+	for k, v := range ro.Canary {
+		canary[k] = v
+	}
+	return canary
+}
+
+// canaryValues returns the values of the canary Deployment, merged by the
+// chart over the stable values.
+func canaryValues(canary, values map[string]interface{}, o RolloutOptions) map[string]interface{} {
+	replicas, found, _ := unstructured.NestedInt64(values, "deployment_parameters", "replicas")
+	if autoscaling, _, _ := unstructured.NestedBool(values, "autoscaling", "enabled"); autoscaling {
+		// the canary is not autoscaled and starts from the minimum replicas
+		replicas, found, _ = unstructured.NestedInt64(values, "autoscaling", "min_replicas")
+	}
+	if !found || replicas < 1 {
+		replicas = 1
+	}
+	if o.Strategy == rolloutCanary {
+		// the canary pods join the stable ones behind the Service
+		replicas = (replicas*o.CanaryWeight + (100-o.CanaryWeight)/2) / (100 - o.CanaryWeight)
+		if replicas < 1 {
+			replicas = 1
+		}
+	}
+	canary["deployment_parameters"] = map[string]interface{}{"replicas": replicas}
+	if o.MaxErrorRate != nil {
+		canary["monitoring"] = map[string]interface{}{"metrics_enable": true}
+	}
+	return canary
+}
+
+// advanceRollout checks the readiness of the release, and optionally the
+// error rate of the canary, and promotes or aborts the rollout. It returns
+// the delay before the rollout should be checked again.
+func (r HelmOperatorReconciler) advanceRollout(ctx context.Context, o *unstructured.Unstructured,
+	status *types.HelmAppStatus, rel *rpb.Release, opts RolloutOptions, ready bool, now time.Time) time.Duration {
+
+	ro := status.Rollout
+
+	if ro.Phase == types.RolloutPromoting {
+		if !ready {
+			return releaseProgressPeriod
+		}
+		ro.Phase = types.RolloutSucceeded
+		ro.Message = "Rollout completed"
+		ro.Stable = ro.Canary
+		ro.Canary = nil
+		ro.ReadySince = nil
+		r.EventRecorder.Event(o, "Normal", "RolloutSucceeded", "Promoted the canary to stable")
+		return rolloutStepPeriod
+	}
+
+	if !ready {
+		ro.ReadySince = nil
+		if ro.StartTime != nil && now.Sub(ro.StartTime.Time) > opts.Timeout {
+			r.abortRollout(o, ro, fmt.Sprintf("Canary not ready within %s", opts.Timeout))
+			return rolloutStepPeriod
+		}
+		ro.Message = "Waiting for the canary to become ready"
+		return releaseProgressPeriod
+	}
+	if ro.ReadySince == nil {
+		ro.ReadySince = &metav1.Time{Time: now}
+	}
+	if remaining := opts.AnalysisPeriod - now.Sub(ro.ReadySince.Time); remaining > 0 {
+		ro.Message = "Analyzing the canary"
+		if remaining > releaseProgressPeriod {
+			remaining = releaseProgressPeriod
+		}
+		return remaining
+	}
+
+	if opts.MaxErrorRate != nil {
+		rate, err := r.canaryErrorRate(ctx, rel, status.TLS)
+		if err != nil {
+			ro.Message = fmt.Sprintf("Failed to get the canary metrics: %v", err)
+			return releaseProgressPeriod
+		}
+		if rate > *opts.MaxErrorRate {
+			r.abortRollout(o, ro, fmt.Sprintf("Canary error rate %.3f exceeds %.3f", rate, *opts.MaxErrorRate))
+			return rolloutStepPeriod
+		}
+	}
+	ro.Phase = types.RolloutPromoting
+	ro.Message = "Promoting the canary"
+	r.EventRecorder.Event(o, "Normal", "RolloutPromoting", "Canary passed the analysis, promoting it to stable")
+	return rolloutStepPeriod
+}
+
+func (r HelmOperatorReconciler) abortRollout(o *unstructured.Unstructured, ro *types.RolloutStatus, message string) {
+	ro.Phase = types.RolloutAborted
+	ro.Message = message
+	ro.ReadySince = nil
+	r.EventRecorder.Event(o, "Warning", "RolloutAborted", message)
+}
+
+// canaryErrorRate returns the ratio of failed inference requests served by
+// the canary.
+func (r HelmOperatorReconciler) canaryErrorRate(ctx context.Context, rel *rpb.Release,
+	tls *types.TLSStatus) (float64, error) {
+
+	endpoint, err := serviceEndpoint(rel, canaryTrack)
+	if err != nil {
+		return 0, err
+	}
+	client, err := modelClientFor(ctx, r.APIReader, r.ModelClient, rel.Namespace, tls)
+	if err != nil {
+		return 0, err
+	}
+	metrics, err := client.Metrics(ctx, endpoint)
+	if err != nil {
+		return 0, err
+	}
+	success, fail := metrics["ovms_requests_success"], metrics["ovms_requests_fail"]
+	if success+fail == 0 {
+		return 0, nil
+	}
+	return fail / (success + fail), nil
+}
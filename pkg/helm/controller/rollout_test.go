@@ -0,0 +1,227 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	rpb "helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+)
+
+const testCanaryManifest = testModelServerManifest + `---
+# Source: ovms/templates/service.yaml
+kind: Service
+apiVersion: v1
+metadata:
+  name: sample-ovms-canary
+  labels:
+    track: canary
+spec:
+  ports:
+    - port: 9001
+      name: rest
+`
+
+func TestRolloutOptionsFor(t *testing.T) {
+	rate := 0.1
+	tests := []struct {
+		name     string
+		rollout  map[string]interface{}
+		expected RolloutOptions
+		wantErr  bool
+	}{
+		{
+			name:    "defaults",
+			rollout: nil,
+			expected: RolloutOptions{Strategy: rolloutRollingUpdate, CanaryWeight: defaultCanaryWeight,
+				AnalysisPeriod: defaultAnalysisPeriod, Timeout: defaultRolloutTimeout},
+		},
+		{
+			name: "canary",
+			rollout: map[string]interface{}{"strategy": "Canary", "canary_weight": int64(10),
+				"analysis_period": "30s", "timeout": "5m", "max_error_rate": rate},
+			expected: RolloutOptions{Strategy: rolloutCanary, CanaryWeight: 10,
+				AnalysisPeriod: 30 * time.Second, Timeout: 5 * time.Minute, MaxErrorRate: &rate},
+		},
+		{name: "invalid strategy", rollout: map[string]interface{}{"strategy": "Recreate"}, wantErr: true},
+		{name: "invalid weight", rollout: map[string]interface{}{"canary_weight": int64(100)}, wantErr: true},
+		{name: "invalid period", rollout: map[string]interface{}{"analysis_period": "soon"}, wantErr: true},
+		{name: "invalid error rate", rollout: map[string]interface{}{"max_error_rate": 1.5}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values := map[string]interface{}{}
+			if test.rollout != nil {
+				values["rollout"] = test.rollout
+			}
+			o, err := rolloutOptionsFor(values)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, o)
+		})
+	}
+}
+
+func TestApplyRollout(t *testing.T) {
+	opts := RolloutOptions{Strategy: rolloutCanary, CanaryWeight: 20}
+	now := time.Now()
+	valuesWith := func(image string) map[string]interface{} {
+		return map[string]interface{}{"image_name": image, "service_parameters": map[string]interface{}{}}
+	}
+
+	status := &types.HelmAppStatus{}
+	assert.Nil(t, applyRollout(status, valuesWith("ovms:1"), opts, now))
+	assert.Equal(t, types.RolloutSucceeded, status.Rollout.Phase)
+	assert.Equal(t, map[string]interface{}{"image_name": "ovms:1"}, status.Rollout.Stable)
+
+	values := valuesWith("ovms:2")
+	canary := applyRollout(status, values, opts, now)
+	assert.Equal(t, map[string]interface{}{"image_name": "ovms:2"}, canary)
+	assert.Equal(t, "ovms:1", values["image_name"])
+	assert.Equal(t, types.RolloutProgressing, status.Rollout.Phase)
+	assert.Equal(t, now, status.Rollout.StartTime.Time)
+
+	// a promoted canary replaces the stable values
+	status.Rollout.Phase = types.RolloutPromoting
+	values = valuesWith("ovms:2")
+	assert.NotNil(t, applyRollout(status, values, opts, now))
+	assert.Equal(t, "ovms:2", values["image_name"])
+
+	// a reverted spec cancels the rollout
+	status.Rollout.Phase = types.RolloutProgressing
+	values = valuesWith("ovms:1")
+	assert.Nil(t, applyRollout(status, values, opts, now))
+	assert.Equal(t, types.RolloutAborted, status.Rollout.Phase)
+	assert.Equal(t, "ovms:1", values["image_name"])
+
+	// an aborted canary is not deployed again until the spec changes
+	status.Rollout.Canary = map[string]interface{}{"image_name": "ovms:2"}
+	assert.Nil(t, applyRollout(status, valuesWith("ovms:2"), opts, now))
+	assert.NotNil(t, applyRollout(status, valuesWith("ovms:3"), opts, now))
+	assert.Equal(t, types.RolloutProgressing, status.Rollout.Phase)
+
+	assert.Nil(t, applyRollout(status, valuesWith("ovms:3"), RolloutOptions{Strategy: rolloutRollingUpdate}, now))
+	assert.Nil(t, status.Rollout)
+}
+
+func TestCanaryValues(t *testing.T) {
+	rate := 0.1
+	values := map[string]interface{}{"deployment_parameters": map[string]interface{}{"replicas": int64(8)}}
+
+	canary := canaryValues(map[string]interface{}{}, values, RolloutOptions{Strategy: rolloutCanary, CanaryWeight: 20})
+	assert.Equal(t, map[string]interface{}{"replicas": int64(2)}, canary["deployment_parameters"])
+	assert.NotContains(t, canary, "monitoring")
+
+	canary = canaryValues(map[string]interface{}{}, map[string]interface{}{},
+		RolloutOptions{Strategy: rolloutCanary, CanaryWeight: 1})
+	assert.Equal(t, map[string]interface{}{"replicas": int64(1)}, canary["deployment_parameters"])
+
+	canary = canaryValues(map[string]interface{}{}, values, RolloutOptions{Strategy: rolloutBlueGreen, MaxErrorRate: &rate})
+	assert.Equal(t, map[string]interface{}{"replicas": int64(8)}, canary["deployment_parameters"])
+	assert.Equal(t, map[string]interface{}{"metrics_enable": true}, canary["monitoring"])
+}
+
+func TestAdvanceRollout(t *testing.T) {
+	rate := 0.01
+	opts := RolloutOptions{Strategy: rolloutCanary, AnalysisPeriod: time.Minute, Timeout: 10 * time.Minute, MaxErrorRate: &rate}
+	start := time.Now()
+	client := &fakeModelClient{metrics: map[string]float64{"ovms_requests_success": 99, "ovms_requests_fail": 1}}
+	recorder := record.NewFakeRecorder(10)
+	r := HelmOperatorReconciler{GVK: schema.GroupVersionKind{Kind: "ModelServer"}, ModelClient: client,
+		EventRecorder: recorder}
+	o := &unstructured.Unstructured{}
+	rel := &rpb.Release{Namespace: "ns", Manifest: testCanaryManifest}
+
+	status := &types.HelmAppStatus{}
+	applyRollout(status, map[string]interface{}{"image_name": "ovms:1"}, opts, start)
+	applyRollout(status, map[string]interface{}{"image_name": "ovms:2"}, opts, start)
+
+	assert.Equal(t, releaseProgressPeriod, r.advanceRollout(context.TODO(), o, status, rel, opts, false, start))
+	assert.Equal(t, types.RolloutProgressing, status.Rollout.Phase)
+
+	assert.Equal(t, releaseProgressPeriod, r.advanceRollout(context.TODO(), o, status, rel, opts, true, start))
+	assert.Equal(t, start, status.Rollout.ReadySince.Time)
+	assert.Equal(t, "Analyzing the canary", status.Rollout.Message)
+
+	assert.Equal(t, rolloutStepPeriod, r.advanceRollout(context.TODO(), o, status, rel, opts, true, start.Add(time.Minute)))
+	assert.Equal(t, types.RolloutPromoting, status.Rollout.Phase)
+	assert.Equal(t, "http://sample-ovms-canary.ns.svc:9001", client.endpoint)
+	assert.Equal(t, "Normal RolloutPromoting Canary passed the analysis, promoting it to stable", <-recorder.Events)
+
+	r.advanceRollout(context.TODO(), o, status, rel, opts, true, start.Add(time.Minute))
+	assert.Equal(t, types.RolloutSucceeded, status.Rollout.Phase)
+	assert.Equal(t, map[string]interface{}{"image_name": "ovms:2"}, status.Rollout.Stable)
+	assert.Nil(t, status.Rollout.Canary)
+	assert.Equal(t, "Normal RolloutSucceeded Promoted the canary to stable", <-recorder.Events)
+
+	// the error rate exceeds the limit
+	applyRollout(status, map[string]interface{}{"image_name": "ovms:3"}, opts, start)
+	client.metrics["ovms_requests_fail"] = 5
+	r.advanceRollout(context.TODO(), o, status, rel, opts, true, start)
+	r.advanceRollout(context.TODO(), o, status, rel, opts, true, start.Add(time.Minute))
+	assert.Equal(t, types.RolloutAborted, status.Rollout.Phase)
+	assert.Equal(t, "Warning RolloutAborted Canary error rate 0.048 exceeds 0.010", <-recorder.Events)
+
+	// the canary does not become ready in time
+	applyRollout(status, map[string]interface{}{"image_name": "ovms:4"}, opts, start)
+	r.advanceRollout(context.TODO(), o, status, rel, opts, false, start.Add(11*time.Minute))
+	assert.Equal(t, types.RolloutAborted, status.Rollout.Phase)
+	assert.Equal(t, "Canary not ready within 10m0s", status.Rollout.Message)
+}
+
+func TestRenderValuesCanary(t *testing.T) {
+	r := HelmOperatorReconciler{GVK: schema.GroupVersionKind{Kind: "ModelServer"}}
+	settings := func(path string) map[string]interface{} {
+		return map[string]interface{}{"single_model_mode": false, "models": []interface{}{
+			map[string]interface{}{"name": "resnet", "base_path": path},
+		}}
+	}
+	valuesWith := func(path string) map[string]interface{} {
+		return map[string]interface{}{
+			"rollout":               map[string]interface{}{"strategy": "BlueGreen"},
+			"deployment_parameters": map[string]interface{}{"replicas": int64(3)},
+			"models_settings":       settings(path),
+		}
+	}
+
+	status := &types.HelmAppStatus{}
+	assert.NoError(t, r.renderValues(context.TODO(), testModelServer("default"), status, valuesWith("/models/v1")))
+
+	values := valuesWith("/models/v2")
+	assert.NoError(t, r.renderValues(context.TODO(), testModelServer("default"), status, values))
+	assert.Equal(t, settings("/models/v1"), values["models_settings"])
+	generated := values[generatedValuesKey].(map[string]interface{})
+	assert.Contains(t, generated["models_config"], "/models/v1")
+	canary := generated["canary"].(map[string]interface{})
+	assert.Equal(t, false, canary["join_service"])
+	assert.Equal(t, false, canary["serve_traffic"])
+	canaryValues := canary["values"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"replicas": int64(3)}, canaryValues["deployment_parameters"])
+	assert.Contains(t, canaryValues[generatedValuesKey].(map[string]interface{})["models_config"], "/models/v2")
+}
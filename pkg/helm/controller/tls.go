@@ -0,0 +1,428 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/ovms"
+)
+
+const (
+	// Suffixes of the Secrets managed by the operator when the tls section
+	// of a ModelServer does not reference a server certificate.
+	caSecretSuffix     = "-ca"
+	serverSecretSuffix = "-server-tls"
+	clientSecretSuffix = "-client-tls"
+
+	caCertKey = "ca.crt"
+	caKeyKey  = "ca.key"
+
+	caValidity          = 10 * 365 * 24 * time.Hour
+	certificateValidity = 365 * 24 * time.Hour
+	// certificateRenewBefore is the time before the expiry of a managed
+	// certificate at which it is renewed.
+	certificateRenewBefore = 30 * 24 * time.Hour
+)
+
+// TLSOptions configures TLS on the gRPC and REST ports of the model server,
+// set in the tls section of the ModelServer values.
+type TLSOptions struct {
+	Enabled bool
+	// SecretName is the kubernetes.io/tls Secret with the server
+	// certificate. The operator issues the certificate from a self-signed CA
+	// if it is empty.
+	SecretName string
+	// ClientAuth requires client certificates (mTLS).
+	ClientAuth bool
+	// ClientCASecret is the Secret with the ca.crt verifying the client
+	// certificates. The self-signed CA is used if it is empty.
+	ClientCASecret string
+	// ClientSecret is the kubernetes.io/tls Secret with the client
+	// certificate used by the operator with ClientCASecret.
+	ClientSecret string
+}
+
+// Managed reports whether the operator issues the certificates.
+func (o TLSOptions) Managed() bool {
+	return o.Enabled && o.SecretName == ""
+}
+
+func tlsOptionsFor(values map[string]interface{}) (TLSOptions, error) {
+	settings, _, _ := unstructured.NestedMap(values, "tls")
+	o := TLSOptions{}
+	o.Enabled, _ = settings["enabled"].(bool)
+	o.SecretName, _ = settings["secret_name"].(string)
+	o.ClientAuth, _ = settings["client_auth"].(bool)
+	o.ClientCASecret, _ = settings["client_ca_secret"].(string)
+	o.ClientSecret, _ = settings["client_secret"].(string)
+	if !o.Enabled {
+		return o, nil
+	}
+	if !o.ClientAuth && (o.ClientCASecret != "" || o.ClientSecret != "") {
+		return o, errors.New("tls.client_ca_secret and tls.client_secret require tls.client_auth")
+	}
+	if o.ClientAuth && o.SecretName != "" && o.ClientCASecret == "" {
+		return o, errors.New("tls.client_auth with tls.secret_name requires tls.client_ca_secret")
+	}
+	if o.ClientCASecret != "" && o.ClientSecret == "" {
+		return o, errors.New("tls.client_ca_secret requires tls.client_secret, used by the operator to query the model server")
+	}
+	return o, nil
+}
+
+// tlsSecrets returns the names of the Secrets referenced in the tls section
+// of the ModelServer values, whose changes are rolled out to the pods.
+func tlsSecrets(values map[string]interface{}) []string {
+	o, err := tlsOptionsFor(values)
+	if err != nil || !o.Enabled {
+		return nil
+	}
+	var secrets []string
+	for _, name := range []string{o.SecretName, o.ClientCASecret, o.ClientSecret} {
+		if name != "" {
+			secrets = append(secrets, name)
+		}
+	}
+	return secrets
+}
+
+// renderTLS issues or renews the managed certificates of the ModelServer,
+// records the server certificate in the status and sets the Secrets mounted
+// by the TLS proxy of the model server pods. It returns the names of these
+// Secrets.
+func (r HelmOperatorReconciler) renderTLS(ctx context.Context, o *unstructured.Unstructured, status *types.HelmAppStatus,
+	values, generated map[string]interface{}, now time.Time) ([]string, error) {
+
+	opts, err := tlsOptionsFor(values)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Enabled {
+		status.TLS = nil
+		return nil, nil
+	}
+
+	serverSecret, clientCASecret, clientSecret := opts.SecretName, opts.ClientCASecret, opts.ClientSecret
+	if opts.Managed() {
+		serverSecret = o.GetName() + serverSecretSuffix
+		if opts.ClientAuth && clientCASecret == "" {
+			clientCASecret = o.GetName() + caSecretSuffix
+			clientSecret = o.GetName() + clientSecretSuffix
+		}
+		if err := r.ensureCertificates(ctx, o, values, clientSecret != "" && opts.ClientCASecret == "", now); err != nil {
+			return nil, err
+		}
+	}
+
+	secret := &corev1.Secret{}
+	found, err := r.getReferenced(ctx, o.GetNamespace(), serverSecret, secret)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("TLS secret %q not found", serverSecret)
+	}
+	cert, err := parseCertificate(secret.Data[corev1.TLSCertKey])
+	if err != nil || len(secret.Data[corev1.TLSPrivateKeyKey]) == 0 {
+		return nil, fmt.Errorf("TLS secret %q must include a PEM certificate in %s and its key in %s", serverSecret,
+			corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+	}
+
+	out := map[string]interface{}{"server_secret": serverSecret}
+	if ca := secret.Data[caCertKey]; len(ca) > 0 {
+		out["ca_certificate"] = string(ca)
+	}
+	secrets := []string{serverSecret}
+	if opts.ClientAuth {
+		out["client_ca_secret"] = clientCASecret
+		out["client_secret"] = clientSecret
+		secrets = append(secrets, clientCASecret)
+	}
+	generated["tls"] = out
+
+	notAfter := metav1.NewTime(cert.NotAfter)
+	status.TLS = &types.TLSStatus{ServerSecret: serverSecret, ClientSecret: clientSecret, NotAfter: &notAfter}
+	return secrets, nil
+}
+
+// ensureCertificates creates the self-signed CA of the ModelServer and the
+// server certificate of the model server Services, and a client certificate
+// if requested. Missing, invalid or expiring certificates are issued again,
+// so that the new content is rolled out to the pods.
+func (r HelmOperatorReconciler) ensureCertificates(ctx context.Context, o *unstructured.Unstructured,
+	values map[string]interface{}, withClient bool, now time.Time) error {
+
+	name := o.GetName()
+	ca, caRenewed, err := r.ensureKeyPair(ctx, o, name+caSecretSuffix, nil, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name + " model server CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, caValidity, false, now)
+	if err != nil {
+		return err
+	}
+	_, _, err = r.ensureKeyPair(ctx, o, name+serverSecretSuffix, ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: modelServerFullname(name, values)},
+		DNSNames:    serviceDNSNames(o.GetNamespace(), modelServerFullname(name, values)),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, certificateValidity, caRenewed, now)
+	if err != nil {
+		return err
+	}
+	if withClient {
+		_, _, err = r.ensureKeyPair(ctx, o, name+clientSecretSuffix, ca, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: name + " model server client"},
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, certificateValidity, caRenewed, now)
+	}
+	return err
+}
+
+// keyPair is a certificate with its private key.
+type keyPair struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// ensureKeyPair returns the key pair stored in the Secret, issuing it with
+// the template and the CA, or self-signed if ca is nil, when it is missing,
+// expiring, does not match the template names or when force is set. It
+// returns true if the key pair was issued.
+func (r HelmOperatorReconciler) ensureKeyPair(ctx context.Context, o *unstructured.Unstructured, name string,
+	ca *keyPair, template *x509.Certificate, validity time.Duration, force bool, now time.Time) (*keyPair, bool, error) {
+
+	secret := &corev1.Secret{}
+	found, err := r.getReferenced(ctx, o.GetNamespace(), name, secret)
+	if err != nil {
+		return nil, false, err
+	}
+	certKey, keyKey := corev1.TLSCertKey, corev1.TLSPrivateKeyKey
+	if ca == nil {
+		certKey, keyKey = caCertKey, caKeyKey
+	}
+	if found && !force {
+		current, err := parseKeyPair(secret.Data[certKey], secret.Data[keyKey])
+		if err == nil && now.Before(current.cert.NotAfter.Add(-certificateRenewBefore)) &&
+			equalNames(current.cert.DNSNames, template.DNSNames) &&
+			(ca == nil || bytes.Equal(secret.Data[caCertKey], ca.certPEM)) {
+			return current, false, nil
+		}
+	}
+
+	pair, err := issueKeyPair(template, ca, validity, now)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to issue certificate %q: %w", name, err)
+	}
+	secret.Namespace, secret.Name = o.GetNamespace(), name
+	secret.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(o, o.GroupVersionKind())}
+	if ca == nil {
+		secret.Type = corev1.SecretTypeOpaque
+		secret.Data = map[string][]byte{caCertKey: pair.certPEM, caKeyKey: pair.keyPEM}
+	} else {
+		secret.Type = corev1.SecretTypeTLS
+		secret.Data = map[string][]byte{certKey: pair.certPEM, keyKey: pair.keyPEM, caCertKey: ca.certPEM}
+	}
+	if found {
+		err = r.Client.Update(ctx, secret)
+	} else {
+		err = r.Client.Create(ctx, secret)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to store certificate %q: %w", name, err)
+	}
+	reason, verb := "CertificateIssued", "Issued"
+	if found {
+		reason, verb = "CertificateRenewed", "Renewed"
+	}
+	r.EventRecorder.Eventf(o, "Normal", reason, "%s the certificate in Secret %s, valid until %s",
+		verb, name, pair.cert.NotAfter.UTC().Format(time.RFC3339))
+	return pair, true, nil
+}
+
+func issueKeyPair(template *x509.Certificate, ca *keyPair, validity time.Duration, now time.Time) (*keyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	cert := *template
+	cert.SerialNumber = serial
+	cert.NotBefore = now.Add(-time.Hour)
+	cert.NotAfter = now.Add(validity)
+	parent, signer := &cert, key
+	if ca != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &cert, parent, &key.PublicKey, signer)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return parseKeyPair(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func parseKeyPair(certPEM, keyPEM []byte) (*keyPair, error) {
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("invalid PEM key")
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &keyPair{cert: cert, key: key, certPEM: certPEM, keyPEM: keyPEM}, nil
+}
+
+// parseCertificate returns the first certificate of the PEM data.
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("invalid PEM certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func equalNames(a, b []string) bool {
+	a, b = append([]string{}, a...), append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
+
+// modelServerFullname returns the name of the model server Service, as
+// computed by the ovms.fullname template of the chart.
+func modelServerFullname(release string, values map[string]interface{}) string {
+	trunc := func(s string) string {
+		if len(s) > 63 {
+			s = s[:63]
+		}
+		return strings.TrimSuffix(s, "-")
+	}
+	if override, _ := values["fullnameOverride"].(string); override != "" {
+		return trunc(override)
+	}
+	name, _ := values["nameOverride"].(string)
+	if name == "" {
+		name = "ovms"
+	}
+	if strings.Contains(release, name) {
+		return trunc(release)
+	}
+	return trunc(release + "-" + name)
+}
+
+// serviceDNSNames returns the DNS names of the model server Service and of
+// the canary Service.
+func serviceDNSNames(namespace, fullname string) []string {
+	var names []string
+	for _, service := range []string{fullname, fullname + "-" + canaryTrack} {
+		names = append(names, service, service+"."+namespace, service+"."+namespace+".svc",
+			service+"."+namespace+".svc.cluster.local")
+	}
+	return append(names, "localhost")
+}
+
+// modelClientFor returns the client querying the model server. With TLS, it
+// trusts the CA of the server certificate and presents the client
+// certificate recorded in the status, otherwise it returns the default
+// client. The Secrets are not cached, so cl is expected to be an API reader.
+func modelClientFor(ctx context.Context, cl client.Reader, defaultClient ovms.Client, namespace string,
+	status *types.TLSStatus) (ovms.Client, error) {
+
+	if status == nil {
+		if defaultClient == nil {
+			defaultClient = ovms.NewClient(nil)
+		}
+		return defaultClient, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	server := &corev1.Secret{}
+	if err := cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: status.ServerSecret}, server); err != nil {
+		return nil, fmt.Errorf("failed to get TLS secret %q: %w", status.ServerSecret, err)
+	}
+	if ca := server.Data[caCertKey]; len(ca) > 0 {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("TLS secret %q includes an invalid %s", status.ServerSecret, caCertKey)
+		}
+	}
+	if status.ClientSecret != "" {
+		secret := &corev1.Secret{}
+		if err := cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: status.ClientSecret}, secret); err != nil {
+			return nil, fmt.Errorf("failed to get TLS secret %q: %w", status.ClientSecret, err)
+		}
+		cert, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate in TLS secret %q: %w", status.ClientSecret, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return ovms.NewClient(&http.Client{Timeout: 10 * time.Second, Transport: transport}), nil
+}
+
+// tlsRenewalDelay returns the time until the managed server certificate of
+// the ModelServer is renewed, or 0 if it is not managed.
+func tlsRenewalDelay(status *types.TLSStatus, name string, now time.Time) time.Duration {
+	if status == nil || status.NotAfter == nil || status.ServerSecret != name+serverSecretSuffix {
+		return 0
+	}
+	delay := status.NotAfter.Add(-certificateRenewBefore).Sub(now)
+	if delay < time.Second {
+		delay = time.Second
+	}
+	return delay
+}
@@ -0,0 +1,261 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+)
+
+func tlsValues(settings map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"tls": settings}
+}
+
+func tlsModelServer() *unstructured.Unstructured {
+	o := testModelServer("ns")
+	o.SetGroupVersionKind(schema.GroupVersionKind{Group: "intel.com", Version: "v1alpha1", Kind: "ModelServer"})
+	o.SetUID("uid")
+	return o
+}
+
+func TestTLSOptionsFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		want     TLSOptions
+		wantErr  string
+	}{
+		{
+			name: "disabled",
+			want: TLSOptions{},
+		},
+		{
+			name:     "managed mTLS",
+			settings: map[string]interface{}{"enabled": true, "client_auth": true},
+			want:     TLSOptions{Enabled: true, ClientAuth: true},
+		},
+		{
+			name:     "client CA without client auth",
+			settings: map[string]interface{}{"enabled": true, "client_ca_secret": "clients"},
+			wantErr:  "tls.client_ca_secret and tls.client_secret require tls.client_auth",
+		},
+		{
+			name:     "server secret without client CA",
+			settings: map[string]interface{}{"enabled": true, "secret_name": "server", "client_auth": true},
+			wantErr:  "tls.client_auth with tls.secret_name requires tls.client_ca_secret",
+		},
+		{
+			name: "client CA without client secret",
+			settings: map[string]interface{}{"enabled": true, "secret_name": "server", "client_auth": true,
+				"client_ca_secret": "clients"},
+			wantErr: "tls.client_ca_secret requires tls.client_secret, used by the operator to query the model server",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := tlsOptionsFor(tlsValues(tt.settings))
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, o)
+		})
+	}
+}
+
+func TestTLSSecrets(t *testing.T) {
+	assert.Empty(t, tlsSecrets(tlsValues(map[string]interface{}{"secret_name": "server"})))
+	assert.Empty(t, tlsSecrets(tlsValues(map[string]interface{}{"enabled": true})))
+	assert.Equal(t, []string{"server", "clients", "operator"}, tlsSecrets(tlsValues(map[string]interface{}{
+		"enabled": true, "secret_name": "server", "client_auth": true,
+		"client_ca_secret": "clients", "client_secret": "operator",
+	})))
+}
+
+func TestRenderTLSManaged(t *testing.T) {
+	o := tlsModelServer()
+	cl := fake.NewClientBuilder().Build()
+	recorder := record.NewFakeRecorder(10)
+	r := HelmOperatorReconciler{Client: cachedClient(cl), APIReader: cl, EventRecorder: recorder}
+	values := tlsValues(map[string]interface{}{"enabled": true, "client_auth": true})
+	now := time.Now()
+
+	status := &types.HelmAppStatus{}
+	generated := map[string]interface{}{}
+	mounted, err := r.renderTLS(context.TODO(), o, status, values, generated, now)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sample-server-tls", "sample-ca"}, mounted)
+	assert.Len(t, recorder.Events, 3)
+
+	ca := &corev1.Secret{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "ns", Name: "sample-ca"}, ca))
+	assert.Equal(t, "sample", ca.OwnerReferences[0].Name)
+	server := &corev1.Secret{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "ns", Name: "sample-server-tls"}, server))
+	assert.Equal(t, corev1.SecretTypeTLS, server.Type)
+	assert.Equal(t, ca.Data[caCertKey], server.Data[caCertKey])
+	assert.Equal(t, map[string]interface{}{
+		"server_secret":    "sample-server-tls",
+		"ca_certificate":   string(ca.Data[caCertKey]),
+		"client_ca_secret": "sample-ca",
+		"client_secret":    "sample-client-tls",
+	}, generated["tls"])
+	assert.Equal(t, "sample-server-tls", status.TLS.ServerSecret)
+	assert.Equal(t, "sample-client-tls", status.TLS.ClientSecret)
+
+	cert, err := parseCertificate(server.Data[corev1.TLSCertKey])
+	assert.NoError(t, err)
+	assert.Contains(t, cert.DNSNames, "sample-ovms.ns.svc")
+	assert.Contains(t, cert.DNSNames, "sample-ovms-canary.ns.svc")
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.Data[caCertKey])
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: "sample-ovms.ns.svc", Roots: roots})
+	assert.NoError(t, err)
+
+	// valid certificates are kept
+	_, err = r.renderTLS(context.TODO(), o, status, values, map[string]interface{}{}, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, recorder.Events, 3)
+
+	// the server and client certificates are renewed before they expire
+	renewal := tlsRenewalDelay(status.TLS, "sample", now)
+	assert.InDelta(t, (certificateValidity - certificateRenewBefore).Seconds(), renewal.Seconds(), 5)
+	_, err = r.renderTLS(context.TODO(), o, status, values, map[string]interface{}{}, now.Add(renewal+time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, recorder.Events, 5)
+	assert.True(t, strings.HasPrefix(<-recorder.Events, "Normal CertificateIssued"))
+	renewed := &corev1.Secret{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "ns", Name: "sample-server-tls"}, renewed))
+	assert.NotEqual(t, server.Data[corev1.TLSCertKey], renewed.Data[corev1.TLSCertKey])
+	assert.Equal(t, server.Data[caCertKey], renewed.Data[caCertKey])
+	assert.True(t, status.TLS.NotAfter.After(cert.NotAfter))
+
+	// disabling TLS clears the status
+	_, err = r.renderTLS(context.TODO(), o, status, map[string]interface{}{}, map[string]interface{}{}, now)
+	assert.NoError(t, err)
+	assert.Nil(t, status.TLS)
+}
+
+func TestRenderTLSSecret(t *testing.T) {
+	o := tlsModelServer()
+	cl := fake.NewClientBuilder().Build()
+	r := HelmOperatorReconciler{Client: cachedClient(cl), APIReader: cl, EventRecorder: record.NewFakeRecorder(10)}
+	values := tlsValues(map[string]interface{}{"enabled": true, "secret_name": "server"})
+
+	_, err := r.renderTLS(context.TODO(), o, &types.HelmAppStatus{}, values, map[string]interface{}{}, time.Now())
+	assert.EqualError(t, err, `TLS secret "server" not found`)
+
+	assert.NoError(t, cl.Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "server"},
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("invalid")},
+	}))
+	_, err = r.renderTLS(context.TODO(), o, &types.HelmAppStatus{}, values, map[string]interface{}{}, time.Now())
+	assert.EqualError(t, err, `TLS secret "server" must include a PEM certificate in tls.crt and its key in tls.key`)
+
+	pair, err := issueKeyPair(&x509.Certificate{DNSNames: []string{"ovms.example.com"}}, nil, time.Hour, time.Now())
+	assert.NoError(t, err)
+	assert.NoError(t, cl.Update(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "server", ResourceVersion: "1"},
+		Data:       map[string][]byte{corev1.TLSCertKey: pair.certPEM, corev1.TLSPrivateKeyKey: pair.keyPEM},
+	}))
+	status := &types.HelmAppStatus{}
+	generated := map[string]interface{}{}
+	mounted, err := r.renderTLS(context.TODO(), o, status, values, generated, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"server"}, mounted)
+	assert.Equal(t, map[string]interface{}{"server_secret": "server"}, generated["tls"])
+	assert.Equal(t, pair.cert.NotAfter.Unix(), status.TLS.NotAfter.Unix())
+	assert.Zero(t, tlsRenewalDelay(status.TLS, "sample", time.Now()))
+}
+
+func TestModelClientFor(t *testing.T) {
+	o := tlsModelServer()
+	cl := fake.NewClientBuilder().Build()
+	r := HelmOperatorReconciler{Client: cachedClient(cl), APIReader: cl, EventRecorder: record.NewFakeRecorder(10)}
+	status := &types.HelmAppStatus{}
+	_, err := r.renderTLS(context.TODO(), o, status,
+		tlsValues(map[string]interface{}{"enabled": true, "client_auth": true}), map[string]interface{}{}, time.Now())
+	assert.NoError(t, err)
+
+	secrets := map[string]*corev1.Secret{}
+	for _, name := range []string{"sample-ca", "sample-server-tls"} {
+		secrets[name] = &corev1.Secret{}
+		assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "ns", Name: name}, secrets[name]))
+	}
+	cert, err := tls.X509KeyPair(secrets["sample-server-tls"].Data[corev1.TLSCertKey],
+		secrets["sample-server-tls"].Data[corev1.TLSPrivateKeyKey])
+	assert.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(secrets["sample-ca"].Data[caCertKey])
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ovms_requests_success 3\n"))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+	endpoint := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	mc, err := modelClientFor(context.TODO(), cl, nil, "ns", status.TLS)
+	assert.NoError(t, err)
+	metrics, err := mc.Metrics(context.TODO(), endpoint)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"ovms_requests_success": 3}, metrics)
+
+	// without the client certificate, the server rejects the connection
+	mc, err = modelClientFor(context.TODO(), cl, nil, "ns", &types.TLSStatus{ServerSecret: "sample-server-tls"})
+	assert.NoError(t, err)
+	_, err = mc.Metrics(context.TODO(), endpoint)
+	assert.Error(t, err)
+
+	_, err = modelClientFor(context.TODO(), cl, nil, "ns", &types.TLSStatus{ServerSecret: "missing"})
+	assert.Error(t, err)
+
+	fallback := &fakeModelClient{}
+	mc, err = modelClientFor(context.TODO(), cl, fallback, "ns", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, fallback, mc)
+}
+
+func TestModelServerFullname(t *testing.T) {
+	assert.Equal(t, "sample-ovms", modelServerFullname("sample", map[string]interface{}{}))
+	assert.Equal(t, "my-ovms", modelServerFullname("my-ovms", map[string]interface{}{}))
+	assert.Equal(t, "sample-server", modelServerFullname("sample", map[string]interface{}{"nameOverride": "server"}))
+	assert.Equal(t, "custom", modelServerFullname("sample", map[string]interface{}{"fullnameOverride": "custom"}))
+}
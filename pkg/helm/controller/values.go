@@ -0,0 +1,245 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+	"github.com/openvinotoolkit/operator/pkg/ovms"
+)
+
+// generatedValuesKey is the chart values key holding the values computed by
+// the operator from the custom resource. Values set under this key in the
+// custom resource are ignored.
+const generatedValuesKey = "generated"
+
+// renderValues validates the chart values of the custom resource, including
+// the objects they reference in its namespace, and adds the values computed
+// by the operator. Model and image changes of a ModelServer rolled out as a
+// canary are recorded in the status. It returns an error if the custom
+// resource is invalid or its images are rejected by the image policy.
+func (r HelmOperatorReconciler) renderValues(ctx context.Context, o *unstructured.Unstructured, status *types.HelmAppStatus,
+	values map[string]interface{}) error {
+
+	namespace := o.GetNamespace()
+	generated := map[string]interface{}{}
+	if r.GVK.Kind == "ModelServer" {
+		if err := r.resolveModelRepository(ctx, namespace, values); err != nil {
+			return err
+		}
+		if err := r.resolveModelRegistry(ctx, o, status, values, time.Now()); err != nil {
+			return err
+		}
+		if err := r.resolveImageDigest(ctx, o, status, values, time.Now()); err != nil {
+			return err
+		}
+		rollout, err := rolloutOptionsFor(values)
+		if err != nil {
+			return err
+		}
+		if err := applyResourcePreset(status, values); err != nil {
+			return err
+		}
+		if err := validateAutoscaling(values); err != nil {
+			return err
+		}
+		if err := r.renderAvailability(ctx, values, generated); err != nil {
+			return err
+		}
+		idle, err := idlePolicyFor(values)
+		if err != nil {
+			return err
+		}
+		if idle.Enabled {
+			enableRequestMetrics(values)
+			if status.IsIdle() {
+				generated["idle"] = true
+			}
+		}
+		canary := applyRollout(status, values, rollout, time.Now())
+		if err := r.resolveRepositoryCredentials(ctx, namespace, values); err != nil {
+			return err
+		}
+		if err := r.checkModelCacheImage(ctx, status, values); err != nil {
+			return err
+		}
+		if err := r.renderModelCache(ctx, o, status, values, generated); err != nil {
+			return err
+		}
+		if err := renderModelsConfig(values, generated); err != nil {
+			return err
+		}
+		if err := r.renderNodeAffinity(ctx, o, values, generated); err != nil {
+			return err
+		}
+		mounted, err := r.renderTLS(ctx, o, status, values, generated, time.Now())
+		if err != nil {
+			return err
+		}
+		checksum, err := r.referencedChecksum(ctx, namespace, values, mounted...)
+		if err != nil {
+			return err
+		}
+		if checksum != "" {
+			generated["referenced_checksum"] = checksum
+		}
+		if err := r.renderExposure(ctx, o, values, generated); err != nil {
+			return err
+		}
+		if err := r.renderNetworkPolicy(ctx, o, values, generated); err != nil {
+			return err
+		}
+		if err := r.renderKServe(values, generated, rollout, idle); err != nil {
+			return err
+		}
+		if canary != nil {
+			canaryGenerated := map[string]interface{}{}
+			if err := renderModelsConfig(canary, canaryGenerated); err != nil {
+				return fmt.Errorf("invalid canary: %w", err)
+			}
+			canaryDevices := map[string]interface{}{
+				"models_settings":       canary["models_settings"],
+				"deployment_parameters": values["deployment_parameters"],
+			}
+			if err := r.renderNodeAffinity(ctx, o, canaryDevices, canaryGenerated); err != nil {
+				return fmt.Errorf("invalid canary: %w", err)
+			}
+			if checksum != "" {
+				canaryGenerated["referenced_checksum"] = checksum
+			}
+			if tls, ok := generated["tls"]; ok {
+				canaryGenerated["tls"] = tls
+			}
+			canary = canaryValues(canary, values, rollout)
+			if len(canaryGenerated) > 0 {
+				canary[generatedValuesKey] = canaryGenerated
+			}
+			// Routes split the traffic by weight, otherwise the canary pods
+			// join the Service
+			exposure, _, _ := unstructured.NestedString(generated, "exposure", "type")
+			weighted := rollout.Strategy == rolloutCanary && (exposure == exposureRoute || exposure == exposureGateway)
+			generated["canary"] = map[string]interface{}{
+				"values":        canary,
+				"join_service":  rollout.Strategy == rolloutCanary && !weighted,
+				"serve_traffic": status.Rollout.Phase == types.RolloutPromoting,
+			}
+			if weighted {
+				generated["canary"].(map[string]interface{})["weight"] = rollout.CanaryWeight
+			}
+		}
+	}
+
+	if err := r.checkImagePolicy(status, values, generated); err != nil {
+		return err
+	}
+
+	if len(generated) == 0 {
+		delete(values, generatedValuesKey)
+		return nil
+	}
+	values[generatedValuesKey] = generated
+	return nil
+}
+
+// renderModelsConfig renders the model server configuration file from the
+// models, MediaPipe graphs and LLMs listed in the ModelServer values, with the
+// graph files of the LLMs. The files are served from a ConfigMap owned by the
+// release and updated in place, so the model server reloads them without
+// restarting its pods.
+func renderModelsConfig(values, generated map[string]interface{}) error {
+	config, err := ovms.ConfigFromValues(values)
+	if err != nil || config == nil {
+		return err
+	}
+	single, _, _ := unstructured.NestedBool(values, "models_settings", "single_model_mode")
+	if single {
+		return errors.New("models_settings.models, mediapipe_graphs and llms require single_model_mode to be false")
+	}
+	if name, _, _ := unstructured.NestedString(values, "models_settings", "config_configmap_name"); name != "" {
+		return errors.New("models_settings.models, mediapipe_graphs and llms cannot be used with config_configmap_name")
+	}
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid models configuration: %w", err)
+	}
+	rendered, err := config.Render()
+	if err != nil {
+		return err
+	}
+	generated["models_config"] = rendered
+	graphs, err := config.Graphs()
+	if err != nil {
+		return err
+	}
+	if len(graphs) > 0 {
+		files := map[string]interface{}{}
+		for name, graph := range graphs {
+			files[name] = graph
+		}
+		generated["llm_graphs"] = files
+	}
+	return nil
+}
+
+// validateAutoscaling checks the autoscaling section of the ModelServer
+// values, rendered by the chart as a HorizontalPodAutoscaler of the model
+// server Deployment. The Deployment manifest then leaves the number of
+// replicas to the autoscaler, so the release reconciliation does not reset
+// it.
+func validateAutoscaling(values map[string]interface{}) error {
+	autoscaling, _, _ := unstructured.NestedMap(values, "autoscaling")
+	if enabled, _ := autoscaling["enabled"].(bool); !enabled {
+		return nil
+	}
+	minReplicas, found, err := unstructured.NestedInt64(autoscaling, "min_replicas")
+	if err != nil || found && minReplicas < 1 {
+		return errors.New("autoscaling.min_replicas must be a positive integer")
+	}
+	if !found {
+		minReplicas = 1
+	}
+	maxReplicas, _, err := unstructured.NestedInt64(autoscaling, "max_replicas")
+	if err != nil || maxReplicas < minReplicas {
+		return errors.New("autoscaling.max_replicas must be set and not lower than min_replicas")
+	}
+	if _, found := autoscaling["target_cpu_utilization"]; found {
+		request, _, _ := unstructured.NestedFieldNoCopy(values, "deployment_parameters", "resources", "requests", "cpu")
+		limit, _, _ := unstructured.NestedFieldNoCopy(values, "deployment_parameters", "resources", "limits", "cpu")
+		if request == nil && limit == nil {
+			return errors.New("autoscaling.target_cpu_utilization requires deployment_parameters.resources with a cpu request")
+		}
+	}
+	metrics, _, err := unstructured.NestedSlice(autoscaling, "custom_metrics")
+	if err != nil {
+		return errors.New("autoscaling.custom_metrics must be a list")
+	}
+	for _, m := range metrics {
+		metric, _ := m.(map[string]interface{})
+		if name, _ := metric["name"].(string); name == "" {
+			return errors.New("autoscaling.custom_metrics entries require a name")
+		}
+		if value := metric["target_average_value"]; value == nil || value == "" {
+			return fmt.Errorf("autoscaling.custom_metrics %q requires a target_average_value", metric["name"])
+		}
+	}
+	return nil
+}
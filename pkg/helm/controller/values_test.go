@@ -0,0 +1,170 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
+)
+
+func TestRenderValues(t *testing.T) {
+	modelServer := HelmOperatorReconciler{GVK: schema.GroupVersionKind{Kind: "ModelServer"}}
+	models := []interface{}{map[string]interface{}{"name": "resnet", "base_path": "/models/resnet"}}
+
+	tests := []struct {
+		name       string
+		settings   map[string]interface{}
+		wantConfig string
+		wantErr    string
+	}{
+		{
+			name:     "single model",
+			settings: map[string]interface{}{"single_model_mode": true, "model_name": "resnet"},
+		},
+		{
+			name:       "models list",
+			settings:   map[string]interface{}{"single_model_mode": false, "models": models},
+			wantConfig: `{"model_config_list": [{"config": {"name": "resnet", "base_path": "/models/resnet"}}]}`,
+		},
+		{
+			name: "llms",
+			settings: map[string]interface{}{"single_model_mode": false, "llms": []interface{}{
+				map[string]interface{}{"name": "meta-llama/Meta-Llama-3-8B-Instruct", "model_path": "/models/llama"},
+			}},
+			wantConfig: `{"model_config_list": [], "mediapipe_config_list": [{"name": "meta-llama/Meta-Llama-3-8B-Instruct",
+				"base_path": "/models/llama", "graph_path": "/config/llm-0.pbtxt"}]}`,
+		},
+		{
+			name:     "models list in single model mode",
+			settings: map[string]interface{}{"single_model_mode": true, "models": models},
+			wantErr:  "models_settings.models, mediapipe_graphs and llms require single_model_mode to be false",
+		},
+		{
+			name: "models list with config map",
+			settings: map[string]interface{}{
+				"single_model_mode": false, "models": models, "config_configmap_name": "ovms-config",
+			},
+			wantErr: "models_settings.models, mediapipe_graphs and llms cannot be used with config_configmap_name",
+		},
+		{
+			name: "invalid models list",
+			settings: map[string]interface{}{
+				"single_model_mode": false, "models": []interface{}{map[string]interface{}{"name": "resnet"}},
+			},
+			wantErr: `invalid models configuration: invalid model "resnet": base_path must not be empty`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values := map[string]interface{}{
+				"models_settings":  test.settings,
+				generatedValuesKey: map[string]interface{}{"models_config": "stale"},
+			}
+			err := modelServer.renderValues(context.TODO(), testModelServer("default"), &types.HelmAppStatus{}, values)
+			if test.wantErr != "" {
+				assert.EqualError(t, err, test.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			if test.wantConfig == "" {
+				assert.NotContains(t, values, generatedValuesKey)
+				return
+			}
+			generated := values[generatedValuesKey].(map[string]interface{})
+			assert.JSONEq(t, test.wantConfig, generated["models_config"].(string))
+		})
+	}
+}
+
+func TestValidateAutoscaling(t *testing.T) {
+	cpuRequest := map[string]interface{}{"resources": map[string]interface{}{
+		"requests": map[string]interface{}{"cpu": "2"},
+	}}
+	tests := []struct {
+		name        string
+		autoscaling map[string]interface{}
+		deployment  map[string]interface{}
+		wantErr     string
+	}{
+		{
+			name:        "disabled",
+			autoscaling: map[string]interface{}{"enabled": false, "max_replicas": int64(0)},
+		},
+		{
+			name: "valid",
+			autoscaling: map[string]interface{}{
+				"enabled": true, "min_replicas": int64(2), "max_replicas": int64(4), "target_cpu_utilization": int64(80),
+				"custom_metrics": []interface{}{
+					map[string]interface{}{"name": "ovms_requests_streams_ratio", "target_average_value": "1"},
+				},
+			},
+			deployment: cpuRequest,
+		},
+		{
+			name:        "max lower than min",
+			autoscaling: map[string]interface{}{"enabled": true, "min_replicas": int64(3), "max_replicas": int64(2)},
+			wantErr:     "autoscaling.max_replicas must be set and not lower than min_replicas",
+		},
+		{
+			name:        "missing max",
+			autoscaling: map[string]interface{}{"enabled": true},
+			wantErr:     "autoscaling.max_replicas must be set and not lower than min_replicas",
+		},
+		{
+			name:        "cpu target without request",
+			autoscaling: map[string]interface{}{"enabled": true, "max_replicas": int64(2), "target_cpu_utilization": int64(80)},
+			wantErr:     "autoscaling.target_cpu_utilization requires deployment_parameters.resources with a cpu request",
+		},
+		{
+			name: "custom metric without target",
+			autoscaling: map[string]interface{}{
+				"enabled": true, "max_replicas": int64(2),
+				"custom_metrics": []interface{}{map[string]interface{}{"name": "ovms_current_requests"}},
+			},
+			wantErr: `autoscaling.custom_metrics "ovms_current_requests" requires a target_average_value`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values := map[string]interface{}{"autoscaling": test.autoscaling}
+			if test.deployment != nil {
+				values["deployment_parameters"] = test.deployment
+			}
+			err := validateAutoscaling(values)
+			if test.wantErr != "" {
+				assert.EqualError(t, err, test.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func testModelServer(namespace string) *unstructured.Unstructured {
+	o := &unstructured.Unstructured{}
+	o.SetNamespace(namespace)
+	o.SetName("sample")
+	return o
+}
@@ -69,13 +69,73 @@ type HelmAppCondition struct {
 type HelmAppRelease struct {
 	Name     string `json:"name,omitempty"`
 	Manifest string `json:"manifest,omitempty"`
+	// Generation is the generation of the custom resource the release was
+	// installed or upgraded for.
+	Generation int64 `json:"generation,omitempty"`
 }
 
+// ModelStatus describes a model verified to be served by the model server.
+type ModelStatus struct {
+	Name    string         `json:"name"`
+	Version string         `json:"version,omitempty"`
+	Inputs  []TensorStatus `json:"inputs,omitempty"`
+	Outputs []TensorStatus `json:"outputs,omitempty"`
+}
+
+// TensorStatus describes a model input or output.
+type TensorStatus struct {
+	Name     string  `json:"name"`
+	Datatype string  `json:"datatype,omitempty"`
+	Shape    []int64 `json:"shape,omitempty"`
+}
+
+// RolloutPhase is the phase of a canary or blue/green rollout.
+type RolloutPhase string
+
 const (
-	ConditionInitialized    HelmAppConditionType = "Initialized"
-	ConditionDeployed       HelmAppConditionType = "Deployed"
-	ConditionReleaseFailed  HelmAppConditionType = "ReleaseFailed"
-	ConditionIrreconcilable HelmAppConditionType = "Irreconcilable"
+	RolloutProgressing RolloutPhase = "Progressing"
+	RolloutPromoting   RolloutPhase = "Promoting"
+	RolloutSucceeded   RolloutPhase = "Succeeded"
+	RolloutAborted     RolloutPhase = "Aborted"
+)
+
+// RolloutStatus records the rollout of a model or image change deployed
+// alongside the stable model server. Stable and Canary hold the image_name
+// and models_settings values of both versions.
+type RolloutStatus struct {
+	Strategy   string                 `json:"strategy"`
+	Phase      RolloutPhase           `json:"phase"`
+	Message    string                 `json:"message,omitempty"`
+	StartTime  *metav1.Time           `json:"startTime,omitempty"`
+	ReadySince *metav1.Time           `json:"readySince,omitempty"`
+	Stable     map[string]interface{} `json:"stable,omitempty"`
+	Canary     map[string]interface{} `json:"canary,omitempty"`
+}
+
+// Active reports whether the rollout is in progress.
+func (s *RolloutStatus) Active() bool {
+	return s != nil && (s.Phase == RolloutProgressing || s.Phase == RolloutPromoting)
+}
+
+const (
+	ConditionInitialized           HelmAppConditionType = "Initialized"
+	ConditionDeployed              HelmAppConditionType = "Deployed"
+	ConditionReleaseFailed         HelmAppConditionType = "ReleaseFailed"
+	ConditionIrreconcilable        HelmAppConditionType = "Irreconcilable"
+	ConditionProgressing           HelmAppConditionType = "Progressing"
+	ConditionTested                HelmAppConditionType = "Tested"
+	ConditionModelReady            HelmAppConditionType = "ModelReady"
+	ConditionDeprecated            HelmAppConditionType = "Deprecated"
+	ConditionIdle                  HelmAppConditionType = "Idle"
+	ConditionReachable             HelmAppConditionType = "Reachable"
+	ConditionModelCached           HelmAppConditionType = "ModelCached"
+	ConditionInferenceServiceReady HelmAppConditionType = "InferenceServiceReady"
+	ConditionModelResolved         HelmAppConditionType = "ModelResolved"
+	ConditionImageVerified         HelmAppConditionType = "ImageVerified"
+	ConditionImagePinned           HelmAppConditionType = "ImagePinned"
+	ConditionUpgradePending        HelmAppConditionType = "UpgradePending"
+	ConditionRepositoryChecked     HelmAppConditionType = "RepositoryChecked"
+	ConditionCacheClaimUpdated     HelmAppConditionType = "CacheClaimUpdated"
 
 	StatusTrue    ConditionStatus = "True"
 	StatusFalse   ConditionStatus = "False"
@@ -89,6 +149,48 @@ const (
 	ReasonReconcileError      HelmAppConditionReason = "ReconcileError"
 	ReasonUninstallError      HelmAppConditionReason = "UninstallError"
 	PreconditionError      	  HelmAppConditionReason = "PreconditionError"
+
+	ReasonWaitingForResources      HelmAppConditionReason = "WaitingForResources"
+	ReasonResourcesReady           HelmAppConditionReason = "ResourcesReady"
+	ReasonProgressDeadlineExceeded HelmAppConditionReason = "ProgressDeadlineExceeded"
+	ReasonRollbackSuccessful       HelmAppConditionReason = "RollbackSuccessful"
+	ReasonRollbackError            HelmAppConditionReason = "RollbackError"
+	ReasonTestPending              HelmAppConditionReason = "TestPending"
+	ReasonTestRunning              HelmAppConditionReason = "TestRunning"
+	ReasonTestSucceeded            HelmAppConditionReason = "TestSucceeded"
+	ReasonTestFailed               HelmAppConditionReason = "TestFailed"
+	ReasonTestSkipped              HelmAppConditionReason = "TestSkipped"
+	ReasonModelAvailable           HelmAppConditionReason = "ModelAvailable"
+	ReasonModelUnavailable         HelmAppConditionReason = "ModelUnavailable"
+	ReasonDeprecatedFieldsInUse    HelmAppConditionReason = "DeprecatedFieldsInUse"
+	ReasonNoRequests               HelmAppConditionReason = "NoRequests"
+	ReasonRequestsReceived         HelmAppConditionReason = "RequestsReceived"
+	ReasonWakeUpRequested          HelmAppConditionReason = "WakeUpRequested"
+	ReasonEndpointReachable        HelmAppConditionReason = "EndpointReachable"
+	ReasonEndpointUnreachable      HelmAppConditionReason = "EndpointUnreachable"
+	ReasonVolumeClaimFound         HelmAppConditionReason = "VolumeClaimFound"
+	ReasonVolumeClaimNotFound      HelmAppConditionReason = "VolumeClaimNotFound"
+	ReasonNotChecked               HelmAppConditionReason = "NotChecked"
+	ReasonInvalidSpec              HelmAppConditionReason = "InvalidSpec"
+	ReasonCacheSynced              HelmAppConditionReason = "CacheSynced"
+	ReasonCacheSyncing             HelmAppConditionReason = "CacheSyncing"
+	ReasonCacheSyncFailed          HelmAppConditionReason = "CacheSyncFailed"
+	ReasonCacheClaimImmutable      HelmAppConditionReason = "CacheClaimImmutable"
+	ReasonCacheClaimResizeFailed   HelmAppConditionReason = "CacheClaimResizeFailed"
+	ReasonInferenceServiceReady    HelmAppConditionReason = "InferenceServiceReady"
+	ReasonInferenceServiceNotReady HelmAppConditionReason = "InferenceServiceNotReady"
+	ReasonModelVersionResolved     HelmAppConditionReason = "ModelVersionResolved"
+	ReasonModelResolutionFailed    HelmAppConditionReason = "ModelResolutionFailed"
+	ReasonImageAllowed             HelmAppConditionReason = "ImageAllowed"
+	ReasonImageSignatureVerified   HelmAppConditionReason = "ImageSignatureVerified"
+	ReasonImageRejected            HelmAppConditionReason = "ImageRejected"
+	ReasonImageDigestResolved      HelmAppConditionReason = "ImageDigestResolved"
+	ReasonImageUpdatePending       HelmAppConditionReason = "ImageUpdatePending"
+	ReasonImageResolutionFailed    HelmAppConditionReason = "ImageResolutionFailed"
+	ReasonMaintenanceWindowClosed  HelmAppConditionReason = "MaintenanceWindowClosed"
+	ReasonModelLayoutValid         HelmAppConditionReason = "ModelLayoutValid"
+	ReasonModelLayoutInvalid       HelmAppConditionReason = "ModelLayoutInvalid"
+	ReasonModelLayoutChecking      HelmAppConditionReason = "ModelLayoutChecking"
 )
 
 type HelmAppStatus struct {
@@ -96,6 +198,119 @@ type HelmAppStatus struct {
 	DeployedRelease *HelmAppRelease    `json:"deployedRelease,omitempty"`
 	Replicas int `json:"replicas,omitempty"`
 	LabelSelector string `json:"labelSelector,omitempty"`
+	// FailedGeneration is the CR generation whose release was rolled back.
+	// Upgrades are not retried until the CR spec changes again.
+	FailedGeneration int64          `json:"failedGeneration,omitempty"`
+	Models           []ModelStatus  `json:"models,omitempty"`
+	Rollout          *RolloutStatus `json:"rollout,omitempty"`
+	// URL and GRPCURL are the external endpoints of the REST and gRPC APIs
+	// of an exposed ModelServer. URL is the URL of the InferenceService of a
+	// ModelServer served by KServe.
+	URL     string     `json:"url,omitempty"`
+	GRPCURL string     `json:"grpcUrl,omitempty"`
+	TLS     *TLSStatus `json:"tls,omitempty"`
+	// ChatCompletionsURL is the OpenAI-compatible endpoint of a ModelServer
+	// serving LLMs, external if it is exposed.
+	ChatCompletionsURL string `json:"chatCompletionsUrl,omitempty"`
+	// Resources are the effective sizing values of a ModelServer using a
+	// resource preset.
+	Resources *ResourcesStatus `json:"resources,omitempty"`
+	// ModelCache records the models synced into the model cache of a
+	// ModelServer.
+	ModelCache *ModelCacheStatus `json:"modelCache,omitempty"`
+	// ModelRegistry records the model version resolved from the model
+	// registry referenced by a ModelServer.
+	ModelRegistry *ModelRegistryStatus `json:"modelRegistry,omitempty"`
+	// Image records the digest the image of a ModelServer is pinned to.
+	Image *ImageStatus `json:"image,omitempty"`
+	// VerifiedImages records the digests whose signatures were verified
+	// with the image policy, which are deployed instead of the images.
+	VerifiedImages []VerifiedImageStatus `json:"verifiedImages,omitempty"`
+}
+
+// VerifiedImageStatus records the digest of the manifest referenced by an
+// image when its signature was verified.
+type VerifiedImageStatus struct {
+	// Image is the image reference set in the values.
+	Image  string `json:"image"`
+	Digest string `json:"digest"`
+}
+
+// ImageStatus records the digest of the manifest referenced by the tag of
+// the image of a ModelServer, which is deployed instead of the tag.
+type ImageStatus struct {
+	// Image is the image reference which was resolved.
+	Image  string `json:"image"`
+	Digest string `json:"digest"`
+	// PendingDigest is the new digest of the tag, deployed in the next
+	// maintenance window.
+	PendingDigest string `json:"pendingDigest,omitempty"`
+	// LastCheckTime is the time of the last request to the registry. The
+	// tag is resolved again periodically with automatic updates.
+	LastCheckTime *metav1.Time `json:"lastCheckTime,omitempty"`
+}
+
+// ModelRegistryStatus records the model version resolved from a model
+// registry and the model path served by the release.
+type ModelRegistryStatus struct {
+	// Reference identifies the registry, the model and the version or stage
+	// which were resolved.
+	Reference string `json:"reference"`
+	Version   string `json:"version"`
+	ModelPath string `json:"modelPath"`
+	// LastResolveTime is the time of the last request to the registry. The
+	// latest version in a stage is resolved again periodically.
+	LastResolveTime *metav1.Time `json:"lastResolveTime,omitempty"`
+}
+
+// ModelCacheStatus records the persistent volume claim caching the models of
+// a ModelServer and the versions of the models synced into it. A version
+// identifies the model paths and the storage they are synced from.
+type ModelCacheStatus struct {
+	ClaimName string `json:"claimName"`
+	// Version is the version served by the release.
+	Version string `json:"version,omitempty"`
+	// SyncVersion is the version being synced by SyncJob. The release is
+	// upgraded to serve it once the job succeeds.
+	SyncVersion string `json:"syncVersion,omitempty"`
+	SyncJob     string `json:"syncJob,omitempty"`
+	// CachedVersions are the versions kept in the cache, the most recent
+	// first. Older versions are deleted by the sync jobs.
+	CachedVersions []string     `json:"cachedVersions,omitempty"`
+	LastSyncTime   *metav1.Time `json:"lastSyncTime,omitempty"`
+}
+
+// Syncing reports whether a version of the models is being synced into the
+// cache, or failed to.
+func (s *ModelCacheStatus) Syncing() bool {
+	return s != nil && s.SyncVersion != ""
+}
+
+// ResourcesStatus records the resource preset of a ModelServer and the
+// values in effect after the fields set in its spec.
+type ResourcesStatus struct {
+	Preset          string            `json:"preset"`
+	Requests        map[string]string `json:"requests,omitempty"`
+	Limits          map[string]string `json:"limits,omitempty"`
+	Nireq           int64             `json:"nireq,omitempty"`
+	GRPCWorkers     int64             `json:"grpcWorkers,omitempty"`
+	PerformanceHint string            `json:"performanceHint,omitempty"`
+}
+
+// TLSStatus records the certificates of the TLS endpoints of a ModelServer.
+type TLSStatus struct {
+	ServerSecret string `json:"serverSecret"`
+	// ClientSecret is the client certificate used by the operator with
+	// mTLS.
+	ClientSecret string       `json:"clientSecret,omitempty"`
+	NotAfter     *metav1.Time `json:"notAfter,omitempty"`
+}
+
+// IsIdle reports whether the model server is scaled to zero by its idle
+// policy.
+func (s *HelmAppStatus) IsIdle() bool {
+	c := s.GetCondition(ConditionIdle)
+	return c != nil && c.Status == StatusTrue
 }
 
 func (s *HelmAppStatus) ToMap() (map[string]interface{}, error) {
@@ -114,24 +329,39 @@ func (s *HelmAppStatus) ToMap() (map[string]interface{}, error) {
 // exists, it will be replaced. SetCondition does not update the resource in
 // the cluster.
 func (s *HelmAppStatus) SetCondition(condition HelmAppCondition) *HelmAppStatus {
+	s.Conditions = setCondition(s.Conditions, condition)
+	return s
+}
+
+func setCondition(conditions []HelmAppCondition, condition HelmAppCondition) []HelmAppCondition {
 	now := metav1.Now()
-	for i := range s.Conditions {
-		if s.Conditions[i].Type == condition.Type {
-			if s.Conditions[i].Status != condition.Status {
+	for i := range conditions {
+		if conditions[i].Type == condition.Type {
+			if conditions[i].Status != condition.Status {
 				condition.LastTransitionTime = now
 			} else {
-				condition.LastTransitionTime = s.Conditions[i].LastTransitionTime
+				condition.LastTransitionTime = conditions[i].LastTransitionTime
 			}
-			s.Conditions[i] = condition
-			return s
+			conditions[i] = condition
+			return conditions
 		}
 	}
 
 	// If the condition does not exist,
 	// initialize the lastTransitionTime
 	condition.LastTransitionTime = now
-	s.Conditions = append(s.Conditions, condition)
-	return s
+	return append(conditions, condition)
+}
+
+// GetCondition returns the condition with the passed condition type, or nil
+// if the status object does not contain it.
+func (s *HelmAppStatus) GetCondition(conditionType HelmAppConditionType) *HelmAppCondition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == conditionType {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
 }
 
 // RemoveCondition removes the condition with the passed condition type from
@@ -171,3 +401,59 @@ func StatusFor(cr *unstructured.Unstructured) *HelmAppStatus {
 		return &HelmAppStatus{}
 	}
 }
+
+// ModelRepositoryStatus is the status of a ModelRepository or a
+// ClusterModelRepository, recording whether its storage endpoints are
+// reachable from the operator.
+type ModelRepositoryStatus struct {
+	Conditions         []HelmAppCondition `json:"conditions"`
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Endpoints          []string           `json:"endpoints,omitempty"`
+	LastCheckTime      *metav1.Time       `json:"lastCheckTime,omitempty"`
+}
+
+// SetCondition sets a condition on the status object, like
+// HelmAppStatus.SetCondition.
+func (s *ModelRepositoryStatus) SetCondition(condition HelmAppCondition) *ModelRepositoryStatus {
+	s.Conditions = setCondition(s.Conditions, condition)
+	return s
+}
+
+// GetCondition returns the condition with the passed condition type, or nil
+// if the status object does not contain it.
+func (s *ModelRepositoryStatus) GetCondition(conditionType HelmAppConditionType) *HelmAppCondition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == conditionType {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// ToMap converts the status to the unstructured status of the custom
+// resource.
+func (s *ModelRepositoryStatus) ToMap() (map[string]interface{}, error) {
+	var out map[string]interface{}
+	jsonObj, err := json.Marshal(&s)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(jsonObj, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ModelRepositoryStatusFor returns the typed status of a ModelRepository or
+// a ClusterModelRepository.
+func ModelRepositoryStatusFor(cr *unstructured.Unstructured) *ModelRepositoryStatus {
+	s, ok := cr.Object["status"].(map[string]interface{})
+	if !ok {
+		return &ModelRepositoryStatus{}
+	}
+	var status *ModelRepositoryStatus
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(s, &status); err != nil || status == nil {
+		return &ModelRepositoryStatus{}
+	}
+	return status
+}
@@ -59,6 +59,15 @@ func TestRemoveCondition(t *testing.T) {
 	assert.Empty(t, actual.Conditions)
 }
 
+func TestGetCondition(t *testing.T) {
+	status := newTestStatus()
+
+	condition := status.GetCondition(ConditionDeployed)
+	assert.NotNil(t, condition)
+	assert.Equal(t, ReasonInstallSuccessful, condition.Reason)
+	assert.Nil(t, status.GetCondition(ConditionProgressing))
+}
+
 func TestStatusForEmpty(t *testing.T) {
 	status := StatusFor(newTestResource())
 
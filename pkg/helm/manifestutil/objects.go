@@ -0,0 +1,48 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package manifestutil
+
+import (
+	"sort"
+
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// ObjectsOfKind returns the objects of the passed kind included in a release
+// manifest, in the order in which they are rendered.
+func ObjectsOfKind(manifest, kind string) ([]*unstructured.Unstructured, error) {
+	manifests := releaseutil.SplitManifests(manifest)
+	keys := make([]string, 0, len(manifests))
+	for k := range manifests {
+		keys = append(keys, k)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	var objects []*unstructured.Unstructured
+	for _, k := range keys {
+		u := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(manifests[k]), &u.Object); err != nil {
+			return nil, err
+		}
+		if u.GetKind() == kind {
+			objects = append(objects, u)
+		}
+	}
+	return objects, nil
+}
@@ -0,0 +1,58 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package manifestutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testManifest = `---
+# Source: ovms/templates/service.yaml
+kind: Service
+apiVersion: v1
+metadata:
+  name: sample-ovms
+---
+# Source: ovms/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: sample-ovms
+---
+# Source: ovms/templates/canary_service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: sample-ovms-canary
+`
+
+func TestObjectsOfKind(t *testing.T) {
+	services, err := ObjectsOfKind(testManifest, "Service")
+	assert.NoError(t, err)
+	assert.Len(t, services, 2)
+	assert.Equal(t, "sample-ovms", services[0].GetName())
+	assert.Equal(t, "sample-ovms-canary", services[1].GetName())
+
+	secrets, err := ObjectsOfKind(testManifest, "Secret")
+	assert.NoError(t, err)
+	assert.Empty(t, secrets)
+
+	_, err = ObjectsOfKind("kind: [", "Service")
+	assert.Error(t, err)
+}
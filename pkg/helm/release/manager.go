@@ -37,7 +37,9 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
+	"time"
 
 	jsonpatch "gomodules.xyz/jsonpatch/v3"
 	"helm.sh/helm/v3/pkg/action"
@@ -47,17 +49,22 @@ import (
 	"helm.sh/helm/v3/pkg/releaseutil"
 	"helm.sh/helm/v3/pkg/storage"
 	"helm.sh/helm/v3/pkg/storage/driver"
+	helmtime "helm.sh/helm/v3/pkg/time"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apiextv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	apitypes "k8s.io/apimachinery/pkg/types"
 	apiutilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	"k8s.io/cli-runtime/pkg/resource"
 	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
 
 	"github.com/openvinotoolkit/operator/pkg/helm/internal/types"
 	"github.com/openvinotoolkit/operator/pkg/helm/manifestutil"
@@ -69,10 +76,15 @@ type Manager interface {
 	ReleaseName() string
 	IsInstalled() bool
 	IsUpgradeRequired() bool
+	IsChartChanged() bool
 	Sync(context.Context) error
 	InstallRelease(context.Context, ...InstallOption) (*rpb.Release, error)
 	UpgradeRelease(context.Context, ...UpgradeOption) (*rpb.Release, *rpb.Release, error)
 	ReconcileRelease(context.Context) (*rpb.Release, error)
+	IsReleaseReady(context.Context, bool) (bool, error)
+	RollbackRelease(context.Context) (*rpb.Release, error)
+	StartReleaseTests(context.Context) (bool, error)
+	ReleaseTestResults(context.Context) (*rpb.Release, string, bool, error)
 	UninstallRelease(context.Context, ...UninstallOption) (*rpb.Release, error)
 	CleanupRelease(context.Context, string) (bool, error)
 	GetValues() map[string]interface{}
@@ -92,7 +104,9 @@ type manager struct {
 
 	isInstalled       bool
 	isUpgradeRequired bool
+	isChartChanged    bool
 	deployedRelease   *rpb.Release
+	rollbackVersion   int
 	chart             *cpb.Chart
 }
 
@@ -125,6 +139,13 @@ func (m manager) IsUpgradeRequired() bool {
 	return m.isUpgradeRequired
 }
 
+// IsChartChanged reports whether the deployed release was installed from
+// another chart than the chart of the manager, like a chart shipped with a
+// previous operator version.
+func (m manager) IsChartChanged() bool {
+	return m.isChartChanged
+}
+
 // Sync ensures the Helm storage backend is in sync with the status of the
 // custom resource.
 func (m *manager) Sync(ctx context.Context) error {
@@ -134,10 +155,25 @@ func (m *manager) Sync(ctx context.Context) error {
 		return fmt.Errorf("failed to retrieve release history: %w", err)
 	}
 
+	// Keep the latest superseded release version while a deployed version
+	// exists, so that a failed upgrade can be rolled back to it.
+	rollbackVersion := 0
+	if hasDeployedRelease(releases) {
+		for _, rel := range releases {
+			if rel.Info != nil && rel.Info.Status == rpb.StatusSuperseded && rel.Version > rollbackVersion {
+				rollbackVersion = rel.Version
+			}
+		}
+	}
+	m.rollbackVersion = rollbackVersion
+
 	// Cleanup non-deployed release versions. If all release versions are
 	// non-deployed, this will ensure that failed installations are correctly
 	// retried.
 	for _, rel := range releases {
+		if rel.Version == rollbackVersion {
+			continue
+		}
 		if rel.Info != nil && rel.Info.Status != rpb.StatusDeployed {
 			_, err := m.storageBackend.Delete(rel.Name, rel.Version)
 			if err != nil && !notFoundErr(err) {
@@ -161,9 +197,23 @@ func (m *manager) Sync(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("failed to get upgrade status: %w", err)
 	}
+	sameChart, err := equalJSONStruct(m.chart, deployedRelease.Chart)
+	if err != nil {
+		return fmt.Errorf("failed to compare the deployed chart: %w", err)
+	}
+	m.isChartChanged = !sameChart
 	return nil
 }
 
+func hasDeployedRelease(releases []*rpb.Release) bool {
+	for _, rel := range releases {
+		if rel.Info != nil && rel.Info.Status == rpb.StatusDeployed {
+			return true
+		}
+	}
+	return false
+}
+
 func notFoundErr(err error) bool {
 	return err != nil && strings.Contains(err.Error(), "not found")
 }
@@ -251,6 +301,24 @@ func (m manager) InstallRelease(ctx context.Context, opts ...InstallOption) (*rp
 	return installedRelease, nil
 }
 
+// InstallTimeout sets the time to wait for Kubernetes operations, such as
+// hooks, during the install.
+func InstallTimeout(timeout time.Duration) InstallOption {
+	return func(i *action.Install) error {
+		i.Timeout = timeout
+		return nil
+	}
+}
+
+// UpgradeTimeout sets the time to wait for Kubernetes operations, such as
+// hooks, during the upgrade.
+func UpgradeTimeout(timeout time.Duration) UpgradeOption {
+	return func(u *action.Upgrade) error {
+		u.Timeout = timeout
+		return nil
+	}
+}
+
 func ForceUpgrade(force bool) UpgradeOption {
 	return func(u *action.Upgrade) error {
 		u.Force = force
@@ -416,6 +484,286 @@ func createJSONMergePatch(existingJSON, expectedJSON []byte) ([]byte, error) {
 	return json.Marshal(patchOps)
 }
 
+// IsReleaseReady reports whether all resources of the deployed release are
+// ready. Unlike the Helm wait option it does not block, so callers are
+// expected to poll it until the release is ready or a timeout expires.
+func (m manager) IsReleaseReady(ctx context.Context, waitForJobs bool) (bool, error) {
+	if m.deployedRelease == nil {
+		return false, driver.ErrReleaseNotFound
+	}
+	clientSet, err := m.actionConfig.KubernetesClientSet()
+	if err != nil {
+		return false, fmt.Errorf("failed to get Kubernetes client: %w", err)
+	}
+	resources, err := m.kubeClient.Build(bytes.NewBufferString(m.deployedRelease.Manifest), false)
+	if err != nil {
+		return false, fmt.Errorf("failed to build resources from manifest: %w", err)
+	}
+	return resourcesReady(ctx, clientSet, resources, waitForJobs)
+}
+
+// resourcesReady reports whether all resources are ready, checking Jobs for
+// completion only if waitForJobs is set.
+func resourcesReady(ctx context.Context, clientSet kubernetes.Interface, resources kube.ResourceList, waitForJobs bool) (bool, error) {
+	checker := kube.NewReadyChecker(clientSet, func(string, ...interface{}) {},
+		kube.PausedAsReady(true), kube.CheckJobs(waitForJobs))
+	for _, r := range resources {
+		ready, err := checker.IsReady(ctx, r)
+		if err != nil {
+			return false, fmt.Errorf("failed to check readiness of %s %q: %w",
+				r.Mapping.GroupVersionKind.Kind, r.Name, err)
+		}
+		if !ready {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// RollbackRelease rolls the release back to the latest superseded version
+// and returns the resulting release.
+func (m manager) RollbackRelease(ctx context.Context) (*rpb.Release, error) {
+	if m.rollbackVersion == 0 {
+		return nil, errors.New("no previous release version to roll back to")
+	}
+	rollback := action.NewRollback(m.actionConfig)
+	rollback.Version = m.rollbackVersion
+	rollback.Force = true
+	if err := rollback.Run(m.releaseName); err != nil {
+		return nil, fmt.Errorf("failed to roll back release to version %d: %w", m.rollbackVersion, err)
+	}
+	return m.getDeployedRelease()
+}
+
+// StartReleaseTests creates the test hooks of the deployed release, the
+// hooks annotated with `helm.sh/hook: test`, without waiting for them to
+// complete. The hooks left by previous tests are deleted first; it returns
+// false while they are being deleted, so that callers retry later.
+func (m manager) StartReleaseTests(ctx context.Context) (bool, error) {
+	if m.deployedRelease == nil {
+		return false, driver.ErrReleaseNotFound
+	}
+	hooks := testHooks(m.deployedRelease)
+	var pending []kube.ResourceList
+	deleting := false
+	for _, h := range hooks {
+		resources, err := m.kubeClient.Build(bytes.NewBufferString(h.Manifest), true)
+		if err != nil {
+			return false, fmt.Errorf("failed to build test hook %q: %w", h.Name, err)
+		}
+		for _, r := range resources {
+			err := r.Get()
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return false, fmt.Errorf("failed to get test hook %q: %w", h.Name, err)
+			}
+			deleting = true
+			if _, errs := m.kubeClient.Delete(kube.ResourceList{r}); len(errs) > 0 {
+				return false, fmt.Errorf("failed to delete previous test hook %q: %w", h.Name, apiutilerrors.NewAggregate(errs))
+			}
+		}
+		pending = append(pending, resources)
+	}
+	if deleting {
+		return false, nil
+	}
+
+	for i, h := range hooks {
+		if _, err := m.kubeClient.Create(pending[i]); err != nil {
+			return false, fmt.Errorf("failed to create test hook %q: %w", h.Name, err)
+		}
+		h.LastRun = rpb.HookExecution{StartedAt: helmtime.Now(), Phase: rpb.HookPhaseRunning}
+	}
+	if err := m.storageBackend.Update(m.deployedRelease); err != nil {
+		return false, fmt.Errorf("failed to record the test hooks: %w", err)
+	}
+	return true, nil
+}
+
+// ReleaseTestResults checks the test hooks started by StartReleaseTests and
+// records their phases in the deployed release. It returns the release, and
+// whether all the hooks completed. Once they did, it also returns the logs
+// of the test pods, and an error if a test failed.
+func (m manager) ReleaseTestResults(ctx context.Context) (*rpb.Release, string, bool, error) {
+	rel := m.deployedRelease
+	if rel == nil {
+		return nil, "", false, driver.ErrReleaseNotFound
+	}
+	hooks := testHooks(rel)
+	completed := true
+	var failed []string
+	var pods []string
+	for _, h := range hooks {
+		resources, err := m.kubeClient.Build(bytes.NewBufferString(h.Manifest), false)
+		if err != nil {
+			return rel, "", false, fmt.Errorf("failed to build test hook %q: %w", h.Name, err)
+		}
+		phase := rpb.HookPhaseSucceeded
+		for _, r := range resources {
+			err := r.Get()
+			if apierrors.IsNotFound(err) {
+				phase = rpb.HookPhaseFailed
+				continue
+			}
+			if err != nil {
+				return rel, "", false, fmt.Errorf("failed to get test hook %q: %w", h.Name, err)
+			}
+			if r.Mapping.GroupVersionKind.Kind == "Pod" {
+				pods = append(pods, r.Name)
+			}
+			switch hookPhase(r.Object) {
+			case rpb.HookPhaseFailed:
+				phase = rpb.HookPhaseFailed
+			case rpb.HookPhaseRunning:
+				if phase != rpb.HookPhaseFailed {
+					phase = rpb.HookPhaseRunning
+				}
+			}
+		}
+		if phase == rpb.HookPhaseRunning {
+			completed = false
+			continue
+		}
+		if h.LastRun.Phase == rpb.HookPhaseRunning {
+			h.LastRun.Phase = phase
+			h.LastRun.CompletedAt = helmtime.Now()
+		}
+		if phase == rpb.HookPhaseFailed {
+			failed = append(failed, h.Name)
+		}
+	}
+	if !completed {
+		return rel, "", false, nil
+	}
+	if err := m.storageBackend.Update(rel); err != nil {
+		return rel, "", false, fmt.Errorf("failed to record the test results: %w", err)
+	}
+
+	var logs bytes.Buffer
+	if err := m.podLogs(ctx, &logs, pods); err != nil {
+		fmt.Fprintf(&logs, "%s\n", err)
+	}
+	if err := m.deleteTestHooks(hooks); err != nil {
+		fmt.Fprintf(&logs, "%s\n", err)
+	}
+	if len(failed) > 0 {
+		return rel, logs.String(), true, fmt.Errorf("failed to test release: test hooks failed: %s",
+			strings.Join(failed, ", "))
+	}
+	return rel, logs.String(), true, nil
+}
+
+// testHooks returns the test hooks of the release, by weight.
+func testHooks(rel *rpb.Release) []*rpb.Hook {
+	var hooks []*rpb.Hook
+	for _, h := range rel.Hooks {
+		for _, e := range h.Events {
+			if e == rpb.HookTest {
+				hooks = append(hooks, h)
+				break
+			}
+		}
+	}
+	sort.SliceStable(hooks, func(i, j int) bool {
+		if hooks[i].Weight == hooks[j].Weight {
+			return hooks[i].Name < hooks[j].Name
+		}
+		return hooks[i].Weight < hooks[j].Weight
+	})
+	return hooks
+}
+
+// hookPhase returns the phase of a test hook from the status of its Pod or
+// Job. The other kinds of resources succeed once they are created.
+func hookPhase(obj runtime.Object) rpb.HookPhase {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return rpb.HookPhaseSucceeded
+	}
+	switch u.GetKind() {
+	case "Pod":
+		phase, _, _ := unstructured.NestedString(u.Object, "status", "phase")
+		switch corev1.PodPhase(phase) {
+		case corev1.PodSucceeded:
+			return rpb.HookPhaseSucceeded
+		case corev1.PodFailed:
+			return rpb.HookPhaseFailed
+		}
+		return rpb.HookPhaseRunning
+	case "Job":
+		conditions, _, _ := unstructured.NestedSlice(u.Object, "status", "conditions")
+		for _, c := range conditions {
+			condition, _ := c.(map[string]interface{})
+			if condition["status"] != string(corev1.ConditionTrue) {
+				continue
+			}
+			switch condition["type"] {
+			case string(batchv1.JobComplete):
+				return rpb.HookPhaseSucceeded
+			case string(batchv1.JobFailed):
+				return rpb.HookPhaseFailed
+			}
+		}
+		return rpb.HookPhaseRunning
+	}
+	return rpb.HookPhaseSucceeded
+}
+
+// podLogs writes the logs of the test pods, in the format of helm test.
+func (m manager) podLogs(ctx context.Context, out *bytes.Buffer, pods []string) error {
+	if len(pods) == 0 {
+		return nil
+	}
+	clientSet, err := m.actionConfig.KubernetesClientSet()
+	if err != nil {
+		return fmt.Errorf("failed to get Kubernetes client: %w", err)
+	}
+	for _, name := range pods {
+		logs, err := clientSet.CoreV1().Pods(m.namespace).GetLogs(name, &corev1.PodLogOptions{}).DoRaw(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get logs of test pod %q: %w", name, err)
+		}
+		fmt.Fprintf(out, "POD LOGS: %s\n%s\n", name, logs)
+	}
+	return nil
+}
+
+// deleteTestHooks deletes the completed test hooks according to their
+// hook-succeeded and hook-failed delete policies.
+func (m manager) deleteTestHooks(hooks []*rpb.Hook) error {
+	var errs []error
+	for _, h := range hooks {
+		policy := rpb.HookSucceeded
+		if h.LastRun.Phase == rpb.HookPhaseFailed {
+			policy = rpb.HookFailed
+		}
+		if !hasDeletePolicy(h, policy) {
+			continue
+		}
+		resources, err := m.kubeClient.Build(bytes.NewBufferString(h.Manifest), false)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to build test hook %q: %w", h.Name, err))
+			continue
+		}
+		if _, deleteErrs := m.kubeClient.Delete(resources); len(deleteErrs) > 0 {
+			errs = append(errs, fmt.Errorf("failed to delete test hook %q: %w", h.Name,
+				apiutilerrors.NewAggregate(deleteErrs)))
+		}
+	}
+	return apiutilerrors.NewAggregate(errs)
+}
+
+func hasDeletePolicy(h *rpb.Hook, policy rpb.HookDeletePolicy) bool {
+	for _, p := range h.DeletePolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
 // UninstallRelease performs a Helm release uninstall.
 func (m manager) UninstallRelease(ctx context.Context, opts ...UninstallOption) (*rpb.Release, error) {
 	uninstall := action.NewUninstall(m.actionConfig)
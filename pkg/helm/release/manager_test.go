@@ -16,20 +16,32 @@ package release
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"io"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"helm.sh/helm/v3/pkg/action"
 	cpb "helm.sh/helm/v3/pkg/chart"
 	lpb "helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/kube"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
 	rpb "helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	apitypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
 )
 
 func newTestUnstructured(containers []interface{}) *unstructured.Unstructured {
@@ -279,6 +291,201 @@ func TestManagerisUpgrade(t *testing.T) {
 	}
 }
 
+func TestResourcesReady(t *testing.T) {
+	pod := func(ready v1.ConditionStatus) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "ovms", Namespace: "ns"},
+			Status:     v1.PodStatus{Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: ready}}},
+		}
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "ovms-job", Namespace: "ns"},
+		Spec:       batchv1.JobSpec{Completions: ptr.To(int32(1)), BackoffLimit: ptr.To(int32(6))},
+		Status:     batchv1.JobStatus{Active: 1},
+	}
+	info := func(obj runtime.Object) *resource.Info {
+		accessor, _ := meta.Accessor(obj)
+		gvk := v1.SchemeGroupVersion.WithKind("Pod")
+		if _, ok := obj.(*batchv1.Job); ok {
+			gvk = batchv1.SchemeGroupVersion.WithKind("Job")
+		}
+		return &resource.Info{
+			Name: accessor.GetName(), Namespace: accessor.GetNamespace(), Object: obj,
+			Mapping: &meta.RESTMapping{GroupVersionKind: gvk},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		objects     []runtime.Object
+		waitForJobs bool
+		expected    bool
+	}{
+		{"ready pod", []runtime.Object{pod(v1.ConditionTrue)}, false, true},
+		{"unready pod", []runtime.Object{pod(v1.ConditionFalse)}, false, false},
+		{"active job", []runtime.Object{pod(v1.ConditionTrue), job}, false, true},
+		{"active job waiting for jobs", []runtime.Object{pod(v1.ConditionTrue), job}, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var resources kube.ResourceList
+			for _, obj := range test.objects {
+				resources = append(resources, info(obj))
+			}
+			ready, err := resourcesReady(context.TODO(), fake.NewSimpleClientset(test.objects...), resources, test.waitForJobs)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, ready)
+		})
+	}
+}
+
+func TestIsReleaseReadyWithoutDeployedRelease(t *testing.T) {
+	_, err := manager{}.IsReleaseReady(context.TODO(), false)
+	assert.ErrorIs(t, err, driver.ErrReleaseNotFound)
+}
+
+// newTestStorageManager returns a manager of the release "sample" whose
+// history is stored in memory.
+func newTestStorageManager(t *testing.T, history ...*rpb.Release) *manager {
+	store := storage.Init(driver.NewMemory())
+	for _, rel := range history {
+		assert.NoError(t, store.Create(rel))
+	}
+	return &manager{
+		actionConfig: &action.Configuration{
+			Releases:     store,
+			KubeClient:   &kubefake.PrintingKubeClient{Out: io.Discard},
+			Capabilities: chartutil.DefaultCapabilities,
+			Log:          func(string, ...interface{}) {},
+		},
+		storageBackend: store,
+		releaseName:    "sample",
+		namespace:      "default",
+		chart:          newTestChart(t, "./testdata/simple"),
+	}
+}
+
+func newTestHistoryRelease(t *testing.T, version int, status rpb.Status, value string) *rpb.Release {
+	rel := newTestRelease(newTestChart(t, "./testdata/simple"), map[string]interface{}{"key": value}, "sample", "default")
+	rel.Version = version
+	rel.Info.Status = status
+	return rel
+}
+
+func TestManagerSyncKeepsSupersededRelease(t *testing.T) {
+	m := newTestStorageManager(t,
+		newTestHistoryRelease(t, 1, rpb.StatusSuperseded, "v1"),
+		newTestHistoryRelease(t, 2, rpb.StatusSuperseded, "v2"),
+		newTestHistoryRelease(t, 3, rpb.StatusDeployed, "v3"),
+		newTestHistoryRelease(t, 4, rpb.StatusFailed, "v4"),
+	)
+	m.values = map[string]interface{}{"key": "v3"}
+
+	assert.NoError(t, m.Sync(context.TODO()))
+	assert.True(t, m.IsInstalled())
+	assert.False(t, m.IsUpgradeRequired())
+	assert.Equal(t, 2, m.rollbackVersion)
+
+	history, err := m.storageBackend.History("sample")
+	assert.NoError(t, err)
+	var versions []int
+	for _, rel := range history {
+		versions = append(versions, rel.Version)
+	}
+	assert.ElementsMatch(t, []int{2, 3}, versions)
+}
+
+func TestManagerSyncRemovesFailedInstall(t *testing.T) {
+	m := newTestStorageManager(t,
+		newTestHistoryRelease(t, 1, rpb.StatusSuperseded, "v1"),
+		newTestHistoryRelease(t, 2, rpb.StatusFailed, "v2"),
+	)
+
+	assert.NoError(t, m.Sync(context.TODO()))
+	assert.False(t, m.IsInstalled())
+	assert.Zero(t, m.rollbackVersion)
+
+	_, err := m.storageBackend.History("sample")
+	assert.True(t, notFoundErr(err))
+}
+
+func TestManagerRollbackRelease(t *testing.T) {
+	m := newTestStorageManager(t,
+		newTestHistoryRelease(t, 1, rpb.StatusSuperseded, "v1"),
+		newTestHistoryRelease(t, 2, rpb.StatusSuperseded, "v2"),
+		newTestHistoryRelease(t, 3, rpb.StatusDeployed, "v3"),
+	)
+	m.values = map[string]interface{}{"key": "v3"}
+	assert.NoError(t, m.Sync(context.TODO()))
+
+	rolledBack, err := m.RollbackRelease(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, rolledBack.Version)
+	assert.Equal(t, rpb.StatusDeployed, rolledBack.Info.Status)
+	assert.Equal(t, map[string]interface{}{"key": "v2"}, rolledBack.Config)
+
+	failed, err := m.storageBackend.Get("sample", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, rpb.StatusSuperseded, failed.Info.Status)
+}
+
+func TestManagerRollbackReleaseWithoutPreviousVersion(t *testing.T) {
+	m := newTestStorageManager(t, newTestHistoryRelease(t, 1, rpb.StatusDeployed, "v1"))
+	m.values = map[string]interface{}{"key": "v1"}
+	assert.NoError(t, m.Sync(context.TODO()))
+
+	_, err := m.RollbackRelease(context.TODO())
+	assert.EqualError(t, err, "no previous release version to roll back to")
+}
+
+func TestTestHooks(t *testing.T) {
+	rel := &rpb.Release{Hooks: []*rpb.Hook{
+		{Name: "test-b", Events: []rpb.HookEvent{rpb.HookTest}},
+		{Name: "pre-install", Events: []rpb.HookEvent{rpb.HookPreInstall}},
+		{Name: "test-c", Events: []rpb.HookEvent{rpb.HookTest}, Weight: -1},
+		{Name: "test-a", Events: []rpb.HookEvent{rpb.HookPreUpgrade, rpb.HookTest}},
+	}}
+	var names []string
+	for _, h := range testHooks(rel) {
+		names = append(names, h.Name)
+	}
+	assert.Equal(t, []string{"test-c", "test-a", "test-b"}, names)
+}
+
+func TestHookPhase(t *testing.T) {
+	object := func(kind string, status map[string]interface{}) runtime.Object {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1", "kind": kind, "status": status,
+		}}
+	}
+	jobCondition := func(conditionType string) map[string]interface{} {
+		return map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "SuccessCriteriaMet", "status": "True"},
+			map[string]interface{}{"type": conditionType, "status": "True"},
+		}}
+	}
+
+	tests := []struct {
+		name     string
+		object   runtime.Object
+		expected rpb.HookPhase
+	}{
+		{"pending pod", object("Pod", map[string]interface{}{"phase": "Pending"}), rpb.HookPhaseRunning},
+		{"running pod", object("Pod", map[string]interface{}{"phase": "Running"}), rpb.HookPhaseRunning},
+		{"succeeded pod", object("Pod", map[string]interface{}{"phase": "Succeeded"}), rpb.HookPhaseSucceeded},
+		{"failed pod", object("Pod", map[string]interface{}{"phase": "Failed"}), rpb.HookPhaseFailed},
+		{"active job", object("Job", map[string]interface{}{"active": int64(1)}), rpb.HookPhaseRunning},
+		{"complete job", object("Job", jobCondition("Complete")), rpb.HookPhaseSucceeded},
+		{"failed job", object("Job", jobCondition("Failed")), rpb.HookPhaseFailed},
+		{"config map", object("ConfigMap", nil), rpb.HookPhaseSucceeded},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, hookPhase(test.object))
+		})
+	}
+}
+
 func newTestChart(t *testing.T, path string) *cpb.Chart {
 	chart, err := lpb.Load(path)
 	assert.Nil(t, err)
@@ -292,8 +499,10 @@ func newTestRelease(chart *cpb.Chart, values map[string]interface{}, name, names
 		Version:   1,
 	})
 
+	// the mock chart has metadata which decoding would not overwrite
 	buffer := &bytes.Buffer{}
 	_ = json.NewEncoder(buffer).Encode(chart)
+	release.Chart = &cpb.Chart{}
 	_ = json.NewDecoder(buffer).Decode(release.Chart)
 	release.Config = values
 	return release
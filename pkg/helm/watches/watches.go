@@ -27,6 +27,9 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/yaml"
+
+	"github.com/openvinotoolkit/operator/pkg/imagepolicy"
+	"github.com/openvinotoolkit/operator/pkg/maintenance"
 )
 
 const WatchesFile = "watches.yaml"
@@ -40,6 +43,35 @@ type Watch struct {
 	OverrideValues          map[string]string    `json:"overrideValues,omitempty"`
 	Selector                metav1.LabelSelector `json:"selector"`
 	ReconcilePeriod         metav1.Duration      `json:"reconcilePeriod,omitempty"`
+
+	// Wait, WaitForJobs, Timeout and Atomic configure how the operator
+	// tracks the readiness of release resources after an install or
+	// upgrade. They can be overridden per custom resource with annotations.
+	Wait        bool            `json:"wait,omitempty"`
+	WaitForJobs bool            `json:"waitForJobs,omitempty"`
+	Timeout     metav1.Duration `json:"timeout,omitempty"`
+	Atomic      bool            `json:"atomic,omitempty"`
+
+	// Test enables running the chart tests after each install or upgrade,
+	// RollbackOnTestFailure rolls back an upgrade whose tests failed.
+	Test                  bool `json:"test,omitempty"`
+	RollbackOnTestFailure bool `json:"rollbackOnTestFailure,omitempty"`
+
+	// NodeFeatureLabels overrides the node labels required by the target
+	// devices and CPU features of the ModelServer models, as label keys or
+	// key=value pairs. An empty label removes the constraint.
+	NodeFeatureLabels map[string]string `json:"nodeFeatureLabels,omitempty"`
+
+	// ImagePolicy restricts the images of the custom resources to allowed
+	// repositories and verifies their signatures before each install or
+	// upgrade.
+	ImagePolicy *imagepolicy.Policy `json:"imagePolicy,omitempty"`
+
+	// MaintenanceWindow restricts the upgrades of the releases to a new
+	// chart, which are not caused by a change of the custom resource, to a
+	// recurring window. It can be overridden per namespace and per custom
+	// resource with annotations.
+	MaintenanceWindow *maintenance.Window `json:"maintenanceWindow,omitempty"`
 }
 
 // UnmarshalYAML unmarshals an individual watch from the Helm watches.yaml file
@@ -111,10 +143,18 @@ func LoadReader(reader io.Reader) ([]Watch, error) {
 			return nil, fmt.Errorf("duplicate GVK: %s", gvk)
 		}
 		watchesMap[gvk] = struct{}{}
+		if w.Timeout.Duration < 0 {
+			return nil, fmt.Errorf("invalid timeout for %s: must not be negative", gvk)
+		}
 		if w.WatchDependentResources == nil {
 			trueVal := true
 			w.WatchDependentResources = &trueVal
 		}
+		if w.ImagePolicy != nil {
+			if err := w.ImagePolicy.Validate(); err != nil {
+				return nil, fmt.Errorf("invalid image policy for %s: %w", gvk, err)
+			}
+		}
 		w.OverrideValues, err = expandOverrideValues(w.OverrideValues)
 		if err != nil {
 			return nil, fmt.Errorf("failed to expand override values: %v", err)
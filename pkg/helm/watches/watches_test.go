@@ -18,11 +18,24 @@ import (
 	"bytes"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/openvinotoolkit/operator/pkg/imagepolicy"
+	"github.com/openvinotoolkit/operator/pkg/maintenance"
 )
 
+func mustWindow(schedule string, duration time.Duration) *maintenance.Window {
+	w, err := maintenance.NewWindow(schedule, duration)
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
+
 func TestLoadReader(t *testing.T) {
 	trueVal, falseVal := true, false
 	testCases := []struct {
@@ -101,6 +114,147 @@ func TestLoadReader(t *testing.T) {
 			},
 			expectErr: false,
 		},
+		{
+			name: "valid with release wait settings",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/plugins/helm/v1/chartutil/testdata/test-chart
+  wait: true
+  waitForJobs: true
+  timeout: 10m
+  atomic: true
+  test: true
+  rollbackOnTestFailure: true
+`,
+			expectWatches: []Watch{
+				{
+					GroupVersionKind:        schema.GroupVersionKind{Group: "mygroup", Version: "v1alpha1", Kind: "MyKind"},
+					ChartDir:                "../../../internal/plugins/helm/v1/chartutil/testdata/test-chart",
+					WatchDependentResources: &trueVal,
+					Wait:                    true,
+					WaitForJobs:             true,
+					Timeout:                 metav1.Duration{Duration: 10 * time.Minute},
+					Atomic:                  true,
+					Test:                    true,
+					RollbackOnTestFailure:   true,
+				},
+			},
+			expectErr: false,
+		},
+		{
+			name: "valid with node feature labels",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/plugins/helm/v1/chartutil/testdata/test-chart
+  nodeFeatureLabels:
+    GPU: gpu.intel.com/device-id.0300-56a0.present=true
+    NPU: ""
+`,
+			expectWatches: []Watch{
+				{
+					GroupVersionKind:        schema.GroupVersionKind{Group: "mygroup", Version: "v1alpha1", Kind: "MyKind"},
+					ChartDir:                "../../../internal/plugins/helm/v1/chartutil/testdata/test-chart",
+					WatchDependentResources: &trueVal,
+					NodeFeatureLabels: map[string]string{
+						"GPU": "gpu.intel.com/device-id.0300-56a0.present=true",
+						"NPU": "",
+					},
+				},
+			},
+			expectErr: false,
+		},
+		{
+			name: "valid with image policy",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/plugins/helm/v1/chartutil/testdata/test-chart
+  imagePolicy:
+    allowedRepositories:
+    - docker.io/openvino/model_server
+    - quay.io/openvino/
+    requireDigest: true
+    verification:
+      publicKeysSecret:
+        name: image-signing-keys
+`,
+			expectWatches: []Watch{
+				{
+					GroupVersionKind:        schema.GroupVersionKind{Group: "mygroup", Version: "v1alpha1", Kind: "MyKind"},
+					ChartDir:                "../../../internal/plugins/helm/v1/chartutil/testdata/test-chart",
+					WatchDependentResources: &trueVal,
+					ImagePolicy: &imagepolicy.Policy{
+						AllowedRepositories: []string{"docker.io/openvino/model_server", "quay.io/openvino/"},
+						RequireDigest:       true,
+						Verification: &imagepolicy.Verification{
+							PublicKeysSecret: imagepolicy.SecretReference{Name: "image-signing-keys"},
+						},
+					},
+				},
+			},
+			expectErr: false,
+		},
+		{
+			name: "image policy without public keys",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/plugins/helm/v1/chartutil/testdata/test-chart
+  imagePolicy:
+    verification: {}
+`,
+			expectErr: true,
+		},
+		{
+			name: "valid with maintenance window",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/plugins/helm/v1/chartutil/testdata/test-chart
+  maintenanceWindow:
+    schedule: 0 2 * * SAT,SUN
+    duration: 4h
+`,
+			expectWatches: []Watch{
+				{
+					GroupVersionKind:        schema.GroupVersionKind{Group: "mygroup", Version: "v1alpha1", Kind: "MyKind"},
+					ChartDir:                "../../../internal/plugins/helm/v1/chartutil/testdata/test-chart",
+					WatchDependentResources: &trueVal,
+					MaintenanceWindow:       mustWindow("0 2 * * SAT,SUN", 4*time.Hour),
+				},
+			},
+			expectErr: false,
+		},
+		{
+			name: "invalid maintenance window",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/plugins/helm/v1/chartutil/testdata/test-chart
+  maintenanceWindow:
+    schedule: 0 2 * *
+`,
+			expectErr: true,
+		},
+		{
+			name: "negative timeout",
+			data: `---
+- group: mygroup
+  version: v1alpha1
+  kind: MyKind
+  chart: ../../../internal/plugins/helm/v1/chartutil/testdata/test-chart
+  timeout: -1m
+`,
+			expectErr: true,
+		},
 		{
 			name: "duplicate gvk",
 			data: `---
@@ -0,0 +1,91 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package imagepolicy
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type cachedVerification struct {
+	digest  string
+	expires time.Time
+}
+
+type cachingVerifier struct {
+	verifier Verifier
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cachedVerification
+}
+
+// NewCachingVerifier returns a Verifier remembering the successful
+// verifications of the verifier for the ttl, so that the images of the
+// custom resources are not verified again on each reconciliation. Images
+// referenced by tag are resolved again once their verification expires.
+func NewCachingVerifier(verifier Verifier, ttl time.Duration) Verifier {
+	return &cachingVerifier{verifier: verifier, ttl: ttl, now: time.Now, entries: map[string]cachedVerification{}}
+}
+
+func (v *cachingVerifier) Verify(ctx context.Context, image Image, keys []crypto.PublicKey) (string, error) {
+	key, ok := cacheKey(image, keys)
+	now := v.now()
+	if ok {
+		v.mu.Lock()
+		entry, found := v.entries[key]
+		v.mu.Unlock()
+		if found && now.Before(entry.expires) {
+			return entry.digest, nil
+		}
+	}
+	d, err := v.verifier.Verify(ctx, image, keys)
+	if err != nil || !ok {
+		return d, err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for k, entry := range v.entries {
+		if !now.Before(entry.expires) {
+			delete(v.entries, k)
+		}
+	}
+	v.entries[key] = cachedVerification{digest: d, expires: now.Add(v.ttl)}
+	return d, nil
+}
+
+// cacheKey identifies the verification of the image with the keys, so that
+// a change of the trusted keys verifies the image again.
+func cacheKey(image Image, keys []crypto.PublicKey) (string, bool) {
+	h := sha256.New()
+	h.Write([]byte(image.String()))
+	for _, key := range keys {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return "", false
+		}
+		h.Write([]byte{0})
+		h.Write(der)
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
@@ -0,0 +1,75 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package imagepolicy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingVerifier struct {
+	calls int
+	err   error
+}
+
+func (v *countingVerifier) Verify(_ context.Context, _ Image, _ []crypto.PublicKey) (string, error) {
+	v.calls++
+	return "sha256:abc", v.err
+}
+
+func TestCachingVerifier(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	image, _ := ParseImage("openvino/model_server:2022.1")
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	counting := &countingVerifier{}
+	verifier := NewCachingVerifier(counting, 10*time.Minute).(*cachingVerifier)
+	verifier.now = func() time.Time { return now }
+	verify := func(keys ...crypto.PublicKey) {
+		d, err := verifier.Verify(context.TODO(), image, keys)
+		assert.Equal(t, "sha256:abc", d)
+		assert.Equal(t, counting.err, err)
+	}
+
+	verify(&key.PublicKey)
+	verify(&key.PublicKey)
+	assert.Equal(t, 1, counting.calls)
+
+	// the keys are part of the cache key
+	verify(&otherKey.PublicKey)
+	assert.Equal(t, 2, counting.calls)
+
+	now = now.Add(10 * time.Minute)
+	verify(&key.PublicKey)
+	assert.Equal(t, 3, counting.calls)
+	assert.Len(t, verifier.entries, 1)
+
+	// failures are not cached
+	counting.err = errors.New("not signed")
+	verify(&otherKey.PublicKey)
+	verify(&otherKey.PublicKey)
+	assert.Equal(t, 5, counting.calls)
+}
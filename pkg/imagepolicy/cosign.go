@@ -0,0 +1,150 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package imagepolicy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// signatureAnnotation is the annotation of the layers of a cosign signature
+// manifest holding the base64 encoded signature of the layer, which is the
+// simple signing payload.
+const signatureAnnotation = "dev.cosignproject.cosign/signature"
+
+// Verifier verifies the signatures of images.
+type Verifier interface {
+	// Verify checks that the image is signed with any of the public keys
+	// and returns the signed digest of its manifest.
+	Verify(ctx context.Context, image Image, keys []crypto.PublicKey) (string, error)
+}
+
+// simpleSigning is the signed payload of a cosign signature.
+type simpleSigning struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+type cosignVerifier struct {
+	registry *Registry
+}
+
+// NewCosignVerifier returns a Verifier of the signatures created by cosign
+// with a key pair, which are stored in the registry of the image under the
+// tag sha256-<digest>.sig. Keyless signatures and transparency log entries
+// are not checked.
+func NewCosignVerifier(registry *Registry) Verifier {
+	return &cosignVerifier{registry: registry}
+}
+
+func (v *cosignVerifier) Verify(ctx context.Context, image Image, keys []crypto.PublicKey) (string, error) {
+	if len(keys) == 0 {
+		return "", errors.New("no trusted public key is configured")
+	}
+	imageDigest, err := v.registry.Digest(ctx, image)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve image %s: %w", image, err)
+	}
+	signatureTag := strings.Replace(imageDigest, ":", "-", 1) + ".sig"
+	data, err := v.registry.Manifest(ctx, image, signatureTag)
+	if errors.Is(err, ErrNotFound) {
+		return "", fmt.Errorf("image %s@%s is not signed", image.Repository(), imageDigest)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get the signatures of image %s: %w", image, err)
+	}
+	manifest := ocispec.Manifest{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", fmt.Errorf("invalid signature manifest of image %s: %w", image, err)
+	}
+
+	for _, layer := range manifest.Layers {
+		signature, err := base64.StdEncoding.DecodeString(layer.Annotations[signatureAnnotation])
+		if err != nil || len(signature) == 0 {
+			continue
+		}
+		payload, err := v.registry.Blob(ctx, image, layer.Digest)
+		if err != nil {
+			return "", fmt.Errorf("failed to get the signature payload of image %s: %w", image, err)
+		}
+		signed := simpleSigning{}
+		if err := json.Unmarshal(payload, &signed); err != nil ||
+			signed.Critical.Image.DockerManifestDigest != imageDigest {
+			continue
+		}
+		for _, key := range keys {
+			if verifySignature(key, payload, signature) {
+				return imageDigest, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no signature of image %s@%s matches the trusted public keys", image.Repository(), imageDigest)
+}
+
+// verifySignature verifies a signature of the payload created by cosign
+// with the private key of the public key.
+func verifySignature(key crypto.PublicKey, payload, signature []byte) bool {
+	hash := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, hash[:], signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, payload, signature)
+	}
+	return false
+}
+
+// ParsePublicKeys parses the PEM encoded public keys, like the cosign.pub
+// files generated by cosign.
+func ParsePublicKeys(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,167 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package imagepolicy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+// sign stores a cosign signature of the image digest in the registry,
+// created with the signing function.
+func sign(t *testing.T, registry *testRegistry, repository, imageDigest string, signFn func([]byte) []byte) {
+	payload := fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"%s/%s"},`+
+		`"image":{"docker-manifest-digest":"%s"},"type":"cosign container image signature"},"optional":null}`,
+		registry.host(), repository, imageDigest)
+	layer := registry.putBlob([]byte(payload))
+	manifest, err := json.Marshal(ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Layers: []ocispec.Descriptor{{
+			MediaType:   "application/vnd.dev.cosign.simplesigning.v1+json",
+			Digest:      layer,
+			Size:        int64(len(payload)),
+			Annotations: map[string]string{signatureAnnotation: base64.StdEncoding.EncodeToString(signFn([]byte(payload)))},
+		}},
+	})
+	assert.NoError(t, err)
+	registry.putManifest(repository, manifest, strings.Replace(imageDigest, ":", "-", 1)+".sig")
+}
+
+func TestCosignVerifier(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	signECDSA := func(payload []byte) []byte {
+		hash := sha256.Sum256(payload)
+		signature, err := ecdsa.SignASN1(rand.Reader, ecKey, hash[:])
+		assert.NoError(t, err)
+		return signature
+	}
+	edPublic, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	signEd25519 := func(payload []byte) []byte {
+		return ed25519.Sign(edKey, payload)
+	}
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	registry := newTestRegistry(t, "secret-token")
+	signed := registry.putManifest("openvino/model_server", []byte(`{"schemaVersion":2,"layers":[]}`), "2022.1")
+	sign(t, registry, "openvino/model_server", signed, signECDSA)
+	edSigned := registry.putManifest("openvino/notebooks", []byte(`{"schemaVersion":2}`), "v1")
+	sign(t, registry, "openvino/notebooks", edSigned, signEd25519)
+	unsigned := registry.putManifest("openvino/model_server", []byte(`{"schemaVersion":2,"layers":null}`), "unsigned")
+	// a signature of another image copied to the tag of the unsigned one
+	moved := registry.putManifest("openvino/model_server", []byte(`{"schemaVersion":2,"config":null}`), "moved")
+	registry.manifests["openvino/model_server:"+strings.Replace(moved, ":", "-", 1)+".sig"] =
+		registry.manifests["openvino/model_server:"+strings.Replace(signed, ":", "-", 1)+".sig"]
+
+	verifier := NewCosignVerifier(NewRegistry(nil, []string{registry.host()}))
+	repository := registry.host() + "/openvino/model_server"
+	tests := []struct {
+		name       string
+		image      string
+		keys       []crypto.PublicKey
+		digest     string
+		errMessage string
+	}{
+		{
+			name:   "tag",
+			image:  repository + ":2022.1",
+			keys:   []crypto.PublicKey{&otherKey.PublicKey, &ecKey.PublicKey},
+			digest: signed,
+		},
+		{
+			name:   "digest",
+			image:  repository + "@" + signed,
+			keys:   []crypto.PublicKey{&ecKey.PublicKey},
+			digest: signed,
+		},
+		{
+			name:   "ed25519",
+			image:  registry.host() + "/openvino/notebooks:v1",
+			keys:   []crypto.PublicKey{edPublic},
+			digest: edSigned,
+		},
+		{
+			name:       "untrusted key",
+			image:      repository + ":2022.1",
+			keys:       []crypto.PublicKey{&otherKey.PublicKey},
+			errMessage: "no signature of image " + repository + "@" + signed + " matches the trusted public keys",
+		},
+		{
+			name:       "unsigned",
+			image:      repository + ":unsigned",
+			keys:       []crypto.PublicKey{&ecKey.PublicKey},
+			errMessage: "image " + repository + "@" + unsigned + " is not signed",
+		},
+		{
+			name:       "signature of another image",
+			image:      repository + ":moved",
+			keys:       []crypto.PublicKey{&ecKey.PublicKey},
+			errMessage: "no signature of image " + repository + "@" + moved + " matches the trusted public keys",
+		},
+		{
+			name:       "no keys",
+			image:      repository + ":2022.1",
+			errMessage: "no trusted public key is configured",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			image, err := ParseImage(test.image)
+			assert.NoError(t, err)
+			d, err := verifier.Verify(context.TODO(), image, test.keys)
+			if test.errMessage != "" {
+				assert.EqualError(t, err, test.errMessage)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.digest, d)
+		})
+	}
+}
+
+func TestParsePublicKeys(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPublic, _, _ := ed25519.GenerateKey(rand.Reader)
+	var data []byte
+	for _, key := range []crypto.PublicKey{&ecKey.PublicKey, edPublic} {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		assert.NoError(t, err)
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+	}
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("ignored")})...)
+
+	keys, err := ParsePublicKeys(data)
+	assert.NoError(t, err)
+	assert.Equal(t, []crypto.PublicKey{&ecKey.PublicKey, edPublic}, keys)
+
+	_, err = ParsePublicKeys(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("invalid")}))
+	assert.Error(t, err)
+}
@@ -0,0 +1,173 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package imagepolicy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+// Policy restricts the images of the custom resources reconciled by a
+// watch.
+type Policy struct {
+	// AllowedRepositories are the repositories images may be pulled from,
+	// normalized like the images, so that openvino/model_server is
+	// docker.io/openvino/model_server.
+	// An entry ending with a slash, or with /*, allows all the repositories
+	// under it. All repositories are allowed if it is empty.
+	AllowedRepositories []string `json:"allowedRepositories,omitempty"`
+	// RequireDigest rejects images not referenced by digest.
+	RequireDigest bool `json:"requireDigest,omitempty"`
+	// Verification enables the verification of the cosign signatures of the
+	// images.
+	Verification *Verification `json:"verification,omitempty"`
+}
+
+// Verification configures the verification of the cosign signatures of the
+// images.
+type Verification struct {
+	// PublicKeysSecret is the Secret holding the PEM encoded public keys
+	// trusted to sign the images, one or more per key. An image must be
+	// signed with any of them.
+	PublicKeysSecret SecretReference `json:"publicKeysSecret"`
+	// InsecureRegistries are the registries reached over plain HTTP.
+	InsecureRegistries []string `json:"insecureRegistries,omitempty"`
+}
+
+// SecretReference selects a Secret. The namespace of the operator is used
+// if Namespace is empty.
+type SecretReference struct {
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// Validate checks the policy settings.
+func (p *Policy) Validate() error {
+	for _, repository := range p.AllowedRepositories {
+		if strings.TrimSuffix(strings.TrimSuffix(repository, "*"), "/") == "" {
+			return fmt.Errorf("invalid allowed repository %q", repository)
+		}
+	}
+	if p.Verification != nil && p.Verification.PublicKeysSecret.Name == "" {
+		return errors.New("verification.publicKeysSecret.name is required")
+	}
+	return nil
+}
+
+// Image is a parsed image reference.
+type Image struct {
+	reference.Named
+}
+
+// ParseImage parses an image reference, normalized like the container
+// runtimes do, so that openvino/model_server is the repository
+// docker.io/openvino/model_server.
+func ParseImage(image string) (Image, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return Image{}, fmt.Errorf("invalid image %q: %w", image, err)
+	}
+	return Image{named}, nil
+}
+
+// Repository returns the normalized repository of the image, without tag or
+// digest.
+func (i Image) Repository() string {
+	return i.Name()
+}
+
+// Digest returns the digest of the image reference, or an empty string if
+// it is referenced by tag only.
+func (i Image) Digest() string {
+	if d, ok := i.Named.(reference.Digested); ok {
+		return d.Digest().String()
+	}
+	return ""
+}
+
+// Tag returns the tag of the image reference, latest if neither a tag nor a
+// digest is set.
+func (i Image) Tag() string {
+	if t, ok := i.Named.(reference.Tagged); ok {
+		return t.Tag()
+	}
+	if i.Digest() != "" {
+		return ""
+	}
+	return "latest"
+}
+
+// Allowed reports whether the repository of the image is allowed. The
+// allowed repositories are normalized like the images, so that
+// openvino/model_server allows docker.io/openvino/model_server.
+func (p *Policy) Allowed(image Image) bool {
+	if len(p.AllowedRepositories) == 0 {
+		return true
+	}
+	repository := image.Repository()
+	for _, allowed := range p.AllowedRepositories {
+		if prefix := strings.TrimSuffix(allowed, "*"); strings.HasSuffix(prefix, "/") {
+			if strings.HasPrefix(repository, normalizePrefix(prefix)) {
+				return true
+			}
+		} else if repository == normalizeRepository(allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeRepository returns the normalized form of an allowed repository.
+func normalizeRepository(repository string) string {
+	named, err := reference.ParseNormalizedNamed(repository)
+	if err != nil {
+		return repository
+	}
+	return named.Name()
+}
+
+// normalizePrefix returns the normalized form of an allowed repository
+// prefix ending with a slash. A prefix starting with a registry, like
+// quay.io/, is kept, and other prefixes, like openvino/, are in Docker Hub.
+func normalizePrefix(prefix string) string {
+	registry, _, _ := strings.Cut(prefix, "/")
+	if strings.ContainsAny(registry, ".:") || registry == "localhost" {
+		return prefix
+	}
+	return "docker.io/" + prefix
+}
+
+// Check parses the image and checks that it is allowed by the repositories
+// and the digest requirement of the policy. The signature is verified
+// separately.
+func (p *Policy) Check(image string) (Image, error) {
+	parsed, err := ParseImage(image)
+	if err != nil {
+		return parsed, err
+	}
+	if !p.Allowed(parsed) {
+		return parsed, fmt.Errorf("image %q is not in an allowed repository: %s", image,
+			strings.Join(p.AllowedRepositories, ", "))
+	}
+	if p.RequireDigest && parsed.Digest() == "" {
+		return parsed, fmt.Errorf("image %q must be referenced by digest", image)
+	}
+	return parsed, nil
+}
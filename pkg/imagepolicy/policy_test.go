@@ -0,0 +1,104 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package imagepolicy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	policy := &Policy{
+		AllowedRepositories: []string{"docker.io/openvino/model_server", "quay.io/openvino/*", "registry.example.com/"},
+		RequireDigest:       true,
+	}
+	const sha = "sha256:0d3e36b4b3a5a8e0e1e3d1c9a4d3f1b5c2e6a7b8c9d0e1f2a3b4c5d6e7f8a9b0"
+
+	tests := []struct {
+		image      string
+		repository string
+		errMessage string
+	}{
+		{image: "openvino/model_server@" + sha, repository: "docker.io/openvino/model_server"},
+		{image: "quay.io/openvino/notebooks:v1@" + sha, repository: "quay.io/openvino/notebooks"},
+		{image: "registry.example.com/team/ovms@" + sha, repository: "registry.example.com/team/ovms"},
+		{
+			image:      "openvino/model_server:2022.1",
+			errMessage: `image "openvino/model_server:2022.1" must be referenced by digest`,
+		},
+		{
+			image: "openvino/model_server_gpu@" + sha,
+			errMessage: `image "openvino/model_server_gpu@` + sha + `" is not in an allowed repository: ` +
+				"docker.io/openvino/model_server, quay.io/openvino/*, registry.example.com/",
+		},
+		{
+			image: "quay.io/openvino@" + sha,
+			errMessage: `image "quay.io/openvino@` + sha + `" is not in an allowed repository: ` +
+				"docker.io/openvino/model_server, quay.io/openvino/*, registry.example.com/",
+		},
+		{
+			image:      "Model_Server",
+			errMessage: `invalid image "Model_Server": invalid reference format: repository name (library/Model_Server) must be lowercase`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+			image, err := policy.Check(test.image)
+			if test.errMessage != "" {
+				assert.EqualError(t, err, test.errMessage)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.repository, image.Repository())
+		})
+	}
+
+	image, err := (&Policy{}).Check("openvino/model_server")
+	assert.NoError(t, err)
+	assert.Equal(t, "latest", image.Tag())
+	assert.Equal(t, "", image.Digest())
+}
+
+func TestPolicyAllowedNormalized(t *testing.T) {
+	policy := &Policy{AllowedRepositories: []string{
+		"openvino/model_server", "nginx", "curlimages/", "localhost:5000/", "quay.io/openvino/*",
+	}}
+	for image, allowed := range map[string]bool{
+		"docker.io/openvino/model_server:2024.0": true,
+		"openvino/model_server:2024.0":           true,
+		"docker.io/library/nginx:1.27":           true,
+		"nginx:1.27":                             true,
+		"curlimages/curl:8.7.1":                  true,
+		"localhost:5000/models/ovms":             true,
+		"quay.io/openvino/model_server":          true,
+		"openvino/model_server_gpu":              false,
+		"quay.io/nginx":                          false,
+		"docker.io/localhost/ovms":               false,
+	} {
+		parsed, err := ParseImage(image)
+		assert.NoError(t, err)
+		assert.Equal(t, allowed, policy.Allowed(parsed), image)
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	assert.NoError(t, (&Policy{AllowedRepositories: []string{"quay.io/openvino/"}}).Validate())
+	assert.EqualError(t, (&Policy{AllowedRepositories: []string{"/*"}}).Validate(), `invalid allowed repository "/*"`)
+	assert.EqualError(t, (&Policy{Verification: &Verification{}}).Validate(),
+		"verification.publicKeysSecret.name is required")
+}
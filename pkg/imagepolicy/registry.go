@@ -0,0 +1,251 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package imagepolicy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// defaultTimeout limits the duration of a single request to a
+	// registry.
+	defaultTimeout = 30 * time.Second
+
+	// maxManifestSize limits the size of the manifests and signature
+	// payloads read from a registry.
+	maxManifestSize = 4 << 20
+
+	dockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// ErrNotFound is wrapped by the errors returned for missing manifests and
+// blobs.
+var ErrNotFound = errors.New("not found")
+
+// manifestTypes are the accepted media types of the image manifests.
+var manifestTypes = strings.Join([]string{
+	ocispec.MediaTypeImageManifest, ocispec.MediaTypeImageIndex, dockerManifest, dockerManifestList,
+}, ", ")
+
+// Resolver resolves image references to the digests of their manifests.
+type Resolver interface {
+	Digest(ctx context.Context, image Image) (string, error)
+}
+
+// Registry reads manifests and blobs over the OCI distribution API, either
+// anonymously or with the bearer tokens issued by the token service of the
+// registry.
+type Registry struct {
+	client   *http.Client
+	insecure map[string]bool
+}
+
+// NewRegistry returns a Registry sending requests with the passed HTTP
+// client, or with a default client if it is nil. The insecure registries
+// are reached over plain HTTP.
+func NewRegistry(httpClient *http.Client, insecureRegistries []string) *Registry {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+	r := &Registry{client: httpClient, insecure: map[string]bool{}}
+	for _, registry := range insecureRegistries {
+		r.insecure[registry] = true
+	}
+	return r
+}
+
+// Digest returns the digest of the manifest of the image, which is the
+// digest of its reference if set, otherwise the digest of its tag.
+func (r *Registry) Digest(ctx context.Context, image Image) (string, error) {
+	if d := image.Digest(); d != "" {
+		return d, nil
+	}
+	resp, err := r.get(ctx, http.MethodHead, image, "manifests/"+image.Tag(), manifestTypes)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if d := resp.Header.Get("Docker-Content-Digest"); d != "" {
+		return d, nil
+	}
+	// registries may omit the digest header of HEAD requests
+	manifest, err := r.Manifest(ctx, image, image.Tag())
+	if err != nil {
+		return "", err
+	}
+	return digest.FromBytes(manifest).String(), nil
+}
+
+// Manifest returns the manifest of the image repository with the tag or
+// digest.
+func (r *Registry) Manifest(ctx context.Context, image Image, tagOrDigest string) ([]byte, error) {
+	resp, err := r.get(ctx, http.MethodGet, image, "manifests/"+tagOrDigest, manifestTypes)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+}
+
+// Blob returns the content of a blob of the image repository and checks
+// its digest.
+func (r *Registry) Blob(ctx context.Context, image Image, d digest.Digest) ([]byte, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid blob digest %q: %w", d, err)
+	}
+	resp, err := r.get(ctx, http.MethodGet, image, "blobs/"+d.String(), "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	blob, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, err
+	}
+	if !d.Algorithm().Available() || d.Algorithm().FromBytes(blob) != d {
+		return nil, fmt.Errorf("blob %s of %s does not match its digest", d, image.Repository())
+	}
+	return blob, nil
+}
+
+// get sends a request to the API of the repository of the image, and
+// retries it with a bearer token if the registry requires one.
+func (r *Registry) get(ctx context.Context, method string, image Image, path, accept string) (*http.Response, error) {
+	domain := reference.Domain(image.Named)
+	host, scheme := domain, "https"
+	if domain == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	if r.insecure[domain] {
+		scheme = "http"
+	}
+	u := fmt.Sprintf("%s://%s/v2/%s/%s", scheme, host, reference.Path(image.Named), path)
+
+	token := ""
+	for {
+		req, err := http.NewRequestWithContext(ctx, method, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := r.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reach the registry %s: %w", domain, err)
+		}
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			return resp, nil
+		case resp.StatusCode == http.StatusUnauthorized && token == "":
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			token, err = r.token(ctx, challenge, reference.Path(image.Named))
+			if err != nil {
+				return nil, fmt.Errorf("failed to authenticate to the registry %s: %w", domain, err)
+			}
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%s of %s %w", path, image.Repository(), ErrNotFound)
+		}
+		return nil, fmt.Errorf("unexpected response of the registry %s to %s: %s", domain, path, resp.Status)
+	}
+}
+
+// token requests an anonymous pull token from the token service of the
+// Bearer authentication challenge.
+func (r *Registry) token(ctx context.Context, challenge, repository string) (string, error) {
+	params := parseChallenge(challenge)
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+	query := url.Values{}
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", repository)
+	}
+	query.Set("scope", scope)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response of the token service: %s", resp.Status)
+	}
+	var result struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid response of the token service: %w", err)
+	}
+	if result.Token != "" {
+		return result.Token, nil
+	}
+	if result.AccessToken != "" {
+		return result.AccessToken, nil
+	}
+	return "", errors.New("the token service returned no token")
+}
+
+// parseChallenge returns the parameters of a Bearer WWW-Authenticate
+// header, like realm="https://auth.docker.io/token",service="registry.docker.io".
+func parseChallenge(challenge string) map[string]string {
+	params := map[string]string{}
+	scheme, rest, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return params
+	}
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, ", "), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return params
+}
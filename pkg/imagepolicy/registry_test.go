@@ -0,0 +1,140 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package imagepolicy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+// testRegistry is an in-process registry serving the manifests and blobs of
+// the OCI distribution API, optionally behind a token service.
+type testRegistry struct {
+	*httptest.Server
+	manifests map[string][]byte
+	blobs     map[digest.Digest][]byte
+	token     string
+}
+
+func newTestRegistry(t *testing.T, token string) *testRegistry {
+	r := &testRegistry{manifests: map[string][]byte{}, blobs: map[digest.Digest][]byte{}, token: token}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "test-registry", req.URL.Query().Get("service"))
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": r.token})
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, req *http.Request) {
+		if r.token != "" && req.Header.Get("Authorization") != "Bearer "+r.token {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+r.URL+`/token",service="test-registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		path := strings.TrimPrefix(req.URL.Path, "/v2/")
+		if i := strings.LastIndex(path, "/manifests/"); i >= 0 {
+			manifest, ok := r.manifests[path[:i]+":"+path[i+len("/manifests/"):]]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", digest.FromBytes(manifest).String())
+			if req.Method == http.MethodGet {
+				_, _ = w.Write(manifest)
+			}
+			return
+		}
+		if i := strings.LastIndex(path, "/blobs/"); i >= 0 {
+			blob, ok := r.blobs[digest.Digest(path[i+len("/blobs/"):])]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(blob)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r.Server = httptest.NewServer(mux)
+	t.Cleanup(r.Close)
+	return r
+}
+
+// host returns the registry domain of the images of the test registry.
+func (r *testRegistry) host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+// putManifest stores a manifest of the repository with its digest and the
+// tags, and returns its digest.
+func (r *testRegistry) putManifest(repository string, manifest []byte, tags ...string) string {
+	d := digest.FromBytes(manifest).String()
+	r.manifests[repository+":"+d] = manifest
+	for _, tag := range tags {
+		r.manifests[repository+":"+tag] = manifest
+	}
+	return d
+}
+
+// putBlob stores a blob and returns its digest.
+func (r *testRegistry) putBlob(blob []byte) digest.Digest {
+	d := digest.FromBytes(blob)
+	r.blobs[d] = blob
+	return d
+}
+
+func TestRegistry(t *testing.T) {
+	for _, token := range []string{"", "secret-token"} {
+		registry := newTestRegistry(t, token)
+		manifestDigest := registry.putManifest("openvino/model_server", []byte(`{"schemaVersion":2}`), "2022.1")
+		blob := registry.putBlob([]byte("layer"))
+		client := NewRegistry(nil, []string{registry.host()})
+
+		image, err := ParseImage(registry.host() + "/openvino/model_server:2022.1")
+		assert.NoError(t, err)
+		d, err := client.Digest(context.TODO(), image)
+		assert.NoError(t, err)
+		assert.Equal(t, manifestDigest, d)
+
+		content, err := client.Blob(context.TODO(), image, blob)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("layer"), content)
+
+		registry.blobs[blob] = []byte("tampered")
+		_, err = client.Blob(context.TODO(), image, blob)
+		assert.EqualError(t, err, "blob "+blob.String()+" of "+registry.host()+"/openvino/model_server does not match its digest")
+
+		missing, _ := ParseImage(registry.host() + "/openvino/model_server:2021.4")
+		_, err = client.Digest(context.TODO(), missing)
+		assert.True(t, errors.Is(err, ErrNotFound))
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	assert.Equal(t, map[string]string{
+		"realm":   "https://auth.docker.io/token",
+		"service": "registry.docker.io",
+		"scope":   "repository:openvino/model_server:pull",
+	}, parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:openvino/model_server:pull"`))
+	assert.Empty(t, parseChallenge(`Basic realm="registry"`))
+}
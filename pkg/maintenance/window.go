@@ -0,0 +1,161 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package maintenance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// DefaultDuration is the duration of the windows set without one.
+const DefaultDuration = time.Hour
+
+// Window is a recurring maintenance window, opening at each activation of
+// its schedule for its duration.
+type Window struct {
+	spec     string
+	schedule cron.Schedule
+	duration time.Duration
+}
+
+// NewWindow returns the window opening on the cron schedule for the
+// duration. The schedule has the standard five fields or a descriptor like
+// @weekly, in UTC unless a CRON_TZ= time zone is set.
+func NewWindow(schedule string, duration time.Duration) (*Window, error) {
+	if duration <= 0 {
+		return nil, errors.New("the maintenance window duration must be positive")
+	}
+	s, err := parseSchedule(schedule)
+	if err != nil {
+		return nil, err
+	}
+	return &Window{spec: schedule, schedule: s, duration: duration}, nil
+}
+
+// NewDailyWindow returns the window opening at the start time of day, as
+// HH:MM in UTC, on the weekdays, or every day if none is set, for the
+// duration. The weekdays are named in full or by their first three letters.
+// It converts the windows given as a time of day to a schedule.
+func NewDailyWindow(start string, days []string, duration time.Duration) (*Window, error) {
+	t, err := time.Parse("15:04", start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start %q, expected HH:MM", start)
+	}
+	weekdays := "*"
+	if len(days) > 0 {
+		selected := map[time.Weekday]bool{}
+		for _, day := range days {
+			weekday, ok := parseWeekday(day)
+			if !ok {
+				return nil, fmt.Errorf("invalid day %q", day)
+			}
+			selected[weekday] = true
+		}
+		var fields []string
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if selected[d] {
+				fields = append(fields, strconv.Itoa(int(d)))
+			}
+		}
+		weekdays = strings.Join(fields, ",")
+	}
+	return NewWindow(fmt.Sprintf("%d %d * * %s", t.Minute(), t.Hour(), weekdays), duration)
+}
+
+// parseWeekday parses a weekday name, or its first three letters.
+func parseWeekday(day string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(day, d.String()) || strings.EqualFold(day, d.String()[:3]) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+func parseSchedule(spec string) (cron.Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every") {
+		return nil, fmt.Errorf("invalid schedule %q: @every is not supported", spec)
+	}
+	if !strings.HasPrefix(spec, "CRON_TZ=") && !strings.HasPrefix(spec, "TZ=") {
+		// the parser defaults to the local time zone
+		spec = "CRON_TZ=UTC " + spec
+	}
+	s, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule: %w", err)
+	}
+	return s, nil
+}
+
+// Open reports whether the window is open at the time. A nil window is
+// always open.
+func (w *Window) Open(now time.Time) bool {
+	if w == nil {
+		return true
+	}
+	start := w.schedule.Next(now.Add(-w.duration))
+	return !start.IsZero() && !start.After(now)
+}
+
+// Next returns the next time the window opens after the time, or the zero
+// time if it never opens again.
+func (w *Window) Next(now time.Time) time.Time {
+	return w.schedule.Next(now)
+}
+
+func (w *Window) String() string {
+	return fmt.Sprintf("%q for %s", w.spec, w.duration)
+}
+
+// windowJSON is the serialized form of a window, like
+// {"schedule": "0 2 * * SAT", "duration": "4h"}.
+type windowJSON struct {
+	Schedule string `json:"schedule"`
+	Duration string `json:"duration,omitempty"`
+}
+
+func (w *Window) MarshalJSON() ([]byte, error) {
+	return json.Marshal(windowJSON{Schedule: w.spec, Duration: w.duration.String()})
+}
+
+func (w *Window) UnmarshalJSON(data []byte) error {
+	var in windowJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	duration := DefaultDuration
+	if in.Duration != "" {
+		d, err := time.ParseDuration(in.Duration)
+		if err != nil {
+			return fmt.Errorf("invalid maintenance window duration %q: %w", in.Duration, err)
+		}
+		duration = d
+	}
+	parsed, err := NewWindow(in.Schedule, duration)
+	if err != nil {
+		return err
+	}
+	*w = *parsed
+	return nil
+}
@@ -0,0 +1,159 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package maintenance
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindowNext(t *testing.T) {
+	// a Wednesday
+	now := time.Date(2022, 6, 1, 10, 30, 20, 0, time.UTC)
+
+	tests := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{spec: "* * * * *", expected: time.Date(2022, 6, 1, 10, 31, 0, 0, time.UTC)},
+		{spec: "0 2 * * *", expected: time.Date(2022, 6, 2, 2, 0, 0, 0, time.UTC)},
+		{spec: "30 22 * * SAT,sun", expected: time.Date(2022, 6, 4, 22, 30, 0, 0, time.UTC)},
+		{spec: "*/20 9-17 * * mon-fri", expected: time.Date(2022, 6, 1, 10, 40, 0, 0, time.UTC)},
+		{spec: "0 3 1 * *", expected: time.Date(2022, 7, 1, 3, 0, 0, 0, time.UTC)},
+		// either day field matches if both are restricted
+		{spec: "0 3 15 * 5", expected: time.Date(2022, 6, 3, 3, 0, 0, 0, time.UTC)},
+		{spec: "0 0 29 2 *", expected: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{spec: "@weekly", expected: time.Date(2022, 6, 5, 0, 0, 0, 0, time.UTC)},
+		{spec: "CRON_TZ=Europe/Berlin 0 2 * * *", expected: time.Date(2022, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 31 2 *"},
+	}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			w, err := NewWindow(test.spec, time.Hour)
+			assert.NoError(t, err)
+			next := w.Next(now)
+			assert.True(t, test.expected.Equal(next), "expected %s, got %s", test.expected, next)
+		})
+	}
+}
+
+func TestNewWindowErrors(t *testing.T) {
+	tests := []struct {
+		spec       string
+		errMessage string
+	}{
+		{spec: "0 2 * *", errMessage: "invalid schedule: expected exactly 5 fields, found 4: [0 2 * *]"},
+		{spec: "60 2 * * *", errMessage: "invalid schedule: end of range (60) above maximum (59): 60"},
+		{spec: "0 2 * * weekend", errMessage: `invalid schedule: failed to parse int from weekend: strconv.Atoi: parsing "weekend": invalid syntax`},
+		{spec: "0 5-2 * * *", errMessage: "invalid schedule: beginning of range (5) beyond end of range (2): 5-2"},
+		{spec: "@every 1h", errMessage: `invalid schedule "@every 1h": @every is not supported`},
+		{spec: "CRON_TZ=Mars/Olympus 0 2 * * *", errMessage: "invalid schedule: provided bad location Mars/Olympus: unknown time zone Mars/Olympus"},
+	}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			_, err := NewWindow(test.spec, time.Hour)
+			assert.EqualError(t, err, test.errMessage)
+		})
+	}
+}
+
+func TestNewDailyWindow(t *testing.T) {
+	tests := []struct {
+		start      string
+		days       []string
+		spec       string
+		errMessage string
+	}{
+		{start: "02:30", spec: "30 2 * * *"},
+		{start: "22:00", days: []string{"sat", "Sunday", "SAT"}, spec: "0 22 * * 0,6"},
+		{start: "2am", errMessage: `invalid start "2am", expected HH:MM`},
+		{start: "02:00", days: []string{"Weekend"}, errMessage: `invalid day "Weekend"`},
+	}
+	for _, test := range tests {
+		t.Run(test.start, func(t *testing.T) {
+			w, err := NewDailyWindow(test.start, test.days, 4*time.Hour)
+			if test.errMessage != "" {
+				assert.EqualError(t, err, test.errMessage)
+				return
+			}
+			assert.NoError(t, err)
+			expected, err := NewWindow(test.spec, 4*time.Hour)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, w)
+		})
+	}
+
+	// the window of a day lasts past midnight
+	w, err := NewDailyWindow("22:00", []string{"Saturday"}, 4*time.Hour)
+	assert.NoError(t, err)
+	saturday := time.Date(2022, 6, 4, 0, 0, 0, 0, time.UTC)
+	assert.False(t, w.Open(saturday.Add(21*time.Hour)))
+	assert.True(t, w.Open(saturday.Add(25*time.Hour)))
+	assert.False(t, w.Open(saturday.Add(26*time.Hour)))
+	assert.True(t, saturday.AddDate(0, 0, 7).Add(22*time.Hour).Equal(w.Next(saturday.Add(23*time.Hour))))
+}
+
+func TestWindow(t *testing.T) {
+	// 22:00 to 02:00 UTC, starting on Saturdays
+	w, err := NewWindow("0 22 * * SAT", 4*time.Hour)
+	assert.NoError(t, err)
+	saturday := time.Date(2022, 6, 4, 0, 0, 0, 0, time.UTC)
+	nextWeek := saturday.AddDate(0, 0, 7).Add(22 * time.Hour)
+
+	tests := []struct {
+		now  time.Time
+		open bool
+		next time.Time
+	}{
+		{now: saturday.Add(21 * time.Hour), next: saturday.Add(22 * time.Hour)},
+		{now: saturday.Add(22 * time.Hour), open: true, next: nextWeek},
+		{now: saturday.Add(25 * time.Hour), open: true, next: nextWeek},
+		{now: saturday.Add(26 * time.Hour), next: nextWeek},
+		{now: saturday.Add(-time.Hour), next: saturday.Add(22 * time.Hour)},
+	}
+	for _, test := range tests {
+		t.Run(test.now.String(), func(t *testing.T) {
+			assert.Equal(t, test.open, w.Open(test.now))
+			assert.Equal(t, test.next, w.Next(test.now))
+		})
+	}
+
+	var always *Window
+	assert.True(t, always.Open(saturday))
+	assert.Equal(t, `"0 22 * * SAT" for 4h0m0s`, w.String())
+
+	_, err = NewWindow("0 22 * * SAT", 0)
+	assert.EqualError(t, err, "the maintenance window duration must be positive")
+}
+
+func TestWindowJSON(t *testing.T) {
+	w := &Window{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"schedule": "0 2 * * SAT,SUN"}`), w))
+	expected, _ := NewWindow("0 2 * * SAT,SUN", DefaultDuration)
+	assert.Equal(t, expected, w)
+
+	data, err := json.Marshal(w)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"schedule": "0 2 * * SAT,SUN", "duration": "1h0m0s"}`, string(data))
+
+	assert.EqualError(t, json.Unmarshal([]byte(`{"schedule": "0 2 * * SAT", "duration": "1d"}`), w),
+		`invalid maintenance window duration "1d": time: unknown unit "d" in duration "1d"`)
+	assert.Error(t, json.Unmarshal([]byte(`{"schedule": "daily"}`), w))
+}
@@ -0,0 +1,138 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package modelregistry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	resty "github.com/go-resty/resty/v2"
+)
+
+// mlflowReady is the status of a model version whose registration
+// completed.
+const mlflowReady = "READY"
+
+type mlflowModelVersion struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	CurrentStage string `json:"current_stage"`
+	Status       string `json:"status"`
+}
+
+type mlflowError struct {
+	ErrorCode string `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+type mlflowResolver struct {
+	rest *resty.Client
+}
+
+// NewMLflowResolver returns a Resolver for the REST API of an MLflow model
+// registry, which sends requests with the passed HTTP client, or with a
+// default client if it is nil.
+func NewMLflowResolver(httpClient *http.Client) Resolver {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+	return &mlflowResolver{rest: resty.NewWithClient(httpClient)}
+}
+
+func (m *mlflowResolver) Resolve(ctx context.Context, ref Reference, token string) (*Model, error) {
+	if err := ref.Validate(); err != nil {
+		return nil, err
+	}
+	base := strings.TrimSuffix(ref.URL, "/") + "/api/2.0/mlflow"
+	request := func() *resty.Request {
+		r := m.rest.R().SetContext(ctx).SetError(&mlflowError{}).ForceContentType("application/json")
+		if token != "" {
+			r.SetAuthToken(token)
+		}
+		return r
+	}
+
+	var version mlflowModelVersion
+	if ref.Pinned() {
+		result := &struct {
+			ModelVersion mlflowModelVersion `json:"model_version"`
+		}{}
+		resp, err := request().SetResult(result).
+			SetQueryParams(map[string]string{"name": ref.Name, "version": ref.Version}).
+			Get(base + "/model-versions/get")
+		if err := responseError(resp, err, fmt.Sprintf("version %s of model %q", ref.Version, ref.Name)); err != nil {
+			return nil, err
+		}
+		version = result.ModelVersion
+	} else {
+		result := &struct {
+			ModelVersions []mlflowModelVersion `json:"model_versions"`
+		}{}
+		resp, err := request().SetResult(result).
+			SetBody(map[string]interface{}{"name": ref.Name, "stages": []string{ref.Stage}}).
+			Post(base + "/registered-models/get-latest-versions")
+		if err := responseError(resp, err, fmt.Sprintf("model %q", ref.Name)); err != nil {
+			return nil, err
+		}
+		for _, v := range result.ModelVersions {
+			if strings.EqualFold(v.CurrentStage, ref.Stage) {
+				version = v
+			}
+		}
+		if version.Version == "" {
+			return nil, fmt.Errorf("model %q has no version in stage %s: %w", ref.Name, ref.Stage, ErrNotFound)
+		}
+	}
+	if version.Status != "" && version.Status != mlflowReady {
+		return nil, fmt.Errorf("version %s of model %q is %s", version.Version, ref.Name, version.Status)
+	}
+
+	result := &struct {
+		ArtifactURI string `json:"artifact_uri"`
+	}{}
+	resp, err := request().SetResult(result).
+		SetQueryParams(map[string]string{"name": ref.Name, "version": version.Version}).
+		Get(base + "/model-versions/get-download-uri")
+	if err := responseError(resp, err, fmt.Sprintf("download URI of version %s of model %q", version.Version, ref.Name)); err != nil {
+		return nil, err
+	}
+	if result.ArtifactURI == "" {
+		return nil, fmt.Errorf("registry returned no download URI for version %s of model %q", version.Version, ref.Name)
+	}
+	return &Model{Version: version.Version, URI: result.ArtifactURI}, nil
+}
+
+// responseError returns the error of a registry request, wrapping
+// ErrNotFound if the registry reports a missing resource.
+func responseError(resp *resty.Response, err error, what string) error {
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %w", what, err)
+	}
+	if resp.IsSuccess() {
+		return nil
+	}
+	message := resp.Status()
+	if e, ok := resp.Error().(*mlflowError); ok && e.Message != "" {
+		message = e.Message
+	}
+	if resp.StatusCode() == http.StatusNotFound {
+		return fmt.Errorf("%s %w: %s", what, ErrNotFound, message)
+	}
+	return fmt.Errorf("unexpected response to the request of %s: %s", what, message)
+}
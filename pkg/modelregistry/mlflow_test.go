@@ -0,0 +1,190 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package modelregistry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testVersions are the versions of the resnet model of the test registry.
+var testVersions = []mlflowModelVersion{
+	{Name: "resnet", Version: "1", CurrentStage: "Archived", Status: "READY"},
+	{Name: "resnet", Version: "2", CurrentStage: "Production", Status: "READY"},
+	{Name: "resnet", Version: "3", CurrentStage: "Staging", Status: "PENDING_REGISTRATION"},
+}
+
+func newTestRegistry(t *testing.T) *httptest.Server {
+	writeJSON := func(w http.ResponseWriter, status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	notFound := func(w http.ResponseWriter) {
+		writeJSON(w, http.StatusNotFound, mlflowError{ErrorCode: "RESOURCE_DOES_NOT_EXIST", Message: "Registered Model not found"})
+	}
+	find := func(name, version string) *mlflowModelVersion {
+		for i, v := range testVersions {
+			if v.Name == name && v.Version == version {
+				return &testVersions[i]
+			}
+		}
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/2.0/mlflow/model-versions/get", func(w http.ResponseWriter, r *http.Request) {
+		v := find(r.URL.Query().Get("name"), r.URL.Query().Get("version"))
+		if v == nil {
+			notFound(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"model_version": v})
+	})
+	mux.HandleFunc("/api/2.0/mlflow/registered-models/get-latest-versions", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		var body struct {
+			Name   string   `json:"name"`
+			Stages []string `json:"stages"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		if body.Name != "resnet" {
+			notFound(w)
+			return
+		}
+		versions := []mlflowModelVersion{}
+		for _, v := range testVersions {
+			for _, s := range body.Stages {
+				if v.CurrentStage == s {
+					versions = append(versions, v)
+				}
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"model_versions": versions})
+	})
+	mux.HandleFunc("/api/2.0/mlflow/model-versions/get-download-uri", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer expired" {
+			writeJSON(w, http.StatusUnauthorized, mlflowError{ErrorCode: "UNAUTHENTICATED", Message: "Token expired"})
+			return
+		}
+		v := find(r.URL.Query().Get("name"), r.URL.Query().Get("version"))
+		if v == nil {
+			notFound(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"artifact_uri": "s3://mlflow/resnet/" + v.Version})
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestMLflowResolve(t *testing.T) {
+	registry := newTestRegistry(t)
+	defer registry.Close()
+	resolver := NewMLflowResolver(registry.Client())
+
+	tests := []struct {
+		name       string
+		ref        Reference
+		token      string
+		expected   *Model
+		errMessage string
+		notFound   bool
+	}{
+		{
+			name:     "version",
+			ref:      Reference{URL: registry.URL, Name: "resnet", Version: "1"},
+			expected: &Model{Version: "1", URI: "s3://mlflow/resnet/1"},
+		},
+		{
+			name:     "stage",
+			ref:      Reference{URL: registry.URL + "/", Name: "resnet", Stage: "Production"},
+			token:    "token",
+			expected: &Model{Version: "2", URI: "s3://mlflow/resnet/2"},
+		},
+		{
+			name:     "version before stage",
+			ref:      Reference{URL: registry.URL, Name: "resnet", Version: "1", Stage: "Production"},
+			expected: &Model{Version: "1", URI: "s3://mlflow/resnet/1"},
+		},
+		{
+			name:       "empty stage",
+			ref:        Reference{URL: registry.URL, Name: "resnet", Stage: "Canary"},
+			errMessage: `model "resnet" has no version in stage Canary: not found`,
+			notFound:   true,
+		},
+		{
+			name:       "missing version",
+			ref:        Reference{URL: registry.URL, Name: "resnet", Version: "7"},
+			errMessage: `version 7 of model "resnet" not found: Registered Model not found`,
+			notFound:   true,
+		},
+		{
+			name:       "missing model",
+			ref:        Reference{URL: registry.URL, Name: "bert", Stage: "Production"},
+			errMessage: `model "bert" not found: Registered Model not found`,
+			notFound:   true,
+		},
+		{
+			name:       "pending registration",
+			ref:        Reference{URL: registry.URL, Name: "resnet", Stage: "Staging"},
+			errMessage: `version 3 of model "resnet" is PENDING_REGISTRATION`,
+		},
+		{
+			name:       "unauthorized",
+			ref:        Reference{URL: registry.URL, Name: "resnet", Version: "2"},
+			token:      "expired",
+			errMessage: `unexpected response to the request of download URI of version 2 of model "resnet": Token expired`,
+		},
+		{
+			name:       "invalid reference",
+			ref:        Reference{URL: registry.URL, Name: "resnet"},
+			errMessage: "version or stage is required",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			model, err := resolver.Resolve(context.TODO(), test.ref, test.token)
+			if test.errMessage != "" {
+				assert.EqualError(t, err, test.errMessage)
+				assert.Equal(t, test.notFound, errors.Is(err, ErrNotFound))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, model)
+		})
+	}
+}
+
+func TestReference(t *testing.T) {
+	ref := Reference{URL: "http://mlflow:5000", Name: "resnet", Stage: "Production"}
+	assert.Equal(t, "http://mlflow:5000/resnet@Production", ref.String())
+	assert.False(t, ref.Pinned())
+
+	ref.Version = "4"
+	assert.Equal(t, "http://mlflow:5000/resnet@4", ref.String())
+	assert.True(t, ref.Pinned())
+
+	assert.EqualError(t, Reference{Name: "resnet", Version: "1"}.Validate(), "url is required")
+	assert.EqualError(t, Reference{URL: "http://mlflow:5000", Version: "1"}.Validate(), "model_name is required")
+}
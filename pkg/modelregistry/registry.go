@@ -0,0 +1,88 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package modelregistry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultTimeout limits the duration of a single request to the registry.
+const defaultTimeout = 10 * time.Second
+
+// ErrNotFound is wrapped by the errors returned when the registry has no
+// matching model version.
+var ErrNotFound = errors.New("not found")
+
+// Reference identifies a registered model version, either by version or as
+// the latest version in a stage.
+type Reference struct {
+	// URL is the base URL of the registry API, for example
+	// http://mlflow.mlops.svc:5000.
+	URL  string
+	Name string
+	// Version selects a version of the model. It takes precedence over
+	// Stage.
+	Version string
+	// Stage selects the latest version of the model in the stage, for
+	// example Production.
+	Stage string
+}
+
+func (r Reference) String() string {
+	if r.Version != "" {
+		return fmt.Sprintf("%s/%s@%s", r.URL, r.Name, r.Version)
+	}
+	return fmt.Sprintf("%s/%s@%s", r.URL, r.Name, r.Stage)
+}
+
+// Validate checks that the reference selects a model version.
+func (r Reference) Validate() error {
+	switch {
+	case r.URL == "":
+		return errors.New("url is required")
+	case r.Name == "":
+		return errors.New("model_name is required")
+	case r.Version == "" && r.Stage == "":
+		return errors.New("version or stage is required")
+	}
+	return nil
+}
+
+// Pinned reports whether the reference selects a fixed version, whose
+// storage URI does not change.
+func (r Reference) Pinned() bool {
+	return r.Version != ""
+}
+
+// Model is a resolved model version.
+type Model struct {
+	Version string
+	// URI is the storage URI of the artifacts of the model version, for
+	// example s3://models/resnet/3.
+	URI string
+}
+
+// Resolver resolves model references into the storage URIs of their
+// artifacts.
+type Resolver interface {
+	// Resolve returns the model version selected by the reference. The
+	// token, if not empty, authenticates the requests to the registry.
+	Resolve(ctx context.Context, ref Reference, token string) (*Model, error)
+}
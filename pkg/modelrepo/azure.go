@@ -0,0 +1,242 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package modelrepo
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const azureAPIVersion = "2020-10-02"
+
+// AzureConfig configures access to Azure Blob Storage.
+type AzureConfig struct {
+	// ConnectionString is the storage account connection string, including
+	// either the account key or a shared access signature.
+	ConnectionString string
+	// HTTPClient defaults to a client with a request timeout.
+	HTTPClient *http.Client
+}
+
+type azureStorage struct {
+	endpoint    string
+	accountName string
+	accountKey  []byte
+	sas         url.Values
+	client      *http.Client
+	now         func() time.Time
+}
+
+// NewAzure returns a Storage listing containers of Azure Blob Storage,
+// authorized with the account key or the shared access signature of the
+// connection string.
+func NewAzure(config AzureConfig) (Storage, error) {
+	settings := parseConnectionString(config.ConnectionString)
+
+	s := &azureStorage{accountName: settings["AccountName"], client: config.HTTPClient, now: time.Now}
+	if s.client == nil {
+		s.client = defaultHTTPClient()
+	}
+	endpoint, err := blobEndpoint(settings)
+	if err != nil {
+		return nil, err
+	}
+	s.endpoint = endpoint
+
+	if sas := settings["SharedAccessSignature"]; sas != "" {
+		values, err := url.ParseQuery(strings.TrimPrefix(sas, "?"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid shared access signature: %w", err)
+		}
+		s.sas = values
+	} else if key := settings["AccountKey"]; key != "" {
+		if s.accountName == "" {
+			return nil, errors.New("connection string does not include AccountName")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid account key: %w", err)
+		}
+		s.accountKey = decoded
+	}
+	return s, nil
+}
+
+// AzureBlobEndpoint returns the URL of the Blob Storage endpoint of the
+// storage account set in the connection string.
+func AzureBlobEndpoint(connectionString string) (string, error) {
+	return blobEndpoint(parseConnectionString(connectionString))
+}
+
+func parseConnectionString(connectionString string) map[string]string {
+	settings := map[string]string{}
+	for _, part := range strings.Split(connectionString, ";") {
+		if k, v, ok := strings.Cut(part, "="); ok {
+			settings[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return settings
+}
+
+func blobEndpoint(settings map[string]string) (string, error) {
+	endpoint := settings["BlobEndpoint"]
+	if endpoint == "" {
+		if settings["AccountName"] == "" {
+			return "", errors.New("connection string does not include AccountName or BlobEndpoint")
+		}
+		protocol := settings["DefaultEndpointsProtocol"]
+		if protocol == "" {
+			protocol = "https"
+		}
+		suffix := settings["EndpointSuffix"]
+		if suffix == "" {
+			suffix = "core.windows.net"
+		}
+		endpoint = fmt.Sprintf("%s://%s.blob.%s", protocol, settings["AccountName"], suffix)
+	}
+	return strings.TrimSuffix(endpoint, "/"), nil
+}
+
+type azureListResult struct {
+	Blobs struct {
+		BlobPrefix []struct {
+			Name string `xml:"Name"`
+		} `xml:"BlobPrefix"`
+		Blob []struct {
+			Name string `xml:"Name"`
+		} `xml:"Blob"`
+	} `xml:"Blobs"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+type azureError struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (s *azureStorage) ListDirs(ctx context.Context, container, prefix string) ([]string, error) {
+	var dirs []string
+	found := false
+	marker := ""
+	for {
+		query := url.Values{}
+		for k, v := range s.sas {
+			query[k] = v
+		}
+		query.Set("restype", "container")
+		query.Set("comp", "list")
+		query.Set("delimiter", "/")
+		query.Set("prefix", dirPrefix(prefix))
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+			fmt.Sprintf("%s/%s?%s", s.endpoint, url.PathEscape(container), query.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("x-ms-date", s.now().UTC().Format(http.TimeFormat))
+		req.Header.Set("x-ms-version", azureAPIVersion)
+		if s.accountKey != nil {
+			s.signSharedKey(req)
+		}
+
+		result := &azureListResult{}
+		if err := s.do(req, container, result); err != nil {
+			return nil, err
+		}
+		for _, p := range result.Blobs.BlobPrefix {
+			dirs = append(dirs, dirName(prefix, p.Name))
+		}
+		found = found || len(result.Blobs.BlobPrefix) > 0 || len(result.Blobs.Blob) > 0
+		if result.NextMarker == "" {
+			break
+		}
+		marker = result.NextMarker
+	}
+	if !found {
+		return nil, fmt.Errorf("no blobs under the path: %w", ErrNotFound)
+	}
+	return dirs, nil
+}
+
+func (s *azureStorage) do(req *http.Request, container string, result interface{}) error {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		e := &azureError{}
+		_ = xml.NewDecoder(resp.Body).Decode(e)
+		if e.Code == "ContainerNotFound" {
+			return fmt.Errorf("container %q: %w", container, ErrNotFound)
+		}
+		if e.Code == "" {
+			e.Code = resp.Status
+		}
+		return fmt.Errorf("container %q: %s", container, strings.TrimSpace(e.Code+" "+strings.SplitN(e.Message, "\n", 2)[0]))
+	}
+	return xml.NewDecoder(resp.Body).Decode(result)
+}
+
+// signSharedKey authorizes the request, without a body, with the Shared Key
+// scheme of the storage account.
+func (s *azureStorage) signSharedKey(req *http.Request) {
+	var headers []string
+	for name := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			headers = append(headers, name)
+		}
+	}
+	sort.Strings(headers)
+	var canonical strings.Builder
+	for _, name := range headers {
+		canonical.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+
+	canonical.WriteString("/" + s.accountName + req.URL.EscapedPath())
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		canonical.WriteString("\n" + strings.ToLower(k) + ":" + strings.Join(values, ","))
+	}
+
+	// verb, 11 empty standard headers, canonicalized headers and resource
+	stringToSign := req.Method + "\n" + strings.Repeat("\n", 11) + canonical.String()
+	h := hmac.New(sha256.New, s.accountKey)
+	h.Write([]byte(stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s",
+		s.accountName, base64.StdEncoding.EncodeToString(h.Sum(nil))))
+}
@@ -0,0 +1,69 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package modelrepo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAzureListDirs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey account:") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/models" || r.URL.Query().Get("comp") != "list" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`<Error><Code>ContainerNotFound</Code><Message>The specified container does not exist.</Message></Error>`))
+			return
+		}
+		if r.URL.Query().Get("prefix") == "resnet/" {
+			_, _ = w.Write([]byte(`<EnumerationResults><Blobs><BlobPrefix><Name>resnet/1/</Name></BlobPrefix>` +
+				`</Blobs><NextMarker /></EnumerationResults>`))
+			return
+		}
+		_, _ = w.Write([]byte(`<EnumerationResults><Blobs /><NextMarker /></EnumerationResults>`))
+	}))
+	defer server.Close()
+
+	s, err := NewAzure(AzureConfig{
+		ConnectionString: "AccountName=account;AccountKey=a2V5;BlobEndpoint=" + server.URL,
+	})
+	assert.NoError(t, err)
+	dirs, err := s.ListDirs(context.TODO(), "models", "resnet")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1"}, dirs)
+
+	_, err = s.ListDirs(context.TODO(), "models", "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.ListDirs(context.TODO(), "other", "resnet")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	s, err = NewAzure(AzureConfig{ConnectionString: "BlobEndpoint=" + server.URL + ";SharedAccessSignature=sv=2020&sig=abc"})
+	assert.NoError(t, err)
+	_, err = s.ListDirs(context.TODO(), "models", "resnet")
+	assert.EqualError(t, err, `container "models": 403 Forbidden`)
+
+	_, err = NewAzure(AzureConfig{ConnectionString: "AccountKey=a2V5"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,22 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package modelrepo checks that model repositories in S3, Google Cloud
+// Storage and Azure Blob Storage are reachable with the configured
+// credentials and follow the layout expected by OpenVINO Model Server,
+// where each model version is stored in a numbered directory under the
+// model path.
+package modelrepo
@@ -0,0 +1,162 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package modelrepo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+)
+
+const (
+	defaultGCSEndpoint = "https://storage.googleapis.com"
+	gcsReadOnlyScope   = "https://www.googleapis.com/auth/devstorage.read_only"
+	defaultGCSTokenURL = "https://oauth2.googleapis.com/token"
+)
+
+// GCSConfig configures access to Google Cloud Storage.
+type GCSConfig struct {
+	// Endpoint defaults to https://storage.googleapis.com.
+	Endpoint string
+	// CredentialsJSON is the content of a service account key file. The
+	// requests are sent anonymously if it is empty.
+	CredentialsJSON []byte
+	// HTTPClient defaults to a client with a request timeout.
+	HTTPClient *http.Client
+}
+
+type gcsStorage struct {
+	config GCSConfig
+	client *http.Client
+}
+
+type serviceAccountKey struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// NewGCS returns a Storage listing buckets of Google Cloud Storage over its
+// JSON API, authenticated with the service account key.
+func NewGCS(ctx context.Context, config GCSConfig) (Storage, error) {
+	if config.Endpoint == "" {
+		config.Endpoint = defaultGCSEndpoint
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	if config.HTTPClient == nil {
+		config.HTTPClient = defaultHTTPClient()
+	}
+	if len(config.CredentialsJSON) == 0 {
+		return &gcsStorage{config: config, client: config.HTTPClient}, nil
+	}
+
+	key := &serviceAccountKey{}
+	if err := json.Unmarshal(config.CredentialsJSON, key); err != nil {
+		return nil, fmt.Errorf("invalid Google Cloud credentials: %w", err)
+	}
+	if key.Type != "service_account" {
+		return nil, fmt.Errorf("unsupported Google Cloud credentials type %q", key.Type)
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = defaultGCSTokenURL
+	}
+	jwtConfig := &jwt.Config{
+		Email:        key.ClientEmail,
+		PrivateKey:   []byte(key.PrivateKey),
+		PrivateKeyID: key.PrivateKeyID,
+		Scopes:       []string{gcsReadOnlyScope},
+		TokenURL:     key.TokenURI,
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, config.HTTPClient)
+	return &gcsStorage{config: config, client: jwtConfig.Client(ctx)}, nil
+}
+
+type gcsListResult struct {
+	Prefixes []string `json:"prefixes"`
+	Items    []struct {
+		Name string `json:"name"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+type gcsError struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (s *gcsStorage) ListDirs(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var dirs []string
+	found := false
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("delimiter", "/")
+		query.Set("prefix", dirPrefix(prefix))
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/storage/v1/b/%s/o?%s",
+			s.config.Endpoint, url.PathEscape(bucket), query.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		result := &gcsListResult{}
+		if err := s.do(req, bucket, result); err != nil {
+			return nil, err
+		}
+		for _, p := range result.Prefixes {
+			dirs = append(dirs, dirName(prefix, p))
+		}
+		found = found || len(result.Prefixes) > 0 || len(result.Items) > 0
+		if result.NextPageToken == "" {
+			break
+		}
+		token = result.NextPageToken
+	}
+	if !found {
+		return nil, fmt.Errorf("no objects under the path: %w", ErrNotFound)
+	}
+	return dirs, nil
+}
+
+func (s *gcsStorage) do(req *http.Request, bucket string, result interface{}) error {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("bucket %q: %w", bucket, ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := &gcsError{}
+		_ = json.NewDecoder(resp.Body).Decode(e)
+		return fmt.Errorf("bucket %q: %s", bucket, strings.TrimSpace(resp.Status+" "+e.Error.Message))
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,55 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package modelrepo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCSListDirs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/storage/v1/b/models/o" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("prefix") == "resnet/" {
+			_, _ = w.Write([]byte(`{"prefixes": ["resnet/1/", "resnet/2/"]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	s, err := NewGCS(context.TODO(), GCSConfig{Endpoint: server.URL})
+	assert.NoError(t, err)
+	dirs, err := s.ListDirs(context.TODO(), "models", "resnet")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, dirs)
+
+	_, err = s.ListDirs(context.TODO(), "models", "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.ListDirs(context.TODO(), "other", "resnet")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = NewGCS(context.TODO(), GCSConfig{CredentialsJSON: []byte(`{"type": "authorized_user"}`)})
+	assert.EqualError(t, err, `unsupported Google Cloud credentials type "authorized_user"`)
+}
@@ -0,0 +1,124 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package modelrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTimeout limits the duration of a single request to the storage.
+const defaultTimeout = 10 * time.Second
+
+func defaultHTTPClient() *http.Client {
+	return &http.Client{Timeout: defaultTimeout}
+}
+
+// ErrNotFound is returned when the listed path does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Storage lists the directories of a model repository.
+type Storage interface {
+	// ListDirs returns the names of the directories directly under prefix
+	// in the bucket or container. It returns an error wrapping ErrNotFound
+	// if the bucket, or any object under prefix, does not exist.
+	ListDirs(ctx context.Context, bucket, prefix string) ([]string, error)
+}
+
+// Location is a model path in a cloud storage.
+type Location struct {
+	// Scheme is the URL scheme of the storage type, one of s3, gs or az.
+	Scheme string
+	// Bucket is the bucket, or the container for Azure Blob Storage.
+	Bucket string
+	// Prefix is the model path in the bucket, without leading or trailing
+	// slashes.
+	Prefix string
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("%s://%s/%s", l.Scheme, l.Bucket, l.Prefix)
+}
+
+// ParseLocation parses a model path in a cloud storage, for example
+// s3://bucket/models/resnet. It returns false if the path is not a URL of
+// a supported cloud storage.
+func ParseLocation(path string) (Location, bool) {
+	u, err := url.Parse(path)
+	if err != nil || u.Host == "" {
+		return Location{}, false
+	}
+	switch u.Scheme {
+	case "s3", "gs", "az":
+	default:
+		return Location{}, false
+	}
+	return Location{Scheme: u.Scheme, Bucket: u.Host, Prefix: strings.Trim(u.Path, "/")}, true
+}
+
+// CheckModel checks that the model path exists in the storage and includes
+// at least one version directory, named with a positive integer.
+func CheckModel(ctx context.Context, s Storage, l Location) error {
+	dirs, err := s.ListDirs(ctx, l.Bucket, l.Prefix)
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("model path %s: %w", l, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list model path %s: %w", l, err)
+	}
+	if len(versions(dirs)) == 0 {
+		return fmt.Errorf("model path %s does not include any version directory, "+
+			"expected the model files in %s/<version>/", l, l)
+	}
+	return nil
+}
+
+// versions returns the model versions among the directory names, in
+// ascending order.
+func versions(dirs []string) []int64 {
+	var out []int64
+	for _, dir := range dirs {
+		v, err := strconv.ParseInt(dir, 10, 64)
+		if err == nil && v > 0 {
+			out = append(out, v)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// dirPrefix returns the prefix listing the content of the directory.
+func dirPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// dirName returns the name of the directory from a common prefix returned
+// by a listing of dirPrefix(prefix).
+func dirName(prefix, common string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(common, dirPrefix(prefix)), "/")
+}
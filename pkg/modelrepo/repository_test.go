@@ -0,0 +1,63 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package modelrepo
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStorage map[string][]string
+
+func (s fakeStorage) ListDirs(_ context.Context, bucket, prefix string) ([]string, error) {
+	dirs, ok := s[bucket+"/"+prefix]
+	if !ok {
+		return nil, fmt.Errorf("no objects: %w", ErrNotFound)
+	}
+	return dirs, nil
+}
+
+func TestParseLocation(t *testing.T) {
+	l, ok := ParseLocation("s3://bucket/models/resnet/")
+	assert.True(t, ok)
+	assert.Equal(t, Location{Scheme: "s3", Bucket: "bucket", Prefix: "models/resnet"}, l)
+	assert.Equal(t, "s3://bucket/models/resnet", l.String())
+
+	l, ok = ParseLocation("gs://bucket")
+	assert.True(t, ok)
+	assert.Equal(t, Location{Scheme: "gs", Bucket: "bucket"}, l)
+
+	for _, path := range []string{"/models/resnet", "azfs://share/resnet", "s3:///resnet"} {
+		_, ok = ParseLocation(path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestCheckModel(t *testing.T) {
+	s := fakeStorage{
+		"bucket/resnet": {"1", "2"},
+		"bucket/face":   {"model", "0"},
+	}
+	assert.NoError(t, CheckModel(context.TODO(), s, Location{Scheme: "s3", Bucket: "bucket", Prefix: "resnet"}))
+	assert.EqualError(t, CheckModel(context.TODO(), s, Location{Scheme: "s3", Bucket: "bucket", Prefix: "face"}),
+		"model path s3://bucket/face does not include any version directory, expected the model files in s3://bucket/face/<version>/")
+	err := CheckModel(context.TODO(), s, Location{Scheme: "s3", Bucket: "bucket", Prefix: "missing"})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
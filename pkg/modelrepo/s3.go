@@ -0,0 +1,265 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package modelrepo
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultS3Region = "us-east-1"
+	// emptyPayloadHash is the SHA-256 hash of an empty request body.
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Config configures access to an S3 compatible storage.
+type S3Config struct {
+	// Endpoint is the URL of the storage, for example http://minio:9000.
+	// It defaults to the AWS S3 endpoint of the region.
+	Endpoint string
+	// Region defaults to us-east-1.
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// HTTPClient defaults to a client with a request timeout.
+	HTTPClient *http.Client
+}
+
+type s3Storage struct {
+	config S3Config
+	now    func() time.Time
+}
+
+// NewS3 returns a Storage listing buckets of an S3 compatible storage with
+// path-style requests. Requests are signed with AWS Signature Version 4
+// when the access key is set, and sent anonymously otherwise.
+func NewS3(config S3Config) Storage {
+	if config.Region == "" {
+		config.Region = defaultS3Region
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = S3Endpoint(config.Region)
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	if config.HTTPClient == nil {
+		config.HTTPClient = defaultHTTPClient()
+	}
+	return &s3Storage{config: config, now: time.Now}
+}
+
+// S3Endpoint returns the URL of the Amazon S3 endpoint of the region, or of
+// the default region if empty.
+func S3Endpoint(region string) string {
+	if region == "" {
+		region = defaultS3Region
+	}
+	return fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+}
+
+type s3ListResult struct {
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (s *s3Storage) ListDirs(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var dirs []string
+	found := false
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("delimiter", "/")
+		query.Set("prefix", dirPrefix(prefix))
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+			fmt.Sprintf("%s/%s?%s", s.config.Endpoint, bucket, query.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+		if s.config.AccessKeyID != "" {
+			signV4(req, s.config.AccessKeyID, s.config.SecretAccessKey, s.config.Region, s.now())
+		}
+
+		result := &s3ListResult{}
+		if err := s.do(req, bucket, result); err != nil {
+			return nil, err
+		}
+		for _, p := range result.CommonPrefixes {
+			dirs = append(dirs, dirName(prefix, p.Prefix))
+		}
+		found = found || len(result.CommonPrefixes) > 0 || len(result.Contents) > 0
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	if !found {
+		return nil, fmt.Errorf("no objects under the path: %w", ErrNotFound)
+	}
+	return dirs, nil
+}
+
+func (s *s3Storage) do(req *http.Request, bucket string, result interface{}) error {
+	resp, err := s.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		e := &s3Error{}
+		_ = xml.NewDecoder(resp.Body).Decode(e)
+		if e.Code == "NoSuchBucket" {
+			return fmt.Errorf("bucket %q: %w", bucket, ErrNotFound)
+		}
+		if e.Code == "" {
+			e.Code = resp.Status
+		}
+		return fmt.Errorf("bucket %q: %s", bucket, strings.TrimSpace(e.Code+" "+e.Message))
+	}
+	return xml.NewDecoder(resp.Body).Decode(result)
+}
+
+// signV4 signs the request, without a body, with AWS Signature Version 4
+// for the S3 service.
+func signV4(req *http.Request, accessKeyID, secretAccessKey, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": emptyPayloadHash,
+		"x-amz-date":           amzDate,
+	}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if _, ok := headers[name]; !ok && (name == "range" || strings.HasPrefix(name, "x-amz-")) {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEncode(path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsURIEncode encodes the string as specified for the canonical request.
+// The path is passed already escaped, so only its unescaped characters are
+// encoded.
+func awsURIEncode(s string, encodeSlash bool) string {
+	if !encodeSlash {
+		if unescaped, err := url.PathUnescape(s); err == nil {
+			s = unescaped
+		}
+	}
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hexSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
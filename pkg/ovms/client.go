@@ -0,0 +1,165 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ovms
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	resty "github.com/go-resty/resty/v2"
+)
+
+// defaultTimeout limits the duration of a single request to the model server.
+const defaultTimeout = 10 * time.Second
+
+// Client queries the KServe compatible REST API of OpenVINO Model Server.
+// The endpoint is the base URL of the REST API, for example
+// http://ovms-sample.default.svc:8081.
+type Client interface {
+	// ModelReady reports whether the model is loaded and ready for inference.
+	ModelReady(ctx context.Context, endpoint, model string) (bool, error)
+	// ModelMetadata returns the metadata of the model.
+	ModelMetadata(ctx context.Context, endpoint, model string) (*ModelMetadata, error)
+	// Metrics returns the sum of the samples of each metric exposed by the
+	// model server at /metrics, which requires metrics_enable.
+	Metrics(ctx context.Context, endpoint string) (map[string]float64, error)
+}
+
+// ModelMetadata is the model metadata returned by the
+// /v2/models/<model> endpoint.
+type ModelMetadata struct {
+	Name     string           `json:"name"`
+	Versions []string         `json:"versions,omitempty"`
+	Platform string           `json:"platform,omitempty"`
+	Inputs   []TensorMetadata `json:"inputs,omitempty"`
+	Outputs  []TensorMetadata `json:"outputs,omitempty"`
+}
+
+// TensorMetadata describes a model input or output.
+type TensorMetadata struct {
+	Name     string  `json:"name"`
+	Datatype string  `json:"datatype,omitempty"`
+	Shape    []int64 `json:"shape,omitempty"`
+}
+
+// LatestVersion returns the highest version of the model served, or an
+// empty string if no version is reported.
+func (m ModelMetadata) LatestVersion() string {
+	versions := append([]string{}, m.Versions...)
+	sort.SliceStable(versions, func(i, j int) bool {
+		vi, erri := strconv.ParseInt(versions[i], 10, 64)
+		vj, errj := strconv.ParseInt(versions[j], 10, 64)
+		if erri != nil || errj != nil {
+			return versions[i] < versions[j]
+		}
+		return vi < vj
+	})
+	if len(versions) == 0 {
+		return ""
+	}
+	return versions[len(versions)-1]
+}
+
+type client struct {
+	rest *resty.Client
+}
+
+// NewClient returns a Client which sends requests with the passed HTTP
+// client, or with a default client if it is nil.
+func NewClient(httpClient *http.Client) Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+	return &client{rest: resty.NewWithClient(httpClient)}
+}
+
+func (c *client) ModelReady(ctx context.Context, endpoint, model string) (bool, error) {
+	resp, err := c.rest.R().SetContext(ctx).Get(modelURL(endpoint, model) + "/ready")
+	if err != nil {
+		return false, fmt.Errorf("failed to get model %q readiness: %w", model, err)
+	}
+	switch resp.StatusCode() {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound, http.StatusServiceUnavailable:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected response to model %q readiness request: %s", model, resp.Status())
+	}
+}
+
+func (c *client) ModelMetadata(ctx context.Context, endpoint, model string) (*ModelMetadata, error) {
+	metadata := &ModelMetadata{}
+	resp, err := c.rest.R().SetContext(ctx).SetResult(metadata).Get(modelURL(endpoint, model))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get model %q metadata: %w", model, err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response to model %q metadata request: %s", model, resp.Status())
+	}
+	return metadata, nil
+}
+
+func (c *client) Metrics(ctx context.Context, endpoint string) (map[string]float64, error) {
+	resp, err := c.rest.R().SetContext(ctx).Get(endpoint + "/metrics")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metrics: %w", err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response to metrics request: %s", resp.Status())
+	}
+	return parseMetrics(resp.String()), nil
+}
+
+// parseMetrics sums the samples of each metric in the Prometheus text
+// exposition format, ignoring the labels.
+func parseMetrics(text string) map[string]float64 {
+	metrics := map[string]float64{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, rest := line, ""
+		if i := strings.IndexAny(line, "{ "); i >= 0 {
+			name, rest = line[:i], line[i:]
+		}
+		if i := strings.LastIndex(rest, "}"); i >= 0 {
+			rest = rest[i+1:]
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		metrics[name] += value
+	}
+	return metrics
+}
+
+func modelURL(endpoint, model string) string {
+	return fmt.Sprintf("%s/v2/models/%s", endpoint, url.PathEscape(model))
+}
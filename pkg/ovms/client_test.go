@@ -0,0 +1,121 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ovms
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const resnetMetadata = `{
+  "name": "resnet",
+  "versions": ["1", "10", "2"],
+  "platform": "OpenVINO",
+  "inputs": [{"name": "0", "datatype": "FP32", "shape": [1, 224, 224, 3]}],
+  "outputs": [{"name": "1463", "datatype": "FP32", "shape": [1, 1000]}]
+}`
+
+const ovmsMetrics = `# HELP ovms_requests_success Number of successful requests to a model or a DAG.
+# TYPE ovms_requests_success counter
+ovms_requests_success{api="KServe",interface="REST",method="ModelInfer",name="resnet",version="1"} 18
+ovms_requests_success{api="KServe",interface="gRPC",method="ModelInfer",name="resnet",version="1"} 2
+# HELP ovms_requests_fail Number of failed requests to a model or a DAG.
+# TYPE ovms_requests_fail counter
+ovms_requests_fail{api="KServe",interface="REST",method="ModelInfer",name="resnet",version="1"} 1
+ovms_current_requests 0
+`
+
+func newTestServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/models/resnet/ready", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/v2/models/resnet", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(resnetMetadata))
+	})
+	mux.HandleFunc("/v2/models/loading/ready", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/v2/models/broken/ready", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(ovmsMetrics))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestModelReady(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+	c := NewClient(server.Client())
+
+	ready, err := c.ModelReady(context.TODO(), server.URL, "resnet")
+	assert.NoError(t, err)
+	assert.True(t, ready)
+
+	ready, err = c.ModelReady(context.TODO(), server.URL, "loading")
+	assert.NoError(t, err)
+	assert.False(t, ready)
+
+	ready, err = c.ModelReady(context.TODO(), server.URL, "missing")
+	assert.NoError(t, err)
+	assert.False(t, ready)
+
+	_, err = c.ModelReady(context.TODO(), server.URL, "broken")
+	assert.Error(t, err)
+}
+
+func TestModelMetadata(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+	c := NewClient(server.Client())
+
+	metadata, err := c.ModelMetadata(context.TODO(), server.URL, "resnet")
+	assert.NoError(t, err)
+	assert.Equal(t, "resnet", metadata.Name)
+	assert.Equal(t, "10", metadata.LatestVersion())
+	assert.Equal(t, []TensorMetadata{{Name: "0", Datatype: "FP32", Shape: []int64{1, 224, 224, 3}}}, metadata.Inputs)
+	assert.Equal(t, []TensorMetadata{{Name: "1463", Datatype: "FP32", Shape: []int64{1, 1000}}}, metadata.Outputs)
+
+	_, err = c.ModelMetadata(context.TODO(), server.URL, "missing")
+	assert.Error(t, err)
+}
+
+func TestLatestVersion(t *testing.T) {
+	assert.Equal(t, "", ModelMetadata{}.LatestVersion())
+	assert.Equal(t, "3", ModelMetadata{Versions: []string{"3", "1"}}.LatestVersion())
+}
+
+func TestMetrics(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+	c := NewClient(server.Client())
+
+	metrics, err := c.Metrics(context.TODO(), server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{
+		"ovms_requests_success": 20,
+		"ovms_requests_fail":    1,
+		"ovms_current_requests": 0,
+	}, metrics)
+}
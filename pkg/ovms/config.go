@@ -0,0 +1,243 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ovms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Config is the model server configuration file, config.json, used to
+// serve multiple models and MediaPipe graphs.
+type Config struct {
+	ModelConfigList     []ModelConfigEntry `json:"model_config_list"`
+	MediapipeConfigList []MediapipeConfig  `json:"mediapipe_config_list,omitempty"`
+	Monitoring          *MonitoringConfig  `json:"monitoring,omitempty"`
+	// LLMs are served by MediaPipe graphs generated by the operator, listed
+	// in MediapipeConfigList.
+	LLMs []LLMConfig `json:"-"`
+}
+
+// ModelConfigEntry wraps a model configuration in the model config list.
+type ModelConfigEntry struct {
+	Config ModelConfig `json:"config"`
+}
+
+// ModelConfig configures a single model served by the model server.
+type ModelConfig struct {
+	Name               string                 `json:"name"`
+	BasePath           string                 `json:"base_path"`
+	TargetDevice       string                 `json:"target_device,omitempty"`
+	BatchSize          string                 `json:"batch_size,omitempty"`
+	Shape              string                 `json:"shape,omitempty"`
+	Layout             string                 `json:"layout,omitempty"`
+	Nireq              int64                  `json:"nireq,omitempty"`
+	PluginConfig       map[string]interface{} `json:"plugin_config,omitempty"`
+	ModelVersionPolicy *VersionPolicy         `json:"model_version_policy,omitempty"`
+}
+
+// VersionPolicy selects the model versions to be served. Exactly one of the
+// policies must be set.
+type VersionPolicy struct {
+	All      *AllPolicy      `json:"all,omitempty"`
+	Latest   *LatestPolicy   `json:"latest,omitempty"`
+	Specific *SpecificPolicy `json:"specific,omitempty"`
+}
+
+// AllPolicy serves all versions of the model.
+type AllPolicy struct{}
+
+// LatestPolicy serves the given number of the highest model versions.
+type LatestPolicy struct {
+	NumVersions int64 `json:"num_versions"`
+}
+
+// SpecificPolicy serves the listed model versions.
+type SpecificPolicy struct {
+	Versions []int64 `json:"versions"`
+}
+
+// MediapipeConfig configures a MediaPipe graph served by the model server.
+type MediapipeConfig struct {
+	Name      string `json:"name"`
+	BasePath  string `json:"base_path,omitempty"`
+	GraphPath string `json:"graph_path,omitempty"`
+	Subconfig string `json:"subconfig,omitempty"`
+}
+
+// MonitoringConfig enables the metrics endpoint of the model server.
+type MonitoringConfig struct {
+	Metrics MetricsConfig `json:"metrics"`
+}
+
+// MetricsConfig selects the metrics exposed at /metrics. All default
+// metrics are exposed if the list is empty.
+type MetricsConfig struct {
+	Enable      bool     `json:"enable"`
+	MetricsList []string `json:"metrics_list,omitempty"`
+}
+
+// ConfigFromValues builds the configuration file from the `models`,
+// `mediapipe_graphs` and `llms` lists in the models_settings section of the
+// ModelServer values, with the metrics settings of the monitoring section. It
+// returns nil if no list is set.
+func ConfigFromValues(values map[string]interface{}) (*Config, error) {
+	settings, _ := values["models_settings"].(map[string]interface{})
+	models, _ := settings["models"].([]interface{})
+	graphs, _ := settings["mediapipe_graphs"].([]interface{})
+	llms, _ := settings["llms"].([]interface{})
+	if len(models) == 0 && len(graphs) == 0 && len(llms) == 0 {
+		return nil, nil
+	}
+
+	var modelConfigs []ModelConfig
+	if err := convert(models, &modelConfigs); err != nil {
+		return nil, fmt.Errorf("invalid models: %w", err)
+	}
+	config := &Config{ModelConfigList: []ModelConfigEntry{}}
+	for _, m := range modelConfigs {
+		config.ModelConfigList = append(config.ModelConfigList, ModelConfigEntry{Config: m})
+	}
+	if err := convert(graphs, &config.MediapipeConfigList); err != nil {
+		return nil, fmt.Errorf("invalid mediapipe_graphs: %w", err)
+	}
+	if err := convert(llms, &config.LLMs); err != nil {
+		return nil, fmt.Errorf("invalid llms: %w", err)
+	}
+	for i, l := range config.LLMs {
+		config.MediapipeConfigList = append(config.MediapipeConfigList, l.mediapipeConfig(i))
+	}
+	monitoring, _ := values["monitoring"].(map[string]interface{})
+	if enable, _ := monitoring["metrics_enable"].(bool); enable {
+		config.Monitoring = &MonitoringConfig{Metrics: MetricsConfig{Enable: true}}
+		list, _ := monitoring["metrics_list"].(string)
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				config.Monitoring.Metrics.MetricsList = append(config.Monitoring.Metrics.MetricsList, name)
+			}
+		}
+	}
+	return config, nil
+}
+
+// convert decodes the unstructured value into out.
+func convert(in interface{}, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+// Validate checks the configuration for errors which would prevent the
+// model server from loading it.
+func (c *Config) Validate() error {
+	names := map[string]struct{}{}
+	checkName := func(name string) error {
+		if name == "" {
+			return errors.New("name must not be empty")
+		}
+		if _, ok := names[name]; ok {
+			return fmt.Errorf("duplicate name %q", name)
+		}
+		names[name] = struct{}{}
+		return nil
+	}
+
+	for _, entry := range c.ModelConfigList {
+		m := entry.Config
+		if err := checkName(m.Name); err != nil {
+			return fmt.Errorf("invalid model: %w", err)
+		}
+		if m.BasePath == "" {
+			return fmt.Errorf("invalid model %q: base_path must not be empty", m.Name)
+		}
+		if m.BatchSize != "" && m.BatchSize != "auto" {
+			if size, err := strconv.Atoi(m.BatchSize); err != nil || size <= 0 {
+				return fmt.Errorf("invalid model %q: batch_size must be a positive integer or auto", m.Name)
+			}
+		}
+		if m.Nireq < 0 {
+			return fmt.Errorf("invalid model %q: nireq must not be negative", m.Name)
+		}
+		if err := m.ModelVersionPolicy.validate(); err != nil {
+			return fmt.Errorf("invalid model %q: %w", m.Name, err)
+		}
+	}
+	for _, g := range c.MediapipeConfigList {
+		if err := checkName(g.Name); err != nil {
+			return fmt.Errorf("invalid mediapipe graph: %w", err)
+		}
+	}
+	for _, l := range c.LLMs {
+		if err := l.validate(); err != nil {
+			return fmt.Errorf("invalid llm %q: %w", l.Name, err)
+		}
+	}
+	return nil
+}
+
+func (p *VersionPolicy) validate() error {
+	if p == nil {
+		return nil
+	}
+	set := 0
+	if p.All != nil {
+		set++
+	}
+	if p.Latest != nil {
+		set++
+		if p.Latest.NumVersions <= 0 {
+			return errors.New("model_version_policy latest num_versions must be positive")
+		}
+	}
+	if p.Specific != nil {
+		set++
+		if len(p.Specific.Versions) == 0 {
+			return errors.New("model_version_policy specific versions must not be empty")
+		}
+	}
+	if set != 1 {
+		return errors.New("model_version_policy must set exactly one of all, latest or specific")
+	}
+	return nil
+}
+
+// Names returns the names of the models and MediaPipe graphs in the
+// configuration.
+func (c *Config) Names() []string {
+	var names []string
+	for _, entry := range c.ModelConfigList {
+		names = append(names, entry.Config.Name)
+	}
+	for _, g := range c.MediapipeConfigList {
+		names = append(names, g.Name)
+	}
+	return names
+}
+
+// Render returns the content of the configuration file.
+func (c *Config) Render() (string, error) {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,172 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ovms
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigFromValues(t *testing.T) {
+	config, err := ConfigFromValues(map[string]interface{}{
+		"models_settings": map[string]interface{}{"single_model_mode": false},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+
+	config, err = ConfigFromValues(map[string]interface{}{
+		"models_settings": map[string]interface{}{
+			"models": []interface{}{
+				map[string]interface{}{
+					"name":                 "resnet",
+					"base_path":            "gs://models/resnet",
+					"target_device":        "CPU",
+					"batch_size":           "auto",
+					"plugin_config":        map[string]interface{}{"PERFORMANCE_HINT": "LATENCY"},
+					"model_version_policy": map[string]interface{}{"latest": map[string]interface{}{"num_versions": int64(2)}},
+				},
+			},
+			"mediapipe_graphs": []interface{}{
+				map[string]interface{}{"name": "pipeline", "graph_path": "/models/graph.pbtxt"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &Config{
+		ModelConfigList: []ModelConfigEntry{{Config: ModelConfig{
+			Name:               "resnet",
+			BasePath:           "gs://models/resnet",
+			TargetDevice:       "CPU",
+			BatchSize:          "auto",
+			PluginConfig:       map[string]interface{}{"PERFORMANCE_HINT": "LATENCY"},
+			ModelVersionPolicy: &VersionPolicy{Latest: &LatestPolicy{NumVersions: 2}},
+		}}},
+		MediapipeConfigList: []MediapipeConfig{{Name: "pipeline", GraphPath: "/models/graph.pbtxt"}},
+	}, config)
+	assert.Equal(t, []string{"resnet", "pipeline"}, config.Names())
+
+	config, err = ConfigFromValues(map[string]interface{}{
+		"models_settings": map[string]interface{}{
+			"models": []interface{}{map[string]interface{}{"name": "resnet", "base_path": "/models/resnet"}},
+		},
+		"monitoring": map[string]interface{}{
+			"metrics_enable": true, "metrics_list": "ovms_requests_success, ovms_requests_fail",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &MonitoringConfig{Metrics: MetricsConfig{
+		Enable: true, MetricsList: []string{"ovms_requests_success", "ovms_requests_fail"},
+	}}, config.Monitoring)
+
+	_, err = ConfigFromValues(map[string]interface{}{
+		"models_settings": map[string]interface{}{
+			"models": []interface{}{map[string]interface{}{"name": "resnet", "nireq": "many"}},
+		},
+	})
+	assert.Error(t, err)
+}
+
+func TestConfigValidate(t *testing.T) {
+	model := func(name string, modify func(*ModelConfig)) ModelConfigEntry {
+		m := ModelConfig{Name: name, BasePath: "/models/" + name}
+		if modify != nil {
+			modify(&m)
+		}
+		return ModelConfigEntry{Config: m}
+	}
+
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr string
+	}{
+		{
+			name: "valid",
+			config: Config{
+				ModelConfigList: []ModelConfigEntry{
+					model("resnet", func(m *ModelConfig) {
+						m.BatchSize = "8"
+						m.ModelVersionPolicy = &VersionPolicy{Specific: &SpecificPolicy{Versions: []int64{1, 3}}}
+					}),
+					model("face", func(m *ModelConfig) { m.ModelVersionPolicy = &VersionPolicy{All: &AllPolicy{}} }),
+				},
+				MediapipeConfigList: []MediapipeConfig{{Name: "pipeline"}},
+			},
+		},
+		{
+			name:    "missing name",
+			config:  Config{ModelConfigList: []ModelConfigEntry{model("", nil)}},
+			wantErr: "invalid model: name must not be empty",
+		},
+		{
+			name:    "missing base path",
+			config:  Config{ModelConfigList: []ModelConfigEntry{model("resnet", func(m *ModelConfig) { m.BasePath = "" })}},
+			wantErr: `invalid model "resnet": base_path must not be empty`,
+		},
+		{
+			name: "duplicate name",
+			config: Config{
+				ModelConfigList:     []ModelConfigEntry{model("resnet", nil)},
+				MediapipeConfigList: []MediapipeConfig{{Name: "resnet"}},
+			},
+			wantErr: `invalid mediapipe graph: duplicate name "resnet"`,
+		},
+		{
+			name:    "invalid batch size",
+			config:  Config{ModelConfigList: []ModelConfigEntry{model("resnet", func(m *ModelConfig) { m.BatchSize = "0" })}},
+			wantErr: `invalid model "resnet": batch_size must be a positive integer or auto`,
+		},
+		{
+			name: "several version policies",
+			config: Config{ModelConfigList: []ModelConfigEntry{model("resnet", func(m *ModelConfig) {
+				m.ModelVersionPolicy = &VersionPolicy{All: &AllPolicy{}, Latest: &LatestPolicy{NumVersions: 1}}
+			})}},
+			wantErr: `invalid model "resnet": model_version_policy must set exactly one of all, latest or specific`,
+		},
+		{
+			name: "no latest versions",
+			config: Config{ModelConfigList: []ModelConfigEntry{model("resnet", func(m *ModelConfig) {
+				m.ModelVersionPolicy = &VersionPolicy{Latest: &LatestPolicy{}}
+			})}},
+			wantErr: `invalid model "resnet": model_version_policy latest num_versions must be positive`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.config.Validate()
+			if test.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigRender(t *testing.T) {
+	config := Config{ModelConfigList: []ModelConfigEntry{{Config: ModelConfig{
+		Name:               "resnet",
+		BasePath:           "/models/resnet",
+		ModelVersionPolicy: &VersionPolicy{All: &AllPolicy{}},
+	}}}}
+	rendered, err := config.Render()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"model_config_list": [{"config": {
+		"name": "resnet", "base_path": "/models/resnet", "model_version_policy": {"all": {}}}}]}`, rendered)
+}
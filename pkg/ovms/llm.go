@@ -0,0 +1,181 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ovms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	// GraphDir is the directory of the model server container where the
+	// configuration file and the LLM graphs are mounted.
+	GraphDir = "/config"
+
+	// ChatCompletionsPath is the path of the OpenAI-compatible chat
+	// completions endpoint of the REST API, serving the LLMs.
+	ChatCompletionsPath = "/v3/chat/completions"
+)
+
+// LLMConfig configures a large language model served with continuous
+// batching by a MediaPipe graph. The graph is generated from the settings
+// and exposes the OpenAI-compatible completions endpoints.
+type LLMConfig struct {
+	Name string `json:"name"`
+	// ModelPath is the directory of the OpenVINO model, with the converted
+	// tokenizer and detokenizer.
+	ModelPath    string                 `json:"model_path"`
+	TargetDevice string                 `json:"target_device,omitempty"`
+	PluginConfig map[string]interface{} `json:"plugin_config,omitempty"`
+	// CacheSize is the size of the KV cache in GB.
+	CacheSize           int64 `json:"cache_size,omitempty"`
+	BlockSize           int64 `json:"block_size,omitempty"`
+	MaxNumSeqs          int64 `json:"max_num_seqs,omitempty"`
+	MaxNumBatchedTokens int64 `json:"max_num_batched_tokens,omitempty"`
+	DynamicSplitFuse    *bool `json:"dynamic_split_fuse,omitempty"`
+	EnablePrefixCaching *bool `json:"enable_prefix_caching,omitempty"`
+	MaxTokensLimit      int64 `json:"max_tokens_limit,omitempty"`
+	BestOfLimit         int64 `json:"best_of_limit,omitempty"`
+}
+
+// graphFile returns the name of the graph file of the LLM at the given
+// index. Model names may include slashes, which are not valid in ConfigMap
+// keys.
+func graphFile(i int) string {
+	return fmt.Sprintf("llm-%d.pbtxt", i)
+}
+
+// mediapipeConfig returns the entry of the MediaPipe config list serving the
+// LLM at the given index.
+func (l LLMConfig) mediapipeConfig(i int) MediapipeConfig {
+	return MediapipeConfig{Name: l.Name, BasePath: l.ModelPath, GraphPath: path.Join(GraphDir, graphFile(i))}
+}
+
+func (l LLMConfig) validate() error {
+	if l.ModelPath == "" {
+		return errors.New("model_path must not be empty")
+	}
+	if !path.IsAbs(l.ModelPath) {
+		return errors.New("model_path must be an absolute path in the model server container")
+	}
+	for _, f := range []struct {
+		name  string
+		value int64
+	}{
+		{"cache_size", l.CacheSize},
+		{"block_size", l.BlockSize},
+		{"max_num_seqs", l.MaxNumSeqs},
+		{"max_num_batched_tokens", l.MaxNumBatchedTokens},
+		{"max_tokens_limit", l.MaxTokensLimit},
+		{"best_of_limit", l.BestOfLimit},
+	} {
+		if f.value < 0 {
+			return fmt.Errorf("%s must not be negative", f.name)
+		}
+	}
+	return nil
+}
+
+// Graph returns the content of the graph.pbtxt file serving the LLM over
+// the HTTP calculator of the model server.
+func (l LLMConfig) Graph() (string, error) {
+	var options strings.Builder
+	option := func(name string, value interface{}) {
+		fmt.Fprintf(&options, "          %s: %v\n", name, value)
+	}
+	option("models_path", fmt.Sprintf("%q", l.ModelPath))
+	if l.TargetDevice != "" {
+		option("device", fmt.Sprintf("%q", l.TargetDevice))
+	}
+	if len(l.PluginConfig) > 0 {
+		b, err := json.Marshal(l.PluginConfig)
+		if err != nil {
+			return "", err
+		}
+		option("plugin_config", fmt.Sprintf("%q", string(b)))
+	}
+	for _, f := range []struct {
+		name  string
+		value int64
+	}{
+		{"cache_size", l.CacheSize},
+		{"block_size", l.BlockSize},
+		{"max_num_seqs", l.MaxNumSeqs},
+		{"max_num_batched_tokens", l.MaxNumBatchedTokens},
+		{"max_tokens_limit", l.MaxTokensLimit},
+		{"best_of_limit", l.BestOfLimit},
+	} {
+		if f.value > 0 {
+			option(f.name, f.value)
+		}
+	}
+	if l.DynamicSplitFuse != nil {
+		option("dynamic_split_fuse", *l.DynamicSplitFuse)
+	}
+	if l.EnablePrefixCaching != nil {
+		option("enable_prefix_caching", *l.EnablePrefixCaching)
+	}
+
+	return `input_stream: "HTTP_REQUEST_PAYLOAD:input"
+output_stream: "HTTP_RESPONSE_PAYLOAD:output"
+
+node: {
+  name: "LLMExecutor"
+  calculator: "HttpLLMCalculator"
+  input_stream: "LOOPBACK:loopback"
+  input_stream: "HTTP_REQUEST_PAYLOAD:input"
+  input_side_packet: "LLM_NODE_RESOURCES:llm"
+  output_stream: "LOOPBACK:loopback"
+  output_stream: "HTTP_RESPONSE_PAYLOAD:output"
+  input_stream_info: {
+    tag_index: 'LOOPBACK:0',
+    back_edge: true
+  }
+  node_options: {
+      [type.googleapis.com / mediapipe.LLMCalculatorOptions]: {
+` + options.String() + `      }
+  }
+  input_stream_handler {
+    input_stream_handler: "SyncSetInputStreamHandler",
+    options {
+      [mediapipe.SyncSetInputStreamHandlerOptions.ext] {
+        sync_set {
+          tag_index: "LOOPBACK:0"
+        }
+      }
+    }
+  }
+}
+`, nil
+}
+
+// Graphs returns the graph files of the LLMs of the configuration, by file
+// name in GraphDir.
+func (c *Config) Graphs() (map[string]string, error) {
+	graphs := map[string]string{}
+	for i, l := range c.LLMs {
+		graph, err := l.Graph()
+		if err != nil {
+			return nil, fmt.Errorf("invalid llm %q: %w", l.Name, err)
+		}
+		graphs[graphFile(i)] = graph
+	}
+	return graphs, nil
+}
@@ -0,0 +1,105 @@
+//
+// Copyright (c) 2022 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ovms
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigFromValuesLLMs(t *testing.T) {
+	config, err := ConfigFromValues(map[string]interface{}{
+		"models_settings": map[string]interface{}{
+			"llms": []interface{}{
+				map[string]interface{}{
+					"name":                  "meta-llama/Meta-Llama-3-8B-Instruct",
+					"model_path":            "/models/Meta-Llama-3-8B-Instruct",
+					"target_device":         "GPU",
+					"plugin_config":         map[string]interface{}{"KV_CACHE_PRECISION": "u8"},
+					"cache_size":            int64(20),
+					"max_num_seqs":          int64(256),
+					"enable_prefix_caching": true,
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, config.Validate())
+	assert.Equal(t, []MediapipeConfig{{
+		Name:      "meta-llama/Meta-Llama-3-8B-Instruct",
+		BasePath:  "/models/Meta-Llama-3-8B-Instruct",
+		GraphPath: "/config/llm-0.pbtxt",
+	}}, config.MediapipeConfigList)
+	assert.Equal(t, []string{"meta-llama/Meta-Llama-3-8B-Instruct"}, config.Names())
+
+	graphs, err := config.Graphs()
+	assert.NoError(t, err)
+	assert.Len(t, graphs, 1)
+	assert.Contains(t, graphs["llm-0.pbtxt"], `calculator: "HttpLLMCalculator"`)
+	assert.Contains(t, graphs["llm-0.pbtxt"], `      [type.googleapis.com / mediapipe.LLMCalculatorOptions]: {
+          models_path: "/models/Meta-Llama-3-8B-Instruct"
+          device: "GPU"
+          plugin_config: "{\"KV_CACHE_PRECISION\":\"u8\"}"
+          cache_size: 20
+          max_num_seqs: 256
+          enable_prefix_caching: true
+      }
+`)
+}
+
+func TestLLMValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		llm     LLMConfig
+		wantErr string
+	}{
+		{
+			name: "valid",
+			llm:  LLMConfig{Name: "llama", ModelPath: "/models/llama", CacheSize: 8},
+		},
+		{
+			name:    "missing model path",
+			llm:     LLMConfig{Name: "llama"},
+			wantErr: `invalid llm "llama": model_path must not be empty`,
+		},
+		{
+			name:    "remote model path",
+			llm:     LLMConfig{Name: "llama", ModelPath: "gs://models/llama"},
+			wantErr: `invalid llm "llama": model_path must be an absolute path in the model server container`,
+		},
+		{
+			name:    "negative cache size",
+			llm:     LLMConfig{Name: "llama", ModelPath: "/models/llama", CacheSize: -1},
+			wantErr: `invalid llm "llama": cache_size must not be negative`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &Config{
+				MediapipeConfigList: []MediapipeConfig{test.llm.mediapipeConfig(0)},
+				LLMs:                []LLMConfig{test.llm},
+			}
+			err := config.Validate()
+			if test.wantErr != "" {
+				assert.EqualError(t, err, test.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -39,4 +39,13 @@ const (
 	// which is the namespace where the watch activity happens.
 	// this value is empty if the operator is running with clusterScope.
 	WatchNamespaceEnvVar = "WATCH_NAMESPACE"
+
+	// OperatorNamespaceEnvVar is the constant for env variable
+	// OPERATOR_NAMESPACE which overrides the namespace of the operator read
+	// from its service account.
+	OperatorNamespaceEnvVar = "OPERATOR_NAMESPACE"
+
+	// serviceAccountNamespaceFile is the file holding the namespace of the
+	// service account mounted in the operator pod.
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
 )
@@ -35,6 +35,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"regexp"
 	"strings"
 	"unicode"
@@ -177,3 +178,19 @@ func SupportsOwnerReference(restMapper meta.RESTMapper, owner, dependent runtime
 	// Both owner and dependent are namespace-scoped and in the same namespace.
 	return true, nil
 }
+
+// GetOperatorNamespace returns the namespace the operator runs in, set in
+// the OPERATOR_NAMESPACE env variable or read from its service account.
+func GetOperatorNamespace() (string, error) {
+	if ns := os.Getenv(OperatorNamespaceEnvVar); ns != "" {
+		return ns, nil
+	}
+	b, err := os.ReadFile(serviceAccountNamespaceFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", errors.New("operator namespace not found, the operator is not running in a cluster")
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
@@ -399,3 +399,10 @@ func TestFormatOperatorNameDNS1123(t *testing.T) {
 		})
 	}
 }
+
+func TestGetOperatorNamespace(t *testing.T) {
+	t.Setenv(OperatorNamespaceEnvVar, "openvino-operator")
+	ns, err := GetOperatorNamespace()
+	assert.NoError(t, err)
+	assert.Equal(t, "openvino-operator", ns)
+}